@@ -0,0 +1,83 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package arena 提供实验性的内存 arena：在一个 arena 中分配的对象可以通过 Free 一次性释放，
+// 而不必等待垃圾回收器逐个回收。
+//
+// 适合的场景是在一次请求中构建大量临时对象，并在请求结束时全部丢弃：
+//
+//	a := arena.NewArena()
+//	defer a.Free()
+//
+//	var req *Request
+//	a.New(&req)
+//	var items []Item
+//	a.MakeSlice(&items, 0, 1024)
+//	...
+//
+// arena 中的对象在 Free 之前与普通对象一样被垃圾回收器追踪，可以指向堆上的对象，
+// 也可以被堆上的对象指向。指向 arena 中任何对象的指针都会使其所在的整块内存（chunk）
+// 保持存活。
+//
+// Free 之后，arena 的内存被映射为不可访问，此后通过残留的指针对 arena 中对象的任何访问
+// 都会使程序崩溃，而不是读写到已经被重新使用的内存。若 Free 在垃圾回收的标记期间被调用，
+// 内存在标记结束时才变为不可访问。在 js/wasm 上内存不会变为不可访问。
+//
+// arena 中的对象不能设置 finalizer 或 cleanup。超过 chunk 大小四分之一的分配
+// 直接在堆上进行，由垃圾回收器回收。
+//
+// Arena 不是并发安全的，同一时间只能有一个 goroutine 使用一个 Arena。
+package arena
+
+import "unsafe"
+
+// Arena 为一个内存 arena，必须由 NewArena 创建。
+type Arena struct {
+	a unsafe.Pointer
+}
+
+// NewArena 创建一个新的 arena。
+func NewArena() *Arena {
+	return &Arena{a: runtime_newArena()}
+}
+
+// New 在 a 中分配一个类型为 T 的零值，并将指向它的指针存入 *ptr。ptr 的类型必须为 **T：
+//
+//	var p *T
+//	a.New(&p)
+func (a *Arena) New(ptr interface{}) {
+	runtime_arenaNew(a.arena(), ptr)
+}
+
+// MakeSlice 在 a 中分配一个长度为 len、容量为 cap 的 []T，并将其存入 *slice。
+// slice 的类型必须为 *[]T：
+//
+//	var s []T
+//	a.MakeSlice(&s, 0, 100)
+//
+// 对切片进行 append 超出其容量时，新的底层数组会分配在堆上。
+func (a *Arena) MakeSlice(slice interface{}, len, cap int) {
+	runtime_arenaSlice(a.arena(), slice, len, cap)
+}
+
+// Free 释放 a 中分配的所有对象。此后 a 不能再被使用，
+// a 中的对象也不能再被访问。
+func (a *Arena) Free() {
+	runtime_freeArena(a.arena())
+	a.a = nil
+}
+
+func (a *Arena) arena() unsafe.Pointer {
+	if a.a == nil {
+		panic("arena: use of freed arena")
+	}
+	return a.a
+}
+
+// Implemented in runtime.
+
+func runtime_newArena() unsafe.Pointer
+func runtime_arenaNew(arena unsafe.Pointer, ptr interface{})
+func runtime_arenaSlice(arena unsafe.Pointer, slice interface{}, len, cap int)
+func runtime_freeArena(arena unsafe.Pointer)
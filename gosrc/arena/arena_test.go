@@ -0,0 +1,62 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package arena_test
+
+import (
+	"arena"
+	"runtime"
+	"testing"
+)
+
+type node struct {
+	next  *node
+	value int
+	heap  *[64]byte
+}
+
+// 仍然可达的 arena 在 GC 之后必须保持原样：chunk 被标记、清扫时不会被回收，
+// arena 中的对象所指向的堆对象同样不会被回收。
+func TestArenaSurvivesGC(t *testing.T) {
+	a := arena.NewArena()
+	defer a.Free()
+
+	const n = 1000
+	var list *node
+	for i := 0; i < n; i++ {
+		var p *node
+		a.New(&p)
+		p.next = list
+		p.value = i
+		p.heap = new([64]byte)
+		p.heap[0] = byte(i)
+		list = p
+	}
+	var s []int
+	a.MakeSlice(&s, n, n)
+	for i := range s {
+		s[i] = i
+	}
+
+	// 第二次 GC 会清扫第一次 GC 标记过的 chunk
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+
+	i := n - 1
+	for p := list; p != nil; p = p.next {
+		if p.value != i || p.heap[0] != byte(i) {
+			t.Fatalf("node %d = {value: %d, heap[0]: %d}, want %d", i, p.value, p.heap[0], i)
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("list has %d nodes, want %d", n-1-i, n)
+	}
+	for i, v := range s {
+		if v != i {
+			t.Fatalf("s[%d] = %d, want %d", i, v, i)
+		}
+	}
+}
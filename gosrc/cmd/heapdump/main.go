@@ -0,0 +1,242 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Heapdump 分析 runtime/debug.WriteHeapDump 写出的堆转储。
+//
+// 用法：
+//
+//	go tool heapdump <command> [flags] <dump>...
+//
+// 命令：
+//
+//	top [-n N] [-sort size|count|retained] dump
+//		按类别统计对象的个数、总大小与保留大小。
+//	retained [-n N] dump
+//		列出保留大小最大的对象以及支配它们的对象。
+//	paths [-n N] [-l L] dump addr
+//		列出从根到包含地址 addr 的对象的引用路径，超过 L 条边的路径省略中间部分。
+//	diff [-n N] old new
+//		比较同一个程序的两个转储，列出变化最大的类别。
+//
+// 转储中的对象不带有类型信息，类别的定义见 debug/heapdump 中的 Dump.Class。
+package main
+
+import (
+	"debug/heapdump"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: go tool heapdump <command> [flags] <dump>...
+
+Commands:
+	top [-n N] [-sort size|count|retained] dump
+	retained [-n N] dump
+	paths [-n N] [-l L] dump addr
+	diff [-n N] old new
+
+Run 'go tool heapdump <command> -h' for the flags of a command.
+`)
+	os.Exit(2)
+}
+
+func fatalf(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "heapdump: "+msg+"\n", args...)
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "top":
+		top(args)
+	case "retained":
+		retained(args)
+	case "paths":
+		paths(args)
+	case "diff":
+		diff(args)
+	case "help", "-h", "-help", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "heapdump: unknown command %q\n", cmd)
+		usage()
+	}
+}
+
+// parseFlags 解析命令 cmd 的参数，要求剩余恰好 nargs 个参数。
+func parseFlags(fs *flag.FlagSet, args []string, nargs int, argsUsage string) []string {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: go tool heapdump %s [flags] %s\n", fs.Name(), argsUsage)
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	if fs.NArg() != nargs {
+		fs.Usage()
+	}
+	return fs.Args()
+}
+
+func readDump(name string) *heapdump.Dump {
+	f, err := os.Open(name)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer f.Close()
+	d, err := heapdump.Read(f)
+	if err != nil {
+		fatalf("%s: %v", name, err)
+	}
+	return d
+}
+
+func newTabWriter() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+}
+
+func top(args []string) {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	n := fs.Int("n", 20, "print at most `N` classes")
+	by := fs.String("sort", "size", "sort classes by `key`: size, count or retained")
+	args = parseFlags(fs, args, 1, "dump")
+	d := readDump(args[0])
+
+	classes := d.Classes()
+	var less func(a, b *heapdump.ClassStats) bool
+	switch *by {
+	case "size":
+		less = func(a, b *heapdump.ClassStats) bool { return a.Size > b.Size }
+	case "count":
+		less = func(a, b *heapdump.ClassStats) bool { return a.Count > b.Count }
+	case "retained":
+		less = func(a, b *heapdump.ClassStats) bool { return a.Retained > b.Retained }
+	default:
+		fatalf("unknown sort key %q", *by)
+	}
+	sort.SliceStable(classes, func(i, j int) bool { return less(classes[i], classes[j]) })
+
+	var count, size uint64
+	for _, c := range classes {
+		count += c.Count
+		size += c.Size
+	}
+	fmt.Printf("%d objects, %d bytes, %d bytes reachable\n", count, size, d.TotalRetained())
+
+	w := newTabWriter()
+	fmt.Fprintf(w, "count\tsize\tretained\tclass\n")
+	for i, c := range classes {
+		if i == *n {
+			break
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\n", c.Count, c.Size, c.Retained, c.Name)
+	}
+	w.Flush()
+}
+
+func retained(args []string) {
+	fs := flag.NewFlagSet("retained", flag.ExitOnError)
+	n := fs.Int("n", 20, "print at most `N` objects")
+	args = parseFlags(fs, args, 1, "dump")
+	d := readDump(args[0])
+
+	objs := make([]*heapdump.Object, 0, len(d.Objects))
+	for _, o := range d.Objects {
+		if d.Reachable(o) {
+			objs = append(objs, o)
+		}
+	}
+	sort.SliceStable(objs, func(i, j int) bool {
+		return d.Retained(objs[i]) > d.Retained(objs[j])
+	})
+
+	w := newTabWriter()
+	fmt.Fprintf(w, "retained\tsize\taddress\tclass\tdominator\n")
+	for i, o := range objs {
+		if i == *n {
+			break
+		}
+		dom := "(root)"
+		if p := d.Dominator(o); p != nil {
+			dom = fmt.Sprintf("%#x", p.Addr)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%#x\t%s\t%s\n", d.Retained(o), o.Size(), o.Addr, d.Class(o), dom)
+	}
+	w.Flush()
+}
+
+func paths(args []string) {
+	fs := flag.NewFlagSet("paths", flag.ExitOnError)
+	n := fs.Int("n", 5, "print at most `N` paths")
+	l := fs.Int("l", 20, "elide the middle of paths longer than `L` edges (0 prints whole paths)")
+	args = parseFlags(fs, args, 2, "dump addr")
+	d := readDump(args[0])
+	addr, err := strconv.ParseUint(args[1], 0, 64)
+	if err != nil {
+		fatalf("bad address %q", args[1])
+	}
+	o := d.FindObject(addr)
+	if o == nil {
+		fatalf("no object at %#x", addr)
+	}
+
+	fmt.Printf("object %#x, %s, %d bytes, retains %d bytes\n", o.Addr, d.Class(o), o.Size(), d.Retained(o))
+	ps := d.PathsTo(o, *n)
+	if len(ps) == 0 {
+		fmt.Printf("unreachable\n")
+		return
+	}
+	for i, p := range ps {
+		fmt.Printf("\npath %d: %d edges\n", i+1, len(p))
+		for j, e := range p {
+			if *l > 0 && len(p) > *l && j >= *l/2 && j < len(p)-*l/2 {
+				if j == *l/2 {
+					fmt.Printf("\t... %d edges elided ...\n", len(p)-*l/2*2)
+				}
+				continue
+			}
+			if e.Root != nil {
+				fmt.Printf("\t%s root: %s", e.Root.Kind, e.Root.Name)
+				if e.Root.Kind == heapdump.RootStack {
+					fmt.Printf(" +%#x", e.Root.Offset)
+				}
+				fmt.Printf("\n")
+			}
+			fmt.Printf("\t-> %#x", e.To.Addr)
+			if e.ToOffset != 0 {
+				fmt.Printf("+%#x", e.ToOffset)
+			}
+			fmt.Printf(" %s", d.Class(e.To))
+			if e.From != nil {
+				fmt.Printf(" (from +%#x)", e.FromOffset)
+			}
+			fmt.Printf("\n")
+		}
+	}
+}
+
+func diff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	n := fs.Int("n", 20, "print at most `N` classes")
+	args = parseFlags(fs, args, 2, "old new")
+	old, new := readDump(args[0]), readDump(args[1])
+
+	w := newTabWriter()
+	fmt.Fprintf(w, "Δcount\tΔsize\tΔretained\tcount\tsize\tclass\n")
+	for i, c := range heapdump.Diff(old, new) {
+		if i == *n {
+			break
+		}
+		fmt.Fprintf(w, "%+d\t%+d\t%+d\t%d\t%d\t%s\n", c.CountDelta(), c.SizeDelta(), c.RetainedDelta(), c.New.Count, c.New.Size, c.Name)
+	}
+	w.Flush()
+}
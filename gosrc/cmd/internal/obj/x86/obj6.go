@@ -971,6 +971,12 @@ func isZeroArgRuntimeCall(s *obj.LSym) bool {
 	case "runtime.panicindex", "runtime.panicslice", "runtime.panicdivide", "runtime.panicwrap":
 		return true
 	}
+	if strings.HasPrefix(s.Name, "runtime.panicIndex") || strings.HasPrefix(s.Name, "runtime.panicSlice") {
+		// These functions do take arguments (in registers),
+		// but use no stack before they do a stack check. We
+		// should include them.
+		return true
+	}
 	return false
 }
 
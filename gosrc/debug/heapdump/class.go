@@ -0,0 +1,200 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxClassPtrs 为类别名称中最多列出的指针字段数
+const maxClassPtrs = 8
+
+// Class 返回 o 所属的类别，用于对对象分组。
+//
+// 若转储中记录了 o 的类型（目前只有设置了 finalizer 的对象），类别为类型名；
+// 否则类别由对象的大小与指针字段的偏移量组成，例如 "48B ptr(0,16)" 或 "64B noptr"。
+// 同一个程序中大小与指针布局都相同的对象通常具有相同的类型，
+// 因此这些类别在同一个程序的不同转储之间也是可以比较的。
+func (d *Dump) Class(o *Object) string {
+	if d.objType == nil {
+		d.objType = make(map[*Object]string)
+		for _, f := range d.Finalizers {
+			t := d.Type(f.OT)
+			obj := d.FindObject(f.Obj)
+			if t == nil || obj == nil || !strings.HasPrefix(t.Name, "*") {
+				continue
+			}
+			d.objType[obj] = t.Name[1:]
+		}
+	}
+	if name, ok := d.objType[o]; ok {
+		return name
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%dB ", o.Size())
+	if len(o.Fields) == 0 {
+		b.WriteString("noptr")
+		return b.String()
+	}
+	b.WriteString("ptr(")
+	for i, f := range o.Fields {
+		if i == maxClassPtrs {
+			fmt.Fprintf(&b, ",…+%d", len(o.Fields)-i)
+			break
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%d", f.Offset)
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// ClassStats 为一个类别中的对象的统计。
+type ClassStats struct {
+	Name  string
+	Count uint64 // 对象的个数
+	Size  uint64 // 对象的总大小
+
+	// Retained 为类别中所有可达对象的保留大小之和。
+	// 被同一类别中其他对象支配的对象不会被重复计算，
+	// 因此 Retained 为该类别的对象全部不可达后可以被回收的字节数的下界。
+	Retained uint64
+}
+
+// Classes 按类别对 d 中的对象分组，返回按总大小从大到小排序的统计。
+func (d *Dump) Classes() []*ClassStats {
+	g := d.getGraph()
+	n := len(d.Objects) + 1
+
+	byName := make(map[string]*ClassStats)
+	var classes []*ClassStats
+	cls := make([]*ClassStats, n)
+	for i, o := range d.Objects {
+		name := d.Class(o)
+		c := byName[name]
+		if c == nil {
+			c = &ClassStats{Name: name}
+			byName[name] = c
+			classes = append(classes, c)
+		}
+		c.Count++
+		c.Size += o.Size()
+		cls[i+1] = c
+	}
+
+	// 支配树的孩子，CSR 形式
+	childStart := make([]int, n+1)
+	for v := 1; v < n; v++ {
+		if p := g.idom[v]; p >= 0 {
+			childStart[p+1]++
+		}
+	}
+	for v := 0; v < n; v++ {
+		childStart[v+1] += childStart[v]
+	}
+	children := make([]int, childStart[n])
+	next := append([]int(nil), childStart[:n]...)
+	for v := 1; v < n; v++ {
+		if p := g.idom[v]; p >= 0 {
+			children[next[p]] = v
+			next[p]++
+		}
+	}
+
+	// 在支配树上深度优先搜索，只累加路径上第一个属于该类别的对象的保留大小
+	active := make(map[*ClassStats]int)
+	type item struct{ v, c int }
+	stack := []item{{0, childStart[0]}}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.c == childStart[top.v+1] {
+			if top.v != 0 {
+				active[cls[top.v]]--
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		w := children[top.c]
+		top.c++
+		c := cls[w]
+		if active[c] == 0 {
+			c.Retained += g.retained[w]
+		}
+		active[c]++
+		stack = append(stack, item{w, childStart[w]})
+	}
+
+	sort.SliceStable(classes, func(i, j int) bool {
+		return classes[i].Size > classes[j].Size
+	})
+	return classes
+}
+
+// ClassDiff 为同一个类别在两个转储中的统计。
+// 只在一个转储中出现的类别，另一个转储中的统计为零值。
+type ClassDiff struct {
+	Name string
+	Old  ClassStats
+	New  ClassStats
+}
+
+// CountDelta 返回对象个数的变化。
+func (c *ClassDiff) CountDelta() int64 {
+	return int64(c.New.Count) - int64(c.Old.Count)
+}
+
+// SizeDelta 返回对象总大小的变化。
+func (c *ClassDiff) SizeDelta() int64 {
+	return int64(c.New.Size) - int64(c.Old.Size)
+}
+
+// RetainedDelta 返回保留大小的变化。
+func (c *ClassDiff) RetainedDelta() int64 {
+	return int64(c.New.Retained) - int64(c.Old.Retained)
+}
+
+// Diff 比较同一个程序的两个转储，返回每个类别的变化，按总大小变化的绝对值从大到小排序。
+// 没有变化的类别不会被返回。
+func Diff(old, new *Dump) []*ClassDiff {
+	byName := make(map[string]*ClassDiff)
+	var diffs []*ClassDiff
+	get := func(name string) *ClassDiff {
+		c := byName[name]
+		if c == nil {
+			c = &ClassDiff{Name: name}
+			byName[name] = c
+			diffs = append(diffs, c)
+		}
+		return c
+	}
+	for _, c := range old.Classes() {
+		get(c.Name).Old = *c
+	}
+	for _, c := range new.Classes() {
+		get(c.Name).New = *c
+	}
+
+	changed := diffs[:0]
+	for _, c := range diffs {
+		if c.Old != c.New {
+			changed = append(changed, c)
+		}
+	}
+	abs := func(x int64) int64 {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	sort.SliceStable(changed, func(i, j int) bool {
+		return abs(changed[i].SizeDelta()) > abs(changed[j].SizeDelta())
+	})
+	return changed
+}
@@ -0,0 +1,67 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump
+
+import "testing"
+
+func TestClass(t *testing.T) {
+	d := newTestDump([]uint64{0x10000},
+		testObject{0x10000, 32, []uint64{0x20000, 0}},
+		testObject{0x20000, 16, nil},
+	)
+	d.Types = []*Type{{Addr: 0x500, Name: "*main.T"}}
+	d.typeByAddr[0x500] = d.Types[0]
+	d.Finalizers = []*Finalizer{{Obj: 0x20000, OT: 0x500}}
+
+	if got, want := d.Class(d.Objects[0]), "32B ptr(0,8)"; got != want {
+		t.Errorf("Class(0x10000) = %q, want %q", got, want)
+	}
+	if got, want := d.Class(d.Objects[1]), "main.T"; got != want {
+		t.Errorf("Class(0x20000) = %q, want %q", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	// old：一个全局变量指向一个有两个元素的链表，以及一个不可达的 64 字节对象
+	old := newTestDump([]uint64{0x10000},
+		testObject{0x10000, 16, []uint64{0x10010}},
+		testObject{0x10010, 16, nil},
+		testObject{0x20000, 64, nil},
+	)
+	// new：链表增长到五个元素，并多了一个 32 字节的缓冲区；64 字节对象不变
+	new := newTestDump([]uint64{0x10000, 0x30000},
+		testObject{0x10000, 16, []uint64{0x10010}},
+		testObject{0x10010, 16, []uint64{0x10020}},
+		testObject{0x10020, 16, []uint64{0x10030}},
+		testObject{0x10030, 16, []uint64{0x10040}},
+		testObject{0x10040, 16, nil},
+		testObject{0x20000, 64, nil},
+		testObject{0x30000, 32, nil},
+	)
+
+	diffs := Diff(old, new)
+	if len(diffs) != 2 {
+		for _, c := range diffs {
+			t.Logf("%+v", *c)
+		}
+		t.Fatalf("Diff returned %d classes, want 2", len(diffs))
+	}
+	// 链表尾（16B noptr）与 64 字节对象没有变化，不会被返回
+	tests := []struct {
+		name                  string
+		count, size, retained int64
+	}{
+		// 链表中的其他结点被链表头支配，只计算链表头的保留大小
+		{"16B ptr(0)", 3, 48, 48},
+		{"32B noptr", 1, 32, 32},
+	}
+	for i, tt := range tests {
+		c := diffs[i]
+		if c.Name != tt.name || c.CountDelta() != tt.count || c.SizeDelta() != tt.size || c.RetainedDelta() != tt.retained {
+			t.Errorf("diffs[%d] = %s %+d objects %+d bytes %+d retained, want %s %+d %+d %+d",
+				i, c.Name, c.CountDelta(), c.SizeDelta(), c.RetainedDelta(), tt.name, tt.count, tt.size, tt.retained)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// RootKind 为根的种类。
+type RootKind int
+
+const (
+	RootData      RootKind = iota // data 段中的全局变量
+	RootBSS                       // bss 段中的全局变量
+	RootStack                     // 栈帧中的局部变量或参数
+	RootGoroutine                 // goroutine 的调度上下文、defer 与 panic 记录
+	RootFinalizer                 // finalizer 的闭包，以及设置了 finalizer 的对象
+	RootOther                     // 运行时中的其他根
+)
+
+func (k RootKind) String() string {
+	switch k {
+	case RootData:
+		return "data"
+	case RootBSS:
+		return "bss"
+	case RootStack:
+		return "stack"
+	case RootGoroutine:
+		return "goroutine"
+	case RootFinalizer:
+		return "finalizer"
+	case RootOther:
+		return "other"
+	}
+	return fmt.Sprintf("RootKind(%d)", int(k))
+}
+
+// Root 为一个指向堆中对象的根。
+type Root struct {
+	Kind RootKind
+	Name string // 根的描述
+
+	// Frame 为 Kind 为 RootStack 时指针所在的栈帧。
+	Frame *Frame
+	// Offset 为指针在 data、bss 段或栈帧中的偏移量。
+	Offset uint64
+
+	To       *Object // 根指向的对象
+	ToOffset uint64  // 指针指向 To 中的偏移量
+}
+
+// Edge 为对象图中的一条边：From 中偏移量为 FromOffset 的指针指向 To 中偏移量为 ToOffset 的位置。
+// 从根出发的边 From 为 nil，Root 为该根。
+type Edge struct {
+	From       *Object
+	Root       *Root
+	FromOffset uint64
+	To         *Object
+	ToOffset   uint64
+}
+
+// Path 为一条从根到某个对象的引用路径，第一条边从根出发。
+type Path []Edge
+
+// graph 为对象图。
+//
+// 节点 0 为一个虚拟的根，它指向所有的根所指向的对象；节点 i+1 为 Dump.Objects[i]。
+// 边以 CSR 的形式存放：节点 v 的出边为 [succStart[v], succStart[v+1])，
+// 节点 0 的第 k 条出边对应 roots[k]。
+type graph struct {
+	roots []*Root
+
+	succStart []int
+	succ      []int    // 边的终点
+	edgeFrom  []int    // 边的起点
+	edgeOff   []uint64 // 指针在起点中的偏移量
+	edgePtr   []uint64 // 指针的值
+
+	predStart []int
+	pred      []int // 入边的下标
+
+	// 从虚拟根开始的广度优先搜索，用于寻找最短的引用路径
+	dist   []int // 与虚拟根的距离，-1 表示不可达
+	bfsVia []int // 最短路径上的最后一条边
+
+	// 支配树
+	idom     []int // 直接支配者，-1 表示不可达
+	retained []uint64
+}
+
+// FindObject 返回包含地址 addr 的对象，addr 不在任何对象中时返回 nil。
+func (d *Dump) FindObject(addr uint64) *Object {
+	i := sort.Search(len(d.Objects), func(i int) bool {
+		return d.Objects[i].Addr > addr
+	}) - 1
+	if i < 0 {
+		return nil
+	}
+	o := d.Objects[i]
+	if addr >= o.Addr+o.Size() {
+		return nil
+	}
+	return o
+}
+
+// Type 返回地址为 addr 的类型，转储中没有记录该类型时返回 nil。
+func (d *Dump) Type(addr uint64) *Type {
+	return d.typeByAddr[addr]
+}
+
+// readPtr 读取 b 中偏移量为 off 的指针。
+func (d *Dump) readPtr(b []byte, off uint64) (uint64, bool) {
+	n := d.Params.PtrSize
+	if off > uint64(len(b)) || n > uint64(len(b))-off {
+		return 0, false
+	}
+	b = b[off : off+n]
+	var order binary.ByteOrder = binary.LittleEndian
+	if d.Params.BigEndian {
+		order = binary.BigEndian
+	}
+	if n == 4 {
+		return uint64(order.Uint32(b)), true
+	}
+	return order.Uint64(b), true
+}
+
+// pointers 对 b 中 fs 描述的每个指向堆中对象的指针调用 fn。
+// 对于接口字段，只考虑其数据字。
+func (d *Dump) pointers(b []byte, fs []Field, fn func(off, ptr uint64, to *Object)) {
+	for _, f := range fs {
+		off := f.Offset
+		if f.Kind != FieldPtr {
+			off += d.Params.PtrSize
+		}
+		p, ok := d.readPtr(b, off)
+		if !ok || p == 0 {
+			continue
+		}
+		if to := d.FindObject(p); to != nil {
+			fn(off, p, to)
+		}
+	}
+}
+
+// Roots 返回所有指向堆中对象的根。
+func (d *Dump) Roots() []*Root {
+	return d.getGraph().roots
+}
+
+func (d *Dump) collectRoots() []*Root {
+	var roots []*Root
+	add := func(kind RootKind, name string, frame *Frame, off, ptr uint64) {
+		if to := d.FindObject(ptr); to != nil {
+			roots = append(roots, &Root{Kind: kind, Name: name, Frame: frame, Offset: off, To: to, ToOffset: ptr - to.Addr})
+		}
+	}
+	for _, s := range []*Segment{d.Data, d.BSS} {
+		if s == nil {
+			continue
+		}
+		kind := RootData
+		if s == d.BSS {
+			kind = RootBSS
+		}
+		d.pointers(s.Data, s.Fields, func(off, ptr uint64, _ *Object) {
+			add(kind, fmt.Sprintf("global at %#x", s.Addr+off), nil, off, ptr)
+		})
+	}
+	for _, f := range d.Frames {
+		f := f
+		d.pointers(f.Data, f.Fields, func(off, ptr uint64, _ *Object) {
+			add(RootStack, fmt.Sprintf("%s in goroutine %d", f.Name, f.Goroutine.ID), f, off, ptr)
+		})
+	}
+	for _, g := range d.Goroutines {
+		add(RootGoroutine, fmt.Sprintf("context of goroutine %d", g.ID), nil, 0, g.Ctxt)
+	}
+	for _, df := range d.Defers {
+		add(RootGoroutine, fmt.Sprintf("defer %#x", df.Addr), nil, 0, df.Fn)
+	}
+	for _, p := range d.Panics {
+		add(RootGoroutine, fmt.Sprintf("panic %#x", p.Addr), nil, 0, p.Data)
+	}
+	for _, f := range d.Finalizers {
+		if f.IsCleanup() {
+			add(RootFinalizer, "queued cleanup", nil, 0, f.Fn)
+			continue
+		}
+		add(RootFinalizer, fmt.Sprintf("finalizer for %#x", f.Obj), nil, 0, f.Fn)
+		// 设置了 finalizer 的对象所引用的对象在 finalizer 运行之前不会被回收，
+		// 已经排队的对象则被 finalizer 队列引用
+		add(RootFinalizer, fmt.Sprintf("object with finalizer %#x", f.Obj), nil, 0, f.Obj)
+	}
+	for _, r := range d.OtherRoots {
+		add(RootOther, r.Description, nil, 0, r.To)
+	}
+	return roots
+}
+
+func (d *Dump) getGraph() *graph {
+	if d.graph == nil {
+		d.graph = d.buildGraph()
+	}
+	return d.graph
+}
+
+func (d *Dump) buildGraph() *graph {
+	g := &graph{roots: d.collectRoots()}
+	n := len(d.Objects) + 1
+
+	// 出边
+	g.succStart = make([]int, n+1)
+	addEdge := func(from int, off, ptr uint64, to *Object) {
+		g.succ = append(g.succ, to.index+1)
+		g.edgeFrom = append(g.edgeFrom, from)
+		g.edgeOff = append(g.edgeOff, off)
+		g.edgePtr = append(g.edgePtr, ptr)
+	}
+	for _, r := range g.roots {
+		addEdge(0, r.Offset, r.To.Addr+r.ToOffset, r.To)
+	}
+	g.succStart[1] = len(g.succ)
+	for i, o := range d.Objects {
+		v := i + 1
+		d.pointers(o.Data, o.Fields, func(off, ptr uint64, to *Object) {
+			addEdge(v, off, ptr, to)
+		})
+		g.succStart[v+1] = len(g.succ)
+	}
+
+	// 入边
+	g.predStart = make([]int, n+1)
+	for _, w := range g.succ {
+		g.predStart[w+1]++
+	}
+	for v := 0; v < n; v++ {
+		g.predStart[v+1] += g.predStart[v]
+	}
+	g.pred = make([]int, len(g.succ))
+	next := append([]int(nil), g.predStart[:n]...)
+	for e, w := range g.succ {
+		g.pred[next[w]] = e
+		next[w]++
+	}
+
+	g.bfs(n)
+	g.dominators(d, n)
+	return g
+}
+
+// bfs 从虚拟根开始进行广度优先搜索。
+func (g *graph) bfs(n int) {
+	g.dist = make([]int, n)
+	g.bfsVia = make([]int, n)
+	for v := range g.dist {
+		g.dist[v] = -1
+	}
+	g.dist[0] = 0
+	queue := []int{0}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for e := g.succStart[v]; e < g.succStart[v+1]; e++ {
+			if w := g.succ[e]; g.dist[w] < 0 {
+				g.dist[w] = g.dist[v] + 1
+				g.bfsVia[w] = e
+				queue = append(queue, w)
+			}
+		}
+	}
+}
+
+// dominators 使用 Lengauer-Tarjan 算法计算以虚拟根为根的支配树，以及每个对象的保留大小。
+//
+// 为了处理很深的对象图（例如很长的链表），深度优先搜索与路径压缩都没有使用递归。
+func (g *graph) dominators(d *Dump, n int) {
+	const none = -1
+	semi := make([]int, n) // 半支配者的 DFS 序号，0 表示未访问
+	vertex := make([]int, n+1)
+	parent := make([]int, n)
+	ancestor := make([]int, n)
+	label := make([]int, n)
+	g.idom = make([]int, n)
+	bucket := make([][]int, n)
+	for v := 0; v < n; v++ {
+		ancestor[v] = none
+		label[v] = v
+		g.idom[v] = none
+	}
+
+	// 深度优先搜索，为节点编号
+	num := 0
+	type item struct{ v, e int }
+	stack := []item{{0, g.succStart[0]}}
+	num++
+	semi[0] = num
+	vertex[num] = 0
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.e == g.succStart[top.v+1] {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		w := g.succ[top.e]
+		top.e++
+		if semi[w] != 0 {
+			continue
+		}
+		num++
+		semi[w] = num
+		vertex[num] = w
+		parent[w] = top.v
+		stack = append(stack, item{w, g.succStart[w]})
+	}
+
+	var path []int
+	eval := func(v int) int {
+		if ancestor[v] == none {
+			return v
+		}
+		// 路径压缩
+		path = path[:0]
+		for x := v; ancestor[ancestor[x]] != none; x = ancestor[x] {
+			path = append(path, x)
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			x := path[i]
+			a := ancestor[x]
+			if semi[label[a]] < semi[label[x]] {
+				label[x] = label[a]
+			}
+			ancestor[x] = ancestor[a]
+		}
+		return label[v]
+	}
+
+	for i := num; i >= 2; i-- {
+		w := vertex[i]
+		for p := g.predStart[w]; p < g.predStart[w+1]; p++ {
+			v := g.edgeFrom[g.pred[p]]
+			if semi[v] == 0 {
+				continue // 不可达
+			}
+			if u := eval(v); semi[u] < semi[w] {
+				semi[w] = semi[u]
+			}
+		}
+		sv := vertex[semi[w]]
+		bucket[sv] = append(bucket[sv], w)
+		pw := parent[w]
+		ancestor[w] = pw
+		for _, v := range bucket[pw] {
+			if u := eval(v); semi[u] < semi[v] {
+				g.idom[v] = u
+			} else {
+				g.idom[v] = pw
+			}
+		}
+		bucket[pw] = nil
+	}
+	for i := 2; i <= num; i++ {
+		w := vertex[i]
+		if g.idom[w] != vertex[semi[w]] {
+			g.idom[w] = g.idom[g.idom[w]]
+		}
+	}
+	g.idom[0] = 0
+
+	// 直接支配者的 DFS 序号总是小于被支配者，因此逆序累加即可得到保留大小
+	g.retained = make([]uint64, n)
+	for i := num; i >= 2; i-- {
+		w := vertex[i]
+		g.retained[w] += d.Objects[w-1].Size()
+		g.retained[g.idom[w]] += g.retained[w]
+	}
+}
+
+// Reachable 报告 o 是否可以从根到达。
+//
+// 转储中包含上一次 GC 之后分配的、已经不可达但还没有被回收的对象。
+func (d *Dump) Reachable(o *Object) bool {
+	return d.getGraph().dist[o.index+1] >= 0
+}
+
+// Dominator 返回 o 在支配树中的直接支配者：所有从根到 o 的路径都经过该对象。
+// 若 o 不可达，或者不存在这样的对象（例如 o 被多个根直接或间接引用），则返回 nil。
+func (d *Dump) Dominator(o *Object) *Object {
+	v := d.getGraph().idom[o.index+1]
+	if v <= 0 {
+		return nil
+	}
+	return d.Objects[v-1]
+}
+
+// Retained 返回 o 的保留大小，即 o 不可达后可以被回收的总字节数，包括 o 本身。
+// 不可达的对象的保留大小为 0。
+func (d *Dump) Retained(o *Object) uint64 {
+	return d.getGraph().retained[o.index+1]
+}
+
+// TotalRetained 返回所有可达对象的总大小。
+func (d *Dump) TotalRetained() uint64 {
+	return d.getGraph().retained[0]
+}
+
+// Edges 返回 o 中所有指向堆中对象的指针。
+func (d *Dump) Edges(o *Object) []Edge {
+	g := d.getGraph()
+	v := o.index + 1
+	var edges []Edge
+	for e := g.succStart[v]; e < g.succStart[v+1]; e++ {
+		edges = append(edges, g.edge(d, e))
+	}
+	return edges
+}
+
+// Referrers 返回所有指向 o 的边，包括从根出发的边。
+func (d *Dump) Referrers(o *Object) []Edge {
+	g := d.getGraph()
+	v := o.index + 1
+	var edges []Edge
+	for p := g.predStart[v]; p < g.predStart[v+1]; p++ {
+		edges = append(edges, g.edge(d, g.pred[p]))
+	}
+	return edges
+}
+
+func (g *graph) edge(d *Dump, e int) Edge {
+	to := d.Objects[g.succ[e]-1]
+	ed := Edge{FromOffset: g.edgeOff[e], To: to, ToOffset: g.edgePtr[e] - to.Addr}
+	if from := g.edgeFrom[e]; from == 0 {
+		ed.Root = g.roots[e]
+	} else {
+		ed.From = d.Objects[from-1]
+	}
+	return ed
+}
+
+// shortestPath 返回从根到节点 v 的最短路径。v 必须可达。
+func (g *graph) shortestPath(d *Dump, v int) Path {
+	p := make(Path, g.dist[v])
+	for i := len(p) - 1; i >= 0; i-- {
+		e := g.bfsVia[v]
+		p[i] = g.edge(d, e)
+		v = g.edgeFrom[e]
+	}
+	return p
+}
+
+// PathsTo 返回至多 max 条从根到 o 的引用路径，按长度从短到长排序。
+//
+// 每条路径经过 o 的一个不同的直接引用者，且是经过该引用者的最短路径之一。
+// o 不可达时返回 nil。
+func (d *Dump) PathsTo(o *Object, max int) []Path {
+	g := d.getGraph()
+	v := o.index + 1
+	if g.dist[v] < 0 || max <= 0 {
+		return nil
+	}
+	// 每个直接引用者只保留一条入边
+	seen := make(map[int]bool)
+	var in []int
+	for p := g.predStart[v]; p < g.predStart[v+1]; p++ {
+		e := g.pred[p]
+		u := g.edgeFrom[e]
+		if g.dist[u] < 0 || (u != 0 && seen[u]) {
+			continue
+		}
+		seen[u] = true
+		in = append(in, e)
+	}
+	sort.SliceStable(in, func(i, j int) bool {
+		return g.dist[g.edgeFrom[in[i]]] < g.dist[g.edgeFrom[in[j]]]
+	})
+	if len(in) > max {
+		in = in[:max]
+	}
+	paths := make([]Path, 0, len(in))
+	for _, e := range in {
+		var p Path
+		if u := g.edgeFrom[e]; u != 0 {
+			p = g.shortestPath(d, u)
+		}
+		paths = append(paths, append(p, g.edge(d, e)))
+	}
+	return paths
+}
@@ -0,0 +1,142 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// testObject 描述手工构造的转储中的一个对象，ptrs 依次存放在偏移量 0、8、16……处。
+type testObject struct {
+	addr uint64
+	size int
+	ptrs []uint64
+}
+
+// newTestDump 构造一个 64 位小端的转储：roots 为 data 段中的全局变量，objs 为堆中的对象。
+func newTestDump(roots []uint64, objs ...testObject) *Dump {
+	d := &Dump{Params: &Params{PtrSize: 8}, typeByAddr: make(map[uint64]*Type)}
+	d.Data = &Segment{Addr: 0x1000}
+	d.Data.Data, d.Data.Fields = ptrWords(len(roots)*8, roots)
+	for _, to := range objs {
+		o := &Object{Addr: to.addr}
+		o.Data, o.Fields = ptrWords(to.size, to.ptrs)
+		d.Objects = append(d.Objects, o)
+	}
+	d.finish()
+	return d
+}
+
+func ptrWords(size int, ptrs []uint64) ([]byte, []Field) {
+	b := make([]byte, size)
+	var fs []Field
+	for i, p := range ptrs {
+		binary.LittleEndian.PutUint64(b[i*8:], p)
+		fs = append(fs, Field{Kind: FieldPtr, Offset: uint64(i * 8)})
+	}
+	return b, fs
+}
+
+func TestDominators(t *testing.T) {
+	const (
+		a = 0x10000 + iota*0x100
+		b
+		c
+		dd
+		e
+		f
+		g
+	)
+	// root -> a -> {b, c} -> dd -> e，root -> f，g 不可达。
+	// 指向对象内部的指针（e+8）同样是指向该对象的边。
+	d := newTestDump([]uint64{a, f, 0, 0x99},
+		testObject{a, 16, []uint64{b, c}},
+		testObject{b, 32, []uint64{dd}},
+		testObject{c, 48, []uint64{dd}},
+		testObject{dd, 64, []uint64{e + 8}},
+		testObject{e, 80, nil},
+		testObject{f, 16, []uint64{0}},
+		testObject{g, 16, []uint64{a}},
+	)
+	obj := func(addr uint64) *Object { return d.FindObject(addr) }
+
+	if n := len(d.Roots()); n != 2 {
+		t.Errorf("len(Roots()) = %d, want 2", n)
+	}
+	tests := []struct {
+		addr      uint64
+		dom       uint64 // 0 表示没有支配对象
+		retained  uint64
+		reachable bool
+	}{
+		{a, 0, 16 + 32 + 48 + 64 + 80, true},
+		{b, a, 32, true},
+		{c, a, 48, true},
+		{dd, a, 64 + 80, true},
+		{e, dd, 80, true},
+		{f, 0, 16, true},
+		{g, 0, 0, false},
+	}
+	for _, tt := range tests {
+		o := obj(tt.addr)
+		if got := d.Reachable(o); got != tt.reachable {
+			t.Errorf("Reachable(%#x) = %v, want %v", tt.addr, got, tt.reachable)
+		}
+		var dom uint64
+		if do := d.Dominator(o); do != nil {
+			dom = do.Addr
+		}
+		if dom != tt.dom {
+			t.Errorf("Dominator(%#x) = %#x, want %#x", tt.addr, dom, tt.dom)
+		}
+		if got := d.Retained(o); got != tt.retained {
+			t.Errorf("Retained(%#x) = %d, want %d", tt.addr, got, tt.retained)
+		}
+	}
+	if got, want := d.TotalRetained(), uint64(16+32+48+64+80+16); got != want {
+		t.Errorf("TotalRetained() = %d, want %d", got, want)
+	}
+
+	// dd 有两个直接引用者，各对应一条最短路径
+	paths := d.PathsTo(obj(dd), 10)
+	if len(paths) != 2 {
+		t.Fatalf("PathsTo(dd) returned %d paths, want 2", len(paths))
+	}
+	for i, via := range []uint64{b, c} {
+		p := paths[i]
+		if len(p) != 3 || p[0].Root == nil || p[0].To.Addr != a || p[1].To.Addr != via || p[2].From.Addr != via {
+			t.Errorf("PathsTo(dd)[%d] does not go root -> a -> %#x -> dd", i, via)
+		}
+	}
+	if edges := d.Referrers(obj(e)); len(edges) != 1 || edges[0].From.Addr != dd || edges[0].ToOffset != 8 {
+		t.Errorf("Referrers(e) = %+v, want a single edge from dd at offset 8", edges)
+	}
+	if paths := d.PathsTo(obj(g), 10); paths != nil {
+		t.Errorf("PathsTo(unreachable) = %v, want nil", paths)
+	}
+}
+
+// 很长的链表不应使深度优先搜索或路径压缩耗尽栈空间。
+func TestDominatorsLongChain(t *testing.T) {
+	const n = 200000
+	objs := make([]testObject, n)
+	for i := range objs {
+		objs[i] = testObject{addr: 0x10000 + uint64(i)*16, size: 16}
+		if i+1 < n {
+			objs[i].ptrs = []uint64{0x10000 + uint64(i+1)*16}
+		}
+	}
+	d := newTestDump([]uint64{0x10000}, objs...)
+	for _, i := range []int{0, 1, n / 2, n - 1} {
+		o := d.Objects[i]
+		if got, want := d.Retained(o), uint64(n-i)*16; got != want {
+			t.Errorf("Retained(node %d) = %d, want %d", i, got, want)
+		}
+		if i > 0 && d.Dominator(o) != d.Objects[i-1] {
+			t.Errorf("Dominator(node %d) is not node %d", i, i-1)
+		}
+	}
+}
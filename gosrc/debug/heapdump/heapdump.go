@@ -0,0 +1,251 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package heapdump 读取 runtime/debug.WriteHeapDump 写出的堆转储，
+// 并在其上构建对象图，用于分析内存泄漏。
+//
+// 堆转储的格式见 https://golang.org/s/go15heapdump，写入方为 runtime/heapdump.go。
+// Read 解析整个转储并返回 Dump，其中包含了堆中的所有对象、goroutine 与栈帧、
+// 全局变量（data 与 bss 段）以及其他根。
+//
+// 在 Dump 之上可以：
+//
+//   - 通过 Roots 与 Edges 遍历对象图；
+//   - 通过 Retained 与 Dominator 计算支配树与对象的保留大小（retained size），
+//     即对象不可达后可以被回收的总字节数；
+//   - 通过 PathsTo 列出从根到某个对象的引用路径；
+//   - 通过 Classes 按类型对对象分组，通过 Diff 比较两个转储。
+//
+// 转储中的对象不带有类型信息。对于设置了 finalizer 的对象，Dump 可以得到其类型；
+// 其他对象只能按大小与指针布局（见 Dump.Class）分组。
+package heapdump
+
+// Dump 为一个解析后的堆转储。
+type Dump struct {
+	Params   *Params
+	MemStats *MemStats
+
+	Objects    []*Object // 堆中的对象，按地址排序
+	Types      []*Type
+	Itabs      []*Itab
+	Goroutines []*Goroutine
+	Frames     []*Frame // 所有 goroutine 的栈帧
+	OSThreads  []*OSThread
+	OtherRoots []*OtherRoot
+	Finalizers []*Finalizer // 包括已经排队等待运行的 finalizer
+	Defers     []*Defer
+	Panics     []*Panic
+
+	Data *Segment // data 段
+	BSS  *Segment // bss 段
+
+	MemProf      []*MemProfBucket
+	AllocSamples []*AllocSample
+
+	typeByAddr map[uint64]*Type
+	objType    map[*Object]string // 已知类型的对象，见 Class
+	graph      *graph             // 对象图，第一次使用时构建
+}
+
+// Params 为转储进程的参数。
+type Params struct {
+	BigEndian  bool
+	PtrSize    uint64
+	HeapStart  uint64 // 堆地址空间的起始地址
+	HeapEnd    uint64 // 堆地址空间的结束地址
+	Arch       string // GOARCH
+	Experiment string // GOEXPERIMENT
+	NCPU       uint64
+}
+
+// FieldKind 为对象中一个字段的种类。
+type FieldKind int
+
+const (
+	FieldPtr   FieldKind = 1 // 指针
+	FieldIface FieldKind = 2 // 非空接口，占两个字
+	FieldEface FieldKind = 3 // 空接口，占两个字
+)
+
+// Field 为对象、栈帧或全局变量中一个包含指针的字段。
+type Field struct {
+	Kind   FieldKind
+	Offset uint64 // 字段在所在内存区域中的偏移量
+}
+
+// Object 为堆中的一个对象。
+type Object struct {
+	Addr   uint64
+	Data   []byte  // 对象的内容
+	Fields []Field // 对象中的指针字段
+
+	index int // 在 Dump.Objects 中的下标
+}
+
+// Size 返回对象的大小，即其所在大小等级的大小。
+func (o *Object) Size() uint64 {
+	return uint64(len(o.Data))
+}
+
+// Segment 为 data 或 bss 段。
+type Segment struct {
+	Addr   uint64
+	Data   []byte
+	Fields []Field
+}
+
+// Type 为转储中记录的一个类型。
+//
+// 运行时只记录了出现在 itab 与 finalizer 中的类型。
+type Type struct {
+	Addr uint64
+	Size uint64
+	Name string // 包路径 + "." + 类型名，或者类型的字面形式
+
+	// Indirect 报告该类型的值存放在接口中时，接口的数据字是否为指向值的指针。
+	Indirect bool
+}
+
+// Itab 为一个 itab，Type 为其动态类型的地址。
+type Itab struct {
+	Addr uint64
+	Type uint64
+}
+
+// Goroutine 为一个 goroutine。
+type Goroutine struct {
+	Addr       uint64 // g 结构的地址
+	SP         uint64 // 栈顶
+	ID         uint64
+	GoPC       uint64 // 创建该 goroutine 的 go 语句的 pc
+	Status     uint64 // g 的状态，参见 runtime 中的 _Grunnable 等常量
+	System     bool   // 是否为运行时创建的系统 goroutine
+	Background bool
+	WaitSince  uint64 // 开始阻塞的时间，近似值
+	WaitReason string
+	Ctxt       uint64 // 调度上下文，通常为闭包
+	M          uint64 // 所在的 m，0 表示没有
+	Defer      uint64 // 最顶层的 defer 记录
+	Panic      uint64 // 最顶层的 panic 记录
+
+	Frames []*Frame // 从栈顶到栈底的栈帧
+}
+
+// Frame 为一个栈帧。
+type Frame struct {
+	SP      uint64 // 栈帧的最低地址
+	Depth   uint64 // 栈帧的深度，0 为栈顶
+	ChildSP uint64 // 被调用者的 sp，栈顶的栈帧为 0
+	Data    []byte // 栈帧的内容
+	Entry   uint64 // 函数的入口地址
+	PC      uint64
+	ContPC  uint64 // 函数继续执行的 pc
+	Name    string // 函数名
+	Fields  []Field
+
+	Goroutine *Goroutine
+}
+
+// OSThread 为一个操作系统线程（m）。
+type OSThread struct {
+	Addr   uint64
+	ID     uint64
+	ProcID uint64
+}
+
+// OtherRoot 为运行时中的其他根。
+type OtherRoot struct {
+	Description string
+	To          uint64
+}
+
+// Finalizer 为一个 finalizer。
+type Finalizer struct {
+	Obj    uint64 // 设置了 finalizer 的对象
+	Fn     uint64 // finalizer 的 funcval
+	Code   uint64 // finalizer 的代码地址
+	FInt   uint64 // finalizer 参数的类型
+	OT     uint64 // 对象指针的类型
+	Queued bool   // 对象已经不可达，finalizer 已经排队等待运行
+}
+
+// 排队等待运行的 cleanup（runtime.AddCleanup）同样记录为 Queued 的 Finalizer，
+// 但它不属于任何对象，Obj、FInt 与 OT 均为 0。
+
+// IsCleanup 报告 f 是否为排队的 cleanup。
+func (f *Finalizer) IsCleanup() bool {
+	return f.Queued && f.Obj == 0 && f.OT == 0
+}
+
+// Defer 为一个 defer 记录。
+type Defer struct {
+	Addr uint64
+	G    uint64
+	SP   uint64
+	PC   uint64
+	Fn   uint64 // funcval
+	Code uint64
+	Link uint64
+}
+
+// Panic 为一个 panic 记录。
+type Panic struct {
+	Addr uint64
+	G    uint64
+	Type uint64 // panic 参数的类型
+	Data uint64 // panic 参数的数据字
+	Link uint64
+}
+
+// MemStats 为转储时的内存统计，字段与 runtime.MemStats 相同。
+type MemStats struct {
+	Alloc        uint64
+	TotalAlloc   uint64
+	Sys          uint64
+	Lookups      uint64
+	Mallocs      uint64
+	Frees        uint64
+	HeapAlloc    uint64
+	HeapSys      uint64
+	HeapIdle     uint64
+	HeapInuse    uint64
+	HeapReleased uint64
+	HeapObjects  uint64
+	StackInuse   uint64
+	StackSys     uint64
+	MSpanInuse   uint64
+	MSpanSys     uint64
+	MCacheInuse  uint64
+	MCacheSys    uint64
+	BuckHashSys  uint64
+	GCSys        uint64
+	OtherSys     uint64
+	NextGC       uint64
+	LastGC       uint64
+	PauseTotalNs uint64
+	PauseNs      [256]uint64
+	NumGC        uint64
+}
+
+// MemProfBucket 为内存 profile 中的一个桶。
+type MemProfBucket struct {
+	Addr   uint64
+	Size   uint64
+	Stack  []MemProfFrame
+	Allocs uint64
+	Frees  uint64
+}
+
+// MemProfFrame 为内存 profile 调用栈中的一帧。
+type MemProfFrame struct {
+	Func string
+	File string
+	Line uint64
+}
+
+// AllocSample 为一个被采样的对象，Bucket 为其分配时所在的 profile 桶。
+type AllocSample struct {
+	Addr   uint64
+	Bucket uint64
+}
@@ -0,0 +1,298 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// 记录的标签，必须与 runtime/heapdump.go 保持一致
+const (
+	tagEOF             = 0
+	tagObject          = 1
+	tagOtherRoot       = 2
+	tagType            = 3
+	tagGoroutine       = 4
+	tagStackFrame      = 5
+	tagParams          = 6
+	tagFinalizer       = 7
+	tagItab            = 8
+	tagOSThread        = 9
+	tagMemStats        = 10
+	tagQueuedFinalizer = 11
+	tagData            = 12
+	tagBSS             = 13
+	tagDefer           = 14
+	tagPanic           = 15
+	tagMemProf         = 16
+	tagAllocSample     = 17
+)
+
+const header = "go1.7 heap dump\n"
+
+// maxBlob 为单个字符串或内存区域的最大长度，用于尽早发现损坏的转储。
+const maxBlob = 1 << 40
+
+// ErrHeader 表示输入不是一个堆转储。
+var ErrHeader = errors.New("heapdump: not a heap dump (bad header)")
+
+// Read 从 r 中读取并解析一个完整的堆转储。
+func Read(r io.Reader) (*Dump, error) {
+	p := &parser{r: bufio.NewReaderSize(r, 64<<10)}
+	d, err := p.parse()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != ErrHeader {
+			err = fmt.Errorf("heapdump: offset %d: %v", p.off, err)
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+type parser struct {
+	r   *bufio.Reader
+	off int64 // 已经读取的字节数，用于错误信息
+	err error // 第一个读取错误
+}
+
+func (p *parser) parse() (*Dump, error) {
+	var hdr [len(header)]byte
+	if _, err := io.ReadFull(p.r, hdr[:]); err != nil || string(hdr[:]) != header {
+		return nil, ErrHeader
+	}
+	p.off = int64(len(header))
+
+	d := &Dump{typeByAddr: make(map[uint64]*Type)}
+	var g *Goroutine // 最近的 goroutine，其后的栈帧属于它
+	for {
+		tag := p.uvarint()
+		if p.err != nil {
+			return nil, p.err
+		}
+		switch tag {
+		case tagEOF:
+			d.finish()
+			return d, nil
+		case tagObject:
+			o := &Object{Addr: p.uvarint(), Data: p.bytes()}
+			o.Fields = p.fields()
+			d.Objects = append(d.Objects, o)
+		case tagOtherRoot:
+			d.OtherRoots = append(d.OtherRoots, &OtherRoot{Description: p.str(), To: p.uvarint()})
+		case tagType:
+			t := &Type{Addr: p.uvarint(), Size: p.uvarint(), Name: p.str(), Indirect: p.bool()}
+			d.Types = append(d.Types, t)
+			d.typeByAddr[t.Addr] = t
+		case tagGoroutine:
+			g = &Goroutine{
+				Addr:       p.uvarint(),
+				SP:         p.uvarint(),
+				ID:         p.uvarint(),
+				GoPC:       p.uvarint(),
+				Status:     p.uvarint(),
+				System:     p.bool(),
+				Background: p.bool(),
+				WaitSince:  p.uvarint(),
+				WaitReason: p.str(),
+				Ctxt:       p.uvarint(),
+				M:          p.uvarint(),
+				Defer:      p.uvarint(),
+				Panic:      p.uvarint(),
+			}
+			d.Goroutines = append(d.Goroutines, g)
+		case tagStackFrame:
+			f := &Frame{
+				SP:      p.uvarint(),
+				Depth:   p.uvarint(),
+				ChildSP: p.uvarint(),
+				Data:    p.bytes(),
+				Entry:   p.uvarint(),
+				PC:      p.uvarint(),
+				ContPC:  p.uvarint(),
+				Name:    p.str(),
+			}
+			f.Fields = p.fields()
+			if g == nil {
+				return nil, errors.New("stack frame before any goroutine")
+			}
+			f.Goroutine = g
+			g.Frames = append(g.Frames, f)
+			d.Frames = append(d.Frames, f)
+		case tagParams:
+			d.Params = &Params{
+				BigEndian:  p.bool(),
+				PtrSize:    p.uvarint(),
+				HeapStart:  p.uvarint(),
+				HeapEnd:    p.uvarint(),
+				Arch:       p.str(),
+				Experiment: p.str(),
+				NCPU:       p.uvarint(),
+			}
+			if s := d.Params.PtrSize; s != 4 && s != 8 {
+				return nil, fmt.Errorf("bad pointer size %d", s)
+			}
+		case tagFinalizer, tagQueuedFinalizer:
+			d.Finalizers = append(d.Finalizers, &Finalizer{
+				Obj:    p.uvarint(),
+				Fn:     p.uvarint(),
+				Code:   p.uvarint(),
+				FInt:   p.uvarint(),
+				OT:     p.uvarint(),
+				Queued: tag == tagQueuedFinalizer,
+			})
+		case tagItab:
+			d.Itabs = append(d.Itabs, &Itab{Addr: p.uvarint(), Type: p.uvarint()})
+		case tagOSThread:
+			d.OSThreads = append(d.OSThreads, &OSThread{Addr: p.uvarint(), ID: p.uvarint(), ProcID: p.uvarint()})
+		case tagMemStats:
+			d.MemStats = p.memStats()
+		case tagData, tagBSS:
+			s := &Segment{Addr: p.uvarint(), Data: p.bytes()}
+			s.Fields = p.fields()
+			if tag == tagData {
+				d.Data = s
+			} else {
+				d.BSS = s
+			}
+		case tagDefer:
+			d.Defers = append(d.Defers, &Defer{
+				Addr: p.uvarint(),
+				G:    p.uvarint(),
+				SP:   p.uvarint(),
+				PC:   p.uvarint(),
+				Fn:   p.uvarint(),
+				Code: p.uvarint(),
+				Link: p.uvarint(),
+			})
+		case tagPanic:
+			pn := &Panic{Addr: p.uvarint(), G: p.uvarint(), Type: p.uvarint(), Data: p.uvarint()}
+			p.uvarint() // was p->defer, no longer recorded
+			pn.Link = p.uvarint()
+			d.Panics = append(d.Panics, pn)
+		case tagMemProf:
+			b := &MemProfBucket{Addr: p.uvarint(), Size: p.uvarint()}
+			n := p.uvarint()
+			for i := uint64(0); i < n && p.err == nil; i++ {
+				b.Stack = append(b.Stack, MemProfFrame{Func: p.str(), File: p.str(), Line: p.uvarint()})
+			}
+			b.Allocs = p.uvarint()
+			b.Frees = p.uvarint()
+			d.MemProf = append(d.MemProf, b)
+		case tagAllocSample:
+			d.AllocSamples = append(d.AllocSamples, &AllocSample{Addr: p.uvarint(), Bucket: p.uvarint()})
+		default:
+			return nil, fmt.Errorf("unknown record tag %d", tag)
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+	}
+}
+
+// finish 在读取完所有记录后检查并整理 d。
+func (d *Dump) finish() {
+	if d.Params == nil {
+		// 没有参数记录的转储只能来自非常旧的运行时，假定为 64 位小端
+		d.Params = &Params{PtrSize: 8}
+	}
+	sort.Slice(d.Objects, func(i, j int) bool {
+		return d.Objects[i].Addr < d.Objects[j].Addr
+	})
+	for i, o := range d.Objects {
+		o.index = i
+	}
+}
+
+func (p *parser) ReadByte() (byte, error) {
+	c, err := p.r.ReadByte()
+	if err == nil {
+		p.off++
+	}
+	return c, err
+}
+
+func (p *parser) uvarint() uint64 {
+	if p.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(p)
+	if err != nil {
+		p.err = err
+	}
+	return v
+}
+
+func (p *parser) bool() bool {
+	return p.uvarint() != 0
+}
+
+func (p *parser) bytes() []byte {
+	n := p.uvarint()
+	if p.err != nil {
+		return nil
+	}
+	if n > maxBlob {
+		p.err = fmt.Errorf("bad length %d", n)
+		return nil
+	}
+	b := make([]byte, n)
+	m, err := io.ReadFull(p.r, b)
+	p.off += int64(m)
+	if err != nil {
+		p.err = err
+		return nil
+	}
+	return b
+}
+
+func (p *parser) str() string {
+	return string(p.bytes())
+}
+
+// fields 读取以 fieldKindEol 结尾的字段列表。
+func (p *parser) fields() []Field {
+	var fs []Field
+	for p.err == nil {
+		kind := p.uvarint()
+		if kind == 0 {
+			break
+		}
+		switch FieldKind(kind) {
+		case FieldPtr, FieldIface, FieldEface:
+		default:
+			if p.err == nil {
+				p.err = fmt.Errorf("unknown field kind %d", kind)
+			}
+			return nil
+		}
+		fs = append(fs, Field{Kind: FieldKind(kind), Offset: p.uvarint()})
+	}
+	return fs
+}
+
+func (p *parser) memStats() *MemStats {
+	s := new(MemStats)
+	for _, f := range []*uint64{
+		&s.Alloc, &s.TotalAlloc, &s.Sys, &s.Lookups, &s.Mallocs, &s.Frees,
+		&s.HeapAlloc, &s.HeapSys, &s.HeapIdle, &s.HeapInuse, &s.HeapReleased, &s.HeapObjects,
+		&s.StackInuse, &s.StackSys, &s.MSpanInuse, &s.MSpanSys, &s.MCacheInuse, &s.MCacheSys,
+		&s.BuckHashSys, &s.GCSys, &s.OtherSys, &s.NextGC, &s.LastGC, &s.PauseTotalNs,
+	} {
+		*f = p.uvarint()
+	}
+	for i := range s.PauseNs {
+		s.PauseNs[i] = p.uvarint()
+	}
+	s.NumGC = p.uvarint()
+	return s
+}
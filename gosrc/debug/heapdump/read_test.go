@@ -0,0 +1,122 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readTestDump 读取 testdata 中 gzip 压缩的转储，见 testdata/list.go。
+func readTestDump(t *testing.T, name string) []byte {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestReadRecorded(t *testing.T) {
+	d, err := Read(bytes.NewReader(readTestDump(t, "list.dump.gz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Params.PtrSize != 8 || d.Params.Arch != "amd64" || d.Params.BigEndian {
+		t.Errorf("Params = %+v, want 64-bit little-endian amd64", *d.Params)
+	}
+	if d.MemStats == nil || d.MemStats.NumGC == 0 {
+		t.Errorf("MemStats = %+v, want at least one GC", d.MemStats)
+	}
+	if d.Data == nil || d.BSS == nil {
+		t.Errorf("missing data or bss segment")
+	}
+	if len(d.Goroutines) == 0 {
+		t.Fatalf("no goroutines")
+	}
+	for _, f := range d.Frames {
+		if f.Goroutine == nil || f.Name == "" {
+			t.Errorf("frame %+v has no goroutine or name", f)
+		}
+	}
+	for i := 1; i < len(d.Objects); i++ {
+		if prev := d.Objects[i-1]; prev.Addr+prev.Size() > d.Objects[i].Addr {
+			t.Fatalf("objects %#x and %#x overlap or are not sorted", prev.Addr, d.Objects[i].Addr)
+		}
+	}
+
+	// 全局变量 list 指向的链表：头结点只被全局变量引用，支配其余两个结点
+	var head *Object
+	for _, r := range d.Roots() {
+		if (r.Kind == RootData || r.Kind == RootBSS) && d.Class(r.To) == "48B ptr(0)" && d.Retained(r.To) == 3*48 {
+			head = r.To
+		}
+	}
+	if head == nil {
+		t.Fatalf("list head not found among global roots")
+	}
+	if dom := d.Dominator(head); dom != nil {
+		t.Errorf("Dominator(head) = %#x, want none", dom.Addr)
+	}
+	o := head
+	for want := uint64(3 * 48); want > 0; want -= 48 {
+		if got := d.Retained(o); got != want {
+			t.Errorf("Retained(%#x) = %d, want %d", o.Addr, got, want)
+		}
+		edges := d.Edges(o)
+		if want == 48 {
+			if len(edges) != 0 {
+				t.Errorf("last node has %d edges, want 0", len(edges))
+			}
+			break
+		}
+		if len(edges) != 1 || edges[0].FromOffset != 0 {
+			t.Fatalf("Edges(%#x) = %+v, want one pointer at offset 0", o.Addr, edges)
+		}
+		next := edges[0].To
+		if dom := d.Dominator(next); dom != o {
+			t.Errorf("Dominator(%#x) = %v, want %#x", next.Addr, dom, o.Addr)
+		}
+		o = next
+	}
+	if paths := d.PathsTo(o, 4); len(paths) != 1 || len(paths[0]) != 3 || paths[0][0].Root == nil {
+		t.Errorf("PathsTo(last node) = %+v, want a single path of 3 edges from a root", paths)
+	}
+
+	var total uint64
+	for _, o := range d.Objects {
+		total += o.Size()
+	}
+	if r := d.TotalRetained(); r == 0 || r > total {
+		t.Errorf("TotalRetained() = %d, want in (0, %d]", r, total)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	if _, err := Read(strings.NewReader("not a heap dump\n")); err != ErrHeader {
+		t.Errorf("Read(bad header) error = %v, want ErrHeader", err)
+	}
+	b := readTestDump(t, "list.dump.gz")
+	if _, err := Read(bytes.NewReader(b[:len(b)/2])); err == nil || !strings.Contains(err.Error(), "unexpected EOF") {
+		t.Errorf("Read(truncated) error = %v, want unexpected EOF", err)
+	}
+	bad := append([]byte(header), 99)
+	if _, err := Read(bytes.NewReader(bad)); err == nil || !strings.Contains(err.Error(), "unknown record tag 99") {
+		t.Errorf("Read(unknown tag) error = %v, want unknown record tag", err)
+	}
+}
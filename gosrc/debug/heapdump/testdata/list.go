@@ -0,0 +1,38 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build ignore
+
+// list.dump.gz 由该程序生成：
+//
+//	go run list.go list.dump && gzip -9 list.dump
+//
+// 全局变量 list 指向一个由 3 个 48 字节的 node 组成的链表。
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+type node struct {
+	next *node
+	buf  [40]byte
+}
+
+var list *node
+
+func main() {
+	for i := 0; i < 3; i++ {
+		list = &node{next: list}
+	}
+	runtime.GC()
+	f, err := os.Create(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	debug.WriteHeapDump(f.Fd())
+	f.Close()
+}
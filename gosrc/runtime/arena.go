@@ -0,0 +1,276 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// arena 包的运行时实现。
+//
+// 一个 arena 由若干个 chunk 组成。每个 chunk 是从堆上分配的一个大对象 span，
+// 大小为 userArenaChunkBytes，arena 在其中以指针碰撞的方式分配对象：
+// 包含指针的对象从 chunk 的低地址向上分配，不包含指针的对象从高地址向下分配。
+//
+// 对垃圾回收器而言，chunk 是一个普通的大对象。指向 chunk 中任何对象的指针都会使
+// 整个 chunk 存活，扫描 chunk 时从起始地址开始，直到第一个 scan 位为 0 的字为止。
+// 为此，分配在 chunk 中的对象的堆位图与普通对象不同：对象中所有字的 scan 位都被置位
+// （见 spanHeapBitsSetType），因此扫描会一直进行到最后一个包含指针的对象的末尾，
+// 而不会在第一个对象的末尾停止。
+//
+// Free 之后，chunk 被重新映射为不可访问（sysFault），此后对其中对象的任何访问都会
+// 立即产生段错误，而不是悄悄地读写已经被重新使用的内存。chunk 仍然作为一个大对象留在堆上，
+// 直到垃圾回收器确认没有指针再指向它时，清扫器才重新映射这段内存并将其归还给堆。
+// 由于垃圾回收器在标记期间可能正在扫描 chunk，在标记期间被释放的 chunk
+// 会被放入 userArenaState.fault，等到标记结束时再设置为不可访问。
+
+package runtime
+
+import (
+	"runtime/internal/math"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+const (
+	// userArenaChunkBytes 为 arena 每个 chunk 的大小，必须为页大小的整数倍
+	userArenaChunkBytes = 8 << 20
+
+	// userArenaChunkPages 为 chunk 的页数
+	userArenaChunkPages = userArenaChunkBytes / _PageSize
+
+	// userArenaChunkMaxAllocBytes 为 arena 中单次分配的最大字节数。
+	// 更大的分配直接在堆上进行，以免浪费 chunk 中剩余的空间。
+	userArenaChunkMaxAllocBytes = userArenaChunkBytes / 4
+)
+
+// userArena 为 arena.Arena 的运行时表示。
+//
+// userArena 不是并发安全的，调用者必须保证同一时间只有一个 goroutine 使用它。
+type userArena struct {
+	// chunks 为 arena 所有 chunk 的起始地址。这些指针使 chunk 在 arena 存活期间保持存活，
+	// 最后一个为当前用于分配的 chunk。
+	chunks []unsafe.Pointer
+
+	// active 为当前用于分配的 chunk，[lo, hi) 为其中还没有被分配的部分
+	active *mspan
+	lo, hi uintptr
+}
+
+var userArenaState struct {
+	lock mutex
+
+	// fault 为在标记期间被释放、等待设置为不可访问的 chunk
+	fault mSpanList
+}
+
+// newUserArena 创建一个新的 arena。
+func newUserArena() *userArena {
+	return new(userArena)
+}
+
+// new 在 a 中分配 n 个类型为 typ 的值，返回指向第一个值的指针。分配的内存已经清零。
+func (a *userArena) new(typ *_type, n uintptr) unsafe.Pointer {
+	size, overflow := math.MulUintptr(typ.size, n)
+	if overflow || size > maxAlloc {
+		panic(plainError("arena: allocation size out of range"))
+	}
+	if size == 0 {
+		return unsafe.Pointer(&zerobase)
+	}
+	if size > userArenaChunkMaxAllocBytes {
+		return mallocgc(size, typ, true)
+	}
+
+	for {
+		if p := a.alloc(typ, size, n); p != nil {
+			return p
+		}
+		a.refill()
+	}
+}
+
+// alloc 尝试在当前 chunk 中分配 size 字节，空间不足时返回 nil。
+func (a *userArena) alloc(typ *_type, size, n uintptr) unsafe.Pointer {
+	if a.active == nil {
+		return nil
+	}
+	if typ.kind&kindNoPointers != 0 {
+		// 不包含指针的对象从 chunk 的末尾向下分配，不需要堆位图
+		align := uintptr(typ.align)
+		if align == 0 {
+			align = 1
+		}
+		if a.hi-a.lo < size {
+			return nil
+		}
+		x := (a.hi - size) &^ (align - 1)
+		if x < a.lo {
+			return nil
+		}
+		a.hi = x
+		return unsafe.Pointer(x)
+	}
+
+	// 包含指针的对象对齐到一个堆位图字节，使不同对象的位图不共享字节
+	x := round(a.lo, wordsPerBitmapByte*sys.PtrSize)
+	if x > a.hi || a.hi-x < size {
+		return nil
+	}
+	spanHeapBitsSetType(a.active, a.lo, x, typ, n)
+	a.lo = x + size
+
+	// 确保在对象可以被其他 goroutine 看到之前，堆位图已经写入
+	publicationBarrier()
+	return unsafe.Pointer(x)
+}
+
+// refill 为 a 分配一个新的 chunk 并将其设置为当前 chunk。
+// 旧的 chunk 中剩余的空间不再使用。
+func (a *userArena) refill() {
+	s := newUserArenaChunk()
+	a.chunks = append(a.chunks, unsafe.Pointer(s.base()))
+	a.active = s
+	a.lo = s.base()
+	a.hi = s.base() + userArenaChunkBytes
+}
+
+// free 释放 a 的所有 chunk。此后 a 不能再被使用。
+func (a *userArena) free() {
+	for _, p := range a.chunks {
+		freeUserArenaChunk(spanOfHeap(uintptr(p)))
+	}
+	a.chunks = nil
+	a.active = nil
+	a.lo, a.hi = 0, 0
+}
+
+// newUserArenaChunk 从堆上分配一个 chunk。
+func newUserArenaChunk() *mspan {
+	if gcphase == _GCmarktermination {
+		throw("newUserArenaChunk called with gcphase == _GCmarktermination")
+	}
+
+	// 与 mallocgc 一样，为这次分配向 GC 偿还辅助标记的债务
+	if gcBlackenEnabled != 0 {
+		assistG := getg()
+		if assistG.m.curg != nil {
+			assistG = assistG.m.curg
+		}
+		assistG.gcAssistBytes -= userArenaChunkBytes
+		if assistG.gcAssistBytes < 0 {
+			gcAssistAlloc(assistG)
+		}
+	}
+
+	deductSweepCredit(userArenaChunkBytes, userArenaChunkPages)
+	s := mheap_.alloc(userArenaChunkPages, makeSpanClass(0, false), true, true)
+	if s == nil {
+		throw("out of memory")
+	}
+	s.limit = s.base() + userArenaChunkBytes
+	s.isUserArenaChunk = true
+	heapBitsForAddr(s.base()).initSpan(s)
+	// 与 mallocgc 中的大对象一样，整个 chunk 为 span 中唯一的对象，
+	// 否则清扫已经标记的 chunk 时会认为分配数增加了
+	s.freeindex = 1
+	s.allocCount = 1
+
+	mp := acquirem()
+	if gcphase != _GCoff {
+		// 标记期间分配的对象为黑色，见 mallocgc
+		gcmarknewobject(s.base(), s.elemsize, 0)
+	}
+	releasem(mp)
+
+	// 整个 chunk 计入分配它的 goroutine
+	chargeAlloc(userArenaChunkBytes)
+
+	if t := (gcTrigger{kind: gcTriggerHeap}); t.test() {
+		gcStart(t)
+	}
+	return s
+}
+
+// freeUserArenaChunk 释放 chunk s，将其设置为不可访问。
+func freeUserArenaChunk(s *mspan) {
+	if s == nil || !s.isUserArenaChunk {
+		throw("freeUserArenaChunk: not an arena chunk")
+	}
+
+	// 禁止抢占，使 GC 不能在下面的检查与修改之间开始
+	mp := acquirem()
+	if gcphase == _GCoff {
+		s.setUserArenaChunkToFault()
+	} else {
+		lock(&userArenaState.lock)
+		userArenaState.fault.insert(s)
+		unlock(&userArenaState.lock)
+	}
+	releasem(mp)
+}
+
+// setUserArenaChunkToFault 将 chunk s 重新映射为不可访问。
+//
+// 调用时 GC 不能处于标记阶段：s 被改为 noscan，使之后的 GC 只标记而不扫描它。
+// 清扫器对大对象 span 并不区分 scan 与 noscan，因此与清扫并发修改 spanclass 是安全的。
+func (s *mspan) setUserArenaChunkToFault() {
+	s.spanclass = makeSpanClass(0, true)
+	s.userArenaFaulted = true
+	sysFault(unsafe.Pointer(s.base()), s.npages<<_PageShift)
+}
+
+// userArenaFaultPending 将在标记期间被释放的 chunk 设置为不可访问。
+// 在标记结束时、世界停止期间调用。
+func userArenaFaultPending() {
+	lock(&userArenaState.lock)
+	for s := userArenaState.fault.first; s != nil; s = userArenaState.fault.first {
+		userArenaState.fault.remove(s)
+		s.setUserArenaChunkToFault()
+	}
+	unlock(&userArenaState.lock)
+}
+
+//go:linkname arena_runtime_newArena arena.runtime_newArena
+func arena_runtime_newArena() unsafe.Pointer {
+	return unsafe.Pointer(newUserArena())
+}
+
+// arena_runtime_arenaNew 在 arena 中分配一个值，ptr 的类型必须为 **T。
+//
+//go:linkname arena_runtime_arenaNew arena.runtime_arenaNew
+func arena_runtime_arenaNew(arena unsafe.Pointer, ptr interface{}) {
+	e := efaceOf(&ptr)
+	t := e._type
+	if t == nil || t.kind&kindMask != kindPtr || e.data == nil {
+		panic(plainError("arena.New: argument must be a non-nil **T"))
+	}
+	pt := (*ptrtype)(unsafe.Pointer(t)).elem
+	if pt.kind&kindMask != kindPtr {
+		panic(plainError("arena.New: argument is " + t.string() + ", not **T"))
+	}
+	typ := (*ptrtype)(unsafe.Pointer(pt)).elem
+	*(*unsafe.Pointer)(e.data) = (*userArena)(arena).new(typ, 1)
+}
+
+// arena_runtime_arenaSlice 在 arena 中分配一个切片，s 的类型必须为 *[]T。
+//
+//go:linkname arena_runtime_arenaSlice arena.runtime_arenaSlice
+func arena_runtime_arenaSlice(arena unsafe.Pointer, s interface{}, len, cap int) {
+	e := efaceOf(&s)
+	t := e._type
+	if t == nil || t.kind&kindMask != kindPtr || e.data == nil {
+		panic(plainError("arena.MakeSlice: argument must be a non-nil *[]T"))
+	}
+	st := (*ptrtype)(unsafe.Pointer(t)).elem
+	if st.kind&kindMask != kindSlice {
+		panic(plainError("arena.MakeSlice: argument is " + t.string() + ", not *[]T"))
+	}
+	if len < 0 || len > cap {
+		panicmakeslicelen()
+	}
+	typ := (*slicetype)(unsafe.Pointer(st)).elem
+	p := (*userArena)(arena).new(typ, uintptr(cap))
+	*(*slice)(e.data) = slice{p, len, cap}
+}
+
+//go:linkname arena_runtime_freeArena arena.runtime_freeArena
+func arena_runtime_freeArena(arena unsafe.Pointer) {
+	(*userArena)(arena).free()
+}
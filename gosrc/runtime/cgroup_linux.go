@@ -0,0 +1,453 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import "unsafe"
+
+// cgroup CPU 限制
+//
+// sched_getaffinity 只反映了进程可以在哪些 CPU 上运行，而容器通常通过 cgroup 的
+// CPU 带宽控制来限制进程可以使用的 CPU 时间：
+//
+//   cgroup v1: cpu.cfs_quota_us / cpu.cfs_period_us，quota 为 -1 表示不限制
+//   cgroup v2: cpu.max，内容为 "$MAX $PERIOD"，$MAX 为 "max" 表示不限制
+//
+// 进程所在的 cgroup 由 /proc/self/cgroup 给出，其中的路径相对于层级的根。
+// 层级挂载在哪里、挂载的是层级中的哪个目录，则由 /proc/self/mountinfo 给出：
+// v1 的 cpu 控制器可能与其他控制器挂载在一起（例如 cpu,cpuacct 或 cpuacct,cpu），
+// 容器中挂载的通常也不是层级的根。cgroup 的目录为挂载点加上路径中挂载根之后的部分。
+// 在使用 cgroup namespace 等路径无法对应的情况下，挂载点本身即为容器的 cgroup。
+//
+// 限制可以设置在任意一级祖先上，因此从叶子开始一直向上读取到挂载点，取其中最小的那个。
+//
+// 这些函数在启动阶段（mallocinit 之后）和 maxprocs helper goroutine 中调用，
+// 只使用栈上的缓冲区，不分配内存。
+
+const (
+	cgroupPathMax = 1024 // 路径缓冲区的大小
+	cgroupFileMax = 4096 // 读取 cgroup 文件的缓冲区大小
+)
+
+var (
+	cgroupProcSelf  = "/proc/self/cgroup"
+	cgroupMountInfo = "/proc/self/mountinfo"
+)
+
+// cgroupCPULimit 返回当前进程的 cgroup CPU 限制，以 CPU 个数计，可能为小数。
+// 若没有设置限制或无法读取，ok 为 false。
+func cgroupCPULimit() (limit float64, ok bool) {
+	return cgroupCPULimitAt(cgroupProcSelf, cgroupMountInfo)
+}
+
+// cgroupCPULimitAt 与 cgroupCPULimit 相同，但从给定的 /proc/self/cgroup 与
+// /proc/self/mountinfo 文件读取，以便使用伪造的 cgroupfs 目录树进行测试。
+func cgroupCPULimitAt(procSelfCgroup, mountInfo string) (limit float64, ok bool) {
+	// 每一行的格式为 hierarchy-ID:controller-list:cgroup-path。
+	// 优先使用 v1 的 cpu 控制器，否则使用 v2 的统一层级（ID 为 0，控制器列表为空）。
+	var path [cgroupPathMax]byte
+	npath := -1
+	v1 := false
+	r := cgroupLineReader{fd: cgroupOpen(procSelfCgroup, "", "")}
+	if r.fd < 0 {
+		return 0, false
+	}
+	for {
+		line, more := r.next()
+		if !more {
+			break
+		}
+		i := cgroupIndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		j := cgroupIndexByte(line[i+1:], ':')
+		if j < 0 {
+			continue
+		}
+		id, ctl, p := line[:i], line[i+1:i+1+j], line[i+2+j:]
+		if len(p) > len(path) {
+			continue
+		}
+		if cgroupHasController(ctl, "cpu") {
+			v1 = true
+			npath = copy(path[:], p)
+			break
+		}
+		if len(ctl) == 0 && len(id) == 1 && id[0] == '0' {
+			npath = copy(path[:], p)
+		}
+	}
+	closefd(r.fd)
+	if npath < 0 {
+		return 0, false
+	}
+
+	var dir [cgroupPathMax]byte
+	mount, end := cgroupFindMount(mountInfo, v1, path[:npath], dir[:])
+	if mount < 0 {
+		return 0, false
+	}
+	// 去掉末尾的 '/'，例如路径为 "/" 时
+	for end > mount && dir[end-1] == '/' {
+		end--
+	}
+
+	// 从叶子向上读取，取最小的限制
+	for {
+		var l float64
+		var found bool
+		if v1 {
+			l, found = cgroupReadV1Limit(dir[:end])
+		} else {
+			l, found = cgroupReadV2Limit(dir[:end])
+		}
+		if found && (!ok || l < limit) {
+			limit, ok = l, true
+		}
+		if end <= mount {
+			break
+		}
+		// 去掉最后一级目录
+		for end > mount && dir[end-1] != '/' {
+			end--
+		}
+		if end > mount {
+			end--
+		}
+	}
+	return limit, ok
+}
+
+// cgroupFindMount 在 mountinfo 中查找 cgroup 所在层级的挂载（v1 为带有 cpu 控制器的
+// cgroup 挂载，否则为 cgroup2 挂载），并将 path 对应的目录写入 dir。
+// 返回挂载点的长度 mount 与整个目录的长度 end；没有找到时 mount 为 -1。
+//
+// mountinfo 每一行的格式为
+//
+//	mount-ID parent-ID major:minor root mount-point options [optional-fields...] - fstype source super-options
+//
+// 其中 root 为挂载的层级中的目录，路径中的空白字符被转义为 \ooo。
+func cgroupFindMount(mountInfo string, v1 bool, path, dir []byte) (mount, end int) {
+	r := cgroupLineReader{fd: cgroupOpen(mountInfo, "", "")}
+	if r.fd < 0 {
+		return -1, 0
+	}
+
+	mount = -1
+	for {
+		line, more := r.next()
+		if !more {
+			break
+		}
+		var fields [5][]byte
+		n := 0
+		for n < len(fields) {
+			line = cgroupSkipSpace(line)
+			i := cgroupIndexByte(line, ' ')
+			if i < 0 {
+				i = len(line)
+			}
+			fields[n], line = line[:i], line[i:]
+			n++
+		}
+		if n < len(fields) || len(fields[4]) == 0 {
+			continue
+		}
+		// 跳过可选字段，直到分隔符 "-"
+		sep := false
+		for len(line) > 0 && !sep {
+			line = cgroupSkipSpace(line)
+			i := cgroupIndexByte(line, ' ')
+			if i < 0 {
+				i = len(line)
+			}
+			sep = string(line[:i]) == "-"
+			line = line[i:]
+		}
+		if !sep {
+			continue
+		}
+		var tail [3][]byte // fstype source super-options
+		for k := range tail {
+			line = cgroupSkipSpace(line)
+			i := cgroupIndexByte(line, ' ')
+			if i < 0 {
+				i = len(line)
+			}
+			tail[k], line = line[:i], line[i:]
+		}
+		if v1 {
+			if string(tail[0]) != "cgroup" || !cgroupHasController(tail[2], "cpu") {
+				continue
+			}
+		} else if string(tail[0]) != "cgroup2" {
+			continue
+		}
+
+		var root [cgroupPathMax]byte
+		nroot := cgroupUnescape(root[:], fields[3])
+		m := cgroupUnescape(dir, fields[4])
+		if nroot < 0 || m < 0 {
+			continue
+		}
+		rel, matched := cgroupRelPath(path, root[:nroot])
+		if !matched && mount >= 0 {
+			// 已经有一个挂载了，只有路径对应的挂载才更好
+			continue
+		}
+		if m+len(rel) > len(dir) {
+			continue
+		}
+		mount, end = m, m+copy(dir[m:], rel)
+		if matched {
+			break
+		}
+	}
+	closefd(r.fd)
+	return mount, end
+}
+
+// cgroupRelPath 返回 cgroup 路径 path 在根为 root 的挂载下的相对路径。
+// 若 path 不在 root 之下，返回空路径（即挂载点本身）与 false。
+func cgroupRelPath(path, root []byte) ([]byte, bool) {
+	for len(root) > 0 && root[len(root)-1] == '/' {
+		root = root[:len(root)-1]
+	}
+	if len(path) < len(root) || string(path[:len(root)]) != string(root) {
+		return nil, false
+	}
+	rel := path[len(root):]
+	if len(rel) > 0 && rel[0] != '/' {
+		// 例如 root 为 /a，path 为 /ab
+		return nil, false
+	}
+	return rel, true
+}
+
+// cgroupUnescape 将 mountinfo 中的路径 src 去掉 \ooo 形式的转义后写入 dst，
+// 返回写入的字节数，dst 不够大时返回 -1。
+func cgroupUnescape(dst, src []byte) int {
+	n := 0
+	for i := 0; i < len(src); i++ {
+		if n == len(dst) {
+			return -1
+		}
+		c := src[i]
+		if c == '\\' && i+3 < len(src) && cgroupIsOctal(src[i+1]) && cgroupIsOctal(src[i+2]) && cgroupIsOctal(src[i+3]) {
+			c = (src[i+1]-'0')<<6 | (src[i+2]-'0')<<3 | (src[i+3] - '0')
+			i += 3
+		}
+		dst[n] = c
+		n++
+	}
+	return n
+}
+
+func cgroupIsOctal(c byte) bool {
+	return '0' <= c && c <= '7'
+}
+
+// cgroupLineReader 逐行读取一个文件，只使用固定大小的缓冲区。
+// 超出缓冲区的行被丢弃。
+type cgroupLineReader struct {
+	fd         int32
+	buf        [cgroupFileMax]byte
+	start, end int  // buf[start:end] 为尚未返回的数据
+	eof        bool // 文件已经读完
+	skip       bool // 正在丢弃一个过长的行
+}
+
+// next 返回下一行（不含换行符），返回的切片在下一次调用 next 之前有效。
+// 读完或出错时 more 为 false。
+func (r *cgroupLineReader) next() (line []byte, more bool) {
+	for {
+		if i := cgroupIndexByte(r.buf[r.start:r.end], '\n'); i >= 0 {
+			line = r.buf[r.start : r.start+i]
+			r.start += i + 1
+			if r.skip {
+				r.skip = false
+				continue
+			}
+			return line, true
+		}
+		if r.eof {
+			if r.start == r.end || r.skip {
+				return nil, false
+			}
+			line = r.buf[r.start:r.end]
+			r.start = r.end
+			return line, true
+		}
+		if r.start == 0 && r.end == len(r.buf) {
+			// 一整个缓冲区都没有换行符，丢弃这一行
+			r.end = 0
+			r.skip = true
+		}
+		r.end = copy(r.buf[:], r.buf[r.start:r.end])
+		r.start = 0
+		n := read(r.fd, unsafe.Pointer(&r.buf[r.end]), int32(len(r.buf)-r.end))
+		if n <= 0 {
+			r.eof = true
+			continue
+		}
+		r.end += int(n)
+	}
+}
+
+// cgroupReadV1Limit 读取 dir 中 cgroup v1 的 CPU 带宽限制。
+func cgroupReadV1Limit(dir []byte) (float64, bool) {
+	var buf [64]byte
+	n := cgroupReadFile(slicebytetostringtmp(dir), "/", "cpu.cfs_quota_us", buf[:])
+	if n <= 0 {
+		return 0, false
+	}
+	quota, ok := cgroupParseInt(cgroupTrimSpace(buf[:n]))
+	if !ok || quota <= 0 {
+		// -1 表示不限制
+		return 0, false
+	}
+	n = cgroupReadFile(slicebytetostringtmp(dir), "/", "cpu.cfs_period_us", buf[:])
+	if n <= 0 {
+		return 0, false
+	}
+	period, ok := cgroupParseInt(cgroupTrimSpace(buf[:n]))
+	if !ok || period <= 0 {
+		return 0, false
+	}
+	return float64(quota) / float64(period), true
+}
+
+// cgroupReadV2Limit 读取 dir 中 cgroup v2 的 cpu.max。
+func cgroupReadV2Limit(dir []byte) (float64, bool) {
+	var buf [64]byte
+	n := cgroupReadFile(slicebytetostringtmp(dir), "/", "cpu.max", buf[:])
+	if n <= 0 {
+		return 0, false
+	}
+	data := cgroupTrimSpace(buf[:n])
+	i := cgroupIndexByte(data, ' ')
+	if i < 0 {
+		return 0, false
+	}
+	max, periodStr := data[:i], data[i+1:]
+	if string(max) == "max" {
+		return 0, false
+	}
+	quota, ok := cgroupParseInt(max)
+	if !ok || quota <= 0 {
+		return 0, false
+	}
+	period, ok := cgroupParseInt(periodStr)
+	if !ok || period <= 0 {
+		return 0, false
+	}
+	return float64(quota) / float64(period), true
+}
+
+// cgroupOpen 以只读方式打开文件 dir+sep+name，失败时返回 -1。
+func cgroupOpen(dir, sep, name string) int32 {
+	var path [cgroupPathMax]byte
+	if len(dir)+len(sep)+len(name)+1 > len(path) {
+		return -1
+	}
+	n := copy(path[:], dir)
+	n += copy(path[n:], sep)
+	n += copy(path[n:], name)
+	path[n] = 0
+	return open(&path[0], _O_RDONLY, 0)
+}
+
+// cgroupReadFile 读取文件 dir+sep+name 的内容到 buf 中，返回读取的字节数，失败时返回 -1。
+func cgroupReadFile(dir, sep, name string, buf []byte) int {
+	fd := cgroupOpen(dir, sep, name)
+	if fd < 0 {
+		return -1
+	}
+	total := 0
+	for total < len(buf) {
+		r := read(fd, unsafe.Pointer(&buf[total]), int32(len(buf)-total))
+		if r < 0 {
+			closefd(fd)
+			return -1
+		}
+		if r == 0 {
+			break
+		}
+		total += int(r)
+	}
+	closefd(fd)
+	return total
+}
+
+// cgroupHasController 报告逗号分隔的控制器列表 list 中是否包含 name。
+func cgroupHasController(list []byte, name string) bool {
+	for len(list) > 0 {
+		item := list
+		if i := cgroupIndexByte(list, ','); i >= 0 {
+			item, list = list[:i], list[i+1:]
+		} else {
+			list = nil
+		}
+		if string(item) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func cgroupIndexByte(b []byte, c byte) int {
+	for i, x := range b {
+		if x == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func cgroupSkipSpace(b []byte) []byte {
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\t') {
+		b = b[1:]
+	}
+	return b
+}
+
+func cgroupTrimSpace(b []byte) []byte {
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\n' || b[0] == '\t') {
+		b = b[1:]
+	}
+	for len(b) > 0 && (b[len(b)-1] == ' ' || b[len(b)-1] == '\n' || b[len(b)-1] == '\t') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// cgroupParseInt 解析一个十进制整数，允许前导的 '-'。
+func cgroupParseInt(b []byte) (int64, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	neg := false
+	if b[0] == '-' {
+		neg = true
+		b = b[1:]
+		if len(b) == 0 {
+			return 0, false
+		}
+	}
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		if n > (1<<63-1)/10 {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
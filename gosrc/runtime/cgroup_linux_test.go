@@ -0,0 +1,181 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeCgroupFS 在临时目录中构造 /proc/self/cgroup、/proc/self/mountinfo
+// 以及 cgroupfs 目录树。files 的键为相对于临时目录的路径。
+// mountinfo 中的 $ROOT 被替换为（转义后的）临时目录。
+func fakeCgroupFS(t *testing.T, procSelfCgroup, mountinfo string, files map[string]string) (cgroupFile, mountinfoFile string) {
+	dir, err := ioutil.TempDir("", "cgroup test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 临时目录的名字带有空格，以检查 mountinfo 中的转义
+	escaped := strings.Replace(dir, " ", `\040`, -1)
+	mountinfo = strings.Replace(mountinfo, "$ROOT", escaped, -1)
+
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	for name, data := range files {
+		write(name, data)
+	}
+	return write("proc/self/cgroup", procSelfCgroup), write("proc/self/mountinfo", mountinfo)
+}
+
+func TestCgroupCPULimit(t *testing.T) {
+	const otherMounts = "22 1 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw\n" +
+		"25 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n"
+
+	tests := []struct {
+		name      string
+		cgroup    string
+		mountinfo string
+		files     map[string]string
+		limit     float64
+		ok        bool
+	}{
+		{
+			name:   "v1",
+			cgroup: "5:memory:/docker/abc\n4:cpuacct,cpu:/docker/abc\n1:name=systemd:/docker/abc\n",
+			mountinfo: otherMounts +
+				"30 25 0:26 / $ROOT/sys/fs/cgroup/cpu,cpuacct rw,nosuid shared:7 - cgroup cgroup rw,cpuacct,cpu\n" +
+				"31 25 0:27 / $ROOT/sys/fs/cgroup/memory rw,nosuid shared:8 - cgroup cgroup rw,memory\n",
+			files: map[string]string{
+				"sys/fs/cgroup/cpu,cpuacct/docker/abc/cpu.cfs_quota_us":  "150000\n",
+				"sys/fs/cgroup/cpu,cpuacct/docker/abc/cpu.cfs_period_us": "100000\n",
+			},
+			limit: 1.5,
+			ok:    true,
+		},
+		{
+			name:   "v1 ancestor",
+			cgroup: "4:cpu,cpuacct:/docker/abc\n",
+			mountinfo: otherMounts +
+				"30 25 0:26 / $ROOT/cg rw,nosuid - cgroup cgroup rw,cpu,cpuacct\n",
+			files: map[string]string{
+				"cg/docker/abc/cpu.cfs_quota_us":  "-1\n",
+				"cg/docker/abc/cpu.cfs_period_us": "100000\n",
+				"cg/docker/cpu.cfs_quota_us":      "200000\n",
+				"cg/docker/cpu.cfs_period_us":     "100000\n",
+			},
+			limit: 2,
+			ok:    true,
+		},
+		{
+			// 容器中挂载的是层级中的 /docker/abc，而不是层级的根
+			name:   "v1 non-root mount",
+			cgroup: "4:cpu,cpuacct:/docker/abc/sub\n",
+			mountinfo: otherMounts +
+				"30 25 0:26 /docker/abc $ROOT/cg ro,nosuid master:7 - cgroup cgroup rw,cpu,cpuacct\n",
+			files: map[string]string{
+				"cg/sub/cpu.cfs_quota_us":  "50000\n",
+				"cg/sub/cpu.cfs_period_us": "100000\n",
+			},
+			limit: 0.5,
+			ok:    true,
+		},
+		{
+			name:   "v1 unlimited",
+			cgroup: "4:cpu,cpuacct:/\n",
+			mountinfo: otherMounts +
+				"30 25 0:26 / $ROOT/cg rw,nosuid - cgroup cgroup rw,cpu,cpuacct\n",
+			files: map[string]string{
+				"cg/cpu.cfs_quota_us":  "-1\n",
+				"cg/cpu.cfs_period_us": "100000\n",
+			},
+		},
+		{
+			name:   "v2",
+			cgroup: "0::/user.slice/app\n",
+			mountinfo: otherMounts +
+				"35 25 0:30 / $ROOT/unified rw,nosuid shared:9 - cgroup2 cgroup2 rw,nsdelegate\n",
+			files: map[string]string{
+				"unified/user.slice/app/cpu.max": "250000 100000\n",
+				"unified/user.slice/cpu.max":     "max 100000\n",
+			},
+			limit: 2.5,
+			ok:    true,
+		},
+		{
+			// 超出读取缓冲区的行被跳过，不影响之后的行
+			name:   "v2 long mountinfo line",
+			cgroup: "0::/app\n",
+			mountinfo: otherMounts +
+				"40 25 0:40 / /mnt/overlay rw - overlay overlay rw,lowerdir=" + strings.Repeat("/layer:", 1000) + "/layer\n" +
+				"35 25 0:30 / $ROOT/unified rw,nosuid - cgroup2 cgroup2 rw\n",
+			files: map[string]string{
+				"unified/app/cpu.max": "300000 100000\n",
+			},
+			limit: 3,
+			ok:    true,
+		},
+		{
+			name:   "v2 max",
+			cgroup: "0::/user.slice/app\n",
+			mountinfo: otherMounts +
+				"35 25 0:30 / $ROOT/unified rw,nosuid - cgroup2 cgroup2 rw\n",
+			files: map[string]string{
+				"unified/user.slice/app/cpu.max": "max 100000\n",
+			},
+		},
+		{
+			// cgroup namespace 中路径无法对应，挂载点本身即为容器的 cgroup
+			name:   "v2 namespace",
+			cgroup: "0::/../../outside\n",
+			mountinfo: otherMounts +
+				"35 25 0:30 /inside $ROOT/unified rw,nosuid - cgroup2 cgroup2 rw\n",
+			files: map[string]string{
+				"unified/cpu.max": "100000 100000\n",
+			},
+			limit: 1,
+			ok:    true,
+		},
+		{
+			name:   "missing quota",
+			cgroup: "0::/app\n",
+			mountinfo: otherMounts +
+				"35 25 0:30 / $ROOT/unified rw,nosuid - cgroup2 cgroup2 rw\n",
+		},
+		{
+			name:      "no cgroup mount",
+			cgroup:    "4:cpu,cpuacct:/docker/abc\n",
+			mountinfo: otherMounts,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cgroup, mountinfo := fakeCgroupFS(t, tt.cgroup, tt.mountinfo, tt.files)
+			defer os.RemoveAll(filepath.Dir(filepath.Dir(filepath.Dir(cgroup))))
+
+			limit, ok := runtime.CgroupCPULimitAt(cgroup, mountinfo)
+			if ok != tt.ok || limit != tt.limit {
+				t.Errorf("CgroupCPULimitAt() = %v, %v, want %v, %v", limit, ok, tt.limit, tt.ok)
+			}
+		})
+	}
+
+	t.Run("missing files", func(t *testing.T) {
+		if limit, ok := runtime.CgroupCPULimitAt("/nonexistent/cgroup", "/nonexistent/mountinfo"); ok {
+			t.Errorf("CgroupCPULimitAt() = %v, true, want false", limit)
+		}
+	})
+}
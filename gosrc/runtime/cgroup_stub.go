@@ -0,0 +1,12 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package runtime
+
+// cgroupCPULimit 在没有 cgroup 的系统上总是报告没有限制。
+func cgroupCPULimit() (limit float64, ok bool) {
+	return 0, false
+}
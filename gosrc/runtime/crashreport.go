@@ -0,0 +1,433 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 崩溃报告
+//
+// 进程因 fatalpanic、throw 或致命信号而崩溃时，panic 消息与 traceback 通过 print 写到 fd 2。
+// 在 systemd 或日志会被轮转的容器中，这些输出很容易丢失，因此：
+//
+// 1. runtime/debug.SetCrashOutput 设置一个额外的文件描述符 crashFD，
+//    崩溃期间（m.dying 或 m.throwing 非零）写到 fd 2 的内容会同时写入该文件，见 writeErr。
+//
+// 2. GOTRACEBACK=json 时，panic 消息、信号信息以及 traceback 不再以文本格式打印，
+//    而是由 printCrashReport 输出一个占据一行的 JSON 对象，便于机器解析。
+//
+// 崩溃报告在系统栈上、世界被冻结的情况下打印，因此不能分配内存，也不能有写屏障，
+// 所有输出都直接经由 print 完成。
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// crashFD 为 debug.SetCrashOutput 设置的文件描述符，原子访问。
+// ^uintptr(0) 表示没有设置。
+var crashFD = ^uintptr(0)
+
+// setCrashFD 设置 crashFD，返回之前的值，由调用方负责关闭。
+//
+//go:linkname setCrashFD runtime/debug.setCrashFD
+func setCrashFD(fd uintptr) uintptr {
+	return atomic.Xchguintptr(&crashFD, fd)
+}
+
+// writeCrashOutput 在进程崩溃期间将 b 同时写入 crashFD
+func writeCrashOutput(b []byte) {
+	gp := getg()
+	if gp != nil && gp.m.dying == 0 && gp.m.throwing == 0 || gp == nil && atomic.Load(&panicking) == 0 {
+		return
+	}
+	if fd := atomic.Loaduintptr(&crashFD); fd != ^uintptr(0) {
+		write(fd, unsafe.Pointer(&b[0]), int32(len(b)))
+	}
+}
+
+// crashJSON 报告是否需要输出 JSON 格式的崩溃报告，即 GOTRACEBACK=json
+//
+//go:nosplit
+func crashJSON() bool {
+	return atomic.Load(&traceback_cache)&tracebackJSON != 0
+}
+
+// crashThrow 记录 GOTRACEBACK=json 时 throw 的错误信息。
+// throw 可能在禁止写屏障的上下文中被调用，因此以 uintptr 保存字符串。
+var crashThrow struct {
+	str uintptr
+	len int
+}
+
+//go:nosplit
+func setCrashThrow(s string) {
+	ss := (*stringStruct)(unsafe.Pointer(&s))
+	crashThrow.str = uintptr(ss.str)
+	crashThrow.len = ss.len
+}
+
+// crashSignal 为导致崩溃的信号，sig 为 0 表示崩溃不是由信号引起的
+type crashSignal struct {
+	sig  uint32
+	code uintptr
+	addr uintptr
+	pc   uintptr
+}
+
+// printCrashReport 以一行 JSON 对象的形式打印崩溃报告：
+//
+//	{"goos":"linux","goarch":"amd64","reason":"panic",
+//	 "error":"...",                             // throw 的错误信息
+//	 "panics":[{"value":...,"recovered":false}], // 由外到内
+//	 "signal":{"number":11,"name":"SIGSEGV","code":"0x1","addr":"0x0","pc":"0x..."},
+//	 "goroutines":[{"id":1,"state":"waiting","waitReason":"chan receive","waitMinutes":0,
+//	                "lockedToThread":false,"current":true,
+//	                "frames":[{"func":"main.main","file":"/x/main.go","line":10,"pc":"0x..."}],
+//	                "createdBy":{...},"ancestors":[{"id":5,"frames":[...],"createdBy":{...}}]}],
+//	 "runtimeStack":[...],                      // 在系统栈上 throw 时
+//	 "memstats":{"heapAlloc":...,...}}
+//
+// gp 为崩溃的 goroutine，从 pc、sp、lr 开始回溯；flags 为 _TraceTrap 时 pc 为收到信号的指令。
+// 由 dopanic_m 调用时若 gp 为 g0，则表示 throw 发生在系统栈上。
+// msgs 为尚未结束的 panic 链，others 表示是否包含其他 goroutine。
+func printCrashReport(gp *g, pc, sp, lr uintptr, flags uint, msgs *_panic, sig crashSignal, others bool) {
+	level, _, _ := gotraceback()
+	_g_ := getg()
+
+	printlock()
+	print(`{"goos":"`, sys.GOOS, `","goarch":"`, sys.GOARCH, `","reason":`)
+	switch {
+	case msgs != nil:
+		print(`"panic"`)
+	case crashThrow.len > 0:
+		print(`"fatal error"`)
+	case sig.sig != 0:
+		print(`"signal"`)
+	default:
+		print(`"unknown"`)
+	}
+	if crashThrow.len > 0 {
+		s := stringStruct{unsafe.Pointer(crashThrow.str), crashThrow.len}
+		print(`,"error":`)
+		printjsonstring(*(*string)(unsafe.Pointer(&s)))
+	}
+	if msgs != nil {
+		print(`,"panics":[`)
+		printCrashPanics(msgs)
+		print("]")
+	}
+	if sig.sig != 0 {
+		print(`,"signal":{"number":`, sig.sig, `,"name":`)
+		printjsonstring(signame(sig.sig))
+		print(`,"code":"`, hex(sig.code), `","addr":"`, hex(sig.addr), `","pc":"`, hex(sig.pc), `"}`)
+	}
+
+	if level > 0 {
+		print(`,"goroutines":[`)
+		n := 0
+		systemThrow := gp == gp.m.g0 && flags&_TraceTrap == 0
+		if !systemThrow {
+			printCrashGoroutine(gp, pc, sp, lr, flags, true)
+			n++
+		}
+		if others {
+			printCrashOthers(gp, n)
+		}
+		print("]")
+		if systemThrow && (level >= 2 || _g_.m.throwing > 0) {
+			print(`,"runtimeStack":`)
+			printCrashFrames(gp, pc, sp, 0, 0)
+		}
+	}
+
+	print(`,"memstats":{"heapAlloc":`, memstats.heap_alloc,
+		`,"heapLive":`, memstats.heap_live,
+		`,"heapSys":`, memstats.heap_sys,
+		`,"heapInuse":`, memstats.heap_inuse,
+		`,"heapReleased":`, memstats.heap_released,
+		`,"heapObjects":`, memstats.heap_objects,
+		`,"stacksInuse":`, memstats.stacks_inuse,
+		`,"sys":`, memstats.sys,
+		`,"nextGC":`, memstats.next_gc,
+		`,"numGC":`, memstats.numgc,
+		`,"pauseTotalNs":`, memstats.pause_total_ns, "}")
+	print("}\n")
+	printunlock()
+}
+
+// printCrashPanics 与 printpanics 相同，由外到内打印 panic 链
+func printCrashPanics(p *_panic) {
+	if p.link != nil {
+		printCrashPanics(p.link)
+		print(",")
+	}
+	print(`{"value":`)
+	printCrashValue(p.arg)
+	print(`,"recovered":`, p.recovered, "}")
+}
+
+// printCrashValue 以 JSON 格式打印 panic 的值，与 printany 对应。
+// error 与 Stringer 已经被 preprintpanics 转换为字符串。
+// 浮点数与复数的打印格式不是合法的 JSON 数字，因此打印为字符串。
+func printCrashValue(i interface{}) {
+	switch v := i.(type) {
+	case nil:
+		print("null")
+	case bool:
+		print(v)
+	case int:
+		print(v)
+	case int8:
+		print(v)
+	case int16:
+		print(v)
+	case int32:
+		print(v)
+	case int64:
+		print(v)
+	case uint:
+		print(v)
+	case uint8:
+		print(v)
+	case uint16:
+		print(v)
+	case uint32:
+		print(v)
+	case uint64:
+		print(v)
+	case uintptr:
+		print(v)
+	case float32:
+		print(`"`, v, `"`)
+	case float64:
+		print(`"`, v, `"`)
+	case complex64:
+		print(`"`, v, `"`)
+	case complex128:
+		print(`"`, v, `"`)
+	case string:
+		printjsonstring(v)
+	default:
+		print(`"(`)
+		printjsonescaped(typestring(i))
+		print(") ", i, `"`)
+	}
+}
+
+// printCrashOthers 与 tracebackothers 相同，打印除 me 以外的 goroutine。
+// n 为已经打印的 goroutine 数量。
+func printCrashOthers(me *g, n int) {
+	level, _, _ := gotraceback()
+
+	g := getg()
+	if gp := g.m.curg; gp != nil && gp != me {
+		if n > 0 {
+			print(",")
+		}
+		printCrashGoroutine(gp, ^uintptr(0), ^uintptr(0), 0, 0, false)
+		n++
+	}
+
+	lock(&allglock)
+	for _, gp := range allgs {
+		if gp == me || gp == g.m.curg || readgstatus(gp) == _Gdead || isSystemGoroutine(gp, false) && level < 2 {
+			continue
+		}
+		if n > 0 {
+			print(",")
+		}
+		printCrashGoroutine(gp, ^uintptr(0), ^uintptr(0), 0, 0, false)
+		n++
+	}
+	unlock(&allglock)
+}
+
+// printCrashGoroutine 打印 gp 的状态、栈、创建它的 go 语句以及祖先 goroutine，
+// 与 goroutineheader 和 traceback1 对应。
+func printCrashGoroutine(gp *g, pc, sp, lr uintptr, flags uint, current bool) {
+	status := readgstatus(gp) &^ _Gscan
+	state := "???"
+	if status < uint32(len(gStatusStrings)) {
+		state = gStatusStrings[status]
+	}
+	var waitfor int64
+	if (status == _Gwaiting || status == _Gsyscall) && gp.waitsince != 0 {
+		waitfor = (nanotime() - gp.waitsince) / 60e9
+	}
+
+	print(`{"id":`, gp.goid, `,"state":`)
+	printjsonstring(state)
+	if status == _Gwaiting && gp.waitreason != waitReasonZero {
+		print(`,"waitReason":`)
+		printjsonstring(gp.waitreason.String())
+	}
+	print(`,"waitMinutes":`, waitfor, `,"lockedToThread":`, gp.lockedm != 0, `,"current":`, current)
+
+	if !current && gp.m != getg().m && status == _Grunning {
+		// 与 tracebackothers 相同，无法获得其他线程上正在运行的 goroutine 的栈
+		print(`,"stackUnavailable":true`)
+	} else {
+		print(`,"frames":`)
+		printCrashFrames(gp, pc, sp, lr, flags)
+	}
+
+	if f := findfunc(gp.gopc); f.valid() && showframe(f, gp, false, funcID_normal, funcID_normal) && gp.goid != 1 {
+		print(`,"createdBy":`)
+		printCrashCreatedBy(f, gp.gopc)
+	}
+
+	if gp.ancestors != nil {
+		print(`,"ancestors":[`)
+		for i, ancestor := range *gp.ancestors {
+			if i > 0 {
+				print(",")
+			}
+			print(`{"id":`, ancestor.goid, `,"frames":`)
+			printCrashPCs(nil, ancestor.pcs, false)
+			if f := findfunc(ancestor.gopc); f.valid() && showfuncinfo(f, false, funcID_normal, funcID_normal) && ancestor.goid != 1 {
+				print(`,"createdBy":`)
+				printCrashCreatedBy(f, ancestor.gopc)
+			}
+			print("}")
+		}
+		print("]")
+	}
+	print("}")
+}
+
+// printCrashFrames 回溯 gp 的栈并打印栈帧数组，与 tracebacktrap 和 traceback1 对应
+func printCrashFrames(gp *g, pc, sp, lr uintptr, flags uint) {
+	if flags&_TraceTrap != 0 && gp.m != nil && gp.m.libcallsp != 0 {
+		// 在 C 代码中，从保存的位置开始回溯
+		pc, sp, lr = gp.m.libcallpc, gp.m.libcallsp, 0
+		gp = gp.m.libcallg.ptr()
+		flags = 0
+	}
+	if readgstatus(gp)&^_Gscan == _Gsyscall {
+		// 阻塞在系统调用中，使用保存的寄存器
+		pc = gp.syscallpc
+		sp = gp.syscallsp
+		flags &^= _TraceTrap
+	}
+	var pcbuf [_TracebackMaxFrames]uintptr
+	n := gentraceback(pc, sp, lr, gp, 0, &pcbuf[0], _TracebackMaxFrames, nil, nil, flags)
+	printCrashPCs(gp, pcbuf[:n], flags&_TraceTrap != 0)
+}
+
+// printCrashPCs 打印 pcs 对应的栈帧，pcs 的格式与 runtime.Callers 相同，
+// 其中被内联的函数各自占据一个 PC。
+// trap 表示 pcs[0] 为收到信号的指令而非返回地址。
+//
+// 与文本格式的 traceback 相同，默认省略运行时内部的栈帧，若因此一个栈帧都不打印，则打印所有栈帧。
+func printCrashPCs(gp *g, pcs []uintptr, trap bool) {
+	all := printCrashPCs1(gp, pcs, trap, false, false) == 0
+	print("[")
+	printCrashPCs1(gp, pcs, trap, all, true)
+	print("]")
+}
+
+// printCrashPCs1 返回需要打印的栈帧数量，doprint 为 false 时只计数
+func printCrashPCs1(gp *g, pcs []uintptr, trap, all, doprint bool) int {
+	n := 0
+	lastFuncID := funcID_normal
+	injected := false
+	for i, pc := range pcs {
+		f := findfunc(pc)
+		if !f.valid() {
+			// 例如 cgo traceback 得到的 C 函数
+			if all {
+				if doprint {
+					if n > 0 {
+						print(",")
+					}
+					print(`{"func":"?","pc":"`, hex(pc), `"}`)
+				}
+				n++
+			}
+			continue
+		}
+
+		// 回退到 CALL 指令以读取内联信息和行号，与 gentraceback 相同
+		tracepc := pc
+		if !(i == 0 && trap) && !injected && pc > f.entry {
+			tracepc--
+		}
+		funcID := f.funcID
+		name := funcname(f)
+		if inldata := funcdata(f, _FUNCDATA_InlTree); inldata != nil {
+			inltree := (*[1 << 20]inlinedCall)(inldata)
+			if ix := pcdatavalue(f, _PCDATA_InlTreeIndex, tracepc, nil); ix >= 0 {
+				funcID = inltree[ix].funcID
+				name = funcnameFromNameoff(f, inltree[ix].func_)
+			}
+		}
+		injected = funcID == funcID_sigpanic
+
+		if all || showframe(f, gp, n == 0, funcID, lastFuncID) {
+			if doprint {
+				if n > 0 {
+					print(",")
+				}
+				if name == "runtime.gopanic" {
+					name = "panic"
+				}
+				file, line := funcline(f, tracepc)
+				print(`{"func":`)
+				printjsonstring(name)
+				print(`,"file":`)
+				printjsonstring(file)
+				print(`,"line":`, line, `,"pc":"`, hex(pc), `"}`)
+			}
+			n++
+		}
+		lastFuncID = funcID
+	}
+	return n
+}
+
+// printCrashCreatedBy 打印位于 pc 的 go 语句，与 printcreatedby1 对应
+func printCrashCreatedBy(f funcInfo, pc uintptr) {
+	tracepc := pc // back up to CALL instruction for funcline.
+	if pc > f.entry {
+		tracepc -= sys.PCQuantum
+	}
+	file, line := funcline(f, tracepc)
+	print(`{"func":`)
+	printjsonstring(funcname(f))
+	print(`,"file":`)
+	printjsonstring(file)
+	print(`,"line":`, line, `,"pc":"`, hex(pc), `"}`)
+}
+
+// printjsonstring 打印带引号的 JSON 字符串
+func printjsonstring(s string) {
+	print(`"`)
+	printjsonescaped(s)
+	print(`"`)
+}
+
+// printjsonescaped 打印 s，并按照 JSON 的规则转义引号、反斜杠与控制字符
+func printjsonescaped(s string) {
+	const hexdigits = "0123456789abcdef"
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' {
+			continue
+		}
+		printstring(s[start:i])
+		switch c {
+		case '"', '\\':
+			print(`\`, s[i:i+1])
+		case '\n':
+			print(`\n`)
+		case '\t':
+			print(`\t`)
+		default:
+			print(`\u00`, hexdigits[c>>4:c>>4+1], hexdigits[c&0xf:c&0xf+1])
+		}
+		start = i + 1
+	}
+	printstring(s[start:])
+}
@@ -13,14 +13,24 @@ import (
 // 如果 n < 1，则他不会进行任何修改。
 // 机器上的逻辑 CPU 的个数可以从 NumCPU 调用上获取。
 // 该调用会在调度器进行改进后被移除。
+//
+// 默认值为 NumCPU 与 cgroup CPU 限制（向上取整）中较小的那个，并且运行时会定期
+// 根据 CPU 限制的变化调整。以 n > 0 调用 GOMAXPROCS 后不再自动调整。
 func GOMAXPROCS(n int) int {
 	if GOARCH == "wasm" && n > 1 {
 		n = 1 // WebAssembly 还没有线程支持，只能设置一个 CPU。
 	}
+	if debug.schedseed != 0 && n > 1 {
+		n = 1 // 确定性调度模式只使用一个 P
+	}
 
 	// 当调整 P 的数量时，调度器会被锁住
 	lock(&sched.lock)
 	ret := int(gomaxprocs)
+	if n > 0 {
+		// 用户显式设置了 GOMAXPROCS，不再根据 CPU 限制自动调整
+		customGOMAXPROCS = true
+	}
 	unlock(&sched.lock)
 
 	// 返回原有设置
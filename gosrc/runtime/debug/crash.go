@@ -0,0 +1,40 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"os"
+	"syscall"
+)
+
+// SetCrashOutput 设置进程崩溃时额外的输出文件。
+//
+// 当进程因未恢复的 panic、运行时的致命错误或致命信号而崩溃时，
+// 写到标准错误的 panic 消息与 traceback 会被同时写入 f，
+// 以免在标准错误被重定向或轮转时丢失。配合 GOTRACEBACK=json，
+// f 中会得到一行 JSON 格式的崩溃报告。
+//
+// SetCrashOutput 复制 f 的文件描述符，因此调用之后关闭 f 不会影响崩溃输出。
+// f 为 nil 时取消之前的设置，崩溃输出只写到标准错误。
+func SetCrashOutput(f *os.File) error {
+	fd := ^uintptr(0)
+	if f != nil {
+		syscall.ForkLock.RLock()
+		nfd, err := syscall.Dup(int(f.Fd()))
+		if err == nil {
+			syscall.CloseOnExec(nfd)
+		}
+		syscall.ForkLock.RUnlock()
+		if err != nil {
+			return &os.PathError{Op: "dup", Path: f.Name(), Err: err}
+		}
+		fd = uintptr(nfd)
+	}
+	if prev := setCrashFD(fd); prev != ^uintptr(0) {
+		// 之前设置的文件描述符是由 SetCrashOutput 复制的，由这里负责关闭
+		syscall.Close(int(prev))
+	}
+	return nil
+}
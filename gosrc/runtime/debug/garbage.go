@@ -92,6 +92,37 @@ func SetGCPercent(percent int) int {
 	return int(setGCPercent(int32(percent)))
 }
 
+// SetMemoryLimit provides the runtime with a soft memory limit.
+//
+// The runtime undertakes several processes to try to respect this
+// memory limit, including adjustments to the frequency of garbage
+// collections and returning memory to the underlying system more
+// aggressively. This limit will be respected even if GOGC=off (or,
+// if SetGCPercent(-1) is executed).
+//
+// The input limit is provided as bytes, and includes all memory
+// mapped, managed, and not released by the Go runtime: the Go heap,
+// goroutine stacks, and runtime metadata such as span structures.
+// Notably, it does not account for space used by the Go binary and
+// memory external to Go, such as memory managed by the underlying
+// system on behalf of the process, or memory managed by non-Go code
+// inside the same process.
+//
+// The limit is soft. A limit that is too low, for example one smaller
+// than the live heap, could otherwise make the garbage collector run
+// continuously. To prevent this, the runtime caps the CPU time the
+// garbage collector may consume under the limit at roughly 50% of
+// GOMAXPROCS; past that point the heap is allowed to exceed the limit.
+//
+// The initial setting is the value of the GOMEMLIMIT environment
+// variable at startup, or math.MaxInt64 (no limit) if it is not set.
+// A negative input does not adjust the limit, and allows for
+// retrieval of the currently set memory limit.
+// SetMemoryLimit returns the previously set memory limit.
+func SetMemoryLimit(limit int64) int64 {
+	return setMemoryLimit(limit)
+}
+
 // FreeOSMemory forces a garbage collection followed by an
 // attempt to return as much memory to the operating system
 // as possible. (Even if this is not called, the runtime gradually
@@ -0,0 +1,143 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GCTrigger 为启动一次垃圾回收的原因。
+type GCTrigger int
+
+const (
+	GCTriggerHeap   GCTrigger = iota // 堆按 GOGC 增长到了触发点
+	GCTriggerLimit                   // 堆接近内存限制，见 SetMemoryLimit
+	GCTriggerTime                    // 长时间没有进行垃圾回收
+	GCTriggerForced                  // 由 runtime.GC、FreeOSMemory 等显式触发
+)
+
+func (t GCTrigger) String() string {
+	switch t {
+	case GCTriggerHeap:
+		return "heap"
+	case GCTriggerLimit:
+		return "limit"
+	case GCTriggerTime:
+		return "time"
+	case GCTriggerForced:
+		return "forced"
+	}
+	return "GCTrigger(" + strconv.Itoa(int(t)) + ")"
+}
+
+// GCInfo 描述一次完成的垃圾回收。
+type GCInfo struct {
+	Cycle   uint32    // 周期编号，与 runtime.MemStats.NumGC 相同
+	Trigger GCTrigger // 启动这次回收的原因
+
+	HeapLive uint64 // 标记结束时存活的堆字节数
+	HeapGoal uint64 // 下一次回收的堆目标，GOGC=off 且没有内存限制时为 math.MaxUint64
+
+	SweepTermPause time.Duration // 清扫终止阶段停止世界的时长
+	MarkTermPause  time.Duration // 标记终止阶段停止世界的时长
+	PauseTotal     time.Duration // 这次回收停止世界的总时长
+
+	End time.Time // 回收结束的时间
+}
+
+// gcEvent 的布局必须与 runtime.gcNotifyEvent 保持一致。
+type gcEvent struct {
+	cycle          uint32
+	trigger        uint32
+	heapLive       uint64
+	heapGoal       uint64
+	sweepTermPause int64
+	markTermPause  int64
+	pauseTotal     int64
+	end            int64
+}
+
+type gcCallback struct {
+	f func(GCInfo)
+}
+
+var gcNotify struct {
+	mu      sync.Mutex
+	started bool
+	fns     []*gcCallback // 注册的回调，修改时整体替换
+}
+
+// OnGC 注册 f，在此后每次垃圾回收结束时调用，并返回一个取消注册的函数。
+//
+// 所有回调都在同一个专门的 goroutine 上按注册顺序依次调用，不会阻塞垃圾回收器，
+// 但一个耗时的回调会推迟其他回调。若回调处理得太慢，运行时最多缓存 16 个
+// 未处理的事件，更早的事件会被丢弃，此时 GCInfo.Cycle 不再连续。
+// 回调中的 panic 与其他 goroutine 中的 panic 一样会使程序崩溃。
+//
+// 例如，缓存可以在堆接近目标时缩小自己：
+//
+//	debug.OnGC(func(info debug.GCInfo) {
+//		if info.HeapLive > info.HeapGoal/10*8 {
+//			cache.Trim()
+//		}
+//	})
+//
+// 取消注册的函数返回时，f 可能仍在执行，或者还会被调用一次。
+func OnGC(f func(GCInfo)) (stop func()) {
+	if f == nil {
+		panic("debug.OnGC: nil callback")
+	}
+	c := &gcCallback{f: f}
+
+	gcNotify.mu.Lock()
+	fns := make([]*gcCallback, len(gcNotify.fns), len(gcNotify.fns)+1)
+	copy(fns, gcNotify.fns)
+	gcNotify.fns = append(fns, c)
+	if !gcNotify.started {
+		gcNotify.started = true
+		go gcNotifyLoop()
+	}
+	gcNotify.mu.Unlock()
+
+	return func() {
+		gcNotify.mu.Lock()
+		defer gcNotify.mu.Unlock()
+		for i, fc := range gcNotify.fns {
+			if fc == c {
+				fns := make([]*gcCallback, 0, len(gcNotify.fns)-1)
+				fns = append(fns, gcNotify.fns[:i]...)
+				gcNotify.fns = append(fns, gcNotify.fns[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// gcNotifyLoop 从运行时接收 GC 事件并分发给注册的回调。
+func gcNotifyLoop() {
+	for {
+		var e gcEvent
+		gcNotifyRecv(&e)
+		info := GCInfo{
+			Cycle:          e.cycle,
+			Trigger:        GCTrigger(e.trigger),
+			HeapLive:       e.heapLive,
+			HeapGoal:       e.heapGoal,
+			SweepTermPause: time.Duration(e.sweepTermPause),
+			MarkTermPause:  time.Duration(e.markTermPause),
+			PauseTotal:     time.Duration(e.pauseTotal),
+			End:            time.Unix(0, e.end),
+		}
+
+		gcNotify.mu.Lock()
+		fns := gcNotify.fns
+		gcNotify.mu.Unlock()
+		for _, c := range fns {
+			c.f(info)
+		}
+	}
+}
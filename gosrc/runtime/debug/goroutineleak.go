@@ -0,0 +1,34 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+// GoroutineLeaks 运行一次带有 goroutine 泄漏检测的垃圾回收，并返回泄漏的 goroutine 的栈。
+// 没有泄漏时返回 nil。
+//
+// 若一个 goroutine 阻塞在 channel、select、信号量（sync.Mutex、sync.WaitGroup 等）或
+// sync.Cond 上，而任何可能运行的 goroutine 以及全局变量都无法访问它等待的对象，
+// 则它永远不会被唤醒，即为泄漏。该检测是保守的，可能漏报，但不会误报。
+//
+// 返回值的格式与 runtime.Stack 相同，每个 goroutine 的栈以 "leaked goroutine" 开头，
+// 并包含创建它的 go 语句；设置 GODEBUG=tracebackancestors=N 时还包含祖先 goroutine 的创建栈。
+// 测试可以据此断言没有泄漏：
+//
+//	if leaks := debug.GoroutineLeaks(); leaks != nil {
+//		t.Fatalf("leaked goroutines:\n%s", leaks)
+//	}
+func GoroutineLeaks() []byte {
+	findGoroutineLeaks()
+	buf := make([]byte, 16<<10)
+	for {
+		n := readGoroutineLeaks(buf)
+		if n == 0 {
+			return nil
+		}
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import "time"
+
+// SchedLatency 描述了一个 P 上的调度延迟分布，即 goroutine 从变为可运行
+// （被放入 runnext、本地或全局运行队列）到真正开始运行之间等待的时间。
+type SchedLatency struct {
+	// Buckets 为直方图各个桶的下界，按升序排列。
+	// 第 i 个桶包含 [Buckets[i], Buckets[i+1]) 内的延迟，最后一个桶没有上界。
+	// 所有 P 共享同一个 Buckets 切片，不应被修改。
+	Buckets []time.Duration
+
+	// Counts[i] 为落在第 i 个桶内的次数，自程序启动开始累积。
+	// 运行时只对部分调度事件进行采样，因此计数远小于实际的调度次数。
+	Counts []uint64
+}
+
+// ReadSchedLatency 返回每个 P 的调度延迟直方图，下标即为 P 的 id。
+//
+// 读取时不会停止世界，因此各个 P 的直方图并不是同一时刻的快照。
+// 两次读取的差值即为这段时间内的调度延迟分布。
+// 所有 P 的总和也可以通过 runtime/metrics 中的 /sched/latencies:seconds 读取。
+func ReadSchedLatency() []SchedLatency {
+	buckets, counts := readSchedLatency()
+	b := make([]time.Duration, len(buckets))
+	for i, ns := range buckets {
+		b[i] = time.Duration(ns)
+	}
+	stats := make([]SchedLatency, len(counts))
+	for i := range counts {
+		stats[i] = SchedLatency{Buckets: b, Counts: counts[i]}
+	}
+	return stats
+}
+
+// Quantile 返回分布中 q 分位数（0 <= q <= 1）所在的桶的下界。
+// 若没有任何记录，返回 0。
+func (s *SchedLatency) Quantile(q float64) time.Duration {
+	var total uint64
+	for _, c := range s.Counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	target := uint64(float64(total) * q)
+	if target >= total {
+		target = total - 1
+	}
+	var seen uint64
+	for i, c := range s.Counts {
+		seen += c
+		if seen > target {
+			return s.Buckets[i]
+		}
+	}
+	return s.Buckets[len(s.Buckets)-1]
+}
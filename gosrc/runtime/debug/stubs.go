@@ -13,5 +13,11 @@ func readGCStats(*[]time.Duration)
 func freeOSMemory()
 func setMaxStack(int) int
 func setGCPercent(int32) int32
+func setMemoryLimit(int64) int64
 func setPanicOnFault(bool) bool
 func setMaxThreads(int) int
+func readSchedLatency() (buckets []int64, counts [][]uint64)
+func findGoroutineLeaks()
+func readGoroutineLeaks([]byte) int
+func setCrashFD(uintptr) uintptr
+func gcNotifyRecv(*gcEvent)
@@ -145,8 +145,9 @@ type epollevent struct {
 // cgo -cdefs defs_linux.go defs1_linux.go
 
 const (
-	_O_RDONLY  = 0x0
-	_O_CLOEXEC = 0x80000
+	_O_RDONLY   = 0x0
+	_O_NONBLOCK = 0x800
+	_O_CLOEXEC  = 0x80000
 )
 
 type usigset struct {
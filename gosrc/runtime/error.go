@@ -73,6 +73,157 @@ func (e plainError) Error() string {
 	return string(e)
 }
 
+// 下面的错误类型为几种常见的运行时 panic 的值。它们的 Error 与此前的错误消息完全相同，
+// recover 得到的值可以通过 errors.As 区分出具体的类型，而不必匹配错误消息。
+
+// MemoryError 为访问无效内存（例如对 nil 指针解引用）引起的 panic。
+// 在 runtime/debug.SetPanicOnFault 开启时，访问任意无效地址都会引起该 panic。
+type MemoryError struct {
+	Addr uintptr // 访问出错的地址，0 表示地址未知
+}
+
+func (*MemoryError) RuntimeError() {}
+
+func (e *MemoryError) Error() string {
+	return "runtime error: invalid memory address or nil pointer dereference"
+}
+
+// DivideError 为整数除以零引起的 panic。
+type DivideError struct{}
+
+func (*DivideError) RuntimeError() {}
+
+func (e *DivideError) Error() string {
+	return "runtime error: integer divide by zero"
+}
+
+// NilMapError 为向 nil map 中的元素赋值引起的 panic。
+type NilMapError struct{}
+
+func (*NilMapError) RuntimeError() {}
+
+func (e *NilMapError) Error() string {
+	return "assignment to entry in nil map"
+}
+
+// ClosedChannelError 为向已关闭的 channel 发送数据或再次关闭它引起的 panic。
+// 关闭 nil channel 不属于这种情况。
+type ClosedChannelError struct {
+	Op string // "send" 或 "close"
+}
+
+func (*ClosedChannelError) RuntimeError() {}
+
+func (e *ClosedChannelError) Error() string {
+	if e.Op == "close" {
+		return "close of closed channel"
+	}
+	return "send on closed channel"
+}
+
+// boundsError 为下标或切片表达式越界时的 panic 值，记录了越界的值与它所违反的上限。
+// 编译器生成的越界检查通过 panicIndex、panicSliceAlen 等入口（见 panic.go）构造它。
+type boundsError struct {
+	x int64
+	y int
+	// x 与 y 同为越界检查的参数，其中 y 总是非负的。
+	// x 来自用户的代码，可能为负数；signed 表示 x 为有符号整数，
+	// 否则 x 为无符号整数，只是转换成了 int64（因此可能显示为负数，需要按无符号打印）。
+	signed bool
+	code   boundsErrorCode
+}
+
+// boundsErrorCode 表示越界检查失败的位置
+type boundsErrorCode uint8
+
+const (
+	boundsIndex boundsErrorCode = iota // s[x], 0 <= x < len(s) failed
+
+	boundsSliceAlen // s[?:x], 0 <= x <= len(s) failed
+	boundsSliceAcap // s[?:x], 0 <= x <= cap(s) failed
+	boundsSliceB    // s[x:y], 0 <= x <= y failed (but boundsSliceA didn't happen)
+
+	boundsSlice3Alen // s[?:?:x], 0 <= x <= len(s) failed
+	boundsSlice3Acap // s[?:?:x], 0 <= x <= cap(s) failed
+	boundsSlice3B    // s[?:x:y], 0 <= x <= y failed (but boundsSlice3A didn't happen)
+	boundsSlice3C    // s[x:y:?], 0 <= x <= y failed (but boundsSlice3A/B didn't happen)
+)
+
+// boundsErrorFmt 为各 boundsErrorCode 的错误消息，%x 与 %y 分别替换为 x 与 y。
+var boundsErrorFmt = [...]string{
+	boundsIndex:      "index out of range [%x] with length %y",
+	boundsSliceAlen:  "slice bounds out of range [:%x] with length %y",
+	boundsSliceAcap:  "slice bounds out of range [:%x] with capacity %y",
+	boundsSliceB:     "slice bounds out of range [%x:%y]",
+	boundsSlice3Alen: "slice bounds out of range [::%x] with length %y",
+	boundsSlice3Acap: "slice bounds out of range [::%x] with capacity %y",
+	boundsSlice3B:    "slice bounds out of range [:%x:%y]",
+	boundsSlice3C:    "slice bounds out of range [%x:%y:]",
+}
+
+// boundsNegErrorFmt 在 x 为负数时使用，此时不必给出 y
+var boundsNegErrorFmt = [...]string{
+	boundsIndex:      "index out of range [%x]",
+	boundsSliceAlen:  "slice bounds out of range [:%x]",
+	boundsSliceAcap:  "slice bounds out of range [:%x]",
+	boundsSliceB:     "slice bounds out of range [%x:]",
+	boundsSlice3Alen: "slice bounds out of range [::%x]",
+	boundsSlice3Acap: "slice bounds out of range [::%x]",
+	boundsSlice3B:    "slice bounds out of range [:%x:]",
+	boundsSlice3C:    "slice bounds out of range [%x::]",
+}
+
+func (e boundsError) RuntimeError() {}
+
+// appendIntStr 将 v 的十进制表示追加到 b 中，signed 为 false 时将 v 视为无符号数
+func appendIntStr(b []byte, v int64, signed bool) []byte {
+	if signed && v < 0 {
+		b = append(b, '-')
+		v = -v
+	}
+	var buf [20]byte
+	b = append(b, itoa(buf[:], uint64(v))...)
+	return b
+}
+
+func (e boundsError) Error() string {
+	fmt := boundsErrorFmt[e.code]
+	if e.signed && e.x < 0 {
+		fmt = boundsNegErrorFmt[e.code]
+	}
+	// 最长的消息为 "runtime error: slice bounds out of range [::%x] with capacity %y"，
+	// x 最多 20 个字符，y 最多 19 个字符，共 99 个字节
+	b := make([]byte, 0, 100)
+	b = append(b, "runtime error: "...)
+	for i := 0; i < len(fmt); i++ {
+		c := fmt[i]
+		if c != '%' {
+			b = append(b, c)
+			continue
+		}
+		i++
+		switch fmt[i] {
+		case 'x':
+			b = appendIntStr(b, e.x, e.signed)
+		case 'y':
+			b = appendIntStr(b, int64(e.y), true)
+		}
+	}
+	return string(b)
+}
+
+// itoa 将 val 的十进制表示写入 buf 的末尾并返回所写的部分。buf 至少要有 20 个字节。
+func itoa(buf []byte, val uint64) []byte {
+	i := len(buf) - 1
+	for val >= 10 {
+		buf[i] = byte(val%10 + '0')
+		i--
+		val /= 10
+	}
+	buf[i] = byte(val + '0')
+	return buf[i:]
+}
+
 type stringer interface {
 	String() string
 }
@@ -0,0 +1,18 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Export guts for testing.
+
+package runtime
+
+// ReadGCScanWork 返回最近一次 GC 周期中扫描 goroutine 栈与全局变量的工作量，
+// 以及下一个周期的堆目标所使用的栈的扫描工作量。
+func ReadGCScanWork() (stack, globals int64, lastStack uint64) {
+	stopTheWorld("ReadGCScanWork")
+	stack = gcController.stackScanWork
+	globals = gcController.globalsScanWork
+	lastStack = gcController.lastStackScan
+	startTheWorld()
+	return
+}
@@ -20,12 +20,35 @@ is GOGC=100. Setting GOGC=off disables the garbage collector entirely.
 The runtime/debug package's SetGCPercent function allows changing this
 percentage at run time. See https://golang.org/pkg/runtime/debug/#SetGCPercent.
 
+The GOMEMLIMIT variable sets a soft memory limit for the runtime. This memory limit
+includes the Go heap and all other memory managed by the runtime, such as
+goroutine stacks and span metadata. The limit is expressed in bytes, with an
+optional unit suffix: B, KiB, MiB, GiB, or TiB. The default is GOMEMLIMIT=off,
+meaning no limit. As the runtime's memory approaches the limit, the garbage
+collector runs more often and free memory is returned to the operating system
+more eagerly. The runtime/debug package's SetMemoryLimit function allows
+changing this limit at run time.
+
 The GODEBUG variable controls debugging variables within the runtime.
 It is a comma-separated list of name=val pairs setting these named variables:
 
+	adaptivestackstart: by default the runtime starts new goroutines with a stack
+	large enough for the average stack usage it observed while scanning stacks
+	during the previous garbage collection, so that goroutines that always grow
+	their stacks skip the first few growths. Setting adaptivestackstart=0 starts
+	every goroutine with the minimum stack size instead. The current starting
+	size is reported by the /gc/stack/starting-size:bytes metric.
+
 	allocfreetrace: setting allocfreetrace=1 causes every allocation to be
 	profiled and a stack trace printed on each object's allocation and free.
 
+	asyncpreemptoff: asyncpreemptoff=1 disables signal-based
+	asynchronous goroutine preemption. This makes some loops
+	non-preemptible for long periods, which may delay GC and
+	goroutine scheduling. This is useful for debugging GC issues
+	because it also disables the conservative stack scanning used
+	for asynchronously preempted goroutines.
+
 	cgocheck: setting cgocheck=0 disables all checks for packages
 	using cgo to incorrectly pass Go pointers to non-Go code.
 	Setting cgocheck=1 (the default) enables relatively cheap
@@ -33,6 +56,10 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	expensive checks that should not miss any errors, but will
 	cause your program to run slower.
 
+	containermaxprocs: setting containermaxprocs=0 makes the default GOMAXPROCS
+	ignore the cgroup CPU limit (cpu.cfs_quota_us or cpu.max) on Linux and use
+	the number of CPUs only.
+
 	efence: setting efence=1 causes the allocator to run in a mode
 	where each object is allocated on a unique page and addresses are
 	never recycled.
@@ -45,6 +72,11 @@ It is a comma-separated list of name=val pairs setting these named variables:
 
 	gcpacertrace: setting gcpacertrace=1 causes the garbage collector to
 	print information about the internal state of the concurrent pacer.
+	At the start of each cycle it prints the assist ratio and the heap, stack
+	and global scan work it expects; at the end of the cycle it prints the GC
+	CPU utilization, the scan work actually done on the heap, stacks and globals,
+	the heap growth relative to the goal, and the cons/mark estimate used to
+	place the next trigger.
 
 	gcshrinkstackoff: setting gcshrinkstackoff=1 disables moving goroutines
 	onto smaller stacks. In this mode, a goroutine's stack can only grow.
@@ -74,20 +106,45 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	runtime.GC() call.
 
 	Setting gctrace to any value > 0 also causes the garbage collector
-	to emit a summary when memory is released back to the system.
-	This process of returning memory to the system is called scavenging.
-	The format of this summary is subject to change.
-	Currently it is:
-		scvg#: # MB released  printed only if non-zero
-		scvg#: inuse: # idle: # sys: # released: # consumed: # (MB)
+	to emit a summary of the memory released back to the system.
+	This process of returning memory to the system is called scavenging,
+	and is performed by a background scavenger that uses about 1% of
+	one CPU to keep the retained heap memory close to a goal derived from
+	the previous heap goal. The format of this summary is subject to change.
+	Currently it is printed after each gc line:
+		scvg: # KB released, # MB retained, # MB goal
+	where the fields are as follows:
+		# KB released  memory released by the background scavenger since the last GC
+		# MB retained  heap memory obtained from and not yet released to the system
+		# MB goal      the scavenger's retained memory goal, or "no goal" if it is idle
+	When debug.FreeOSMemory releases memory, it prints
+		scvg: # MB released (forced)
+		scvg: inuse: # idle: # sys: # released: # consumed: # (MB)
 	where the fields are as follows:
-		scvg#        the scavenge cycle number, incremented at each scavenge
 		inuse: #     MB used or partially used spans
-		idle: #      MB spans pending scavenging
+		idle: #      MB free pages
 		sys: #       MB mapped from the system
 		released: #  MB released to the system
 		consumed: #  MB allocated from the system
 
+	goroutineleak: setting goroutineleak=1 causes every garbage collection to look for
+	leaked goroutines: goroutines blocked on a channel, select, semaphore or sync.Cond
+	that no runnable goroutine or global variable can reach, and that therefore can
+	never be woken. Each leaked goroutine is reported once to standard error with its
+	stack and the go statement that created it (plus its ancestors' creation stacks
+	when tracebackancestors is set). See also runtime/debug.GoroutineLeaks.
+
+	guardalloc: setting guardalloc=N places about one in every N heap allocations
+	of at most one physical page at the end of its own page, directly in front of
+	an inaccessible guard page. Once the garbage collector frees such an object, its
+	pages stay inaccessible for a few more cycles instead of being reused. Reading
+	or writing past the end of a sampled object, or using it after it has been freed
+	(for example through a pointer kept in C memory or in a uintptr), crashes the
+	program with a report showing where the object was allocated and when it was
+	freed. At most 256 sampled objects exist at a time. The mode is meant for
+	finding memory errors in unsafe and cgo code and is not available on js/wasm
+	or with the race detector or memory sanitizer.
+
 	madvdontneed: setting madvdontneed=1 will use MADV_DONTNEED
 	instead of MADV_FREE on Linux when returning memory to the
 	kernel. This is less efficient, but causes RSS numbers to drop
@@ -107,14 +164,22 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	with a trivial allocator that obtains memory from the operating system and
 	never reclaims any memory.
 
-	scavenge: scavenge=1 enables debugging mode of heap scavenger.
-
 	scheddetail: setting schedtrace=X and scheddetail=1 causes the scheduler to emit
 	detailed multiline info every X milliseconds, describing state of the scheduler,
 	processors, threads and goroutines.
 
+	schedseed: setting schedseed=N with N != 0 enables a deterministic scheduling mode
+	for reproducing concurrency bugs. GOMAXPROCS is fixed at 1, and the choice of the
+	next goroutine to run, the use of the runnext slot and the preemption points
+	(counted in allocations, channel operations and go statements) are driven by a
+	pseudo-random generator seeded with N. Re-running the same program with the same
+	seed reproduces the same goroutine interleaving, as long as the program does not
+	depend on system calls, timers, the network or the garbage collector's timing.
+
 	schedtrace: setting schedtrace=X causes the scheduler to emit a single line to standard
-	error every X milliseconds, summarizing the scheduler state.
+	error every X milliseconds, summarizing the scheduler state. It is followed by a
+	"SCHED latency" line summarizing how long runnable goroutines waited before they
+	started running since the previous report.
 
 	tracebackancestors: setting tracebackancestors=N extends tracebacks with the stacks at
 	which goroutines were created, where N limits the number of ancestor goroutines to
@@ -122,6 +187,9 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	IDs will refer to the ID of the goroutine at the time of creation; it's possible for this
 	ID to be reused for another goroutine. Setting N to 0 will report no ancestry information.
 
+	updatemaxprocs: setting updatemaxprocs=0 disables the periodic adjustment of the
+	default GOMAXPROCS when the cgroup CPU limit changes at run time.
+
 The net and net/http packages also refer to debugging variables in GODEBUG.
 See the documentation for those packages for details.
 
@@ -129,7 +197,10 @@ The GOMAXPROCS variable limits the number of operating system threads that
 can execute user-level Go code simultaneously. There is no limit to the number of threads
 that can be blocked in system calls on behalf of Go code; those do not count against
 the GOMAXPROCS limit. This package's GOMAXPROCS function queries and changes
-the limit.
+the limit. If GOMAXPROCS is not set, the default on Linux is the number of CPUs
+or the cgroup CPU limit rounded up, whichever is lower, and the runtime
+periodically re-checks the limit and adjusts GOMAXPROCS to match until it is set
+explicitly.
 
 The GOTRACEBACK variable controls the amount of output generated when a Go
 program fails due to an unrecovered panic or an unexpected runtime condition.
@@ -145,6 +216,10 @@ and shows goroutines created internally by the run-time.
 GOTRACEBACK=crash is like ``system'' but crashes in an operating system-specific
 manner instead of exiting. For example, on Unix systems, the crash raises
 SIGABRT to trigger a core dump.
+GOTRACEBACK=json is like ``all'' but prints the panic values, signal information,
+stack traces of all goroutines and a summary of the memory statistics as a single
+line of JSON instead of text. See the runtime/debug package's SetCrashOutput
+function for saving the crash output to a file.
 For historical reasons, the GOTRACEBACK settings 0, 1, and 2 are synonyms for
 none, all, and system, respectively.
 The runtime/debug package's SetTraceback function allows increasing the
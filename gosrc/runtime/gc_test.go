@@ -0,0 +1,35 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+// 栈与全局变量的扫描工作量参与堆目标与触发点的计算，GC 之后必须不为零。
+func TestGCScanWork(t *testing.T) {
+	// 避免在读取之前开始新的周期，从而清零本周期的统计
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	done := make(chan bool)
+	go func() {
+		<-done // 至少有一个阻塞的 goroutine 的栈需要扫描
+	}()
+	defer close(done)
+
+	runtime.GC()
+	stack, globals, lastStack := runtime.ReadGCScanWork()
+	if stack <= 0 {
+		t.Errorf("stack scan work = %d, want > 0", stack)
+	}
+	if globals <= 0 {
+		t.Errorf("globals scan work = %d, want > 0", globals)
+	}
+	if lastStack != uint64(stack) {
+		t.Errorf("lastStackScan = %d, want %d", lastStack, stack)
+	}
+}
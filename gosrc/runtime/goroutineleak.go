@@ -0,0 +1,270 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// goroutine 泄漏检测
+//
+// checkdead 只能发现所有 goroutine 都阻塞的全局死锁。更常见的情况是部分 goroutine
+// 永远阻塞在一个再也没有人能够访问的 channel 或信号量上，例如发送方因超时提前返回后，
+// 接收结果的 goroutine 永远等待下去。
+//
+// 泄漏检测在一个 GC 周期中进行，利用可达性来判断：
+//
+// 1. 在 gcStart 中（世界停止），将阻塞在 channel、select、信号量或 sync.Cond 上的用户
+//    goroutine 作为候选，把它们移动到 allgs 中 [work.nStackRoots, goroutineLeak.end)
+//    的位置，此时标记阶段不会扫描它们的栈。
+//
+// 2. 同时隐藏候选的 sudog 中的 elem 与 c（见 maybeTraceablePtr），因此
+//    allgs -> g -> g.waiting（或 g.leakSudog）-> sudog -> channel 以及 semtable -> sudog -> 信号量
+//    这样的路径不会使阻塞对象变为可达。阻塞对象只有从全局变量、可运行的 goroutine
+//    或已扫描的候选 goroutine 出发可达时才会被标记。
+//
+// 3. 每当 gcMarkDone 认为标记已经完成（世界停止）时，检查剩余的候选：若某个候选等待的对象
+//    已被标记（或不在堆上），或者它在此期间运行过，则它可能被唤醒，将其移入需要扫描的栈
+//    并回到并发标记。重复这一过程直到不动点。候选在被加入需要扫描的栈时恢复它的 sudog，
+//    写屏障会标记恢复的对象，因此隐藏期间没有被标记的对象不会被回收。
+//
+// 4. 到达不动点后剩余的候选即为泄漏：任何可能运行的 goroutine 都无法再访问它们等待的对象。
+//    它们的栈同样会被扫描，从而保证它们引用的内存不会被回收，泄漏检测不改变程序的行为。
+//
+// 该检测是保守的：经由候选 goroutine 自身的 defer 链等 g 结构可达的对象被视为可达，
+// 这可能会漏报，但不会误报。
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// goroutineLeak 为泄漏检测的状态
+var goroutineLeak struct {
+	// pending 为 1 表示下一个 GC 周期需要进行泄漏检测，原子访问
+	pending uint32
+
+	// enabled 表示当前 GC 周期正在进行泄漏检测。
+	// 只在世界停止时修改。
+	enabled bool
+
+	// end 为 allgs 中候选 goroutine 的结尾，
+	// 尚未扫描的候选位于 allgs[work.nStackRoots:end]。
+	end int
+}
+
+// isLeakCandidate 报告 gp 是否可能泄漏，即阻塞在只能由其他 goroutine 唤醒的同步对象上。
+//
+// 世界必须停止。
+func isLeakCandidate(gp *g) bool {
+	if readgstatus(gp)&^_Gscan != _Gwaiting || isSystemGoroutine(gp, false) {
+		return false
+	}
+	switch gp.waitreason {
+	case waitReasonChanReceiveNilChan,
+		waitReasonChanSendNilChan,
+		waitReasonSelectNoCases,
+		waitReasonChanReceive,
+		waitReasonChanSend,
+		waitReasonSelect,
+		waitReasonSemacquire,
+		waitReasonSyncCondWait:
+		return true
+	}
+	return false
+}
+
+// gcLeakPrepareStackRoots 在开启泄漏检测的 GC 周期中，将 allgs 的前 n 个 goroutine
+// 中的候选移动到末尾，返回需要立即扫描的栈的数量。
+//
+// 世界必须停止。
+func gcLeakPrepareStackRoots(n int) int {
+	goroutineLeak.enabled = debug.goroutineleak > 0 || atomic.Cas(&goroutineLeak.pending, 1, 0)
+	if !goroutineLeak.enabled {
+		return n
+	}
+	lock(&allglock)
+	k := 0
+	for i := 0; i < n; i++ {
+		gp := allgs[i]
+		if isLeakCandidate(gp) {
+			// execute 会清除 waitsince，据此发现候选在标记期间运行过
+			if gp.waitsince == 0 {
+				gp.waitsince = work.tSweepTerm
+			}
+			gcLeakSetTraceable(gp, false)
+			continue
+		}
+		allgs[i], allgs[k] = allgs[k], allgs[i]
+		k++
+	}
+	unlock(&allglock)
+	goroutineLeak.end = n
+	return k
+}
+
+// gcLeakMaybeRunnable 报告候选 gp 是否可能再次运行。
+//
+// 世界必须停止，且所有可达对象都已被标记。
+func gcLeakMaybeRunnable(gp *g) bool {
+	if gp.waitsince == 0 || !isLeakCandidate(gp) {
+		// 在标记期间被唤醒过
+		return true
+	}
+	switch gp.waitreason {
+	case waitReasonChanReceiveNilChan, waitReasonChanSendNilChan, waitReasonSelectNoCases:
+		// 永远不会被唤醒
+		return false
+	case waitReasonSemacquire, waitReasonSyncCondWait:
+		// 信号量与 notifyList 的等待者不在 g.waiting 中，elem 为等待的地址
+		s := gp.leakSudog
+		return s == nil || gcLeakReachable(uintptr(s.elem.get()))
+	}
+	if gp.waiting == nil {
+		return true
+	}
+	for s := gp.waiting; s != nil; s = s.waitlink {
+		if gcLeakReachable(uintptr(unsafe.Pointer(s.c.get()))) {
+			return true
+		}
+	}
+	return false
+}
+
+// gcLeakSetTraceable 隐藏或恢复候选 gp 的 sudog 所等待的对象。
+// 若 gp 在标记期间运行过，它原来的 sudog 已经被释放并清空，
+// 当前的 sudog 总是可追踪的，恢复它们没有影响。
+//
+// 世界必须停止。
+func gcLeakSetTraceable(gp *g, traceable bool) {
+	for s := gp.waiting; s != nil; s = s.waitlink {
+		sudogSetTraceable(s, traceable)
+	}
+	if s := gp.leakSudog; s != nil {
+		sudogSetTraceable(s, traceable)
+	}
+}
+
+func sudogSetTraceable(s *sudog, traceable bool) {
+	if traceable {
+		s.elem.setTraceable()
+		s.c.setTraceable()
+	} else {
+		s.elem.setUntraceable()
+		s.c.setUntraceable()
+	}
+}
+
+// gcLeakReachable 报告 p 指向的对象是否已被标记。
+// 不在堆上的对象（例如全局变量）总是可达的。
+func gcLeakReachable(p uintptr) bool {
+	base, s, objIndex := findObject(p, 0, 0)
+	if base == 0 {
+		return true
+	}
+	return s.markBitsForIndex(objIndex).isMarked()
+}
+
+// gcLeakUpdateRoots 在 gcMarkDone 认为标记完成时调用。
+// 将可能再次运行的候选加入需要扫描的栈；若已经到达不动点，则剩余的候选即为泄漏，
+// 记录它们并同样加入需要扫描的栈。报告是否加入了新的 markroot 任务，
+// 此时必须回到并发标记。
+//
+// 世界必须停止。
+func gcLeakUpdateRoots() bool {
+	lock(&allglock)
+	k := work.nStackRoots
+	for i := k; i < goroutineLeak.end; i++ {
+		gp := allgs[i]
+		if gcLeakMaybeRunnable(gp) {
+			gcLeakSetTraceable(gp, true)
+			allgs[i], allgs[k] = allgs[k], allgs[i]
+			k++
+		}
+	}
+	fixpoint := k == work.nStackRoots
+	if fixpoint {
+		for i := k; i < goroutineLeak.end; i++ {
+			gp := allgs[i]
+			gcLeakSetTraceable(gp, true)
+			if !gp.leaked {
+				gp.leaked = true
+				gp.leakReported = false
+			}
+		}
+		k = goroutineLeak.end
+		goroutineLeak.enabled = false
+	}
+	unlock(&allglock)
+
+	added := k - work.nStackRoots
+	if added > 0 {
+		// 超出 markrootJobs 的 markrootNext 不对应任何已完成的任务
+		work.markrootNext = work.markrootJobs
+		work.markrootJobs += uint32(added)
+		work.nStackRoots = k
+	}
+	if fixpoint && debug.goroutineleak > 0 {
+		systemstack(printLeakedGoroutines)
+	}
+	return added > 0
+}
+
+// printLeakedGoroutines 打印新发现的泄漏的 goroutine
+func printLeakedGoroutines() {
+	lock(&allglock)
+	for _, gp := range allgs {
+		if gp.leaked && !gp.leakReported {
+			gp.leakReported = true
+			print("\n")
+			printLeakedGoroutine(gp)
+		}
+	}
+	unlock(&allglock)
+}
+
+// printLeakedGoroutine 打印 gp 的栈，包括创建它的 go 语句，
+// 以及 GODEBUG=tracebackancestors=N 时祖先 goroutine 的创建栈。
+func printLeakedGoroutine(gp *g) {
+	print("leaked ")
+	goroutineheader(gp)
+	traceback(^uintptr(0), ^uintptr(0), 0, gp)
+}
+
+// findGoroutineLeaks 运行一个开启泄漏检测的完整 GC 周期
+//
+//go:linkname findGoroutineLeaks runtime/debug.findGoroutineLeaks
+func findGoroutineLeaks() {
+	atomic.Store(&goroutineLeak.pending, 1)
+	GC()
+}
+
+// readGoroutineLeaks 将最近一次泄漏检测发现的、仍在阻塞的 goroutine 的栈写入 buf，
+// 返回写入的字节数。若 buf 不够大，则写满为止。
+//
+//go:linkname readGoroutineLeaks runtime/debug.readGoroutineLeaks
+func readGoroutineLeaks(buf []byte) int {
+	if len(buf) == 0 {
+		return 0
+	}
+	stopTheWorld("goroutine leaks")
+	n := 0
+	systemstack(func() {
+		g0 := getg()
+		g0.m.traceback = 1
+		g0.writebuf = buf[0:0:len(buf)]
+		lock(&allglock)
+		for _, gp := range allgs {
+			if gp.leaked && readgstatus(gp) == _Gwaiting {
+				if len(g0.writebuf) > 0 {
+					print("\n")
+				}
+				printLeakedGoroutine(gp)
+			}
+		}
+		unlock(&allglock)
+		g0.m.traceback = 0
+		n = len(g0.writebuf)
+		g0.writebuf = nil
+	})
+	startTheWorld()
+	return n
+}
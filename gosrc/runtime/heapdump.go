@@ -213,6 +213,11 @@ func dumpotherroot(description string, to unsafe.Pointer) {
 }
 
 func dumpfinalizer(obj unsafe.Pointer, fn *funcval, fint *_type, ot *ptrtype) {
+	// 记录对象指针的类型，使读取方可以知道对象的类型
+	dumptype(fint)
+	if ot != nil {
+		dumptype(&ot.typ)
+	}
 	dumpint(tagFinalizer)
 	dumpint(uint64(uintptr(obj)))
 	dumpint(uint64(uintptr(unsafe.Pointer(fn))))
@@ -406,6 +411,11 @@ func dumpgs() {
 }
 
 func finq_callback(fn *funcval, obj unsafe.Pointer, nret uintptr, fint *_type, ot *ptrtype) {
+	dumptype(fint)
+	// 排队的 cleanup（见 freespecial）没有对象与类型，ot 为 nil，记录为 0
+	if ot != nil {
+		dumptype(&ot.typ)
+	}
 	dumpint(tagQueuedFinalizer)
 	dumpint(uint64(uintptr(obj)))
 	dumpint(uint64(uintptr(unsafe.Pointer(fn))))
@@ -453,7 +463,15 @@ var freemark [_PageSize / 8]bool
 
 func dumpobjs() {
 	for _, s := range mheap_.allspans {
-		if s.state != mSpanInUse {
+		if s.state != mSpanInUse || s.userArenaFaulted {
+			// 已经释放的 arena chunk 不可访问
+			continue
+		}
+		if g := s.guard; g != nil {
+			// 保护分配的 span 中只有对象本身可以访问
+			if g.state == guardInUse && !s.isFree(0) {
+				dumpobj(unsafe.Pointer(g.addr), g.size, makeheapobjbv(g.addr, g.size))
+			}
 			continue
 		}
 		p := s.base()
@@ -0,0 +1,141 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import "runtime/internal/atomic"
+
+const (
+	// 对于时间直方图的类型，我们使用类 HDR 的直方图：
+	// 桶按 2 的幂次分为 super-bucket，每个 super-bucket 再线性地分为
+	// timeHistNumSubBuckets 个 sub-bucket。
+	//
+	// 因此对于任意给定的 super-bucket，相对误差不超过 1/timeHistNumSubBuckets。
+	// super-bucket 0 内的 sub-bucket 对应 [0, timeHistNumSubBuckets) 纳秒，
+	// 精确到 1ns。
+	timeHistSubBucketBits   = 4
+	timeHistNumSubBuckets   = 1 << timeHistSubBucketBits
+	timeHistNumSuperBuckets = 45
+	timeHistTotalBuckets    = timeHistNumSuperBuckets*timeHistNumSubBuckets + 1
+)
+
+// timeHistogram 表示以纳秒为单位的时间分布。
+//
+// 超出最大可表示范围（约 2^48ns，即大约 3 天）的值计入 overflow。
+//
+// 直方图的记录与读取都是原子的，因此可以被并发地写入，读取方无需 STW。
+// 但读取到的直方图不保证是某一瞬间的快照。
+type timeHistogram struct {
+	counts   [timeHistNumSuperBuckets * timeHistNumSubBuckets]uint64
+	overflow uint64
+}
+
+// record 将一个以纳秒为单位的时间记录到直方图中。
+// 负值会被忽略。
+//
+//go:nosplit
+func (h *timeHistogram) record(duration int64) {
+	if duration < 0 {
+		return
+	}
+	var superBucket, subBucket uint
+	if duration >= timeHistNumSubBuckets {
+		// 找到最高位，并将其作为 super-bucket 的下标
+		superBucket = uint(len64(uint64(duration))) - timeHistSubBucketBits
+		if superBucket*timeHistNumSubBuckets >= uint(len(h.counts)) {
+			// 超出可表示的范围
+			atomic.Xadd64(&h.overflow, 1)
+			return
+		}
+		// 最高位之后的 timeHistSubBucketBits 位决定 sub-bucket
+		subBucket = uint((duration >> (superBucket - 1)) % timeHistNumSubBuckets)
+	} else {
+		subBucket = uint(duration)
+	}
+	atomic.Xadd64(&h.counts[superBucket*timeHistNumSubBuckets+subBucket], 1)
+}
+
+// merge 将 h 中的计数原子地读出并累加到 counts 中。
+// counts 的长度必须为 timeHistTotalBuckets，其中最后一个元素为 overflow。
+func (h *timeHistogram) merge(counts []uint64) {
+	for i := range h.counts {
+		counts[i] += atomic.Load64(&h.counts[i])
+	}
+	counts[len(h.counts)] += atomic.Load64(&h.overflow)
+}
+
+// len64 返回表示 x 所需的最少位数；x == 0 时返回 0。
+//
+//go:nosplit
+func len64(x uint64) (n int) {
+	if x >= 1<<32 {
+		x >>= 32
+		n = 32
+	}
+	if x >= 1<<16 {
+		x >>= 16
+		n += 16
+	}
+	if x >= 1<<8 {
+		x >>= 8
+		n += 8
+	}
+	for x != 0 {
+		x >>= 1
+		n++
+	}
+	return n
+}
+
+// timeHistBucketMin 返回第 i 个桶的下界（纳秒）。
+// i == timeHistTotalBuckets-1 时为 overflow 桶的下界。
+func timeHistBucketMin(i int) int64 {
+	if i >= timeHistNumSuperBuckets*timeHistNumSubBuckets {
+		return int64(1) << (timeHistNumSuperBuckets - 1 + timeHistSubBucketBits)
+	}
+	superBucket, subBucket := i/timeHistNumSubBuckets, i%timeHistNumSubBuckets
+	if superBucket == 0 {
+		// super-bucket 0 中每个 sub-bucket 宽 1ns
+		return int64(subBucket)
+	}
+	// 第 n 个 super-bucket 的下界为 2^(n+timeHistSubBucketBits-1)，
+	// 其中每个 sub-bucket 宽 2^(n-1)
+	return int64(1)<<uint(superBucket-1+timeHistSubBucketBits) | int64(subBucket)<<uint(superBucket-1)
+}
+
+// timeHistQuantile 返回 counts 描述的分布中 q 分位数所在的桶的下界（纳秒）。
+// counts 的长度必须为 timeHistTotalBuckets。若 counts 为空，返回 0。
+func timeHistQuantile(counts []uint64, q float64) int64 {
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	target := uint64(float64(total) * q)
+	if target >= total {
+		target = total - 1
+	}
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen > target {
+			return timeHistBucketMin(i)
+		}
+	}
+	return timeHistBucketMin(len(counts) - 1)
+}
+
+// timeHistogramMetricsBuckets 生成 timeHistogram 各个桶以秒为单位的边界，
+// 供 runtime/metrics 使用。返回的切片长度为 timeHistTotalBuckets+1，
+// 最后一个边界为正无穷（对应 overflow 桶）。
+func timeHistogramMetricsBuckets() []float64 {
+	b := make([]float64, timeHistTotalBuckets+1)
+	for i := 0; i < timeHistTotalBuckets; i++ {
+		b[i] = float64(timeHistBucketMin(i)) / 1e9
+	}
+	b[len(b)-1] = inf
+	return b
+}
@@ -149,9 +149,26 @@ func init() {
 	gopark(nil, nil, waitReasonZero, traceEvNone, 1)
 }
 
+// idleID 是 beforeIdle 调度的超时事件的 id
+var idleID int32
+
 // beforeIdle gets called by the scheduler if no goroutine is awake.
+// If delay > 0, a timeout event is scheduled so that the next timer
+// can run after delay nanoseconds.
 // We resume the event handler (if available) which will pause the execution.
-func beforeIdle() bool {
+func beforeIdle(delay int64) bool {
+	if delay > 0 {
+		clearIdleID()
+		if delay < 1e6 {
+			delay = 1
+		} else if delay < 1e15 {
+			delay = delay / 1e6
+		} else {
+			// 任意设置的等待上限，1e9 毫秒约为 11.5 天
+			delay = 1e9
+		}
+		idleID = scheduleTimeoutEvent(delay)
+	}
 	if returnedEventHandler != nil {
 		goready(returnedEventHandler, 1)
 		return true
@@ -159,6 +176,14 @@ func beforeIdle() bool {
 	return false
 }
 
+// clearIdleID 清除 beforeIdle 调度的超时事件
+func clearIdleID() {
+	if idleID != 0 {
+		clearTimeoutEvent(idleID)
+		idleID = 0
+	}
+}
+
 // pause sets SP to newsp and pauses the execution of Go's WebAssembly code until an event is triggered.
 func pause(newsp uintptr)
 
@@ -174,6 +199,7 @@ func handleEvent() {
 	returnedEventHandler = nil
 	checkTimeouts()
 	eventHandler()
+	clearIdleID()
 
 	returnedEventHandler = getg()
 	gopark(nil, nil, waitReasonZero, traceEvNone, 1)
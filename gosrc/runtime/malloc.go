@@ -773,6 +773,11 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		return unsafe.Pointer(&zerobase)
 	}
 
+	// 确定性调度模式下，内存分配是一个调度点
+	if debug.schedseed != 0 {
+		schedSeedPoint()
+	}
+
 	if debug.sbrk != 0 {
 		align := uintptr(16)
 		if typ != nil {
@@ -818,7 +823,25 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 	c := gomcache()
 	var x unsafe.Pointer
 	noscan := typ == nil || typ.kind&kindNoPointers != 0
-	if size <= maxSmallSize {
+	var guard *guardSlot
+	var guardChargeSize uintptr // 被采样的对象计入 goroutine 的大小
+	if debug.guardalloc > 0 {
+		guard = guardSample(c, size, typ, noscan)
+	}
+	if guard != nil {
+		// 被采样的对象单独占用一个 span，紧挨着一个不可访问的保护页
+		s := guard.span
+		shouldhelpgc = true
+		atomic.Xadd64(&c.stats.largeAlloc, int64(s.elemsize))
+		atomic.Xadd64(&c.stats.largeAllocCount, 1)
+		s.freeindex = 1
+		s.allocCount = 1
+		x = unsafe.Pointer(guard.addr)
+		// span 还包含保护页，计入 goroutine 时按请求所在的大小等级计算，
+		// 与没有被采样时相同
+		guardChargeSize = roundupsize(dataSize)
+		size = s.elemsize
+	} else if size <= maxSmallSize {
 		if noscan && size < maxTinySize {
 			// 微型分配器 (tiny allocator)
 			//
@@ -861,6 +884,8 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 				c.tinyoffset = off + size
 				// 统计数量
 				c.local_tinyallocs++
+				c.tinyAllocs++
+				chargeAlloc(size)
 				// 完成分配，释放 m
 				mp.mallocing = 0
 				releasem(mp)
@@ -909,6 +934,8 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		systemstack(func() {
 			s = largeAlloc(size, needzero, noscan)
 		})
+		atomic.Xadd64(&c.stats.largeAlloc, int64(s.elemsize))
+		atomic.Xadd64(&c.stats.largeAllocCount, 1)
 		s.freeindex = 1
 		s.allocCount = 1
 		x = unsafe.Pointer(s.base())
@@ -926,7 +953,11 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		if typ == deferType {
 			dataSize = unsafe.Sizeof(_defer{})
 		}
-		heapBitsSetType(uintptr(x), size, dataSize, typ)
+		if guard != nil {
+			spanHeapBitsSetType(guard.span, guard.span.base(), uintptr(x), typ, dataSize/typ.size)
+		} else {
+			heapBitsSetType(uintptr(x), size, dataSize, typ)
+		}
 		if dataSize > typ.size {
 			// Array allocation. If there are any
 			// pointers, GC has to scan to the last
@@ -971,12 +1002,23 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		tracealloc(x, size, typ)
 	}
 
+	if guard != nil {
+		guard.recordAlloc()
+	}
+
+	// 此时 size 已经是大小等级或大对象 span 的实际大小
+	if guard != nil {
+		chargeAlloc(guardChargeSize)
+	} else {
+		chargeAlloc(size)
+	}
+
 	if rate := MemProfileRate; rate > 0 {
 		if rate != 1 && int32(size) < c.next_sample {
 			c.next_sample -= int32(size)
 		} else {
 			mp := acquirem()
-			profilealloc(mp, x, size)
+			profilealloc(mp, x, size, typ)
 			releasem(mp)
 		}
 	}
@@ -996,6 +1038,17 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 	return x
 }
 
+// chargeAlloc 将一次 size 字节的分配计入当前 goroutine。
+// 在系统栈上的分配计入 m 当前运行的用户 goroutine。
+//
+//go:nosplit
+func chargeAlloc(size uintptr) {
+	if gp := getg().m.curg; gp != nil {
+		gp.allocBytes += uint64(size)
+		gp.allocObjects++
+	}
+}
+
 func largeAlloc(size uintptr, needzero bool, noscan bool) *mspan {
 	// print("largeAlloc size=", size, "\n")
 
@@ -1051,9 +1104,9 @@ func reflect_unsafe_NewArray(typ *_type, n int) unsafe.Pointer {
 	return newarray(typ, n)
 }
 
-func profilealloc(mp *m, x unsafe.Pointer, size uintptr) {
+func profilealloc(mp *m, x unsafe.Pointer, size uintptr, typ *_type) {
 	mp.mcache.next_sample = nextSample()
-	mProf_Malloc(x, size)
+	mProf_Malloc(x, size, typ)
 }
 
 // nextSample 返回堆分析的下一个采样点。目标是平均每个 MemProfileRate 字节采样分配，
@@ -575,7 +575,7 @@ func mapaccess2_fat(t *maptype, h *hmap, key, zero unsafe.Pointer) (unsafe.Point
 // Like mapaccess, but allocates a slot for the key if it is not present in the map.
 func mapassign(t *maptype, h *hmap, key unsafe.Pointer) unsafe.Pointer {
 	if h == nil {
-		panic(plainError("assignment to entry in nil map"))
+		panic(&NilMapError{})
 	}
 	if raceenabled {
 		callerpc := getcallerpc()
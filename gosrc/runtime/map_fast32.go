@@ -91,7 +91,7 @@ func mapaccess2_fast32(t *maptype, h *hmap, key uint32) (unsafe.Pointer, bool) {
 
 func mapassign_fast32(t *maptype, h *hmap, key uint32) unsafe.Pointer {
 	if h == nil {
-		panic(plainError("assignment to entry in nil map"))
+		panic(&NilMapError{})
 	}
 	if raceenabled {
 		callerpc := getcallerpc()
@@ -181,7 +181,7 @@ done:
 
 func mapassign_fast32ptr(t *maptype, h *hmap, key unsafe.Pointer) unsafe.Pointer {
 	if h == nil {
-		panic(plainError("assignment to entry in nil map"))
+		panic(&NilMapError{})
 	}
 	if raceenabled {
 		callerpc := getcallerpc()
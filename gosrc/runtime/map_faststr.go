@@ -201,7 +201,7 @@ dohash:
 
 func mapassign_faststr(t *maptype, h *hmap, s string) unsafe.Pointer {
 	if h == nil {
-		panic(plainError("assignment to entry in nil map"))
+		panic(&NilMapError{})
 	}
 	if raceenabled {
 		callerpc := getcallerpc()
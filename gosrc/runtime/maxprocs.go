@@ -0,0 +1,118 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import "runtime/internal/atomic"
+
+// 默认的 GOMAXPROCS
+//
+// 若用户没有通过 GOMAXPROCS 环境变量或 runtime.GOMAXPROCS 显式设置，
+// 默认的 GOMAXPROCS 为 CPU 个数与 cgroup CPU 限制（向上取整）中较小的那个。
+// 否则在 CPU 配额很小的容器中会创建大量的 P，导致严重的 CPU throttling。
+//
+// 容器的 CPU 限制在运行期间可能会被修改，因此 sysmon 会每隔 maxprocsCheckPeriod
+// 唤醒 maxprocs helper goroutine 重新读取限制，若默认值发生变化，helper 会在 STW
+// 下通过 procresize 调整 P 的数量。读取 cgroup 文件需要若干次系统调用，
+// 放在 helper goroutine 中可以避免在 sysmon 较小的 g0 栈上进行。
+//
+// GODEBUG=containermaxprocs=0 忽略 cgroup 的 CPU 限制，
+// GODEBUG=updatemaxprocs=0 关闭运行期间的定期调整。
+
+// maxprocsCheckPeriod 为 sysmon 检查 CPU 限制变化的间隔（纳秒）
+const maxprocsCheckPeriod = 1 * 1000 * 1000 * 1000
+
+// customGOMAXPROCS 表示用户显式设置了 GOMAXPROCS，此时不再自动调整。
+// 由 sched.lock 保护写入。
+var customGOMAXPROCS bool
+
+var maxprocs struct {
+	lock mutex
+	g    *g
+	idle uint32
+}
+
+// defaultGOMAXPROCS 返回在给定 CPU 个数下默认的 GOMAXPROCS。
+func defaultGOMAXPROCS(ncpu int32) int32 {
+	procs := ncpu
+	if debug.containermaxprocs == 0 {
+		return procs
+	}
+	if limit, ok := cgroupCPULimit(); ok {
+		// 向上取整：1.5 个 CPU 的配额可以让 2 个 P 各自运行一部分时间
+		n := int32(limit)
+		if float64(n) < limit {
+			n++
+		}
+		if n < 1 {
+			n = 1
+		}
+		if n < procs {
+			procs = n
+		}
+	}
+	return procs
+}
+
+// maxprocsUpdateEnabled 报告是否需要在运行期间定期重新读取 CPU 限制。
+// 只有 linux 上有 cgroup，其他系统上不需要 helper goroutine。
+func maxprocsUpdateEnabled() bool {
+	return GOOS == "linux" && debug.updatemaxprocs != 0 && debug.containermaxprocs != 0
+}
+
+// 启动 maxprocs helper goroutine
+func init() {
+	if maxprocsUpdateEnabled() {
+		go maxprocshelper()
+	}
+}
+
+func maxprocshelper() {
+	maxprocs.g = getg()
+	for {
+		lock(&maxprocs.lock)
+		if maxprocs.idle != 0 {
+			throw("maxprocs: phase error")
+		}
+		atomic.Store(&maxprocs.idle, 1)
+		goparkunlock(&maxprocs.lock, waitReasonMaxProcsIdle, traceEvGoBlock, 1)
+		// 由 sysmon 唤醒
+
+		lock(&sched.lock)
+		custom := customGOMAXPROCS
+		cur := gomaxprocs
+		unlock(&sched.lock)
+		if custom {
+			continue
+		}
+		procs := defaultGOMAXPROCS(ncpu)
+		if procs == cur {
+			continue
+		}
+
+		stopTheWorld("GOMAXPROCS (cgroup)")
+		// 用户可能在此期间显式地设置了 GOMAXPROCS
+		if !customGOMAXPROCS {
+			newprocs = procs
+		}
+		startTheWorld()
+	}
+}
+
+// sysmonUpdateMaxProcs 由 sysmon 定期调用，唤醒 maxprocs helper 重新检查 CPU 限制。
+func sysmonUpdateMaxProcs() {
+	if !maxprocsUpdateEnabled() {
+		return
+	}
+	if atomic.Load(&maxprocs.idle) == 0 {
+		// helper 还没有启动，或上一次检查还没有完成
+		return
+	}
+	lock(&maxprocs.lock)
+	maxprocs.idle = 0
+	var list gList
+	list.push(maxprocs.g)
+	injectglist(&list)
+	unlock(&maxprocs.lock)
+}
@@ -1507,6 +1507,50 @@ var debugPtrmask struct {
 	data *byte
 }
 
+// spanHeapBitsSetType 为大对象 span s 中 x 处的 n 个类型为 typ 的值写入堆位图，
+// 用于不在 span 起始位置的对象（arena 的 chunk 与保护分配）。
+// [lo, x) 为 x 之前已经写入位图的部分之后的空隙。
+//
+// 与 heapBitsSetType 不同，[lo, x+n*typ.size) 中所有字的 scan 位都会被置位，
+// 垃圾回收器因此会从 s.base() 开始一直扫描到 x+n*typ.size。
+// span 的第二个字除外，它的 scan 位被用作 checkmark 位。
+//
+// span 在写入期间可能正在被垃圾回收器扫描，扫描在第一个 scan 位为 0 的字处停止。
+// 因此先写入指针位，最后才写入 scan 位，使垃圾回收器只会看到已经清零的对象。
+func spanHeapBitsSetType(s *mspan, lo, x uintptr, typ *_type, n uintptr) {
+	mask := typ.gcdata
+	if typ.kind&kindGCProg != 0 {
+		// 将 GC 程序展开为 1 位的指针掩码，暂时存放在对象自己的内存中
+		mask = (*byte)(unsafe.Pointer(x))
+		runGCProg(addb(typ.gcdata, 4), nil, mask, 1)
+	}
+	nptr := typ.ptrdata / sys.PtrSize
+	nw := typ.size / sys.PtrSize
+
+	h := heapBitsForAddr(x)
+	for i := uintptr(0); i < n; i++ {
+		for j := uintptr(0); j < nw; j++ {
+			if j < nptr && *addb(mask, j/8)>>(j%8)&1 != 0 {
+				atomic.Or8(h.bitp, bitPointer<<h.shift)
+			}
+			h = h.next()
+		}
+	}
+	if typ.kind&kindGCProg != 0 {
+		memclrNoHeapPointers(unsafe.Pointer(x), (nptr+7)/8)
+	}
+
+	checkmark := s.base() + sys.PtrSize
+	end := x + n*typ.size
+	h = heapBitsForAddr(lo)
+	for p := lo; p < end; p += sys.PtrSize {
+		if p != checkmark {
+			atomic.Or8(h.bitp, bitScan<<h.shift)
+		}
+		h = h.next()
+	}
+}
+
 // heapBitsSetTypeGCProg implements heapBitsSetType using a GC program.
 // progSize is the size of the memory described by the program.
 // elemSize is the size of the element that the GC program describes (a prefix of).
@@ -37,11 +37,21 @@ type mcache struct {
 
 	stackcache [_NumStackOrders]stackfreelist
 
+	// next_guard 为距离下一次保护分配还需要的分配次数，见 mguard.go
+	next_guard int32
+
 	// 本地分配器统计，在 GC 期间被刷新
 	local_largefree  uintptr                  // bytes freed for large objects (>maxsmallsize)
 	local_nlargefree uintptr                  // number of frees for large objects (>maxsmallsize)
 	local_nsmallfree [_NumSizeClasses]uintptr // number of frees for small objects (<=maxsmallsize)
 
+	// stats 为所属 P 的堆统计分片，供 runtime/metrics 在不 STW 的情况下读取。
+	// 只由所属 P 原子地更新。
+	stats heapStatsShard
+
+	// tinyAllocs 为上一次 refill 以来的 tiny 分配数量，在 refill 时并入 stats。
+	tinyAllocs uintptr
+
 	// flushGen indicates the sweepgen during which this mcache
 	// was last flushed. If flushGen != mheap_.sweepgen, the spans
 	// in this mcache are stale and need to the flushed so they
@@ -100,6 +110,7 @@ func freemcache(c *mcache) {
 		lock(&mheap_.lock)
 		// 记录局部统计
 		purgecachedstats(c)
+		retireHeapStats(c)
 		// 将 mcache 释放
 		mheap_.cachealloc.free(unsafe.Pointer(c))
 		unlock(&mheap_.lock)
@@ -140,6 +151,12 @@ func (c *mcache) refill(spc spanClass) {
 	// sweeping in the next sweep phase.
 	s.sweepgen = mheap_.sweepgen + 3
 
+	// 将 span 中剩余的空闲对象预先计为已分配，在 span 被归还时扣除未使用的部分。
+	// 这样 malloc 的快速路径无需更新任何统计。
+	atomic.Xadd64(&c.stats.smallAllocCount[spc.sizeclass()], int64(s.nelems)-int64(s.allocCount))
+	atomic.Xadd64(&c.stats.tinyAllocCount, int64(c.tinyAllocs))
+	c.tinyAllocs = 0
+
 	c.alloc[spc] = s
 }
 
@@ -147,6 +164,11 @@ func (c *mcache) releaseAll() {
 	for i := range c.alloc {
 		s := c.alloc[i]
 		if s != &emptymspan {
+			// 扣除在 refill 时预先计入但没有被分配的对象
+			n := int64(s.nelems) - int64(s.allocCount)
+			if n > 0 {
+				atomic.Xadd64(&c.stats.smallAllocCount[spanClass(i).sizeclass()], -n)
+			}
 			// 将 span 归还
 			mheap_.central[i].mcentral.uncacheSpan(s)
 			c.alloc[i] = &emptymspan
@@ -155,6 +177,8 @@ func (c *mcache) releaseAll() {
 	// 清空 tinyalloc 池.
 	c.tiny = 0
 	c.tinyoffset = 0
+	atomic.Xadd64(&c.stats.tinyAllocCount, int64(c.tinyAllocs))
+	c.tinyAllocs = 0
 }
 
 // prepareForSweep flushes c if the system has entered a new sweep phase
@@ -0,0 +1,93 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import "unsafe"
+
+// AddCleanup 为 ptr 指向的对象添加一个清理函数（cleanup）。
+// 当垃圾回收器发现对象不可达后的某个时刻，会在单独的 goroutine 中调用 cleanup(arg)。
+//
+// 与 SetFinalizer 不同：
+//
+//   - cleanup 不接收对象本身，对象不会被复活，因此对象以及它引用的内存会在
+//     第一次不可达时被立即回收，即使对象处于循环引用之中。
+//   - 同一个对象可以有多个 cleanup，它们的执行顺序是不确定的。
+//
+// ptr 必须为指针。若 arg 等于 ptr，或 arg、cleanup 的闭包引用了该对象，
+// 则对象永远可达，cleanup 永远不会运行；arg 为 ptr 时 AddCleanup 会 panic。
+//
+// 与 finalizer 相同，cleanup 由同一个 goroutine 依次运行，且不保证在程序退出前运行。
+// 若 ptr 指向的对象不在堆上（例如全局变量或零大小的对象），则 cleanup 永远不会运行。
+// 若对象同时设置了 finalizer，cleanup 在 finalizer 运行后、对象再次不可达时才会运行。
+//
+// 返回的 Cleanup 可以用来取消该 cleanup。
+func AddCleanup(ptr interface{}, cleanup func(interface{}), arg interface{}) Cleanup {
+	e := efaceOf(&ptr)
+	etyp := e._type
+	if etyp == nil {
+		throw("runtime.AddCleanup: ptr is nil")
+	}
+	if etyp.kind&kindMask != kindPtr {
+		throw("runtime.AddCleanup: ptr is " + etyp.string() + ", not pointer")
+	}
+	if cleanup == nil {
+		throw("runtime.AddCleanup: cleanup is nil")
+	}
+	if a := efaceOf(&arg); a._type == etyp && a.data == e.data {
+		panic("runtime.AddCleanup: ptr is equal to arg, cleanup will never run")
+	}
+	if debug.sbrk != 0 {
+		// debug.sbrk never frees memory, so no cleanups run
+		return Cleanup{}
+	}
+
+	// find the containing object
+	base, span, _ := findObject(uintptr(e.data), 0, 0)
+	if base == 0 {
+		// 不在堆上的对象永远不会被释放
+		return Cleanup{}
+	}
+	if span.isUserArenaChunk {
+		panic("runtime.AddCleanup: ptr is arena-allocated")
+	}
+
+	fn := func() {
+		cleanup(arg)
+	}
+	fv := *(**funcval)(unsafe.Pointer(&fn))
+
+	// make sure we have a finalizer goroutine
+	createfing()
+
+	var id uint64
+	systemstack(func() {
+		id = addcleanup(unsafe.Pointer(base), fv)
+	})
+	// 在 cleanup 添加完成之前保持对象与闭包存活
+	KeepAlive(ptr)
+	KeepAlive(fn)
+	return Cleanup{id: id, ptr: base}
+}
+
+// Cleanup 为 AddCleanup 返回的句柄，用于取消 cleanup
+type Cleanup struct {
+	id  uint64  // cleanup 的 ID，为 0 表示 cleanup 永远不会运行
+	ptr uintptr // 对象的起始地址，不能使对象存活，因此保存为 uintptr
+}
+
+// Stop 取消 cleanup。
+// 若 cleanup 已经开始运行或已经被取消，Stop 不做任何事情。
+//
+// Stop 不会等待正在运行的 cleanup 结束。
+func (c Cleanup) Stop() {
+	if c.id == 0 {
+		return
+	}
+	// 对象可能已经被释放，其内存被另一个对象重新使用，
+	// 但 ID 是唯一的，因此不会错误地移除其他对象的 cleanup
+	systemstack(func() {
+		removecleanup(unsafe.Pointer(c.ptr), c.id)
+	})
+}
@@ -0,0 +1,608 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// runtime/metrics 的运行时实现
+//
+// 与 ReadMemStats 不同，读取 metrics 时不会 STW：
+//
+// 1. 分配与释放的统计按 P 分片，记录在每个 P 的 mcache.stats 中，
+//    由拥有该 mcache 的 P 原子地更新。读取方在不可抢占的状态下（systemstack）
+//    遍历 allp 求和。由于 procresize 需要 STW，读取期间 allp 中的 mcache
+//    不会被销毁。已销毁的 mcache 的统计会并入 heapStatsRetired。
+//
+// 2. 调度延迟同样记录在每个 P 的直方图中，GC 停顿与周期时间记录在全局直方图中，
+//    所有直方图均为原子更新。
+//
+// 3. 其余的内存统计从 memstats 中读取，只需要持有 mheap_.lock。
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+var (
+	// metrics 是 metric 名称到其实现的映射，由 metricsSema 保护。
+	metrics     map[string]metricData
+	metricsSema uint32 = 1
+	metricsInit bool
+
+	// 直方图桶的边界，在 initMetrics 中计算。
+	sizeClassBuckets []float64
+	timeHistBuckets  []float64
+
+	// heapStatsRetired 保存已经被销毁的 mcache 的统计分片。
+	// 只在 STW 时写入。
+	heapStatsRetired heapStatsShard
+
+	// gcPauseDist 记录每一次 GC STW 停顿的时长。
+	gcPauseDist timeHistogram
+
+	// gcCycleDist 记录每个 GC 周期从 sweep termination 开始到
+	// mark termination 结束的时长。
+	gcCycleDist timeHistogram
+)
+
+// gTrackingPeriod 为调度延迟的采样周期：
+// goroutine 每离开 _Grunning 状态这么多次，才会跟踪一次它从 _Grunnable 到 _Grunning 的延迟。
+const gTrackingPeriod = 8
+
+// heapStatsShard 为一个 P 的堆统计分片。
+//
+// 分片中的字段只由拥有该分片的 P 原子地增加（或在 STW 时修改），
+// 因此可以在不停止世界的情况下被读取。
+type heapStatsShard struct {
+	// smallAllocCount 为按大小等级分配的小对象数量。
+	// 在 mcache refill 时按 span 中剩余的空闲对象计入，在 span 被归还时扣除未使用的部分。
+	smallAllocCount [_NumSizeClasses]uint64
+	// smallFreeCount 为清扫时按大小等级释放的小对象数量
+	smallFreeCount [_NumSizeClasses]uint64
+
+	largeAlloc      uint64 // 分配的大对象字节数
+	largeAllocCount uint64 // 分配的大对象数量
+	largeFree       uint64 // 释放的大对象字节数
+	largeFreeCount  uint64 // 释放的大对象数量
+	tinyAllocCount  uint64 // 被合并到 tiny 块中的分配数量
+}
+
+// merge 将 o 中的统计原子地读出并累加到 s 中。s 必须是一个私有的副本。
+//
+//go:nosplit
+func (s *heapStatsShard) merge(o *heapStatsShard) {
+	for i := range o.smallAllocCount {
+		s.smallAllocCount[i] += atomic.Load64(&o.smallAllocCount[i])
+		s.smallFreeCount[i] += atomic.Load64(&o.smallFreeCount[i])
+	}
+	s.largeAlloc += atomic.Load64(&o.largeAlloc)
+	s.largeAllocCount += atomic.Load64(&o.largeAllocCount)
+	s.largeFree += atomic.Load64(&o.largeFree)
+	s.largeFreeCount += atomic.Load64(&o.largeFreeCount)
+	s.tinyAllocCount += atomic.Load64(&o.tinyAllocCount)
+}
+
+// retireHeapStats 将即将被释放的 mcache c 的统计分片并入 heapStatsRetired。
+//
+// 世界必须停止。
+func retireHeapStats(c *mcache) {
+	heapStatsRetired.merge(&c.stats)
+	c.stats = heapStatsShard{}
+}
+
+// metricData 描述一个 metric 的实现。
+type metricData struct {
+	// deps 为计算该 metric 前必须完成的聚合
+	deps statDepSet
+
+	// compute 根据聚合后的统计计算 metric 的值。
+	compute func(in *statAggregate, out *metricValue)
+}
+
+// initMetrics 初始化 metrics 映射。
+//
+// metricsSema 必须被持有。
+func initMetrics() {
+	if metricsInit {
+		return
+	}
+
+	sizeClassBuckets = make([]float64, _NumSizeClasses, _NumSizeClasses+1)
+	// 跳过大小等级 0，它代表大对象，而大对象会被放在最后一个桶中。
+	sizeClassBuckets[0] = 1 // 最小的分配为 1 字节
+	for i := 1; i < _NumSizeClasses; i++ {
+		// 大小等级的区间为左开右闭（例如 48 字节的等级为 (32, 48]），
+		// 而直方图的桶为左闭右开，因此将所有边界加一（[33, 49)）。
+		sizeClassBuckets[i] = float64(class_to_size[i] + 1)
+	}
+	sizeClassBuckets = append(sizeClassBuckets, inf)
+
+	timeHistBuckets = timeHistogramMetricsBuckets()
+
+	metrics = map[string]metricData{
+		"/cgo/go-to-c-calls:calls": {
+			compute: func(_ *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = uint64(NumCgoCall())
+			},
+		},
+		"/gc/cycles/automatic:gc-cycles": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.gcCyclesDone - in.sysStats.gcCyclesForced
+			},
+		},
+		"/gc/cycles/duration:seconds": {
+			compute: func(_ *statAggregate, out *metricValue) {
+				hist := out.float64HistOrInit(timeHistBuckets)
+				gcCycleDist.merge(hist.counts)
+			},
+		},
+		"/gc/cycles/forced:gc-cycles": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.gcCyclesForced
+			},
+		},
+		"/gc/cycles/total:gc-cycles": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.gcCyclesDone
+			},
+		},
+		"/gc/heap/allocs-by-size:bytes": {
+			deps: makeStatDepSet(heapStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				hist := out.float64HistOrInit(sizeClassBuckets)
+				// 跳过大小等级 0：大对象单独统计，放在最后一个桶中。
+				for i, count := range in.heapStats.smallAllocCount[1:] {
+					hist.counts[i] = count
+				}
+				hist.counts[len(hist.counts)-1] = in.heapStats.largeAllocCount
+			},
+		},
+		"/gc/heap/allocs:bytes": {
+			deps: makeStatDepSet(heapStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.heapStats.totalAllocated
+			},
+		},
+		"/gc/heap/allocs:objects": {
+			deps: makeStatDepSet(heapStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.heapStats.totalAllocs
+			},
+		},
+		"/gc/heap/frees-by-size:bytes": {
+			deps: makeStatDepSet(heapStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				hist := out.float64HistOrInit(sizeClassBuckets)
+				for i, count := range in.heapStats.smallFreeCount[1:] {
+					hist.counts[i] = count
+				}
+				hist.counts[len(hist.counts)-1] = in.heapStats.largeFreeCount
+			},
+		},
+		"/gc/heap/frees:bytes": {
+			deps: makeStatDepSet(heapStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.heapStats.totalFreed
+			},
+		},
+		"/gc/heap/frees:objects": {
+			deps: makeStatDepSet(heapStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.heapStats.totalFrees
+			},
+		},
+		"/gc/heap/goal:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.heapGoal
+			},
+		},
+		"/gc/heap/objects:objects": {
+			deps: makeStatDepSet(heapStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.heapStats.numObjects
+			},
+		},
+		"/gc/heap/tiny/allocs:objects": {
+			deps: makeStatDepSet(heapStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.heapStats.tinyAllocCount
+			},
+		},
+		"/gc/pauses:seconds": {
+			compute: func(_ *statAggregate, out *metricValue) {
+				hist := out.float64HistOrInit(timeHistBuckets)
+				gcPauseDist.merge(hist.counts)
+			},
+		},
+		"/gc/stack/starting-size:bytes": {
+			compute: func(_ *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = uint64(startingStackSize)
+			},
+		},
+		"/memory/classes/heap/free:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.heapSys - in.sysStats.heapInUse - in.sysStats.heapReleased
+			},
+		},
+		"/memory/classes/heap/objects:bytes": {
+			deps: makeStatDepSet(heapStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.heapStats.inObjects
+			},
+		},
+		"/memory/classes/heap/released:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.heapReleased
+			},
+		},
+		"/memory/classes/heap/stacks:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.stacksInUse
+			},
+		},
+		"/memory/classes/heap/unused:bytes": {
+			deps: makeStatDepSet(heapStatsDep, sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				// 分片与 memstats 并不是同时读取的，因此差值可能短暂地为负。
+				if in.sysStats.heapInUse > in.heapStats.inObjects {
+					out.scalar = in.sysStats.heapInUse - in.heapStats.inObjects
+				} else {
+					out.scalar = 0
+				}
+			},
+		},
+		"/memory/classes/metadata/mcache/free:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.mCacheSys - in.sysStats.mCacheInUse
+			},
+		},
+		"/memory/classes/metadata/mcache/inuse:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.mCacheInUse
+			},
+		},
+		"/memory/classes/metadata/mspan/free:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.mSpanSys - in.sysStats.mSpanInUse
+			},
+		},
+		"/memory/classes/metadata/mspan/inuse:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.mSpanInUse
+			},
+		},
+		"/memory/classes/metadata/other:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.gcMiscSys
+			},
+		},
+		"/memory/classes/os-stacks:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.stacksSys
+			},
+		},
+		"/memory/classes/other:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.otherSys
+			},
+		},
+		"/memory/classes/profiling/buckets:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.buckHashSys
+			},
+		},
+		"/memory/classes/total:bytes": {
+			deps: makeStatDepSet(sysStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = in.sysStats.heapSys + in.sysStats.stacksInUse + in.sysStats.stacksSys +
+					in.sysStats.mSpanSys + in.sysStats.mCacheSys + in.sysStats.buckHashSys +
+					in.sysStats.gcMiscSys + in.sysStats.otherSys
+			},
+		},
+		"/sched/gomaxprocs:threads": {
+			compute: func(_ *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = uint64(gomaxprocs)
+			},
+		},
+		"/sched/goroutines:goroutines": {
+			compute: func(_ *statAggregate, out *metricValue) {
+				out.kind = metricKindUint64
+				out.scalar = uint64(gcount())
+			},
+		},
+		"/sched/latencies:seconds": {
+			deps: makeStatDepSet(schedStatsDep),
+			compute: func(in *statAggregate, out *metricValue) {
+				hist := out.float64HistOrInit(timeHistBuckets)
+				copy(hist.counts, in.schedStats.latencies[:])
+			},
+		},
+	}
+	metricsInit = true
+}
+
+// statDep 为一个统计聚合的依赖。
+type statDep uint
+
+const (
+	heapStatsDep  statDep = iota // 对应 heapStatsAggregate
+	sysStatsDep                  // 对应 sysStatsAggregate
+	schedStatsDep                // 对应 schedStatsAggregate
+	numStatsDeps
+)
+
+// statDepSet 为 statDep 的集合。
+type statDepSet uint64
+
+// makeStatDepSet 创建一个包含 deps 的集合。
+func makeStatDepSet(deps ...statDep) statDepSet {
+	var s statDepSet
+	for _, d := range deps {
+		s |= 1 << d
+	}
+	return s
+}
+
+// has 报告 d 是否在集合 s 中。
+func (s statDepSet) has(d statDep) bool {
+	return s&(1<<d) != 0
+}
+
+// heapStatsAggregate 为所有 P 的堆统计分片之和，以及由此推导出的统计。
+type heapStatsAggregate struct {
+	heapStatsShard
+
+	inObjects      uint64 // 分配且尚未释放（或尚未被清扫）的对象占用的字节数
+	numObjects     uint64 // 分配且尚未释放的对象数量
+	totalAllocated uint64 // 分配的总字节数
+	totalFreed     uint64 // 释放的总字节数
+	totalAllocs    uint64 // 分配的对象总数
+	totalFrees     uint64 // 释放的对象总数
+}
+
+// compute 汇总所有 P 的统计分片。它不会停止世界。
+func (a *heapStatsAggregate) compute() {
+	// 在系统栈上读取分片：此时当前 M 不可被抢占，
+	// procresize 无法并发地销毁 allp 中的 mcache。
+	systemstack(func() {
+		for _, p := range allp {
+			if c := p.mcache; c != nil {
+				a.merge(&c.stats)
+			}
+		}
+		a.merge(&heapStatsRetired)
+	})
+
+	a.totalAllocs = a.largeAllocCount
+	a.totalFrees = a.largeFreeCount
+	a.totalAllocated = a.largeAlloc
+	a.totalFreed = a.largeFree
+	for i := range a.smallAllocCount {
+		na := a.smallAllocCount[i]
+		nf := a.smallFreeCount[i]
+		a.totalAllocs += na
+		a.totalFrees += nf
+		a.totalAllocated += na * uint64(class_to_size[i])
+		a.totalFreed += nf * uint64(class_to_size[i])
+	}
+	// 分配在 refill 时预先计入，而释放在清扫时才计入，
+	// 两者读取的时刻也不同，因此做一次饱和减法。
+	if a.totalAllocated > a.totalFreed {
+		a.inObjects = a.totalAllocated - a.totalFreed
+	}
+	if a.totalAllocs > a.totalFrees {
+		a.numObjects = a.totalAllocs - a.totalFrees
+	}
+}
+
+// sysStatsAggregate 为从 memstats 中读取的统计。
+type sysStatsAggregate struct {
+	heapSys        uint64
+	heapInUse      uint64
+	heapReleased   uint64
+	stacksInUse    uint64
+	stacksSys      uint64
+	mSpanSys       uint64
+	mSpanInUse     uint64
+	mCacheSys      uint64
+	mCacheInUse    uint64
+	buckHashSys    uint64
+	gcMiscSys      uint64
+	otherSys       uint64
+	heapGoal       uint64
+	gcCyclesDone   uint64
+	gcCyclesForced uint64
+}
+
+// compute 从 memstats 中读取统计，只需要持有 mheap_.lock。
+func (a *sysStatsAggregate) compute() {
+	systemstack(func() {
+		lock(&mheap_.lock)
+		a.heapSys = memstats.heap_sys
+		a.heapInUse = memstats.heap_inuse
+		a.heapReleased = memstats.heap_released
+		a.stacksInUse = memstats.stacks_inuse
+		a.stacksSys = atomic.Load64(&memstats.stacks_sys)
+		a.mSpanSys = atomic.Load64(&memstats.mspan_sys)
+		a.mSpanInUse = uint64(mheap_.spanalloc.inuse)
+		a.mCacheSys = atomic.Load64(&memstats.mcache_sys)
+		a.mCacheInUse = uint64(mheap_.cachealloc.inuse)
+		a.buckHashSys = atomic.Load64(&memstats.buckhash_sys)
+		a.gcMiscSys = atomic.Load64(&memstats.gc_sys)
+		a.otherSys = atomic.Load64(&memstats.other_sys)
+		a.heapGoal = atomic.Load64(&memstats.next_gc)
+		a.gcCyclesDone = uint64(atomic.Load(&memstats.numgc))
+		a.gcCyclesForced = uint64(atomic.Load(&memstats.numforcedgc))
+		unlock(&mheap_.lock)
+	})
+}
+
+// schedStatsAggregate 为所有 P 的调度统计之和。
+type schedStatsAggregate struct {
+	// latencies 为 goroutine 从 _Grunnable 到 _Grunning 的延迟分布
+	latencies [timeHistTotalBuckets]uint64
+}
+
+// compute 汇总所有 P 的调度延迟直方图。它不会停止世界。
+func (a *schedStatsAggregate) compute() {
+	systemstack(func() {
+		for _, p := range allp {
+			p.schedLatency.merge(a.latencies[:])
+		}
+	})
+}
+
+// statAggregate 为一次 readMetrics 期间计算的所有聚合统计。
+type statAggregate struct {
+	ensured    statDepSet
+	heapStats  heapStatsAggregate
+	sysStats   sysStatsAggregate
+	schedStats schedStatsAggregate
+}
+
+// ensure 确保 deps 中的聚合都已经计算过。
+func (a *statAggregate) ensure(deps *statDepSet) {
+	missing := *deps &^ a.ensured
+	if missing == 0 {
+		return
+	}
+	for i := statDep(0); i < numStatsDeps; i++ {
+		if !missing.has(i) {
+			continue
+		}
+		switch i {
+		case heapStatsDep:
+			a.heapStats.compute()
+		case sysStatsDep:
+			a.sysStats.compute()
+		case schedStatsDep:
+			a.schedStats.compute()
+		}
+	}
+	a.ensured |= missing
+}
+
+// metricKind 必须与 runtime/metrics 中的 ValueKind 保持一致。
+type metricKind int
+
+const (
+	// 必须与 runtime/metrics 中的常量保持一致
+	metricKindBad metricKind = iota
+	metricKindUint64
+	metricKindFloat64
+	metricKindFloat64Histogram
+)
+
+// metricSample 必须与 runtime/metrics 中的 Sample 具有相同的内存布局。
+type metricSample struct {
+	name  string
+	value metricValue
+}
+
+// metricValue 必须与 runtime/metrics 中的 Value 具有相同的内存布局。
+type metricValue struct {
+	kind    metricKind
+	scalar  uint64         // 包含标量值
+	pointer unsafe.Pointer // 包含非标量值
+}
+
+// float64HistOrInit 尝试复用 v 中已有的直方图，若不存在或桶的数量不同则分配一个新的。
+// 返回的直方图中的计数已被清零。
+func (v *metricValue) float64HistOrInit(buckets []float64) *metricFloat64Histogram {
+	var hist *metricFloat64Histogram
+	if v.kind == metricKindFloat64Histogram && v.pointer != nil {
+		hist = (*metricFloat64Histogram)(v.pointer)
+	} else {
+		v.kind = metricKindFloat64Histogram
+		hist = new(metricFloat64Histogram)
+		v.pointer = unsafe.Pointer(hist)
+	}
+	hist.buckets = buckets
+	if len(hist.counts) != len(hist.buckets)-1 {
+		hist.counts = make([]uint64, len(buckets)-1)
+	} else {
+		for i := range hist.counts {
+			hist.counts[i] = 0
+		}
+	}
+	return hist
+}
+
+// metricFloat64Histogram 必须与 runtime/metrics 中的 Float64Histogram 具有相同的内存布局。
+type metricFloat64Histogram struct {
+	counts  []uint64
+	buckets []float64
+}
+
+// readMetrics 为 runtime/metrics.Read 的实现。
+//
+// samplesp 为一个 []metricSample 的数据指针，len 与 cap 为其长度与容量。
+//
+//go:linkname readMetrics runtime/metrics.runtime_readMetrics
+func readMetrics(samplesp unsafe.Pointer, len int, cap int) {
+	// 构造 slice
+	sl := slice{samplesp, len, cap}
+	samples := *(*[]metricSample)(unsafe.Pointer(&sl))
+
+	semacquire(&metricsSema)
+
+	initMetrics()
+
+	// 聚合统计只在需要时计算，每次读取最多计算一次。
+	var agg statAggregate
+
+	for i := range samples {
+		sample := &samples[i]
+		data, ok := metrics[sample.name]
+		if !ok {
+			sample.value.kind = metricKindBad
+			continue
+		}
+		agg.ensure(&data.deps)
+		data.compute(&agg, &sample.value)
+	}
+
+	semrelease(&metricsSema)
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package metrics 提供了一个稳定的接口，用于访问 Go 运行时导出的、
+由实现定义的 metric。它与 runtime.ReadMemStats 和 runtime/debug.ReadGCStats
+类似，但更加通用，并且读取时不会停止世界。
+
+metric 的集合可能会随着运行时本身的演进而变化，也可能因实现而异。
+因此 metric 由名称而不是结构体字段来标识，使用方应当通过 All 查询
+当前运行时支持的 metric。
+
+metric 由一个字符串名称标识，名称中包含单位，例如 "/gc/heap/goal:bytes"。
+名称的完整定义参见 Description 的 Name 字段。名称的路径部分可以用来对
+metric 分组，单位部分则说明了如何解释它的值。
+
+每个 metric 都有一个 Description，描述其值的类型（ValueKind）以及它是否是
+累积的。目前的值可以是：uint64 计数（counter）或瞬时值（gauge），
+float64，以及 float64 直方图（Float64Histogram）。
+
+分配、释放与调度延迟等频繁更新的统计按 P 分片，由各个 P 以原子操作更新；
+Read 只是将这些分片相加，因此读取不需要停止世界，代价是同一次 Read 中的
+不同 metric 之间不保证完全一致。
+
+以下为当前支持的 metric 列表：
+
+	/cgo/go-to-c-calls:calls
+		Count of calls made from Go to C by the current process.
+
+	/gc/cycles/automatic:gc-cycles
+		Count of completed GC cycles generated by the Go runtime.
+
+	/gc/cycles/duration:seconds
+		Distribution of wall-clock durations of GC cycles, from the start of
+		sweep termination to the end of mark termination.
+
+	/gc/cycles/forced:gc-cycles
+		Count of completed GC cycles forced by the application.
+
+	/gc/cycles/total:gc-cycles
+		Count of all completed GC cycles.
+
+	/gc/heap/allocs-by-size:bytes
+		Distribution of heap allocations by approximate size. Note that this
+		does not include tiny objects as defined by
+		/gc/heap/tiny/allocs:objects, only tiny blocks.
+
+	/gc/heap/allocs:bytes
+		Cumulative sum of memory allocated to the heap by the application.
+
+	/gc/heap/allocs:objects
+		Cumulative count of heap allocations triggered by the application.
+		Note that this does not include tiny objects as defined by
+		/gc/heap/tiny/allocs:objects, only tiny blocks.
+
+	/gc/heap/frees-by-size:bytes
+		Distribution of freed heap allocations by approximate size. Note that
+		this does not include tiny objects as defined by
+		/gc/heap/tiny/allocs:objects, only tiny blocks.
+
+	/gc/heap/frees:bytes
+		Cumulative sum of heap memory freed by the garbage collector.
+
+	/gc/heap/frees:objects
+		Cumulative count of heap allocations whose storage was freed by the
+		garbage collector. Note that this does not include tiny objects as
+		defined by /gc/heap/tiny/allocs:objects, only tiny blocks.
+
+	/gc/heap/goal:bytes
+		Heap size target for the end of the GC cycle.
+
+	/gc/heap/objects:objects
+		Number of objects, live or unswept, occupying heap memory.
+
+	/gc/heap/tiny/allocs:objects
+		Count of small allocations that are packed together into blocks.
+		These allocations are counted separately from other allocations
+		because each individual allocation is not tracked by the runtime,
+		only their block. Each block is already accounted for in
+		allocs-by-size and frees-by-size.
+
+	/gc/pauses:seconds
+		Distribution of individual GC-related stop-the-world pause latencies.
+
+	/gc/stack/starting-size:bytes
+		The stack size of new goroutines.
+
+	/memory/classes/heap/free:bytes
+		Memory that is completely free and eligible to be returned to the
+		underlying system, but has not been. This metric is the runtime's
+		estimate of free address space that is backed by physical memory.
+
+	/memory/classes/heap/objects:bytes
+		Memory occupied by live objects and dead objects that have not yet
+		been marked free by the garbage collector.
+
+	/memory/classes/heap/released:bytes
+		Memory that is completely free and has been returned to the
+		underlying system. This metric is the runtime's estimate of free
+		address space that is still mapped into the process, but is not
+		backed by physical memory.
+
+	/memory/classes/heap/stacks:bytes
+		Memory allocated from the heap that is reserved for stack space,
+		whether or not it is currently in-use.
+
+	/memory/classes/heap/unused:bytes
+		Memory that is reserved for heap objects but is not currently used to
+		hold heap objects.
+
+	/memory/classes/metadata/mcache/free:bytes
+		Memory that is reserved for runtime mcache structures, but not
+		in-use.
+
+	/memory/classes/metadata/mcache/inuse:bytes
+		Memory that is occupied by runtime mcache structures that are
+		currently being used.
+
+	/memory/classes/metadata/mspan/free:bytes
+		Memory that is reserved for runtime mspan structures, but not in-use.
+
+	/memory/classes/metadata/mspan/inuse:bytes
+		Memory that is occupied by runtime mspan structures that are
+		currently being used.
+
+	/memory/classes/metadata/other:bytes
+		Memory that is reserved for or used to hold runtime metadata.
+
+	/memory/classes/os-stacks:bytes
+		Stack memory allocated by the underlying operating system.
+
+	/memory/classes/other:bytes
+		Memory used by execution trace buffers, structures for debugging the
+		runtime, finalizer and profiler specials, and more.
+
+	/memory/classes/profiling/buckets:bytes
+		Memory that is used by the stack trace hash map used for profiling.
+
+	/memory/classes/total:bytes
+		All memory mapped by the Go runtime into the current process as
+		read-write. Note that this does not include memory mapped by code
+		called via cgo or via the syscall package. Sum of all metrics in
+		/memory/classes.
+
+	/sched/gomaxprocs:threads
+		The current runtime.GOMAXPROCS setting, or the number of operating
+		system threads that can execute user-level Go code simultaneously.
+
+	/sched/goroutines:goroutines
+		Count of live goroutines.
+
+	/sched/latencies:seconds
+		Distribution of the time goroutines have spent in the scheduler in a
+		runnable state before actually running. Sampled.
+*/
+package metrics
@@ -0,0 +1,23 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+// Float64Histogram 表示一个 float64 值的分布。
+type Float64Histogram struct {
+	// Counts 包含每个直方图桶的权重。
+	//
+	// 给定 N 个桶，Count[n] 为区间 [bucket[n], bucket[n+1]) 的权重，
+	// 其中 0 <= n < N。
+	Counts []uint64
+
+	// Buckets 包含直方图桶的边界，按升序排列。
+	//
+	// Buckets 的长度总是比 Counts 大一。第一个与最后一个边界可能为
+	// -Inf 和 +Inf，表示无界的桶。
+	//
+	// 对于同一个 metric，Buckets 在多次读取之间保持不变，
+	// 并且可能与运行时共享底层数组，因此不应被修改。
+	Buckets []float64
+}
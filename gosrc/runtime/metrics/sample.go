@@ -0,0 +1,40 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	_ "runtime" // 依赖 runtime 中 runtime_readMetrics 的实现
+	"unsafe"
+)
+
+// Sample 捕获一个 metric 的单个样本。
+type Sample struct {
+	// Name 为被采样的 metric 的名称。
+	//
+	// 它必须是 All 返回的某个 metric 描述中的名称。
+	Name string
+
+	// Value 为该 metric 样本的值。
+	Value Value
+}
+
+// runtime_readMetrics 由 runtime 实现。
+func runtime_readMetrics(unsafe.Pointer, int, int)
+
+// Read 为给定的 metric 样本切片填充对应的值。
+//
+// Read 是并发安全的，且不会停止世界。
+//
+// 调用方应该在切片的每个元素中填好 Name。若 Name 不是已知的 metric，
+// 则对应的 Value 的 Kind 为 KindBad。
+//
+// 多次调用 Read 时复用同一个切片可以避免重复分配：已有的 Float64Histogram
+// 会被原地覆盖。同一个切片不应被并发地传给 Read。
+func Read(m []Sample) {
+	if len(m) == 0 {
+		return
+	}
+	runtime_readMetrics(unsafe.Pointer(&m[0]), len(m), cap(m))
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"math"
+	"unsafe"
+)
+
+// ValueKind 为标识 Value 类型的标签。
+type ValueKind int
+
+const (
+	// KindBad 表示 Value 没有类型，不应被使用。
+	KindBad ValueKind = iota
+
+	// KindUint64 表示 Value 的类型为 uint64。
+	KindUint64
+
+	// KindFloat64 表示 Value 的类型为 float64。
+	KindFloat64
+
+	// KindFloat64Histogram 表示 Value 的类型为 *Float64Histogram。
+	KindFloat64Histogram
+)
+
+// Value 表示运行时返回的一个 metric 的值。
+//
+// 其内存布局必须与 runtime 中的 metricValue 保持一致。
+type Value struct {
+	kind    ValueKind
+	scalar  uint64         // 包含标量值
+	pointer unsafe.Pointer // 包含非标量值
+}
+
+// Kind 返回该值的类型标签。
+func (v Value) Kind() ValueKind {
+	return v.kind
+}
+
+// Uint64 返回 metric 的内部 uint64 值。
+//
+// 若 v.Kind() != KindUint64，则 panic。
+func (v Value) Uint64() uint64 {
+	if v.kind != KindUint64 {
+		panic("called Uint64 on non-uint64 metric value")
+	}
+	return v.scalar
+}
+
+// Float64 返回 metric 的内部 float64 值。
+//
+// 若 v.Kind() != KindFloat64，则 panic。
+func (v Value) Float64() float64 {
+	if v.kind != KindFloat64 {
+		panic("called Float64 on non-float64 metric value")
+	}
+	return math.Float64frombits(v.scalar)
+}
+
+// Float64Histogram 返回 metric 的内部 *Float64Histogram 值。
+//
+// 若 v.Kind() != KindFloat64Histogram，则 panic。
+//
+// 返回的直方图在下一次以同一个 Sample 调用 Read 时会被复用并覆盖。
+func (v Value) Float64Histogram() *Float64Histogram {
+	if v.kind != KindFloat64Histogram {
+		panic("called Float64Histogram on non-Float64Histogram metric value")
+	}
+	return (*Float64Histogram)(v.pointer)
+}
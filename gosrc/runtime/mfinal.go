@@ -183,44 +183,49 @@ func runfinq() {
 			for i := fb.cnt; i > 0; i-- {
 				f := &fb.fin[i-1]
 
-				framesz := unsafe.Sizeof((interface{})(nil)) + f.nret
-				if framecap < framesz {
-					// The frame does not contain pointers interesting for GC,
-					// all not yet finalized objects are stored in finq.
-					// If we do not mark it as FlagNoScan,
-					// the last finalized object is not collected.
-					frame = mallocgc(framesz, nil, true)
-					framecap = framesz
-				}
-
 				if f.fint == nil {
-					throw("missing type in runfinq")
-				}
-				// frame is effectively uninitialized
-				// memory. That means we have to clear
-				// it before writing to it to avoid
-				// confusing the write barrier.
-				*(*[2]uintptr)(frame) = [2]uintptr{}
-				switch f.fint.kind & kindMask {
-				case kindPtr:
-					// direct use of pointer
-					*(*unsafe.Pointer)(frame) = f.arg
-				case kindInterface:
-					ityp := (*interfacetype)(unsafe.Pointer(f.fint))
-					// set up with empty interface
-					(*eface)(frame)._type = &f.ot.typ
-					(*eface)(frame).data = f.arg
-					if len(ityp.mhdr) != 0 {
-						// convert to interface with methods
-						// this conversion is guaranteed to succeed - we checked in SetFinalizer
-						*(*iface)(frame) = assertE2I(ityp, *(*eface)(frame))
+					// AddCleanup 添加的 cleanup，是一个不接收参数的闭包
+					cleanup := *(*func())(unsafe.Pointer(&f.fn))
+					fingRunning = true
+					cleanup()
+					fingRunning = false
+				} else {
+					framesz := unsafe.Sizeof((interface{})(nil)) + f.nret
+					if framecap < framesz {
+						// The frame does not contain pointers interesting for GC,
+						// all not yet finalized objects are stored in finq.
+						// If we do not mark it as FlagNoScan,
+						// the last finalized object is not collected.
+						frame = mallocgc(framesz, nil, true)
+						framecap = framesz
+					}
+
+					// frame is effectively uninitialized
+					// memory. That means we have to clear
+					// it before writing to it to avoid
+					// confusing the write barrier.
+					*(*[2]uintptr)(frame) = [2]uintptr{}
+					switch f.fint.kind & kindMask {
+					case kindPtr:
+						// direct use of pointer
+						*(*unsafe.Pointer)(frame) = f.arg
+					case kindInterface:
+						ityp := (*interfacetype)(unsafe.Pointer(f.fint))
+						// set up with empty interface
+						(*eface)(frame)._type = &f.ot.typ
+						(*eface)(frame).data = f.arg
+						if len(ityp.mhdr) != 0 {
+							// convert to interface with methods
+							// this conversion is guaranteed to succeed - we checked in SetFinalizer
+							*(*iface)(frame) = assertE2I(ityp, *(*eface)(frame))
+						}
+					default:
+						throw("bad kind in runfinq")
 					}
-				default:
-					throw("bad kind in runfinq")
+					fingRunning = true
+					reflectcall(nil, unsafe.Pointer(f.fn), frame, uint32(framesz), uint32(framesz))
+					fingRunning = false
 				}
-				fingRunning = true
-				reflectcall(nil, unsafe.Pointer(f.fn), frame, uint32(framesz), uint32(framesz))
-				fingRunning = false
 
 				// Drop finalizer queue heap references
 				// before hiding them from markroot.
@@ -326,7 +331,7 @@ func SetFinalizer(obj interface{}, finalizer interface{}) {
 	}
 
 	// find the containing object
-	base, _, _ := findObject(uintptr(e.data), 0, 0)
+	base, span, _ := findObject(uintptr(e.data), 0, 0)
 
 	if base == 0 {
 		// 0-length objects are okay.
@@ -353,6 +358,15 @@ func SetFinalizer(obj interface{}, finalizer interface{}) {
 		throw("runtime.SetFinalizer: pointer not in allocated block")
 	}
 
+	// arena 中的对象随 arena 一起释放，不能设置 finalizer
+	if span.isUserArenaChunk {
+		throw("runtime.SetFinalizer: first argument was allocated into an arena")
+	}
+	if span.guard != nil {
+		// 保护分配的对象不在 span 的起始位置
+		base = span.guard.addr
+	}
+
 	if uintptr(e.data) != base {
 		// As an implementation detail we allow to set finalizers for an inner byte
 		// of an object if it could come from tiny alloc (see mallocgc for details).
@@ -125,7 +125,7 @@ const (
 	sweepMinHeapDistance = 1024 * 1024
 )
 
-// heapminimum is the minimum heap size at which to trigger GC.
+// heapminimum is the minimum heap goal.
 // For small heaps, this overrides the usual GOGC*live set rule.
 //
 // When there is a very small live set but a lot of allocation, simply
@@ -153,13 +153,11 @@ func gcinit() {
 	// 第一个周期没有扫描。
 	mheap_.sweepdone = 1
 
-	// 设置合理的初始 GC 触发比率。
-	memstats.triggerRatio = 7 / 8.0
+	// 从环境中读取内存限制，它会参与下面 GC 触发器和目标的计算。
+	memoryLimit = readGOMEMLIMIT()
 
-	// 伪造一个 heap_marked 值，使它看起来像一个触发器
-	// heapminimum 是 heap_marked的 适当增长。
-	// 这将用于计算初始 GC 目标。
-	memstats.heap_marked = uint64(float64(heapminimum) / (1 + memstats.triggerRatio))
+	// 全局变量同样计入堆目标
+	gcController.globalsScan = gcGlobalsScan()
 
 	// 从环境中设置 gcpercent。这也将计算并设置 GC 触发器和目标。
 	_ = setGCPercent(readgogc())
@@ -184,6 +182,8 @@ func readgogc() int32 {
 func gcenable() {
 	c := make(chan int, 1)
 	go bgsweep(c)
+	go bgscavenge(c)
+	<-c
 	<-c
 	memstats.enablegc = true // 现在运行时已经初始化完毕了，GC 已就绪
 }
@@ -198,7 +198,7 @@ func setGCPercent(in int32) (out int32) {
 	gcpercent = in
 	heapminimum = defaultHeapMinimum * uint64(gcpercent) / 100
 	// 更新步调来响应 gcpercent 变化
-	gcSetTriggerRatio(memstats.triggerRatio)
+	gcControllerCommit()
 	unlock(&mheap_.lock)
 
 	// 如果我们刚好禁用了 GC，则等待任何并发 GC 标记完成，从而我们总是能够在没有 GC 的情况下返回
@@ -282,15 +282,22 @@ var gcMarkWorkerModeStrings = [...]string{
 // when to trigger concurrent garbage collection and how much marking
 // work to do in mutator assists and background marking.
 //
-// It uses a feedback control algorithm to adjust the memstats.gc_trigger
-// trigger based on the heap growth and GC CPU utilization each cycle.
-// This algorithm optimizes for heap growth to match GOGC and for CPU
-// utilization between assist and background marking to be 25% of
-// GOMAXPROCS. The high-level design of this algorithm is documented
-// at https://golang.org/s/go15gcpacing.
+// 堆目标不只由上一个周期标记的堆决定：goroutine 栈与全局变量同样需要扫描，
+// 因此它们的大小也按 GOGC 的比例计入增长的空间：
+//
+//	next_gc = heap_marked + (heap_marked + lastStackScan + globalsScan) * GOGC/100
+//
+// 触发点由一个反馈控制器决定。每个周期结束时，endCycle 测量 mutator 的分配速率
+// 与 GC 的扫描速率之比（cons/mark）。下一个周期在距离堆目标 runway 字节时触发，
+// runway 为 GC 以 gcGoalUtilization 的 CPU 占用完成预期的扫描工作量期间
+// mutator 会分配的字节数，见 gcControllerState.trigger。
 //
-// All fields of gcController are used only during a single mark
-// cycle.
+// 标记期间，assist 的比率按预期的扫描工作量（上一个周期实际扫描的堆、栈与全局变量）
+// 计算，只有当实际的工作量超出预期时才按最坏情况计算，从而避免周期开始时的 assist 尖峰。
+// 原始的设计见 https://golang.org/s/go15gcpacing。
+//
+// Most fields of gcController are used only during a single mark
+// cycle. The fields from lastHeapScan on persist across cycles.
 var gcController gcControllerState
 
 type gcControllerState struct {
@@ -305,6 +312,12 @@ type gcControllerState struct {
 	// definition is important.
 	scanWork int64
 
+	// stackScanWork 与 globalsScanWork 为 scanWork 中扫描 goroutine 栈
+	// 与全局变量（data 与 bss 段）的部分，原子更新。
+	// 栈的工作量为栈的使用量（stack.hi - sp），全局变量的工作量为扫描的字节数。
+	stackScanWork   int64
+	globalsScanWork int64
+
 	// bgScanCredit is the scan work credit accumulated by the
 	// concurrent background scan. This credit is accumulated by
 	// the background scan and stolen by mutator assists. This is
@@ -365,6 +378,23 @@ type gcControllerState struct {
 	// If this is zero, no fractional workers are needed.
 	fractionalUtilizationGoal float64
 
+	// lastHeapScan 与 lastStackScan 为上一个周期扫描堆与栈的工作量，
+	// 在标记结束时更新。globalsScan 为全局变量的总大小。
+	// 三者之和为下一个周期预期的扫描工作量。
+	lastHeapScan  uint64
+	lastStackScan uint64
+	globalsScan   uint64
+
+	// consMark 为 cons/mark 的估计：标记期间 mutator 的分配速率与 GC 的扫描速率之比，
+	// 已按两者的 CPU 占用归一化。取最近几个周期测量值中的最大值。
+	consMark float64
+
+	// lastConsMark 为最近几个周期测量到的 cons/mark，最后一个为最新的
+	lastConsMark [4]float64
+
+	// limitTrigger 表示当前的触发点是由内存限制而不是 GOGC 决定的
+	limitTrigger bool
+
 	_ cpu.CacheLinePad
 }
 
@@ -372,27 +402,23 @@ type gcControllerState struct {
 // for a new GC cycle. The caller must hold worldsema.
 func (c *gcControllerState) startCycle() {
 	c.scanWork = 0
+	c.stackScanWork = 0
+	c.globalsScanWork = 0
 	c.bgScanCredit = 0
 	c.assistTime = 0
 	c.dedicatedMarkTime = 0
 	c.fractionalMarkTime = 0
 	c.idleMarkTime = 0
 
-	// If this is the first GC cycle or we're operating on a very
-	// small heap, fake heap_marked so it looks like gc_trigger is
-	// the appropriate growth from heap_marked, even though the
-	// real heap_marked may not have a meaningful value (on the
-	// first cycle) or may be much smaller (resulting in a large
-	// error response).
-	if memstats.gc_trigger <= heapminimum {
-		memstats.heap_marked = uint64(float64(memstats.gc_trigger) / (1 + memstats.triggerRatio))
-	}
+	// 插件可能加载了新的模块
+	c.globalsScan = gcGlobalsScan()
 
 	// Re-compute the heap goal for this cycle in case something
 	// changed. This is the same calculation we use elsewhere.
-	memstats.next_gc = memstats.heap_marked + memstats.heap_marked*uint64(gcpercent)/100
-	if gcpercent < 0 {
-		memstats.next_gc = ^uint64(0)
+	memstats.next_gc = c.gogcHeapGoal()
+	// 内存限制可能要求更小的目标
+	if limitGoal := memoryLimitHeapGoal(); limitGoal < memstats.next_gc {
+		memstats.next_gc = limitGoal
 	}
 
 	// Ensure that the heap goal is at least a little larger than
@@ -447,7 +473,9 @@ func (c *gcControllerState) startCycle() {
 
 	if debug.gcpacertrace > 0 {
 		print("pacer: assist ratio=", c.assistWorkPerByte,
-			" (scan ", memstats.heap_scan>>20, " MB in ",
+			" (scan ", memstats.heap_scan>>20, " MB heap + ",
+			c.lastStackScan>>20, " MB stacks + ",
+			c.globalsScan>>20, " MB globals in ",
 			work.initialHeapLive>>20, "->",
 			memstats.next_gc>>20, " MB)",
 			" workers=", c.dedicatedMarkWorkersNeeded,
@@ -464,47 +492,32 @@ func (c *gcControllerState) startCycle() {
 // is when assists are enabled and the necessary statistics are
 // available).
 func (c *gcControllerState) revise() {
-	gcpercent := gcpercent
-	if gcpercent < 0 {
-		// If GC is disabled but we're running a forced GC,
-		// act like GOGC is huge for the below calculations.
-		gcpercent = 100000
-	}
 	live := atomic.Load64(&memstats.heap_live)
 
-	var heapGoal, scanWorkExpected int64
-	if live <= memstats.next_gc {
-		// We're under the soft goal. Pace GC to complete at
-		// next_gc assuming the heap is in steady-state.
-		heapGoal = int64(memstats.next_gc)
-
-		// Compute the expected scan work remaining.
-		//
-		// This is estimated based on the expected
-		// steady-state scannable heap. For example, with
-		// GOGC=100, only half of the scannable heap is
-		// expected to be live, so that's what we target.
-		//
-		// (This is a float calculation to avoid overflowing on
-		// 100*heap_scan.)
-		scanWorkExpected = int64(float64(memstats.heap_scan) * 100 / float64(100+gcpercent))
-	} else {
-		// We're past the soft goal. Pace GC so that in the
-		// worst case it will complete by the hard goal.
+	// 假设程序处于稳态，本周期的扫描工作量与上一个周期实际完成的相同：
+	// 堆、栈与全局变量。按这个估计安排 GC 在 next_gc 处完成。
+	heapGoal := int64(memstats.next_gc)
+	scanWorkExpected := int64(c.lastHeapScan + c.lastStackScan + c.globalsScan)
+	if c.lastHeapScan == 0 {
+		// 还没有完成过 GC 周期，没有实际的工作量可以参考。
+		// 假设所有可扫描的堆都是存活的。
+		scanWorkExpected = int64(memstats.heap_scan + c.globalsScan)
+	}
+	if c.scanWork > scanWorkExpected || live > memstats.next_gc {
+		// 工作量超出了预期，或者已经越过了软目标。
+		// 按最坏情况安排，使 GC 最迟在硬目标处完成：
+		// 所有可扫描的堆、所有的栈与全局变量都需要扫描。
 		const maxOvershoot = 1.1
 		heapGoal = int64(float64(memstats.next_gc) * maxOvershoot)
-
-		// Compute the upper bound on the scan work remaining.
-		scanWorkExpected = int64(memstats.heap_scan)
+		scanWorkExpected = int64(memstats.heap_scan + memstats.stacks_inuse + c.globalsScan)
 	}
 
 	// Compute the remaining scan work estimate.
 	//
 	// Note that we currently count allocations during GC as both
 	// scannable heap (heap_scan) and scan work completed
-	// (scanWork), so allocation will change this difference will
-	// slowly in the soft regime and not at all in the hard
-	// regime.
+	// (scanWork), so allocation will not change this difference
+	// in the hard regime.
 	scanWorkRemaining := scanWorkExpected - c.scanWork
 	if scanWorkRemaining < 1000 {
 		// We set a somewhat arbitrary lower bound on
@@ -533,75 +546,125 @@ func (c *gcControllerState) revise() {
 	c.assistBytesPerWork = float64(heapRemaining) / float64(scanWorkRemaining)
 }
 
-// endCycle computes the trigger ratio for the next cycle.
-func (c *gcControllerState) endCycle() float64 {
+// endCycle 在标记结束时根据本周期的测量结果更新 cons/mark 的估计。
+// 下一个周期的触发点由 gcControllerCommit 根据它计算。
+func (c *gcControllerState) endCycle() {
 	if work.userForced {
 		// Forced GC means this cycle didn't start at the
 		// trigger, so where it finished isn't good
 		// information about how to adjust the trigger.
 		// Just leave it where it is.
-		return memstats.triggerRatio
-	}
-
-	// Proportional response gain for the trigger controller. Must
-	// be in [0, 1]. Lower values smooth out transient effects but
-	// take longer to respond to phase changes. Higher values
-	// react to phase changes quickly, but are more affected by
-	// transient changes. Values near 1 may be unstable.
-	const triggerGain = 0.5
-
-	// Compute next cycle trigger ratio. First, this computes the
-	// "error" for this cycle; that is, how far off the trigger
-	// was from what it should have been, accounting for both heap
-	// growth and GC CPU utilization. We compute the actual heap
-	// growth during this cycle and scale that by how far off from
-	// the goal CPU utilization we were (to estimate the heap
-	// growth if we had the desired CPU utilization). The
-	// difference between this estimate and the GOGC-based goal
-	// heap growth is the error.
-	goalGrowthRatio := float64(gcpercent) / 100
-	actualGrowthRatio := float64(memstats.heap_live)/float64(memstats.heap_marked) - 1
-	assistDuration := nanotime() - c.markStartTime
+		return
+	}
+
+	markDuration := nanotime() - c.markStartTime
 
 	// Assume background mark hit its utilization goal.
 	utilization := gcBackgroundUtilization
+	idleUtilization := 0.0
 	// Add assist utilization; avoid divide by zero.
-	if assistDuration > 0 {
-		utilization += float64(c.assistTime) / float64(assistDuration*int64(gomaxprocs))
+	if markDuration > 0 {
+		utilization += float64(c.assistTime) / float64(markDuration*int64(gomaxprocs))
+		idleUtilization = float64(c.idleMarkTime) / float64(markDuration*int64(gomaxprocs))
+	}
+
+	// 测量本周期的 cons/mark。分配的字节数按 mutator 的 CPU 占用归一化，
+	// 扫描工作量按 GC 的 CPU 占用归一化，因此结果与 GOMAXPROCS 以及
+	// 本周期实际的 GC CPU 占用无关。idle worker 的工作不占用 mutator 的时间，
+	// 不计入 mutator 的 CPU 占用。
+	allocated := int64(memstats.heap_live) - int64(work.initialHeapLive)
+	currentConsMark := 0.0
+	if allocated > 0 && c.scanWork > 0 && utilization < 1 {
+		currentConsMark = (float64(allocated) * (utilization + idleUtilization)) /
+			(float64(c.scanWork) * (1 - utilization))
+	}
+
+	// 取本周期与最近几个周期测量值中的最大值。测量结果有噪声，
+	// 偏大的估计使 GC 更早开始，以稍多的 GC 周期为代价减少 assist。
+	oldConsMark := c.consMark
+	c.consMark = currentConsMark
+	for _, m := range c.lastConsMark {
+		if m > c.consMark {
+			c.consMark = m
+		}
 	}
+	copy(c.lastConsMark[:], c.lastConsMark[1:])
+	c.lastConsMark[len(c.lastConsMark)-1] = currentConsMark
 
-	triggerError := goalGrowthRatio - memstats.triggerRatio - utilization/gcGoalUtilization*(actualGrowthRatio-memstats.triggerRatio)
+	if debug.gcpacertrace > 0 {
+		heapScanWork := c.scanWork - c.stackScanWork - c.globalsScanWork
+		print("pacer: ", int(utilization*100), "% CPU (", int(gcGoalUtilization*100), " exp.) for ",
+			heapScanWork, "+", c.stackScanWork, "+", c.globalsScanWork, " B work (",
+			c.lastHeapScan+c.lastStackScan+c.globalsScan, " B exp.) in ",
+			work.initialHeapLive, " B -> ", memstats.heap_live, " B (∆goal ",
+			int64(memstats.heap_live)-int64(memstats.next_gc), ", cons/mark ",
+			oldConsMark, " -> ", c.consMark, ")\n")
+	}
+}
 
-	// Finally, we adjust the trigger for next time by this error,
-	// damped by the proportional gain.
-	triggerRatio := memstats.triggerRatio + triggerGain*triggerError
+// gogcHeapGoal 返回按 GOGC 计算的堆目标，GOGC=off 时返回 ^uint64(0)。
+// 目标不低于 heapminimum，因此第一个周期（heap_marked 为 0）以及很小的堆
+// 都以 heapminimum 为目标。
+//
+// mheap_.lock must be held or the world must be stopped.
+func (c *gcControllerState) gogcHeapGoal() uint64 {
+	if gcpercent < 0 {
+		return ^uint64(0)
+	}
+	goal := memstats.heap_marked + (memstats.heap_marked+c.lastStackScan+c.globalsScan)*uint64(gcpercent)/100
+	if goal < heapminimum {
+		goal = heapminimum
+	}
+	return goal
+}
 
-	if debug.gcpacertrace > 0 {
-		// Print controller state in terms of the design
-		// document.
-		H_m_prev := memstats.heap_marked
-		h_t := memstats.triggerRatio
-		H_T := memstats.gc_trigger
-		h_a := actualGrowthRatio
-		H_a := memstats.heap_live
-		h_g := goalGrowthRatio
-		H_g := int64(float64(H_m_prev) * (1 + h_g))
-		u_a := utilization
-		u_g := gcGoalUtilization
-		W_a := c.scanWork
-		print("pacer: H_m_prev=", H_m_prev,
-			" h_t=", h_t, " H_T=", H_T,
-			" h_a=", h_a, " H_a=", H_a,
-			" h_g=", h_g, " H_g=", H_g,
-			" u_a=", u_a, " u_g=", u_g,
-			" W_a=", W_a,
-			" goalΔ=", goalGrowthRatio-h_t,
-			" actualΔ=", h_a-h_t,
-			" u_a/u_g=", u_a/u_g,
-			"\n")
-	}
-
-	return triggerRatio
+// trigger 返回堆目标为 goal 时的触发点。
+//
+// 标记开始后，在 GC 以 gcGoalUtilization 的 CPU 占用完成预期的扫描工作量之前，
+// mutator 会再分配 cons/mark × (1-u_g)/u_g × 预期的扫描工作量 字节，即 runway。
+// 触发点位于 goal 之前 runway 字节处。为了限制估计误差的影响，
+// 触发点被限制在 heap_marked 与 goal 之间 [70%, 95%] 的位置：
+// 上限确保触发点与目标之间总有余量，使 assist 的比率不会为无穷大。
+// 在测量到 cons/mark 之前（例如第一个周期），runway 无从估计，保守地使用下限。
+//
+// mheap_.lock must be held or the world must be stopped.
+func (c *gcControllerState) trigger(goal uint64) uint64 {
+	const (
+		minTriggerRatio = 0.7
+		maxTriggerRatio = 0.95
+	)
+	marked := memstats.heap_marked
+	if goal <= marked {
+		return marked
+	}
+	minTrigger := marked + uint64(float64(goal-marked)*minTriggerRatio)
+	maxTrigger := marked + uint64(float64(goal-marked)*maxTriggerRatio)
+	if c.consMark == 0 {
+		return minTrigger
+	}
+
+	runway := c.consMark * (1 - gcGoalUtilization) / gcGoalUtilization *
+		float64(c.lastHeapScan+c.lastStackScan+c.globalsScan)
+	trigger := uint64(0)
+	if runway < float64(goal) {
+		trigger = goal - uint64(runway)
+	}
+	if trigger < minTrigger {
+		trigger = minTrigger
+	}
+	if trigger > maxTrigger {
+		trigger = maxTrigger
+	}
+	return trigger
+}
+
+// gcGlobalsScan 返回所有模块中全局变量（data 与 bss 段）的总大小。
+func gcGlobalsScan() uint64 {
+	var n uintptr
+	for _, datap := range activeModules() {
+		n += datap.edata - datap.data + datap.ebss - datap.bss
+	}
+	return uint64(n)
 }
 
 // enlistWorker encourages another dedicated mark worker to start on
@@ -727,42 +790,33 @@ func pollFractionalWorkerExit() bool {
 	return float64(selfTime)/float64(delta) > 1.2*gcController.fractionalUtilizationGoal
 }
 
-// gcSetTriggerRatio sets the trigger ratio and updates everything
-// derived from it: the absolute trigger, the heap goal, mark pacing,
-// and sweep pacing.
+// gcControllerCommit recomputes everything derived from the pacer's
+// inputs: the absolute trigger, the heap goal, mark pacing, and sweep
+// pacing.
 //
 // This can be called any time. If GC is the in the middle of a
 // concurrent phase, it will adjust the pacing of that phase.
 //
-// This depends on gcpercent, memstats.heap_marked, and
-// memstats.heap_live. These must be up to date.
+// This depends on gcpercent, memoryLimit, memstats.heap_marked,
+// memstats.heap_live, and the persistent fields of gcController.
+// These must be up to date.
 //
 // mheap_.lock must be held or the world must be stopped.
-func gcSetTriggerRatio(triggerRatio float64) {
-	// Set the trigger ratio, capped to reasonable bounds.
-	if triggerRatio < 0 {
-		// This can happen if the mutator is allocating very
-		// quickly or the GC is scanning very slowly.
-		triggerRatio = 0
-	} else if gcpercent >= 0 {
-		// Ensure there's always a little margin so that the
-		// mutator assist ratio isn't infinity.
-		maxTriggerRatio := 0.95 * float64(gcpercent) / 100
-		if triggerRatio > maxTriggerRatio {
-			triggerRatio = maxTriggerRatio
-		}
-	}
-	memstats.triggerRatio = triggerRatio
+func gcControllerCommit() {
+	c := &gcController
 
-	// Compute the absolute GC trigger from the trigger ratio.
-	//
-	// We trigger the next GC cycle when the allocated heap has
-	// grown by the trigger ratio over the marked heap size.
+	// Compute the next GC goal, which is when the allocated heap
+	// has grown by GOGC/100 over the heap marked by the last
+	// cycle, the stacks it scanned and the globals.
+	goal := c.gogcHeapGoal()
+
+	// Compute the absolute GC trigger from the goal and the
+	// estimated runway.
+	c.limitTrigger = false
 	trigger := ^uint64(0)
 	if gcpercent >= 0 {
-		trigger = uint64(float64(memstats.heap_marked) * (1 + triggerRatio))
-		// Don't trigger below the minimum heap size.
-		minTrigger := heapminimum
+		// heapminimum 已经作用于 goal，触发点由 runway 决定。
+		trigger = c.trigger(goal)
 		if !isSweepDone() {
 			// Concurrent sweep happens in the heap growth
 			// from heap_live to gc_trigger, so ensure
@@ -770,38 +824,48 @@ func gcSetTriggerRatio(triggerRatio float64) {
 			// in which to perform sweeping before we
 			// start the next GC cycle.
 			sweepMin := atomic.Load64(&memstats.heap_live) + sweepMinHeapDistance*uint64(gcpercent)/100
-			if sweepMin > minTrigger {
-				minTrigger = sweepMin
+			if trigger < sweepMin {
+				trigger = sweepMin
 			}
 		}
-		if trigger < minTrigger {
-			trigger = minTrigger
-		}
 		if int64(trigger) < 0 {
-			print("runtime: next_gc=", memstats.next_gc, " heap_marked=", memstats.heap_marked, " heap_live=", memstats.heap_live, " initialHeapLive=", work.initialHeapLive, "triggerRatio=", triggerRatio, " minTrigger=", minTrigger, "\n")
+			print("runtime: next_gc=", memstats.next_gc, " heap_marked=", memstats.heap_marked, " heap_live=", memstats.heap_live, " initialHeapLive=", work.initialHeapLive, " goal=", goal, "\n")
 			throw("gc_trigger underflow")
 		}
-	}
-	memstats.gc_trigger = trigger
-
-	// Compute the next GC goal, which is when the allocated heap
-	// has grown by GOGC/100 over the heap marked by the last
-	// cycle.
-	goal := ^uint64(0)
-	if gcpercent >= 0 {
-		goal = memstats.heap_marked + memstats.heap_marked*uint64(gcpercent)/100
 		if goal < trigger {
-			// The trigger ratio is always less than GOGC/100, but
+			// The trigger is always below the goal, but
 			// other bounds on the trigger may have raised it.
 			// Push up the goal, too.
 			goal = trigger
 		}
 	}
+
+	// 如果设置了内存限制，且按 GOGC 计算的目标会让运行时管理的总内存超过限制，
+	// 则改用限制所允许的目标。此时无视 heapminimum，触发点同样由 runway 决定，
+	// 从而仍为并发标记留出余量。
+	if limitGoal := memoryLimitHeapGoal(); limitGoal < goal {
+		goal = limitGoal
+		limitTrigger := memstats.heap_marked
+		if goal > memstats.heap_marked {
+			limitTrigger = c.trigger(goal)
+		} else {
+			// 存活堆已经超过了限制，只能让 GC 尽快开始
+			goal = memstats.heap_marked
+		}
+		if limitTrigger < trigger {
+			trigger = limitTrigger
+			c.limitTrigger = true
+		}
+	}
+	memstats.gc_trigger = trigger
 	memstats.next_gc = goal
 	if trace.enabled {
 		traceNextGC()
 	}
 
+	// Update the scavenger's retained memory goal.
+	gcPaceScavenger()
+
 	// Update mark pacing.
 	if gcphase != _GCoff {
 		gcController.revise()
@@ -827,7 +891,7 @@ func gcSetTriggerRatio(triggerRatio float64) {
 			heapDistance = _PageSize
 		}
 		pagesSwept := atomic.Load64(&mheap_.pagesSwept)
-		sweepDistancePages := int64(mheap_.pagesInUse) - int64(pagesSwept)
+		sweepDistancePages := int64(atomic.Load64(&mheap_.pagesInUse)) - int64(pagesSwept)
 		if sweepDistancePages <= 0 {
 			mheap_.sweepPagesPerByte = 0
 		} else {
@@ -950,6 +1014,11 @@ var work struct {
 	// explicit user call.
 	userForced bool
 
+	// trigger 为启动当前周期的触发条件。limitTrigger 表示堆触发点
+	// 是由内存限制而不是 GOGC 决定的。用于 GC 结束通知，见 mgcnotify.go。
+	trigger      gcTriggerKind
+	limitTrigger bool
+
 	// totaltime is the CPU nanoseconds spent in GC since the
 	// program started if debug.gctrace > 0.
 	totaltime int64
@@ -1208,6 +1277,8 @@ func gcStart(trigger gcTrigger) {
 
 	// For stats, check if this GC was forced by the user.
 	work.userForced = trigger.kind == gcTriggerAlways || trigger.kind == gcTriggerCycle
+	work.trigger = trigger.kind
+	work.limitTrigger = trigger.kind == gcTriggerHeap && gcController.limitTrigger
 
 	// In gcstoptheworld debug mode, upgrade the mode accordingly.
 	// We do this after re-checking the transition condition so
@@ -1318,6 +1389,7 @@ func gcStart(trigger gcTrigger) {
 		now = startTheWorldWithSema(trace.enabled)
 		work.pauseNS += now - work.pauseStart
 		work.tMark = now
+		gcPauseDist.record(now - work.pauseStart)
 	})
 	// In STW mode, we could block the instant systemstack
 	// returns, so don't do anything important here. Make sure we
@@ -1474,6 +1546,7 @@ top:
 	// below. The important thing is that the wb remains active until
 	// all marking is complete. This includes writes made by the GC.
 
+	restart := false
 	if debugCachedWork {
 		// For debugging, double check that no work was added after we
 		// went around above and disable write barrier buffering.
@@ -1508,7 +1581,6 @@ top:
 		// Switch to the system stack to call wbBufFlush1,
 		// though in this case it doesn't matter because we're
 		// non-preemptible anyway.
-		restart := false
 		systemstack(func() {
 			for _, p := range allp {
 				wbBufFlush1(p)
@@ -1518,14 +1590,19 @@ top:
 				}
 			}
 		})
-		if restart {
-			getg().m.preemptoff = ""
-			systemstack(func() {
-				now := startTheWorldWithSema(true)
-				work.pauseNS += now - work.pauseStart
-			})
-			goto top
-		}
+	}
+	// 泄漏检测推迟扫描的栈可能变为可达，此时需要回到并发标记扫描它们。
+	if !restart && goroutineLeak.enabled {
+		restart = gcLeakUpdateRoots()
+	}
+	if restart {
+		getg().m.preemptoff = ""
+		systemstack(func() {
+			now := startTheWorldWithSema(true)
+			work.pauseNS += now - work.pauseStart
+			gcPauseDist.record(now - work.pauseStart)
+		})
+		goto top
 	}
 
 	// Disable assists and background workers. We must do
@@ -1548,13 +1625,13 @@ top:
 	// endCycle depends on all gcWork cache stats being flushed.
 	// The termination algorithm above ensured that up to
 	// allocations since the ragged barrier.
-	nextTriggerRatio := gcController.endCycle()
+	gcController.endCycle()
 
 	// Perform mark termination. This will restart the world.
-	gcMarkTermination(nextTriggerRatio)
+	gcMarkTermination()
 }
 
-func gcMarkTermination(nextTriggerRatio float64) {
+func gcMarkTermination() {
 	// World is stopped.
 	// Start marktermination which includes enabling the write barrier.
 	atomic.Store(&gcBlackenEnabled, 0)
@@ -1605,6 +1682,8 @@ func gcMarkTermination(nextTriggerRatio float64) {
 
 		// marking is complete so we can turn the write barrier off
 		setGCPhase(_GCoff)
+		// 标记期间被释放的 arena chunk 不会再被扫描，可以设置为不可访问了
+		userArenaFaultPending()
 		gcSweep(work.mode)
 	})
 
@@ -1622,15 +1701,24 @@ func gcMarkTermination(nextTriggerRatio float64) {
 		throw("gc done but gcphase != _GCoff")
 	}
 
+	// Record the heap goal of this cycle for the scavenger.
+	memstats.last_next_gc = memstats.next_gc
+
+	// 根据本周期扫描到的栈更新新 goroutine 的初始栈大小
+	gcComputeStartingStackSize()
+
 	// Update GC trigger and pacing for the next cycle.
-	gcSetTriggerRatio(nextTriggerRatio)
+	gcControllerCommit()
 
 	// Update timing memstats
 	now := nanotime()
-	sec, nsec, _ := time_now()
+	// 不使用 time_now：它在 synctest 气泡中返回假时钟
+	sec, nsec := walltime()
 	unixNow := sec*1e9 + int64(nsec)
 	work.pauseNS += now - work.pauseStart
 	work.tEnd = now
+	gcPauseDist.record(now - work.pauseStart)
+	gcCycleDist.record(work.tEnd - work.tSweepTerm)
 	atomic.Store64(&memstats.last_gc_unix, uint64(unixNow)) // must be Unix time to make sense to user
 	atomic.Store64(&memstats.last_gc_nanotime, uint64(now)) // monotonic time for us
 	memstats.pause_ns[memstats.numgc%uint32(len(memstats.pause_ns))] = uint64(work.pauseNS)
@@ -1650,6 +1738,9 @@ func gcMarkTermination(nextTriggerRatio float64) {
 	totalCpu := sched.totaltime + (now-sched.procresizetime)*int64(gomaxprocs)
 	memstats.gc_cpu_fraction = float64(work.totaltime) / float64(totalCpu)
 
+	// 更新 GC CPU 限制器，防止内存限制过低时 GC 占满 CPU
+	gcCPULimiter.update(cycleCpu, now)
+
 	// Reset sweep state.
 	sweep.nbgsweep = 0
 	sweep.npausesweep = 0
@@ -1730,9 +1821,13 @@ func gcMarkTermination(nextTriggerRatio float64) {
 			print(" (forced)")
 		}
 		print("\n")
+		printScavTrace()
 		printunlock()
 	}
 
+	// 通知 runtime/debug.OnGC 注册的回调
+	gcNotifyCycle(unixNow)
+
 	semrelease(&worldsema)
 	// Careful: another GC cycle may start now.
 
@@ -2048,7 +2143,11 @@ func gcMark(start_time int64) {
 	// cachestats (which flushes local statistics to these) and
 	// flushallmcaches (which modifies heap_live).
 	memstats.heap_live = work.bytesMarked
-	memstats.heap_scan = uint64(gcController.scanWork)
+	memstats.heap_scan = uint64(gcController.scanWork - gcController.stackScanWork - gcController.globalsScanWork)
+
+	// 记录本周期的扫描工作量，用于下一个周期的堆目标与步调
+	gcController.lastHeapScan = memstats.heap_scan
+	gcController.lastStackScan = uint64(gcController.stackScanWork)
 
 	if trace.enabled {
 		traceHeapAlloc()
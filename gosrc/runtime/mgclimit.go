@@ -0,0 +1,244 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 软内存限制
+//
+// GOGC 只描述了堆相对于上一次标记结果的增长比例，在内存受限的环境（例如容器）中，
+// 用户只能在 OOM 与浪费内存（例如堆 ballast）之间二选一。
+// 内存限制（GOMEMLIMIT 或 runtime/debug.SetMemoryLimit）为运行时管理的
+// 总内存（堆、栈、mspan 以及其他运行时元数据）设置一个软上限：
+//
+// 1. 当按 GOGC 计算的堆目标会使总内存超过限制时，gcControllerCommit 会将堆目标
+//    下调为限制所允许的堆大小，并相应提前触发点。
+//
+// 2. 当保留（未归还给操作系统）的内存超过限制时，堆增长和 sysmon 会主动 scavenge
+//    空闲的 span，将内存归还给操作系统。
+//
+// 3. 当限制设置得过低（例如低于存活堆的大小）时，GC 会不断地运行，程序会陷入
+//    death spiral。为了避免这种情况，gcCPULimiter 会跟踪 GC 使用的 CPU 时间，
+//    当 GC 的 CPU 占用持续超过 gcCPULimiterCap 时，禁用 mark assist，
+//    允许堆超过限制，以此换取程序继续执行。
+//
+// 该限制是「软」的：运行时不保证总内存一定低于限制。
+
+package runtime
+
+import "runtime/internal/atomic"
+
+const (
+	// maxMemoryLimit 表示没有设置内存限制
+	maxMemoryLimit = 1<<63 - 1
+
+	// memoryLimitHeadroomPercent 为在限制下计算堆目标时预留的余量（百分比）。
+	// 从触发 GC 到标记结束之间仍会有分配，并且非堆内存的统计是近似的。
+	memoryLimitHeadroomPercent = 3
+
+	// gcCPULimiterCap 为 GC 在内存限制下允许使用的 CPU 比例上限
+	gcCPULimiterCap = 0.5
+
+	// gcCPULimiterBucketNS 为每个 P 对应的桶容量（纳秒）。
+	// GC 的 CPU 占用需要持续超过上限大约这么长时间才会开始限制。
+	gcCPULimiterBucketNS = 1e9
+)
+
+// memoryLimit 为运行时管理的总内存的软上限（字节）。
+// 由 mheap_.lock 保护写入，可以原子读取。
+var memoryLimit uint64 = maxMemoryLimit
+
+// readGOMEMLIMIT 从环境变量 GOMEMLIMIT 中读取内存限制。
+// 取值为字节数，可以带 B、KiB、MiB、GiB、TiB 后缀，"off" 表示不限制。
+func readGOMEMLIMIT() uint64 {
+	p := gogetenv("GOMEMLIMIT")
+	if p == "" || p == "off" {
+		return maxMemoryLimit
+	}
+	n, ok := parseByteCount(p)
+	if !ok {
+		print("GOMEMLIMIT=", p, "\n")
+		throw("malformed GOMEMLIMIT; see `go doc runtime/debug.SetMemoryLimit`")
+	}
+	return n
+}
+
+// parseByteCount 解析一个带可选单位后缀的非负字节数，例如 "512MiB"。
+func parseByteCount(s string) (uint64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	// 纯数字
+	last := s[len(s)-1]
+	if last >= '0' && last <= '9' {
+		n, ok := atoi(s)
+		if !ok || n < 0 {
+			return 0, false
+		}
+		return uint64(n), true
+	}
+	// 其余情况必须以 B 结尾
+	if last != 'B' || len(s) < 2 {
+		return 0, false
+	}
+	// 以 B 为单位
+	if c := s[len(s)-2]; c >= '0' && c <= '9' {
+		n, ok := atoi(s[:len(s)-1])
+		if !ok || n < 0 {
+			return 0, false
+		}
+		return uint64(n), true
+	} else if c != 'i' || len(s) < 4 {
+		return 0, false
+	}
+	var shift uint
+	switch s[len(s)-3] {
+	case 'K':
+		shift = 10
+	case 'M':
+		shift = 20
+	case 'G':
+		shift = 30
+	case 'T':
+		shift = 40
+	default:
+		return 0, false
+	}
+	n, ok := atoi(s[:len(s)-3])
+	if !ok || n < 0 {
+		return 0, false
+	}
+	un := uint64(n)
+	if un > maxMemoryLimit>>shift {
+		// 溢出
+		return 0, false
+	}
+	return un << shift, true
+}
+
+//go:linkname setMemoryLimit runtime/debug.setMemoryLimit
+func setMemoryLimit(in int64) (out int64) {
+	// 需要持有堆锁，并可能 scavenge，因此在系统栈上运行
+	systemstack(func() {
+		lock(&mheap_.lock)
+		out = int64(memoryLimit)
+		if in >= 0 {
+			atomic.Store64(&memoryLimit, uint64(in))
+			// 更新步调来响应内存限制的变化
+			gcControllerCommit()
+			// 立刻尝试归还超出新限制的内存
+			mheap_.scavengeForMemoryLimit()
+		}
+		unlock(&mheap_.lock)
+	})
+	return out
+}
+
+// memoryLimitNonHeap 返回运行时管理的非堆内存：栈、mspan、mcache、
+// profiling bucket 以及 GC 元数据等。
+//
+// memstats.mspan_inuse 与 mcache_inuse 只在 updatememstats 中刷新，
+// 因此直接读取 spanalloc 与 cachealloc 的统计，它们受 mheap_.lock 保护。
+//
+// mheap_.lock 必须被持有或世界必须停止。
+func memoryLimitNonHeap() uint64 {
+	return memstats.stacks_inuse + uint64(mheap_.spanalloc.inuse) + uint64(mheap_.cachealloc.inuse) +
+		memstats.buckhash_sys + memstats.gc_sys + memstats.other_sys
+}
+
+// memoryLimitHeapGoal 返回内存限制所允许的堆目标（以 heap_live 计）。
+// 若没有设置内存限制，返回 ^uint64(0)。
+//
+// mheap_.lock 必须被持有或世界必须停止。
+func memoryLimitHeapGoal() uint64 {
+	limit := atomic.Load64(&memoryLimit)
+	if limit == maxMemoryLimit {
+		return ^uint64(0)
+	}
+	nonHeap := memoryLimitNonHeap()
+	if limit <= nonHeap {
+		// 仅非堆内存就已经超过了限制，尽可能频繁地 GC
+		return 0
+	}
+	goal := limit - nonHeap
+	goal -= goal / 100 * memoryLimitHeadroomPercent
+	return goal
+}
+
+// memoryLimitRetained 返回运行时当前保留的内存总量：
+// 未归还给操作系统的堆内存加上非堆内存。
+//
+// mheap_.lock 必须被持有或世界必须停止。
+func memoryLimitRetained() uint64 {
+	return memstats.heap_sys - memstats.heap_released + memoryLimitNonHeap()
+}
+
+// scavengeForMemoryLimit 在保留内存超过内存限制时 scavenge 空闲页，
+// 尽量将保留内存降到限制以下。
+//
+// h 必须被锁住。
+func (h *mheap) scavengeForMemoryLimit() {
+	limit := atomic.Load64(&memoryLimit)
+	if limit == maxMemoryLimit {
+		return
+	}
+	retained := memoryLimitRetained()
+	if retained <= limit {
+		return
+	}
+	h.scavengeLocked(uintptr(retained-limit), nanotime())
+}
+
+// gcCPULimiter 限制 GC 在内存限制下的 CPU 占用。
+var gcCPULimiter gcCPULimiterState
+
+type gcCPULimiterState struct {
+	// enabled 为 1 表示 GC 的 CPU 占用已经超过上限，此时禁用 mark assist。
+	// 原子访问。
+	enabled uint32
+
+	// bucketFill 为一个漏桶：GC 使用的 CPU 时间中超出 gcCPULimiterCap
+	// 的部分注入，低于上限的部分流出。桶满时开始限制。
+	bucketFill int64
+
+	// lastUpdate 为上一次 update 的 nanotime
+	lastUpdate int64
+}
+
+// limiting 报告当前是否在限制 GC 的 CPU 占用
+func (l *gcCPULimiterState) limiting() bool {
+	return atomic.Load(&l.enabled) != 0
+}
+
+// update 在每个 GC 周期的 mark termination 阶段调用，
+// gcTime 为本周期 GC 消耗的 CPU 时间（不包括 idle 标记时间）。
+//
+// 世界必须停止。
+func (l *gcCPULimiterState) update(gcTime, now int64) {
+	if l.lastUpdate == 0 {
+		l.lastUpdate = now
+		return
+	}
+	window := now - l.lastUpdate
+	l.lastUpdate = now
+	if window <= 0 {
+		return
+	}
+
+	capacity := int64(gcCPULimiterBucketNS) * int64(gomaxprocs)
+	total := window * int64(gomaxprocs)
+	l.bucketFill += gcTime - int64(float64(total)*gcCPULimiterCap)
+	if l.bucketFill < 0 {
+		l.bucketFill = 0
+	} else if l.bucketFill > capacity {
+		l.bucketFill = capacity
+	}
+
+	// 只有设置了内存限制时才需要限制 GC：否则 GC 的频率完全由 GOGC 决定。
+	enabled := uint32(0)
+	if atomic.Load64(&memoryLimit) != maxMemoryLimit && l.bucketFill >= capacity {
+		enabled = 1
+	}
+	if enabled != l.enabled && debug.gcpacertrace > 0 {
+		print("pacer: GC CPU limiter enabled=", enabled, " fill=", l.bucketFill, "/", capacity, "\n")
+	}
+	atomic.Store(&l.enabled, enabled)
+}
@@ -96,7 +96,9 @@ func gcMarkRootPrepare() {
 	// there's nothing to scan, and any roots they create during
 	// the concurrent phase will be scanned during mark
 	// termination.
-	work.nStackRoots = int(atomic.Loaduintptr(&allglen))
+	//
+	// 开启泄漏检测时，候选 goroutine 的栈被推迟扫描，见 goroutineleak.go。
+	work.nStackRoots = gcLeakPrepareStackRoots(int(atomic.Loaduintptr(&allglen)))
 
 	work.markrootNext = 0
 	work.markrootJobs = uint32(fixedRootCount + work.nFlushCacheRoots + work.nDataRoots + work.nBSSRoots + work.nSpanRoots + work.nStackRoots)
@@ -258,6 +260,10 @@ func markrootBlock(b0, n0 uintptr, ptrmask0 *uint8, gcw *gcWork, shard int) {
 
 	// Scan this shard.
 	scanblock(b, n, ptrmask, gcw, nil)
+	// scanblock 不计入扫描工作量。全局变量的扫描工作量为扫描的字节数，
+	// 单独统计，参与下一个周期的堆目标，见 gcControllerState
+	gcw.scanWork += int64(n)
+	atomic.Xaddint64(&gcController.globalsScanWork, int64(n))
 }
 
 // markrootFreeGStacks frees stacks of dead Gs.
@@ -305,6 +311,9 @@ func markrootSpans(gcw *gcWork, shard int) {
 	// collected heap) are roots. In practice, this means the fn
 	// field must be scanned.
 	//
+	// cleanup 与弱引用句柄只需满足 2)：fn 与 handle 字段是根，
+	// 但对象引用的内存不需要存活。
+	//
 	// TODO(austin): There are several ideas for making this more
 	// efficient in issue #11485.
 
@@ -343,22 +352,28 @@ func markrootSpans(gcw *gcWork, shard int) {
 		lock(&s.speciallock)
 
 		for sp := s.specials; sp != nil; sp = sp.next {
-			if sp.kind != _KindSpecialFinalizer {
-				continue
+			switch sp.kind {
+			case _KindSpecialFinalizer:
+				// don't mark finalized object, but scan it so we
+				// retain everything it points to.
+				spf := (*specialfinalizer)(unsafe.Pointer(sp))
+				// A finalizer can be set for an inner byte of an object, find object beginning.
+				p := s.base() + uintptr(spf.special.offset)/s.elemsize*s.elemsize
+
+				// Mark everything that can be reached from
+				// the object (but *not* the object itself or
+				// we'll never collect it).
+				scanobject(p, gcw)
+
+				// The special itself is a root.
+				scanblock(uintptr(unsafe.Pointer(&spf.fn)), sys.PtrSize, &oneptrmask[0], gcw, nil)
+			case _KindSpecialCleanup:
+				spc := (*specialcleanup)(unsafe.Pointer(sp))
+				scanblock(uintptr(unsafe.Pointer(&spc.fn)), sys.PtrSize, &oneptrmask[0], gcw, nil)
+			case _KindSpecialWeakHandle:
+				spw := (*specialweakhandle)(unsafe.Pointer(sp))
+				scanblock(uintptr(unsafe.Pointer(&spw.handle)), sys.PtrSize, &oneptrmask[0], gcw, nil)
 			}
-			// don't mark finalized object, but scan it so we
-			// retain everything it points to.
-			spf := (*specialfinalizer)(unsafe.Pointer(sp))
-			// A finalizer can be set for an inner byte of an object, find object beginning.
-			p := s.base() + uintptr(spf.special.offset)/s.elemsize*s.elemsize
-
-			// Mark everything that can be reached from
-			// the object (but *not* the object itself or
-			// we'll never collect it).
-			scanobject(p, gcw)
-
-			// The special itself is a root.
-			scanblock(uintptr(unsafe.Pointer(&spf.fn)), sys.PtrSize, &oneptrmask[0], gcw, nil)
 		}
 
 		unlock(&s.speciallock)
@@ -378,6 +393,11 @@ func gcAssistAlloc(gp *g) {
 	if mp := getg().m; mp.locks > 0 || mp.preemptoff != "" {
 		return
 	}
+	// 如果 GC 的 CPU 占用已经被限制，则不进行 assist，允许堆超过内存限制，
+	// 以避免 GC 陷入 death spiral。
+	if gcCPULimiter.limiting() {
+		return
+	}
 
 	traced := false
 retry:
@@ -698,6 +718,17 @@ func scanstack(gp *g, gcw *gcWork) {
 	// Shrink the stack if not much of it is being used.
 	shrinkstack(gp)
 
+	// 栈的使用量既是栈的扫描工作量，也用于计算新 goroutine 的初始栈大小
+	sp := gp.sched.sp
+	if gp.syscallsp != 0 {
+		sp = gp.syscallsp
+	}
+	scannedSize := gp.stack.hi - sp
+	if p := getg().m.p.ptr(); p != nil {
+		p.scannedStackSize += uint64(scannedSize)
+		p.scannedStacks++
+	}
+
 	var state stackScanState
 	state.stack = gp.stack
 
@@ -729,7 +760,7 @@ func scanstack(gp *g, gcw *gcWork) {
 	// Find and scan all reachable stack objects.
 	state.buildIndex()
 	for {
-		p := state.getPtr()
+		p, conservative := state.getPtr()
 		if p == 0 {
 			break
 		}
@@ -762,7 +793,12 @@ func scanstack(gp *g, gcw *gcWork) {
 			gcdata = (*byte)(unsafe.Pointer(s.startAddr))
 		}
 
-		scanblock(state.stack.lo+uintptr(obj.off), t.ptrdata, gcdata, gcw, &state)
+		b := state.stack.lo + uintptr(obj.off)
+		if conservative {
+			scanConservative(b, t.ptrdata, gcdata, gcw, &state)
+		} else {
+			scanblock(b, t.ptrdata, gcdata, gcw, &state)
+		}
 
 		if s != nil {
 			dematerializeGCProg(s)
@@ -786,31 +822,83 @@ func scanstack(gp *g, gcw *gcWork) {
 		x.nobj = 0
 		putempty((*workbuf)(unsafe.Pointer(x)))
 	}
-	if state.buf != nil || state.freeBuf != nil {
+	if state.buf != nil || state.cbuf != nil || state.freeBuf != nil {
 		throw("remaining pointer buffers")
 	}
+	// 栈帧由 scanblock 扫描，不计入扫描工作量，栈的扫描工作量单独统计
+	gcw.scanWork += int64(scannedSize)
+	atomic.Xaddint64(&gcController.stackScanWork, int64(scannedSize))
 
 	gp.gcscanvalid = true
 }
 
 // Scan a stack frame: local variables and function arguments/results.
 //go:nowritebarrier
-func scanframeworker(frame *stkframe, cache *pcvalueCache, gcw *gcWork) {
+func scanframeworker(frame *stkframe, state *stackScanState, gcw *gcWork) {
 	if _DebugGC > 1 && frame.continpc != 0 {
 		print("scanframe ", funcname(frame.fn), "\n")
 	}
 
-	locals, args := getStackMap(frame, cache, false)
+	isAsyncPreempt := frame.fn.valid() && frame.fn.entry == asyncPreemptPC
+	if state.conservative || isAsyncPreempt {
+		// Conservatively scan the frame. Unlike the precise
+		// case, this includes the outgoing argument space
+		// since we may have stopped while this function was
+		// setting up a call.
+		if frame.varp != 0 {
+			size := frame.varp - frame.sp
+			if size > 0 {
+				scanConservative(frame.sp, size, nil, gcw, state)
+			}
+		}
+
+		// Scan arguments to this frame.
+		if frame.arglen != 0 {
+			scanConservative(frame.argp, frame.arglen, nil, gcw, state)
+		}
+
+		// The asyncPreempt frame contains the registers of the
+		// asynchronously stopped parent frame, so scan the
+		// parent conservatively as well. Frames above that are
+		// stopped at calls and can be scanned precisely again.
+		state.conservative = isAsyncPreempt
+		return
+	}
+
+	locals, args, objs := getStackMap(frame, &state.cache, false)
 
 	// Scan local variables if stack frame has been allocated.
 	if locals.n > 0 {
 		size := uintptr(locals.n) * sys.PtrSize
-		scanblock(frame.varp-size, size, locals.bytedata, gcw)
+		scanblock(frame.varp-size, size, locals.bytedata, gcw, state)
 	}
 
 	// Scan arguments.
 	if args.n > 0 {
-		scanblock(frame.argp, uintptr(args.n)*sys.PtrSize, args.bytedata, gcw)
+		scanblock(frame.argp, uintptr(args.n)*sys.PtrSize, args.bytedata, gcw, state)
+	}
+
+	// Add all stack objects to the stack object list.
+	if frame.varp != 0 {
+		// varp is 0 for defers, where there are no locals.
+		// In that case, there can't be a pointer to its args, either.
+		// (And all args would be scanned above anyway.)
+		for _, obj := range objs {
+			off := obj.off
+			base := frame.varp // locals base pointer
+			if off >= 0 {
+				base = frame.argp // arguments and return values base pointer
+			}
+			ptr := base + uintptr(off)
+			if ptr < frame.sp {
+				// object hasn't been allocated in the frame yet.
+				continue
+			}
+			if stackTraceDebug {
+				println("stkobj at", hex(ptr), "of type", obj.typ.string())
+			}
+			state.addObject(ptr, obj.typ)
+		}
 	}
 }
 
@@ -1049,7 +1137,7 @@ func scanblock(b0, n0 uintptr, ptrmask *uint8, gcw *gcWork, stk *stackScanState)
 					if obj, span, objIndex := findObject(p, b, i); obj != 0 {
 						greyobject(obj, b, i, span, gcw, objIndex)
 					} else if stk != nil && p >= stk.stack.lo && p < stk.stack.hi {
-						stk.putPtr(p)
+						stk.putPtr(p, false)
 					}
 				}
 			}
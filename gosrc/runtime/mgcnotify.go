@@ -0,0 +1,117 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// GC 结束通知，runtime/debug.OnGC 的运行时实现。
+//
+// 每个 GC 周期结束时（gcMarkTermination 重新启动世界之后），gcNotifyCycle 将描述该周期的
+// gcNotifyEvent 放入一个环形缓冲区，并唤醒阻塞在 gcNotifyRecv 中的 goroutine。
+// 该 goroutine 由 runtime/debug 在第一次调用 OnGC 时创建，由它依次调用用户注册的回调，
+// 因此回调不会阻塞垃圾回收器。若回调处理得太慢，缓冲区中最旧的事件会被覆盖，
+// 用户可以通过事件中不连续的周期编号发现这一点。
+//
+// 在第一次调用 gcNotifyRecv 之前，gcNotifyCycle 不记录任何事件。
+
+package runtime
+
+const gcNotifyBufLen = 16
+
+// gcNotifyEvent 描述一个完成的 GC 周期。
+// 其布局必须与 runtime/debug.gcEvent 保持一致。
+type gcNotifyEvent struct {
+	cycle          uint32 // memstats.numgc
+	trigger        uint32 // 触发 GC 的原因，见 gcNotifyTrigger
+	heapLive       uint64 // 标记结束时存活的堆字节数
+	heapGoal       uint64 // 下一个周期的堆目标
+	sweepTermPause int64  // 清扫终止阶段 STW 的时长（纳秒）
+	markTermPause  int64  // 标记终止阶段 STW 的时长（纳秒）
+	pauseTotal     int64  // 本周期所有 STW 的总时长（纳秒）
+	end            int64  // 周期结束时的 Unix 时间（纳秒）
+}
+
+// 触发 GC 的原因，必须与 runtime/debug 中的 GCTrigger 常量保持一致
+const (
+	gcNotifyTriggerHeap   = iota // 堆按 GOGC 增长到了触发点
+	gcNotifyTriggerLimit         // 堆接近内存限制
+	gcNotifyTriggerTime          // 长时间没有 GC，由 sysmon 触发
+	gcNotifyTriggerForced        // runtime.GC、debug.FreeOSMemory 等
+)
+
+var gcNotify struct {
+	lock    mutex
+	enabled bool
+	g       *g // 阻塞在 gcNotifyRecv 中的 goroutine，没有则为 nil
+
+	// buf[head%gcNotifyBufLen] 为最旧的事件，共有 n 个事件
+	buf  [gcNotifyBufLen]gcNotifyEvent
+	head uint32
+	n    uint32
+}
+
+// gcNotifyTrigger 返回当前周期的触发原因。
+func gcNotifyTrigger() uint32 {
+	switch {
+	case work.userForced:
+		return gcNotifyTriggerForced
+	case work.trigger == gcTriggerTime:
+		return gcNotifyTriggerTime
+	case work.limitTrigger:
+		return gcNotifyTriggerLimit
+	}
+	return gcNotifyTriggerHeap
+}
+
+// gcNotifyCycle 记录刚刚结束的 GC 周期并唤醒接收者。
+// 在 gcMarkTermination 重新启动世界之后、释放 worldsema 之前调用，
+// 此时 work 与 memstats 中本周期的统计还没有被下一个周期覆盖。
+func gcNotifyCycle(unixNow int64) {
+	lock(&gcNotify.lock)
+	if !gcNotify.enabled {
+		unlock(&gcNotify.lock)
+		return
+	}
+	if gcNotify.n == gcNotifyBufLen {
+		// 接收者跟不上，覆盖最旧的事件
+		gcNotify.head++
+		gcNotify.n--
+	}
+	e := &gcNotify.buf[(gcNotify.head+gcNotify.n)%gcNotifyBufLen]
+	gcNotify.n++
+	*e = gcNotifyEvent{
+		cycle:          memstats.numgc,
+		trigger:        gcNotifyTrigger(),
+		heapLive:       memstats.heap_marked,
+		heapGoal:       memstats.next_gc,
+		sweepTermPause: work.tMark - work.tSweepTerm,
+		markTermPause:  work.tEnd - work.tMarkTerm,
+		pauseTotal:     work.pauseNS,
+		end:            unixNow,
+	}
+	gp := gcNotify.g
+	gcNotify.g = nil
+	unlock(&gcNotify.lock)
+	if gp != nil {
+		goready(gp, 0)
+	}
+}
+
+// gcNotifyRecv 阻塞直到有一个 GC 周期结束，并将其事件存入 *e。
+// 同一时间只能有一个 goroutine 调用 gcNotifyRecv。
+//
+//go:linkname gcNotifyRecv runtime/debug.gcNotifyRecv
+func gcNotifyRecv(e *gcNotifyEvent) {
+	lock(&gcNotify.lock)
+	gcNotify.enabled = true
+	for gcNotify.n == 0 {
+		if gcNotify.g != nil {
+			throw("gcNotifyRecv: multiple receivers")
+		}
+		gcNotify.g = getg()
+		goparkunlock(&gcNotify.lock, waitReasonGCNotifyWait, traceEvGoBlock, 1)
+		lock(&gcNotify.lock)
+	}
+	*e = gcNotify.buf[gcNotify.head%gcNotifyBufLen]
+	gcNotify.head++
+	gcNotify.n--
+	unlock(&gcNotify.lock)
+}
@@ -0,0 +1,265 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 后台 scavenger
+//
+// scavenger 负责将堆中空闲页的物理内存归还给操作系统（Linux 上为 madvise，见 sysUnused），
+// 从而在一次突发的分配过后尽快降低 RSS，而不需要等待空闲内存变老或调用 debug.FreeOSMemory。
+//
+// 后台 scavenger 是一个在 gcenable 中启动的 goroutine。它的步调由一个保留内存的目标决定：
+// 每次 GC 结束时，根据上一个 GC 周期的堆目标（memstats.last_next_gc）计算出目标
+// memstats.scav_goal，并留出 retainExtraPercent 的余量以免刚归还的内存马上又要被用回来。
+// 只要保留的堆内存（heap_sys - heap_released）超过目标，scavenger 就每次归还
+// scavengeQuantum 字节的空闲页，并在每两次归还之间休眠，使其 CPU 占用不超过
+// 单个 CPU 的 scavengePercent%。达到目标后 scavenger 休眠，直到下一次清扫完成时被唤醒。
+//
+// 归还从最高的地址开始（见 pageAlloc.scavenge），与页分配器优先使用低地址的策略相配合。
+
+package runtime
+
+import "runtime/internal/atomic"
+
+const (
+	// scavengePercent 为后台 scavenger 最多使用的单个 CPU 的百分比。
+	scavengePercent = 1 // 1%
+
+	// retainExtraPercent 为保留内存目标在上一个堆目标之上额外留出的百分比。
+	//
+	// 堆的大小在两次 GC 之间会有正常的波动，留出余量可以避免 scavenger
+	// 归还马上又要被重新分配的内存。
+	retainExtraPercent = 10
+
+	// scavengeQuantum 为后台 scavenger 每次持有堆锁时最多归还的字节数。
+	//
+	// 太小会让持有堆锁、遍历页分配器元数据的开销占主导；
+	// 太大会让 scavenger 长时间持有堆锁，阻塞分配。
+	scavengeQuantum = 64 << 10
+)
+
+// heapRetained 返回当前堆保留的物理内存的估计值，即从操作系统获得的
+// 堆内存中还没有归还的部分。
+//
+// 必须持有堆锁或 STW。
+func heapRetained() uint64 {
+	return memstats.heap_sys - memstats.heap_released
+}
+
+// gcPaceScavenger 根据上一个 GC 周期的堆目标更新后台 scavenger 的保留内存目标。
+//
+// 在第一个 GC 周期结束之前，或保留内存已经在目标之下时，后台 scavenger 被关闭，
+// 此时 memstats.scav_goal 为 ^uint64(0)。
+//
+// 必须持有 mheap_.lock 或 STW。
+func gcPaceScavenger() {
+	// 还没有完成过 GC，或者关闭了 GC（next_gc 为 ^0），没有可参考的堆目标
+	if memstats.last_next_gc == 0 || memstats.last_next_gc == ^uint64(0) {
+		memstats.scav_goal = ^uint64(0)
+		return
+	}
+	// Compute our scavenging goal.
+	retainedGoal := memstats.last_next_gc + memstats.last_next_gc/100*retainExtraPercent
+
+	// Align it to a physical page boundary to make the following calculations
+	// a bit more exact.
+	retainedGoal = (retainedGoal + uint64(physPageSize) - 1) &^ (uint64(physPageSize) - 1)
+
+	// Represents where we are now in the heap's contribution to RSS in bytes.
+	//
+	// If we're already below our goal, or within one page of our goal, then
+	// disable the background scavenger. We disable the background scavenger if
+	// there's less than one physical page of work to do because it's not worth
+	// it.
+	if heapRetained() <= retainedGoal+uint64(physPageSize) {
+		memstats.scav_goal = ^uint64(0)
+		return
+	}
+	memstats.scav_goal = retainedGoal
+}
+
+// State of the background scavenger.
+var scavenge struct {
+	lock   mutex
+	g      *g
+	parked bool
+	timer  *timer
+
+	// printed 为上一次 gctrace 输出时 memstats.scav_released 的值
+	printed uint64
+}
+
+// wakeScavenger 在后台 scavenger 休眠时唤醒它，使其重新检查是否有工作要做。
+func wakeScavenger() {
+	lock(&scavenge.lock)
+	if scavenge.parked {
+		// Try to stop the timer but we don't really care if we succeed.
+		// It's possible that either a timer was never started, or that
+		// we're racing with it.
+		// In the case that we're racing with there's the low chance that
+		// we experience a spurious wake-up of the scavenger, but that's
+		// totally safe.
+		stopTimer(scavenge.timer)
+
+		// Unpark the goroutine and tell it that there may have been a pacing
+		// change. Note that we skip the scheduler's runnext slot because we
+		// want to avoid having the scavenger interfere with the fair
+		// scheduling of user goroutines. In effect, this schedules the
+		// scavenger at a "lower priority" but that's OK because it'll
+		// catch up on the work it missed when it does get scheduled.
+		scavenge.parked = false
+		systemstack(func() {
+			ready(scavenge.g, 0, false)
+		})
+	}
+	unlock(&scavenge.lock)
+}
+
+// scavengeSleep 使后台 scavenger 休眠约 ns 纳秒，返回实际休眠的时间。
+// 休眠期间可以被 wakeScavenger 提前唤醒。
+func scavengeSleep(ns int64) int64 {
+	lock(&scavenge.lock)
+
+	// Set the timer.
+	//
+	// This must happen here instead of inside gopark
+	// because we can't close over any variables without
+	// failing escape analysis.
+	start := nanotime()
+	resettimer(scavenge.timer, start+ns)
+
+	// Mark ourself as asleep and go to sleep.
+	scavenge.parked = true
+	goparkunlock(&scavenge.lock, waitReasonGCScavengeWait, traceEvGoSleep, 2)
+
+	// Return how long we actually slept for.
+	return nanotime() - start
+}
+
+// Background scavenger.
+//
+// The background scavenger maintains the RSS of the application below
+// the retained memory goal in memstats.scav_goal.
+func bgscavenge(c chan int) {
+	scavenge.g = getg()
+
+	lock(&scavenge.lock)
+	scavenge.parked = true
+
+	scavenge.timer = new(timer)
+	scavenge.timer.f = func(_ interface{}, _ uintptr) {
+		wakeScavenger()
+	}
+
+	c <- 1
+	goparkunlock(&scavenge.lock, waitReasonGCScavengeWait, traceEvGoBlock, 1)
+
+	// Exponentially-weighted moving average of the fraction of time this
+	// goroutine spends scavenging (that is, percent of a single CPU).
+	// It represents a measure of scheduling overheads which might extend
+	// the sleep or the critical time beyond what's expected. Assume no
+	// overhead to begin with.
+	const idealFraction = scavengePercent / 100.0
+	scavengeEWMA := float64(idealFraction)
+
+	for {
+		released := uintptr(0)
+
+		// Time in scavenging critical section.
+		crit := int64(0)
+
+		// Run on the system stack since we grab the heap lock,
+		// and a stack growth with the heap lock means a deadlock.
+		systemstack(func() {
+			lock(&mheap_.lock)
+
+			// If background scavenging is disabled or if there's no work to do just park.
+			retained, goal := heapRetained(), memstats.scav_goal
+			if retained <= goal {
+				unlock(&mheap_.lock)
+				return
+			}
+			want := uintptr(retained - goal)
+			if want > scavengeQuantum {
+				want = scavengeQuantum
+			}
+
+			// Scavenge some pages, and measure the amount of time spent scavenging.
+			start := nanotime()
+			released = mheap_.scavengeLocked(want, start)
+			crit = nanotime() - start
+			atomic.Xadd64(&memstats.scav_released, int64(released))
+
+			unlock(&mheap_.lock)
+		})
+
+		if released == 0 {
+			lock(&scavenge.lock)
+			scavenge.parked = true
+			goparkunlock(&scavenge.lock, waitReasonGCScavengeWait, traceEvGoBlock, 1)
+			continue
+		}
+
+		// On some platforms we may see crit as zero if the time it takes to scavenge
+		// memory is less than the minimum granularity of its clock (e.g. Windows).
+		// In this case, just assume scavenging takes 10 µs per regular physical page
+		// (determined empirically), and conservatively ignore the impact of huge pages
+		// on timing.
+		const approxCritNSPerPhysicalPage = 10e3
+		if crit <= 0 {
+			crit = approxCritNSPerPhysicalPage * int64(released/physPageSize)
+		}
+
+		// If we spent more than 10 ms (for example, if the OS scheduled us away, or someone
+		// put their machine to sleep) in the critical section, bound the time we use to
+		// calculate at 10 ms to avoid letting the sleep time get arbitrarily high.
+		const maxCrit = 10e6
+		if crit > maxCrit {
+			crit = maxCrit
+		}
+
+		// Compute the amount of time to sleep, assuming we want to use at most
+		// scavengePercent of CPU time. Take into account scheduling overheads
+		// that may extend the length of our sleep and multiply by how far
+		// off we are from the ideal ratio. For example, if we're sleeping too
+		// much, then scavengeEMWA < idealFraction, so we'll adjust the sleep time
+		// down.
+		adjust := scavengeEWMA / idealFraction
+		sleepTime := int64(adjust * float64(crit) / (scavengePercent / 100.0))
+
+		// Go to sleep.
+		slept := scavengeSleep(sleepTime)
+
+		// Compute the new ratio.
+		fraction := float64(crit) / float64(crit+slept)
+
+		// Set a lower bound on the fraction.
+		// Due to OS-related anomalies we may "sleep" for an inordinate amount
+		// of time. Let's avoid letting the ratio get out of hand by bounding
+		// the sleep time we use in our EWMA.
+		const minFraction = 1.0 / 1000.0
+		if fraction < minFraction {
+			fraction = minFraction
+		}
+
+		// Update scavengeEWMA by merging in the new crit/slept ratio.
+		const alpha = 0.5
+		scavengeEWMA = alpha*fraction + (1-alpha)*scavengeEWMA
+	}
+}
+
+// printScavTrace 在 gctrace > 0 时输出自上一次输出以来后台 scavenger 的工作。
+// 它在每个 GC 周期结束时被调用，不持有堆锁，因此输出的保留内存与目标只是近似值。
+//
+// 必须持有 worldsema，从而不会与另一个 GC 周期的输出交错。
+func printScavTrace() {
+	released := atomic.Load64(&memstats.scav_released)
+	goal := memstats.scav_goal
+	print("scvg: ", (released-scavenge.printed)>>10, " KB released, ",
+		heapRetained()>>20, " MB retained, ")
+	if goal == ^uint64(0) {
+		print("no goal\n")
+	} else {
+		print(goal>>20, " MB goal\n")
+	}
+	scavenge.printed = released
+}
@@ -152,6 +152,9 @@ func sweepone() uintptr {
 		if debug.gcpacertrace > 0 {
 			print("pacer: sweep done at heap size ", memstats.heap_live>>20, "MB; allocated ", (memstats.heap_live-mheap_.sweepHeapLiveBasis)>>20, "MB during sweep; swept ", mheap_.pagesSwept, " pages at ", sweepRatio, " pages/byte\n")
 		}
+		// 清扫已经完成，所有可以释放的页都已经被释放，
+		// 如果 scavenger 在休眠则唤醒它
+		wakeScavenger()
 	}
 	_g_.m.locks--
 	return npages
@@ -223,6 +226,10 @@ func (s *mspan) sweep(preserve bool) bool {
 
 	atomic.Xadd64(&mheap_.pagesSwept, int64(s.npages))
 
+	if s.guard != nil && s.guard.state == guardQuarantined {
+		return s.sweepQuarantined(sweepgen)
+	}
+
 	spc := s.spanclass
 	size := s.elemsize
 	res := false
@@ -243,6 +250,8 @@ func (s *mspan) sweep(preserve bool) bool {
 	// 1. An object can have both finalizer and profile special records.
 	//    In such case we need to queue finalizer for execution,
 	//    mark the object as live and preserve the profile special.
+	//    cleanup 记录同样被保留，直到对象真正被释放时才运行；
+	//    弱引用句柄则总是被清零，即弱指针在 finalizer 运行之前就会失效。
 	// 2. A tiny object can have several finalizers setup for different offsets.
 	//    If such object is not marked, we need to queue all finalizers at once.
 	// Both 1 and 2 are possible at the same time.
@@ -271,14 +280,14 @@ func (s *mspan) sweep(preserve bool) bool {
 				// Find the exact byte for which the special was setup
 				// (as opposed to object beginning).
 				p := s.base() + uintptr(special.offset)
-				if special.kind == _KindSpecialFinalizer || !hasFin {
+				if special.kind == _KindSpecialFinalizer || special.kind == _KindSpecialWeakHandle || !hasFin {
 					// Splice out special record.
 					y := special
 					special = special.next
 					*specialp = special
 					freespecial(y, unsafe.Pointer(p), size)
 				} else {
-					// This is profile record, but the object has finalizers (so kept alive).
+					// This is profile or cleanup record, but the object has finalizers (so kept alive).
 					// Keep special record.
 					specialp = &special.next
 					special = *specialp
@@ -361,6 +370,7 @@ func (s *mspan) sweep(preserve bool) bool {
 
 	if nfreed > 0 && spc.sizeclass() != 0 {
 		c.local_nsmallfree[spc.sizeclass()] += uintptr(nfreed)
+		atomic.Xadd64(&c.stats.smallFreeCount[spc.sizeclass()], int64(nfreed))
 		res = mheap_.central[spc].mcentral.freeSpan(s, preserve, wasempty)
 		// mcentral.freeSpan updates sweepgen
 	} else if freeToHeap {
@@ -380,7 +390,14 @@ func (s *mspan) sweep(preserve bool) bool {
 		// have mysterious crashes due to confused memory reuse.
 		// It should be possible to switch back to sysFree if we also
 		// implement and then call some kind of mheap.deleteSpan.
-		if debug.efence > 0 {
+		if s.userArenaFaulted {
+			// 已经释放的 arena chunk 不再被引用，重新映射后才能归还给堆
+			sysMap(unsafe.Pointer(s.base()), size, nil)
+		}
+		if s.guard != nil {
+			// 保护分配的对象先进入隔离区，span 暂不归还给堆
+			s.guard.quarantine(s)
+		} else if debug.efence > 0 {
 			s.limit = 0 // prevent mlookup from finding this span
 			sysFault(unsafe.Pointer(s.base()), size)
 		} else {
@@ -388,7 +405,9 @@ func (s *mspan) sweep(preserve bool) bool {
 		}
 		c.local_nlargefree++
 		c.local_largefree += size
-		res = true
+		atomic.Xadd64(&c.stats.largeFreeCount, 1)
+		atomic.Xadd64(&c.stats.largeFree, int64(size))
+		res = s.guard == nil
 	}
 	if !res {
 		// The span has been swept and is still in-use, so put
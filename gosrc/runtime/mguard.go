@@ -0,0 +1,244 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 采样保护分配（guarded allocation），用于发现 unsafe 与 cgo 代码中的
+// 越界访问与释放后使用。思路来自 GWP-ASan。
+//
+// 设置 GODEBUG=guardalloc=N 后，平均每 N 次不超过一个物理页的分配中有一次被采样。
+// 被采样的对象单独占用一个大对象 span，span 的布局为：
+//
+//	| 数据页 ... [padding][object] | 保护页 (PROT_NONE) | ... |
+//
+// 对象被放在数据页的末尾，紧挨着一个被映射为不可访问的保护页，
+// 因此越过对象末尾的读写会立即产生段错误。
+//
+// 垃圾回收器发现对象不可达后，span 不会立即归还给堆，而是整个被映射为不可访问，
+// 并在隔离区中停留 guardQuarantineCycles 个 GC 周期。在此期间通过残留的指针
+// （例如保存在 uintptr 或 C 内存中的指针）对对象的访问同样会产生段错误。
+//
+// 在 sigpanic 与信号处理函数中，落在这些页上的错误地址会额外打印一份报告，
+// 包括对象的大小、分配对象的调用栈，以及释放对象的 GC 周期与清扫它的调用栈。
+//
+// 同时使用的保护分配最多有 guardMaxSlots 个，超出时不再采样。
+// 对垃圾回收器而言被采样的对象与普通的大对象相同，只是不在 span 的起始位置，
+// 因此其堆位图的写法与 arena 的 chunk 相同，见 spanHeapBitsSetType。
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+const (
+	// guardMaxSlots 为同时存在（包括隔离区中）的保护分配的最大个数
+	guardMaxSlots = 256
+
+	// guardQuarantineCycles 为被释放的对象在隔离区中停留的 GC 周期数
+	guardQuarantineCycles = 4
+)
+
+// 保护分配槽的状态
+const (
+	guardFree        = iota // 槽空闲
+	guardInUse              // 对象存活
+	guardQuarantined        // 对象已被释放，位于隔离区中
+)
+
+// guardSlot 记录一个保护分配。
+//
+//go:notinheap
+type guardSlot struct {
+	state uint32
+	span  *mspan
+	addr  uintptr // 对象的地址
+	size  uintptr // 对象的大小
+	guard uintptr // 保护页的地址
+
+	allocGoid int64
+	allocStk  [maxStack]uintptr
+	nallocStk int
+	freeCycle uint32 // 释放对象的 GC 周期
+	freeGoid  int64  // 清扫对象的 goroutine
+	freeStk   [maxStack]uintptr
+	nfreeStk  int
+}
+
+var guardAlloc struct {
+	lock  mutex
+	slots *[guardMaxSlots]guardSlot
+}
+
+// guardNextSample 返回距离下一次保护分配的分配次数，平均为 GODEBUG=guardalloc 的值。
+func guardNextSample() int32 {
+	n := debug.guardalloc
+	if n <= 1 {
+		return 1
+	}
+	return int32(fastrandn(uint32(2*n))) + 1
+}
+
+// guardSample 决定 mallocgc 中大小为 size 的分配是否被采样。若被采样，
+// 分配一个带保护页的 span 并返回其槽，否则返回 nil。
+//
+// 调用者必须已经设置 mp.mallocing。
+func guardSample(c *mcache, size uintptr, typ *_type, noscan bool) *guardSlot {
+	if size > physPageSize || typ == deferType || raceenabled || msanenabled || GOOS == "js" {
+		return nil
+	}
+	if c.next_guard--; c.next_guard > 0 {
+		return nil
+	}
+	c.next_guard = guardNextSample()
+
+	var slot *guardSlot
+	lock(&guardAlloc.lock)
+	if guardAlloc.slots == nil {
+		guardAlloc.slots = (*[guardMaxSlots]guardSlot)(persistentalloc(unsafe.Sizeof(*guardAlloc.slots), sys.PtrSize, &memstats.other_sys))
+	}
+	for i := range guardAlloc.slots {
+		if guardAlloc.slots[i].state == guardFree {
+			slot = &guardAlloc.slots[i]
+			slot.state = guardInUse
+			break
+		}
+	}
+	unlock(&guardAlloc.lock)
+	if slot == nil {
+		return nil
+	}
+
+	// 数据页之后至少留出一个完整的物理页作为保护页
+	var s *mspan
+	systemstack(func() {
+		s = largeAlloc(size+2*physPageSize, true, noscan)
+	})
+	guard := round(s.base()+size, physPageSize)
+	align := uintptr(1)
+	if typ != nil {
+		align = uintptr(typ.align)
+		if size >= 8 && align < 8 {
+			// 与普通的分配一样，保证 64 位原子操作所需的对齐
+			align = 8
+		}
+	}
+	slot.span = s
+	slot.addr = (guard - size) &^ (align - 1)
+	slot.size = size
+	slot.guard = guard
+	slot.nallocStk = 0
+	slot.nfreeStk = 0
+	s.limit = guard
+	s.guard = slot
+	sysFault(unsafe.Pointer(guard), physPageSize)
+	return slot
+}
+
+// recordAlloc 记录分配对象的 goroutine 与调用栈。由 mallocgc 调用。
+func (slot *guardSlot) recordAlloc() {
+	gp := getg()
+	if gp.m.curg != nil {
+		gp = gp.m.curg
+	}
+	slot.allocGoid = gp.goid
+	slot.nallocStk = callers(2, slot.allocStk[:])
+}
+
+// quarantine 在清扫器发现对象 span s 不可达时调用，将整个 span 设置为不可访问。
+// span 仍然留在堆上，直到 sweepQuarantined 将其归还。
+func (slot *guardSlot) quarantine(s *mspan) {
+	gp := getg()
+	if gp.m.curg != nil {
+		gp = gp.m.curg
+	}
+	slot.freeGoid = gp.goid
+	slot.nfreeStk = callers(2, slot.freeStk[:])
+	slot.freeCycle = memstats.numgc
+
+	// 悬空指针仍然可能使 span 被标记，此后不能再扫描它
+	s.spanclass = makeSpanClass(0, true)
+	sysFault(unsafe.Pointer(s.base()), s.npages<<_PageShift)
+	atomic.Store(&slot.state, guardQuarantined)
+}
+
+// sweepQuarantined 清扫隔离区中的 span s。隔离期满时将 span 重新映射并归还给堆，
+// 返回 true；否则将其放回已清扫的列表中。
+func (s *mspan) sweepQuarantined(sweepgen uint32) bool {
+	// 对象早已被释放，悬空指针造成的标记没有意义
+	s.allocBits = s.gcmarkBits
+	s.gcmarkBits = newMarkBits(s.nelems)
+	s.refillAllocCache(0)
+
+	slot := s.guard
+	if memstats.numgc-slot.freeCycle < guardQuarantineCycles {
+		atomic.Store(&s.sweepgen, sweepgen)
+		mheap_.sweepSpans[sweepgen/2%2].push(s)
+		return false
+	}
+
+	s.guard = nil
+	s.needzero = 1
+	sysMap(unsafe.Pointer(s.base()), s.npages<<_PageShift, nil)
+	lock(&guardAlloc.lock)
+	slot.span = nil
+	slot.state = guardFree
+	unlock(&guardAlloc.lock)
+
+	atomic.Store(&s.sweepgen, sweepgen)
+	mheap_.freeSpan(s, true)
+	return true
+}
+
+// printGuardFault 在 addr 落在保护分配的保护页或隔离区中时打印一份报告。
+// 在崩溃路径上调用，不获取任何锁。
+func printGuardFault(addr uintptr) {
+	s := spanOf(addr)
+	if s == nil || s.guard == nil {
+		return
+	}
+	slot := s.guard
+	state := atomic.Load(&slot.state)
+	switch {
+	case state == guardQuarantined:
+		print("runtime: use after free: fault address ", hex(addr), " is ")
+		if addr < slot.addr {
+			print(slot.addr-addr, " bytes before")
+		} else {
+			print(addr-slot.addr, " bytes into")
+		}
+		print(" freed ", slot.size, "-byte object at ", hex(slot.addr), "\n")
+	case state == guardInUse && addr >= slot.guard:
+		print("runtime: heap buffer overflow: fault address ", hex(addr), " is ",
+			addr-(slot.addr+slot.size), " bytes past the end of ", slot.size, "-byte object at ", hex(slot.addr), "\n")
+	default:
+		return
+	}
+
+	print("\nobject allocated by goroutine ", slot.allocGoid, ":\n")
+	printGuardStack(slot.allocStk[:slot.nallocStk])
+	if state == guardQuarantined {
+		print("\nobject freed by garbage collection #", slot.freeCycle, ", swept by goroutine ", slot.freeGoid, ":\n")
+		printGuardStack(slot.freeStk[:slot.nfreeStk])
+	}
+	print("\n")
+}
+
+// printGuardStack 打印 callers 返回的调用栈。
+func printGuardStack(stk []uintptr) {
+	for _, pc := range stk {
+		f := findfunc(pc)
+		if !f.valid() {
+			print("\t?\n")
+			continue
+		}
+		tracepc := pc
+		if tracepc > f.entry {
+			// pc 为返回地址，调用指令在它之前
+			tracepc--
+		}
+		file, line := funcline(f, tracepc)
+		print(funcname(f), "(...)\n\t", file, ":", line, "\n")
+	}
+}
@@ -20,7 +20,7 @@ import (
 const minPhysPageSize = 4096
 
 // 主分配堆
-// 堆自身是 free 和 scav 树堆的组合，但其他全局数据也保存在这里。
+// 堆中的空闲页由页分配器 pages 管理（见 mpagealloc.go），但其他全局数据也保存在这里。
 //
 // 因为 mheap 包含不能被 heap-allocated 的 mSpanLists，
 // 因此 mheap 必须不能作为 heap-allocated
@@ -28,11 +28,10 @@ const minPhysPageSize = 4096
 //go:notinheap
 type mheap struct {
 	lock      mutex
-	free      mTreap // free 和 non-scavenged spans
-	scav      mTreap // free 和 scavenged spans
-	sweepgen  uint32 // sweep-generation, 参见 mspan 的注释
-	sweepdone uint32 // 所有清扫过的 span
-	sweepers  uint32 // 活跃的清扫调用数
+	pages     pageAlloc // 页分配器
+	sweepgen  uint32    // sweep-generation, 参见 mspan 的注释
+	sweepdone uint32    // 所有清扫过的 span
+	sweepers  uint32    // 活跃的清扫调用数
 
 	// allspans 是所有创建过的 mspans 的 slice。每个 mspan 只出现一次.
 	//
@@ -71,7 +70,7 @@ type mheap struct {
 	// accounting for current progress. If we could only adjust
 	// the slope, it would create a discontinuity in debt if any
 	// progress has already been made.
-	pagesInUse         uint64  // pages of spans in stats mSpanInUse; R/W with mheap.lock
+	pagesInUse         uint64  // pages of spans in stats mSpanInUse; updated atomically
 	pagesSwept         uint64  // pages swept this cycle; updated atomically
 	pagesSweptBasis    uint64  // pagesSwept to use as the origin of the sweep ratio; updated atomically
 	sweepHeapLiveBasis uint64  // value of heap_live to use as the origin of sweep ratio; written with lock, read without
@@ -98,14 +97,6 @@ type mheap struct {
 	// This is accessed atomically.
 	reclaimCredit uintptr
 
-	// scavengeCredit is spare credit for extra bytes scavenged.
-	// Since the scavenging mechanisms operate on spans, it may
-	// scavenge more than requested. Any spare pages released
-	// go to this credit pool.
-	//
-	// This is protected by the mheap lock.
-	scavengeCredit uintptr
-
 	// 内存分配统计
 	largealloc  uint64                  // 分配给大对象的总字节数
 	nlargealloc uint64                  // 进行大对象分配的次数
@@ -172,10 +163,12 @@ type mheap struct {
 
 	spanalloc             fixalloc // span* 分配器
 	cachealloc            fixalloc // mcache* 分配器
-	treapalloc            fixalloc // treapNodes* 分配器
 	specialfinalizeralloc fixalloc // specialfinalizer* 分配器
 	specialprofilealloc   fixalloc // specialprofile* 分配器
+	specialcleanupalloc   fixalloc // specialcleanup* 分配器
+	specialweakalloc      fixalloc // specialweakhandle* 分配器
 	speciallock           mutex    // 特殊记录分配器的锁
+	cleanupID             uint64   // 最后一个分配的 cleanup 的 ID，由 speciallock 保护
 	arenaHintAlloc        fixalloc // arenaHints 分配器
 
 	unused *specialfinalizer // 从不设置，仅强制让 specialfinalizer 类型进入 DWARF
@@ -195,10 +188,11 @@ type heapArena struct {
 
 	// spans 将此 arena 中的虚拟地址页 ID 映射到 *mspan.
 	// 对于已分配的 span, 它们的页映射到 span 自己
-	// 对于空闲的 span，只有最低和最高的页会被映射到 span 自身，内部的页则会映射到任意的 span。
+	// 对于空闲的页，span 入口可能指向任意的（已经释放或被重新使用的）span。
 	// 对于从未被分配过的页，span 入口为 nil
 	//
-	// 修改由 mheap.lock 进行保护。读取可以在没有锁的情况下进行，
+	// 修改只由拥有这些页的一方进行：分配 span 的线程在发布 span 之前写入，
+	// 此时不一定持有 mheap.lock（见 mheap.allocSpan）。读取可以在没有锁的情况下进行，
 	// 但仅限于那些已知包含正在使用的 span 或栈 span。
 	// 也就是说在确定地址是活跃的 和 在 span 数组中查找地址之间是不安全的。
 	spans [pagesPerArena]*mspan
@@ -208,7 +202,7 @@ type heapArena struct {
 	// but only the bit corresponding to the first page in each
 	// span is used.
 	//
-	// Writes are protected by mheap_.lock.
+	// Writes are done atomically.
 	pageInUse [pagesPerArena / 8]uint8
 
 	// pageMarks is a bitmap that indicates which spans have any
@@ -225,6 +219,13 @@ type heapArena struct {
 	// faster scanning, but we don't have 64-bit atomic bit
 	// operations.
 	pageMarks [pagesPerArena / 8]uint8
+
+	// zeroedBase 为该 arena 中第一个可能不为零的字节相对于 arena 起始地址的偏移量。
+	// 页分配器总是优先分配地址最低的页，因此 arena 中的页是从低到高被使用的，
+	// zeroedBase 之后的内存从未被使用过，一定为零，分配时无需清零。
+	//
+	// 单调递增，原子访问。
+	zeroedBase uintptr
 }
 
 // arenaHint 是一个用于增长 heap arena 的 hint，见 mheap_.arenaHints
@@ -238,38 +239,33 @@ type arenaHint struct {
 
 // 一个 mspan 是一系列 page
 //
-// 当一个 mspan 是 heap 中的树堆时， state == mspanFree
-// 且 heapmap(s->start) == span, heapmap(s->start+s->npages-1) == span.
-// If the mspan is in the heap scav treap, then in addition to the
-// above scavenged == true. scavenged == false in all other cases.
-//
 // 当 mspan 被分配后, state == mspanInUse 或 mspanManual
 // 且对于所有的 s->start <= i < s->start+s->npages，heapmap(i) == span。
+// 空闲的页由页分配器管理，不再由 mspan 表示；
+// span 被释放后 state == mSpanDead，mspan 结构本身被回收以供重新使用。
 
 // Every mspan is in one doubly-linked list, either in the mheap's
 // busy list or one of the mcentral's span lists.
 
-// An mspan representing actual memory has state mSpanInUse,
-// mSpanManual, or mSpanFree. Transitions between these states are
+// An mspan representing actual memory has state mSpanInUse or
+// mSpanManual. Transitions between these states and freeing are
 // constrained as follows:
 //
-// * A span may transition from free to in-use or manual during any GC
+// * A span may be allocated as in-use or manual during any GC
 //   phase.
 //
-// * During sweeping (gcphase == _GCoff), a span may transition from
-//   in-use to free (as a result of sweeping) or manual to free (as a
-//   result of stacks being freed).
+// * During sweeping (gcphase == _GCoff), a span may be freed (as a
+//   result of sweeping, or of stacks being freed).
 //
-// * During GC (gcphase != _GCoff), a span *must not* transition from
-//   manual or in-use to free. Because concurrent GC may read a pointer
-//   and then look up its span, the span state must be monotonic.
+// * During GC (gcphase != _GCoff), a span *must not* be freed.
+//   Because concurrent GC may read a pointer and then look up its
+//   span, the span state must be monotonic.
 type mSpanState uint8
 
 const (
 	mSpanDead   mSpanState = iota
 	mSpanInUse             // allocated for garbage collected heap
 	mSpanManual            // allocated for manual management (e.g., stack allocator)
-	mSpanFree
 )
 
 // mSpanStateNames are the names of the span states, indexed by
@@ -278,7 +274,6 @@ var mSpanStateNames = []string{
 	"mSpanDead",
 	"mSpanInUse",
 	"mSpanManual",
-	"mSpanFree",
 }
 
 // mSpanList 是一个 span 的单向链表
@@ -368,12 +363,15 @@ type mspan struct { // 双向链表
 	needzero    uint8      // needs to be zeroed before allocation
 	divShift    uint8      // for divide by elemsize - divMagic.shift
 	divShift2   uint8      // for divide by elemsize - divMagic.shift2
-	scavenged   bool       // whether this span has had its pages released to the OS
 	elemsize    uintptr    // computed from sizeclass or from npages
 	npreleased  uintptr    // number of pages released to the os
 	limit       uintptr    // end of data in span
 	speciallock mutex      // guards specials list
 	specials    *special   // linked list of special records sorted by offset.
+
+	isUserArenaChunk bool       // span 为 arena 包的一个 chunk，见 arena.go
+	userArenaFaulted bool       // chunk 已被释放并被映射为不可访问
+	guard            *guardSlot // span 中的对象为保护分配，见 mguard.go
 }
 
 func (s *mspan) base() uintptr {
@@ -389,154 +387,6 @@ func (s *mspan) layout() (size, n, total uintptr) {
 	return
 }
 
-// physPageBounds returns the start and end of the span
-// rounded in to the physical page size.
-func (s *mspan) physPageBounds() (uintptr, uintptr) {
-	start := s.base()
-	end := start + s.npages<<_PageShift
-	if physPageSize > _PageSize {
-		// Round start and end in.
-		start = (start + physPageSize - 1) &^ (physPageSize - 1)
-		end &^= physPageSize - 1
-	}
-	return start, end
-}
-
-func (h *mheap) coalesce(s *mspan) {
-	// We scavenge s at the end after coalescing if s or anything
-	// it merged with is marked scavenged.
-	needsScavenge := false
-	prescavenged := s.released() // number of bytes already scavenged.
-
-	// merge is a helper which merges other into s, deletes references to other
-	// in heap metadata, and then discards it. other must be adjacent to s.
-	merge := func(other *mspan) {
-		// Adjust s via base and npages and also in heap metadata.
-		s.npages += other.npages
-		s.needzero |= other.needzero
-		if other.startAddr < s.startAddr {
-			s.startAddr = other.startAddr
-			h.setSpan(s.base(), s)
-		} else {
-			h.setSpan(s.base()+s.npages*pageSize-1, s)
-		}
-
-		// If before or s are scavenged, then we need to scavenge the final coalesced span.
-		needsScavenge = needsScavenge || other.scavenged || s.scavenged
-		prescavenged += other.released()
-
-		// The size is potentially changing so the treap needs to delete adjacent nodes and
-		// insert back as a combined node.
-		if other.scavenged {
-			h.scav.removeSpan(other)
-		} else {
-			h.free.removeSpan(other)
-		}
-		other.state = mSpanDead
-		h.spanalloc.free(unsafe.Pointer(other))
-	}
-
-	// realign is a helper which shrinks other and grows s such that their
-	// boundary is on a physical page boundary.
-	realign := func(a, b, other *mspan) {
-		// Caller must ensure a.startAddr < b.startAddr and that either a or
-		// b is s. a and b must be adjacent. other is whichever of the two is
-		// not s.
-
-		// If pageSize <= physPageSize then spans are always aligned
-		// to physical page boundaries, so just exit.
-		if pageSize <= physPageSize {
-			return
-		}
-		// Since we're resizing other, we must remove it from the treap.
-		if other.scavenged {
-			h.scav.removeSpan(other)
-		} else {
-			h.free.removeSpan(other)
-		}
-		// Round boundary to the nearest physical page size, toward the
-		// scavenged span.
-		boundary := b.startAddr
-		if a.scavenged {
-			boundary &^= (physPageSize - 1)
-		} else {
-			boundary = (boundary + physPageSize - 1) &^ (physPageSize - 1)
-		}
-		a.npages = (boundary - a.startAddr) / pageSize
-		b.npages = (b.startAddr + b.npages*pageSize - boundary) / pageSize
-		b.startAddr = boundary
-
-		h.setSpan(boundary-1, a)
-		h.setSpan(boundary, b)
-
-		// Re-insert other now that it has a new size.
-		if other.scavenged {
-			h.scav.insert(other)
-		} else {
-			h.free.insert(other)
-		}
-	}
-
-	// Coalesce with earlier, later spans.
-	if before := spanOf(s.base() - 1); before != nil && before.state == mSpanFree {
-		if s.scavenged == before.scavenged {
-			merge(before)
-		} else {
-			realign(before, s, before)
-		}
-	}
-
-	// Now check to see if next (greater addresses) span is free and can be coalesced.
-	if after := spanOf(s.base() + s.npages*pageSize); after != nil && after.state == mSpanFree {
-		if s.scavenged == after.scavenged {
-			merge(after)
-		} else {
-			realign(s, after, after)
-		}
-	}
-
-	if needsScavenge {
-		// When coalescing spans, some physical pages which
-		// were not returned to the OS previously because
-		// they were only partially covered by the span suddenly
-		// become available for scavenging. We want to make sure
-		// those holes are filled in, and the span is properly
-		// scavenged. Rather than trying to detect those holes
-		// directly, we collect how many bytes were already
-		// scavenged above and subtract that from heap_released
-		// before re-scavenging the entire newly-coalesced span,
-		// which will implicitly bump up heap_released.
-		memstats.heap_released -= uint64(prescavenged)
-		s.scavenge()
-	}
-}
-
-func (s *mspan) scavenge() uintptr {
-	// start and end must be rounded in, otherwise madvise
-	// will round them *out* and release more memory
-	// than we want.
-	start, end := s.physPageBounds()
-	if end <= start {
-		// start and end don't span a whole physical page.
-		return 0
-	}
-	released := end - start
-	memstats.heap_released += uint64(released)
-	s.scavenged = true
-	sysUnused(unsafe.Pointer(start), released)
-	return released
-}
-
-// released returns the number of bytes in this span
-// which were returned back to the OS.
-func (s *mspan) released() uintptr {
-	if !s.scavenged {
-		return 0
-	}
-	start, end := s.physPageBounds()
-	return end - start
-}
-
 // recordspan 为 h.allspans 添加新分配的 span。
 //
 // 这仅在第一次从 mheap.spanalloc 分配 span 时发生（在重用 span 时不调用）。
@@ -747,11 +597,12 @@ func pageIndexOf(p uintptr) (arena *heapArena, pageIdx uintptr, pageMask uint8)
 // 堆初始化
 func (h *mheap) init() {
 	// 初始化堆中各个组件的分配器
-	h.treapalloc.init(unsafe.Sizeof(treapNode{}), nil, nil, &memstats.other_sys)
 	h.spanalloc.init(unsafe.Sizeof(mspan{}), recordspan, unsafe.Pointer(h), &memstats.mspan_sys)
 	h.cachealloc.init(unsafe.Sizeof(mcache{}), nil, nil, &memstats.mcache_sys)
 	h.specialfinalizeralloc.init(unsafe.Sizeof(specialfinalizer{}), nil, nil, &memstats.other_sys)
 	h.specialprofilealloc.init(unsafe.Sizeof(specialprofile{}), nil, nil, &memstats.other_sys)
+	h.specialcleanupalloc.init(unsafe.Sizeof(specialcleanup{}), nil, nil, &memstats.other_sys)
+	h.specialweakalloc.init(unsafe.Sizeof(specialweakhandle{}), nil, nil, &memstats.other_sys)
 	h.arenaHintAlloc.init(unsafe.Sizeof(arenaHint{}), nil, nil, &memstats.other_sys)
 
 	// 不对 mspan 的分配清零，后台扫描可以通过分配它来并发的检查一个 span
@@ -765,6 +616,8 @@ func (h *mheap) init() {
 	for i := range h.central {
 		h.central[i].mcentral.init(spanClass(i))
 	}
+
+	h.pages.init(&memstats.gc_sys)
 }
 
 // reclaim sweeps and reclaims at least npage pages into the heap.
@@ -923,7 +776,7 @@ func (h *mheap) reclaimChunk(arenas []arenaIdx, pageIdx, n uintptr) uintptr {
 
 // alloc_m is the internal implementation of mheap.alloc.
 //
-// alloc_m must run on the system stack because it locks the heap, so
+// alloc_m must run on the system stack because it may lock the heap, so
 // any stack growth during alloc_m would self-deadlock.
 //
 //go:systemstack
@@ -936,6 +789,15 @@ func (h *mheap) alloc_m(npage uintptr, spanclass spanClass, large bool) *mspan {
 		h.reclaim(npage)
 	}
 
+	s := h.allocSpan(npage, false, spanclass, &memstats.heap_inuse)
+
+	// 小对象的 span 在 GC 没有标记时不需要更新全局的统计信息，也不需要调整 GC 的步调，
+	// 此时从 P 的页缓存中分配完全不需要堆锁。
+	// mcache 中的 local_scan 等统计留到下一次持有堆锁时再转移。
+	if !large && gcBlackenEnabled == 0 {
+		return s
+	}
+
 	lock(&h.lock)
 	// transfer stats from cache to global
 	memstats.heap_scan += uint64(_g_.m.mcache.local_scan)
@@ -943,61 +805,16 @@ func (h *mheap) alloc_m(npage uintptr, spanclass spanClass, large bool) *mspan {
 	memstats.tinyallocs += uint64(_g_.m.mcache.local_tinyallocs)
 	_g_.m.mcache.local_tinyallocs = 0
 
-	s := h.allocSpanLocked(npage, &memstats.heap_inuse)
-	if s != nil {
-		// Record span info, because gc needs to be
-		// able to map interior pointer to containing span.
-		atomic.Store(&s.sweepgen, h.sweepgen)
-		h.sweepSpans[h.sweepgen/2%2].push(s) // Add to swept in-use list.
-		s.state = mSpanInUse
-		s.allocCount = 0
-		s.spanclass = spanclass
-		if sizeclass := spanclass.sizeclass(); sizeclass == 0 {
-			s.elemsize = s.npages << _PageShift
-			s.divShift = 0
-			s.divMul = 0
-			s.divShift2 = 0
-			s.baseMask = 0
-		} else {
-			s.elemsize = uintptr(class_to_size[sizeclass])
-			m := &class_to_divmagic[sizeclass]
-			s.divShift = m.shift
-			s.divMul = m.mul
-			s.divShift2 = m.shift2
-			s.baseMask = m.baseMask
-		}
-
-		// Mark in-use span in arena page bitmap.
-		arena, pageIdx, pageMask := pageIndexOf(s.base())
-		arena.pageInUse[pageIdx] |= pageMask
-
-		// update stats, sweep lists
-		h.pagesInUse += uint64(npage)
-		if large {
-			memstats.heap_objects++
-			mheap_.largealloc += uint64(s.elemsize)
-			mheap_.nlargealloc++
-			atomic.Xadd64(&memstats.heap_live, int64(npage<<_PageShift))
-		}
+	if s != nil && large {
+		memstats.heap_objects++
+		mheap_.largealloc += uint64(s.elemsize)
+		mheap_.nlargealloc++
+		atomic.Xadd64(&memstats.heap_live, int64(npage<<_PageShift))
 	}
 	// heap_scan and heap_live were updated.
 	if gcBlackenEnabled != 0 {
 		gcController.revise()
 	}
-
-	if trace.enabled {
-		traceHeapAlloc()
-	}
-
-	// h.spans is accessed concurrently without synchronization
-	// from other threads. Hence, there must be a store/store
-	// barrier here to ensure the writes to h.spans above happen
-	// before the caller can publish a pointer p to an object
-	// allocated from s. As soon as this happens, the garbage
-	// collector running on another processor could read p and
-	// look up s in h.spans. The unlock acts as the barrier to
-	// order these writes. On the read side, the data dependency
-	// between p and the index in h.spans orders the reads.
 	unlock(&h.lock)
 	return s
 }
@@ -1044,24 +861,7 @@ func (h *mheap) alloc(npage uintptr, spanclass spanClass, large bool, needzero b
 //
 //go:systemstack
 func (h *mheap) allocManual(npage uintptr, stat *uint64) *mspan {
-	lock(&h.lock)
-	s := h.allocSpanLocked(npage, stat)
-	if s != nil {
-		s.state = mSpanManual
-		s.manualFreeList = 0
-		s.allocCount = 0
-		s.spanclass = 0
-		s.nelems = 0
-		s.elemsize = 0
-		s.limit = s.base() + s.npages<<_PageShift
-		// Manually managed memory doesn't count toward heap_sys.
-		memstats.heap_sys -= uint64(s.npages << _PageShift)
-	}
-
-	// This unlock acts as a release barrier. See mheap.alloc_m.
-	unlock(&h.lock)
-
-	return s
+	return h.allocSpan(npage, true, 0, stat)
 }
 
 // setSpan modifies the span map so spanOf(base) is s.
@@ -1070,28 +870,6 @@ func (h *mheap) setSpan(base uintptr, s *mspan) {
 	h.arenas[ai.l1()][ai.l2()].spans[(base/pageSize)%pagesPerArena] = s
 }
 
-// pickFreeSpan acquires a free span from internal free list
-// structures if one is available. Otherwise returns nil.
-// h must be locked.
-func (h *mheap) pickFreeSpan(npage uintptr) *mspan {
-	tf := h.free.find(npage)
-	ts := h.scav.find(npage)
-
-	// Check for whichever treap gave us the smaller, non-nil result.
-	// Note that we want the _smaller_ free span, i.e. the free span
-	// closer in size to the amount we requested (npage).
-	// 选择更小的 span
-	var s *mspan
-	if tf != nil && (ts == nil || tf.spanKey.npages <= ts.spanKey.npages) {
-		s = tf.spanKey
-		h.free.removeNode(tf)
-	} else if ts != nil && (tf == nil || tf.spanKey.npages > ts.spanKey.npages) {
-		s = ts.spanKey
-		h.scav.removeNode(ts)
-	}
-	return s
-}
-
 // setSpans modifies the span map so [spanOf(base), spanOf(base+npage*pageSize))
 // is s.
 func (h *mheap) setSpans(base, npage uintptr, s *mspan) {
@@ -1108,90 +886,291 @@ func (h *mheap) setSpans(base, npage uintptr, s *mspan) {
 	}
 }
 
-// Allocates a span of the given size.  h must be locked.
-// The returned span has been removed from the
-// free structures, but its state is still mSpanFree.
-func (h *mheap) allocSpanLocked(npage uintptr, stat *uint64) *mspan {
-	var s *mspan
+// allocNeedsZero 报告刚刚分配的 [base, base+npage*pageSize) 是否需要清零，
+// 并更新其所在 arena 的 zeroedBase。
+//
+// 每次从页分配器分配页时都必须调用，即使能够确定这些页是刚从操作系统获得的。
+//
+// 不需要持有任何锁。
+func (h *mheap) allocNeedsZero(base, npage uintptr) (needZero bool) {
+	for npage > 0 {
+		ai := arenaIndex(base)
+		ha := h.arenas[ai.l1()][ai.l2()]
 
-	s = h.pickFreeSpan(npage)
-	if s != nil {
-		goto HaveSpan
+		zeroedBase := atomic.Loaduintptr(&ha.zeroedBase)
+		arenaBase := base % heapArenaBytes
+		if arenaBase < zeroedBase {
+			// We extended into the non-zeroed part of the
+			// arena, so this region needs to be zeroed before use.
+			//
+			// zeroedBase is monotonically increasing, so if we see this now then
+			// we can be sure we need to zero this memory region.
+			//
+			// We still need to update zeroedBase for this arena, and
+			// potentially more arenas.
+			needZero = true
+		}
+		// We may observe arenaBase > zeroedBase if we're racing with one or more
+		// allocations which are acquiring memory directly before us in the address
+		// space. But, because we know no one else is acquiring *this* memory, it's
+		// still safe to not zero.
+
+		// Compute how far into the arena we extend into, capped
+		// at heapArenaBytes.
+		arenaLimit := arenaBase + npage*pageSize
+		if arenaLimit > heapArenaBytes {
+			arenaLimit = heapArenaBytes
+		}
+		// Increase ha.zeroedBase so it's >= arenaLimit.
+		// We may be racing with other updates.
+		for arenaLimit > zeroedBase {
+			if atomic.Casuintptr(&ha.zeroedBase, zeroedBase, arenaLimit) {
+				break
+			}
+			zeroedBase = atomic.Loaduintptr(&ha.zeroedBase)
+			// Sanity check zeroedBase.
+			if zeroedBase <= arenaLimit && zeroedBase > arenaBase {
+				// The zeroedBase moved into the space we were trying to
+				// claim. That's very bad, and indicates someone allocated
+				// the same region we did.
+				throw("potentially overlapping in-use allocations detected")
+			}
+		}
+
+		// Move base forward and subtract from npage to move into
+		// the next arena, or finish.
+		base += arenaLimit - arenaBase
+		npage -= (arenaLimit - arenaBase) / pageSize
 	}
-	// On failure, grow the heap and try again.
-	if !h.grow(npage) {
+	return
+}
+
+// tryAllocMSpan 尝试从当前 P 的 mspan 缓存中取得一个 mspan 结构，失败时返回 nil。
+//
+// 调用方必须在系统栈上运行，以保证调用期间不会切换 P。
+//
+//go:systemstack
+func (h *mheap) tryAllocMSpan() *mspan {
+	pp := getg().m.p.ptr()
+	// If we don't have a p or the cache is empty, we can't do
+	// anything here.
+	if pp == nil || pp.mspancache.len == 0 {
 		return nil
 	}
-	s = h.pickFreeSpan(npage)
-	if s != nil {
-		goto HaveSpan
+	// Pull off the last entry in the cache.
+	s := pp.mspancache.buf[pp.mspancache.len-1]
+	pp.mspancache.len--
+	return s
+}
+
+// allocMSpanLocked 分配一个 mspan 结构，必要时重新填充当前 P 的 mspan 缓存。
+//
+// h 必须被锁住。
+//
+//go:systemstack
+func (h *mheap) allocMSpanLocked() *mspan {
+	pp := getg().m.p.ptr()
+	if pp == nil {
+		// We don't have a p so just do the normal thing.
+		return (*mspan)(h.spanalloc.alloc())
+	}
+	// Refill the cache if necessary.
+	if pp.mspancache.len == 0 {
+		const refillCount = len(pp.mspancache.buf) / 2
+		for i := 0; i < refillCount; i++ {
+			pp.mspancache.buf[i] = (*mspan)(h.spanalloc.alloc())
+		}
+		pp.mspancache.len = refillCount
+	}
+	// Pull off the last entry in the cache.
+	s := pp.mspancache.buf[pp.mspancache.len-1]
+	pp.mspancache.len--
+	return s
+}
+
+// freeMSpanLocked 释放一个 mspan 结构，优先放回当前 P 的 mspan 缓存。
+//
+// h 必须被锁住。
+//
+//go:systemstack
+func (h *mheap) freeMSpanLocked(s *mspan) {
+	pp := getg().m.p.ptr()
+	// First try to free the mspan directly to the cache.
+	if pp != nil && pp.mspancache.len < len(pp.mspancache.buf) {
+		pp.mspancache.buf[pp.mspancache.len] = s
+		pp.mspancache.len++
+		return
+	}
+	// Failing that (or if we don't have a p), just free it to
+	// the heap.
+	h.spanalloc.free(unsafe.Pointer(s))
+}
+
+// allocSpan 分配一个 npages 页的 span。
+//
+// 若 manual 为 true，则分配一个手动管理的 span，spanclass 被忽略；
+// 否则分配一个 GC 管理的堆中的 span，spanclass 为其大小等级与是否需要扫描。
+// 分配的字节数记录在 *sysStat 中。失败时返回 nil。
+//
+// 小的 span 优先从当前 P 的页缓存中分配，此时 span 的初始化与发布都不需要持有堆锁；
+// 否则持有堆锁从页分配器中分配，必要时增长堆。
+//
+// allocSpan 必须在系统栈上调用，这既是因为它可能持有堆锁，
+// 也是为了保证在使用 P 的缓存期间不会切换 P。
+//
+//go:systemstack
+func (h *mheap) allocSpan(npages uintptr, manual bool, spanclass spanClass, sysStat *uint64) (s *mspan) {
+	// Function-global state.
+	gp := getg()
+	base, scav := uintptr(0), uintptr(0)
+
+	// If the allocation is small enough, try the page cache!
+	pp := gp.m.p.ptr()
+	if pp != nil && npages < pageCachePages/4 {
+		c := &pp.pcache
+
+		// If the cache is empty, refill it.
+		if c.empty() {
+			lock(&h.lock)
+			*c = h.pages.allocToCache()
+			unlock(&h.lock)
+		}
+
+		// Try to allocate from the cache.
+		base, scav = c.alloc(npages)
+		if base != 0 {
+			s = h.tryAllocMSpan()
+			if s != nil {
+				goto HaveSpan
+			}
+			// We failed to acquire an mspan from the cache, so we
+			// have to lock the heap to get one.
+		}
+	}
+
+	lock(&h.lock)
+	if base == 0 {
+		// Try to acquire a base address.
+		base, scav = h.pages.alloc(npages)
+		if base == 0 {
+			// On failure, grow the heap and try again.
+			if !h.grow(npages) {
+				unlock(&h.lock)
+				return nil
+			}
+			base, scav = h.pages.alloc(npages)
+			if base == 0 {
+				throw("grew heap, but no adequate free space found")
+			}
+		}
 	}
-	throw("grew heap, but no adequate free span found")
+	if s == nil {
+		// We failed to get an mspan earlier, so grab
+		// one now that we have the heap lock.
+		s = h.allocMSpanLocked()
+	}
+	unlock(&h.lock)
 
 HaveSpan:
-	// Mark span in use.
-	if s.state != mSpanFree {
-		throw("candidate mspan for allocation is not free")
-	}
-	if s.npages < npage {
-		throw("candidate mspan for allocation is too small")
-	}
-
-	// First, subtract any memory that was released back to
-	// the OS from s. We will re-scavenge the trimmed section
-	// if necessary.
-	memstats.heap_released -= uint64(s.released())
-
-	if s.npages > npage {
-		// Trim extra and put it back in the heap.
-		t := (*mspan)(h.spanalloc.alloc())
-		t.init(s.base()+npage<<_PageShift, s.npages-npage)
-		s.npages = npage
-		h.setSpan(t.base()-1, s)
-		h.setSpan(t.base(), t)
-		h.setSpan(t.base()+t.npages*pageSize-1, t)
-		t.needzero = s.needzero
-		// If s was scavenged, then t may be scavenged.
-		start, end := t.physPageBounds()
-		if s.scavenged && start < end {
-			memstats.heap_released += uint64(end - start)
-			t.scavenged = true
+	// At this point, both s != nil and base != 0, and the heap
+	// lock is no longer held. Initialize the span.
+	s.init(base, npages)
+	if h.allocNeedsZero(base, npages) {
+		s.needzero = 1
+	}
+	nbytes := npages * pageSize
+	if manual {
+		s.manualFreeList = 0
+		s.nelems = 0
+		s.limit = s.base() + nbytes
+		// Manually managed memory doesn't count toward heap_sys.
+		mSysStatDec(&memstats.heap_sys, nbytes)
+		s.state = mSpanManual
+	} else {
+		// We must set span properties before the span is published anywhere
+		// since we're not holding the heap lock.
+		s.spanclass = spanclass
+		if sizeclass := spanclass.sizeclass(); sizeclass == 0 {
+			s.elemsize = nbytes
+			s.divShift = 0
+			s.divMul = 0
+			s.divShift2 = 0
+			s.baseMask = 0
+		} else {
+			s.elemsize = uintptr(class_to_size[sizeclass])
+			m := &class_to_divmagic[sizeclass]
+			s.divShift = m.shift
+			s.divMul = m.mul
+			s.divShift2 = m.shift2
+			s.baseMask = m.baseMask
 		}
-		s.state = mSpanManual // prevent coalescing with s
-		t.state = mSpanManual
-		h.freeSpanLocked(t, false, false, s.unusedsince)
-		s.state = mSpanFree
-	}
-	// "Unscavenge" s only AFTER splitting so that
-	// we only sysUsed whatever we actually need.
-	if s.scavenged {
+		// It's safe to access h.sweepgen without the heap lock because it's
+		// only ever updated with the world stopped and we run on the
+		// systemstack which blocks a STW transition.
+		atomic.Store(&s.sweepgen, h.sweepgen)
+
+		// Now that the span is filled in, set its state. A pointer into
+		// the span may be found by the garbage collector only after the
+		// span is published in h.spans below, so the state must be set
+		// before that.
+		s.state = mSpanInUse
+	}
+
+	// Commit and account for any scavenged memory that the span now owns.
+	if scav != 0 {
 		// sysUsed all the pages that are actually available
-		// in the span. Note that we don't need to decrement
-		// heap_released since we already did so earlier.
-		sysUsed(unsafe.Pointer(s.base()), s.npages<<_PageShift)
-		s.scavenged = false
-
-		// Since we allocated out of a scavenged span, we just
-		// grew the RSS. Mitigate this by scavenging enough free
-		// space to make up for it.
-		//
-		// Also, scavengeLargest may cause coalescing, so prevent
-		// coalescing with s by temporarily changing its state.
-		s.state = mSpanManual
-		h.scavengeLargest(s.npages * pageSize)
-		s.state = mSpanFree
+		// in the span since some of them might be scavenged.
+		sysUsed(unsafe.Pointer(base), nbytes)
+		mSysStatDec(&memstats.heap_released, scav)
 	}
-	s.unusedsince = 0
+	// Update stats.
+	mSysStatInc(sysStat, nbytes)
+	mSysStatDec(&memstats.heap_idle, nbytes)
+
+	// Publish the span in various locations.
+
+	// This is safe to call without the lock held because the slots
+	// related to this span will only ever be read or modified by
+	// this thread until pointers into the span are published or
+	// pageInUse is updated.
+	h.setSpans(s.base(), npages, s)
+
+	if !manual {
+		// Add to swept in-use list.
+		//
+		// This publishes the span to root marking.
+		//
+		// h.sweepgen is guaranteed to only change during STW,
+		// and preemption is disabled in the page allocator.
+		h.sweepSpans[h.sweepgen/2%2].push(s)
 
-	h.setSpans(s.base(), npage, s)
+		// Mark in-use span in arena page bitmap.
+		//
+		// This publishes the span to the page sweeper, so
+		// it's imperative that the span be completely initialized
+		// prior to this line.
+		arena, pageIdx, pageMask := pageIndexOf(s.base())
+		atomic.Or8(&arena.pageInUse[pageIdx], pageMask)
 
-	*stat += uint64(npage << _PageShift)
-	memstats.heap_idle -= uint64(npage << _PageShift)
+		// Update related page sweeper stats.
+		atomic.Xadd64(&h.pagesInUse, int64(npages))
 
-	//println("spanalloc", hex(s.start<<_PageShift))
-	if s.inList() {
-		throw("still in list")
+		if trace.enabled {
+			// Trace that a heap alloc occurred.
+			traceHeapAlloc()
+		}
 	}
+
+	// h.spans is accessed concurrently without synchronization
+	// from other threads. Hence, there must be a store/store
+	// barrier here to ensure the writes to h.spans above happen
+	// before the caller can publish a pointer p to an object
+	// allocated from s. As soon as this happens, the garbage
+	// collector running on another processor could read p and
+	// look up s in h.spans. On the read side, the data dependency
+	// between p and the index in h.spans orders the reads.
+	publicationBarrier()
+
 	return s
 }
 
@@ -1207,23 +1186,18 @@ func (h *mheap) grow(npage uintptr) bool {
 		return false
 	}
 
-	// Scavenge some pages out of the free treap to make up for
-	// the virtual memory space we just allocated. We prefer to
-	// scavenge the largest spans first since the cost of scavenging
-	// is proportional to the number of sysUnused() calls rather than
-	// the number of pages released, so we make fewer of those calls
-	// with larger spans.
-	h.scavengeLargest(size)
-
-	// Create a fake "in use" span and free it, so that the
-	// right coalescing happens.
-	s := (*mspan)(h.spanalloc.alloc())
-	s.init(uintptr(v), size/pageSize)
-	h.setSpans(s.base(), s.npages, s)
-	atomic.Store(&s.sweepgen, h.sweepgen)
-	s.state = mSpanInUse
-	h.pagesInUse += uint64(s.npages)
-	h.freeSpanLocked(s, false, true, 0)
+	// 新映射的内存还没有对应的物理内存，因此既是空闲的也是已归还的，
+	// 不会增加保留的内存。分配这些页时才会由分配方 sysUsed。
+	mSysStatInc(&memstats.heap_released, size)
+	mSysStatInc(&memstats.heap_idle, size)
+
+	// Update the page allocator's structures to make this
+	// space ready for allocation.
+	h.pages.grow(uintptr(v), size)
+
+	// 如果设置了内存限制，堆增长后保留内存可能超过限制，
+	// 继续归还空闲内存。
+	h.scavengeForMemoryLimit()
 	return true
 }
 
@@ -1253,7 +1227,7 @@ func (h *mheap) freeSpan(s *mspan, large bool) {
 			// heap_scan changed.
 			gcController.revise()
 		}
-		h.freeSpanLocked(s, true, true, 0)
+		h.freeSpanLocked(s, true, true)
 		unlock(&h.lock)
 	})
 }
@@ -1272,14 +1246,14 @@ func (h *mheap) freeSpan(s *mspan, large bool) {
 func (h *mheap) freeManual(s *mspan, stat *uint64) {
 	s.needzero = 1
 	lock(&h.lock)
-	*stat -= uint64(s.npages << _PageShift)
-	memstats.heap_sys += uint64(s.npages << _PageShift)
-	h.freeSpanLocked(s, false, true, 0)
+	mSysStatDec(stat, s.npages*pageSize)
+	mSysStatInc(&memstats.heap_sys, s.npages*pageSize)
+	h.freeSpanLocked(s, false, true)
 	unlock(&h.lock)
 }
 
 // s must be on the busy list or unlinked.
-func (h *mheap) freeSpanLocked(s *mspan, acctinuse, acctidle bool, unusedsince int64) {
+func (h *mheap) freeSpanLocked(s *mspan, acctinuse, acctidle bool) {
 	switch s.state {
 	case mSpanManual:
 		if s.allocCount != 0 {
@@ -1290,138 +1264,64 @@ func (h *mheap) freeSpanLocked(s *mspan, acctinuse, acctidle bool, unusedsince i
 			print("mheap.freeSpanLocked - span ", s, " ptr ", hex(s.base()), " allocCount ", s.allocCount, " sweepgen ", s.sweepgen, "/", h.sweepgen, "\n")
 			throw("mheap.freeSpanLocked - invalid free")
 		}
-		h.pagesInUse -= uint64(s.npages)
+		atomic.Xadd64(&h.pagesInUse, -int64(s.npages))
 
 		// Clear in-use bit in arena page bitmap.
 		arena, pageIdx, pageMask := pageIndexOf(s.base())
-		arena.pageInUse[pageIdx] &^= pageMask
+		atomic.And8(&arena.pageInUse[pageIdx], ^pageMask)
 	default:
 		throw("mheap.freeSpanLocked - invalid span state")
 	}
 
 	if acctinuse {
-		memstats.heap_inuse -= uint64(s.npages << _PageShift)
+		mSysStatDec(&memstats.heap_inuse, s.npages*pageSize)
 	}
 	if acctidle {
-		memstats.heap_idle += uint64(s.npages << _PageShift)
+		mSysStatInc(&memstats.heap_idle, s.npages*pageSize)
 	}
-	s.state = mSpanFree
 
-	// Stamp newly unused spans. The scavenger will use that
-	// info to potentially give back some pages to the OS.
-	s.unusedsince = unusedsince
-	if unusedsince == 0 {
-		s.unusedsince = nanotime()
-	}
+	// Mark the space as free.
+	h.pages.free(s.base(), s.npages)
 
-	// Coalesce span with neighbors.
-	h.coalesce(s)
-
-	// Insert s into the appropriate treap.
-	if s.scavenged {
-		h.scav.insert(s)
-	} else {
-		h.free.insert(s)
-	}
+	// Free the span structure. We no longer have a use for it.
+	s.state = mSpanDead
+	h.freeMSpanLocked(s)
 }
 
-// scavengeLargest scavenges nbytes worth of spans in unscav
-// starting from the largest span and working down. It then takes those spans
-// and places them in scav. h must be locked.
-func (h *mheap) scavengeLargest(nbytes uintptr) {
-	// Use up scavenge credit if there's any available.
-	if nbytes > h.scavengeCredit {
-		nbytes -= h.scavengeCredit
-		h.scavengeCredit = 0
-	} else {
-		h.scavengeCredit -= nbytes
-		return
-	}
-
-	// Iterate over the treap backwards (from largest to smallest) scavenging spans
-	// until we've reached our quota of nbytes.
-	released := uintptr(0)
-	for t := h.free.end(); released < nbytes && t.valid(); {
-		s := t.span()
-		r := s.scavenge()
-		if r == 0 {
-			// Since we're going in order of largest-to-smallest span, this
-			// means all other spans are no bigger than s. There's a high
-			// chance that the other spans don't even cover a full page,
-			// (though they could) but iterating further just for a handful
-			// of pages probably isn't worth it, so just stop here.
-			//
-			// This check also preserves the invariant that spans that have
-			// `scavenged` set are only ever in the `scav` treap, and
-			// those which have it unset are only in the `free` treap.
-			return
-		}
-		n := t.prev()
-		h.free.erase(t)
-		// Now that s is scavenged, we must eagerly coalesce it
-		// with its neighbors to prevent having two spans with
-		// the same scavenged state adjacent to each other.
-		h.coalesce(s)
-		t = n
-		h.scav.insert(s)
-		released += r
-	}
-
-	// If we over-scavenged, turn that extra amount into credit.
-	if released > nbytes {
-		h.scavengeCredit += released - nbytes
-	}
-}
-
-// scavengeAll visits each node in the unscav treap and scavenges the
-// treapNode's span. It then removes the scavenged span from
-// unscav and adds it into scav before continuing. h must be locked.
-func (h *mheap) scavengeAll(now, limit uint64) uintptr {
-	// Iterate over the treap scavenging spans if unused for at least limit time.
-	released := uintptr(0)
-	for t := h.free.start(); t.valid(); {
-		s := t.span()
-		n := t.next()
-		if (now - uint64(s.unusedsince)) > limit {
-			r := s.scavenge()
-			if r != 0 {
-				h.free.erase(t)
-				// Now that s is scavenged, we must eagerly coalesce it
-				// with its neighbors to prevent having two spans with
-				// the same scavenged state adjacent to each other.
-				h.coalesce(s)
-				h.scav.insert(s)
-				released += r
-			}
-		}
-		t = n
-	}
+// scavengeLocked 将至多 nbytes 字节的空闲内存归还给操作系统，
+// 只考虑在 unusedBefore 时刻之后没有页被释放的内存，返回实际归还的字节数。
+//
+// h 必须被锁住。
+func (h *mheap) scavengeLocked(nbytes uintptr, unusedBefore int64) uintptr {
+	released := h.pages.scavenge(nbytes, unusedBefore)
+	mSysStatInc(&memstats.heap_released, released)
 	return released
 }
 
-func (h *mheap) scavenge(k int32, now, limit uint64) {
+// scavengeAll 将所有空闲页的物理内存归还给操作系统。
+func (h *mheap) scavengeAll() {
 	// Disallow malloc or panic while holding the heap lock. We do
 	// this here because this is an non-mallocgc entry-point to
 	// the mheap API.
 	gp := getg()
 	gp.m.mallocing++
 	lock(&h.lock)
-	released := h.scavengeAll(now, limit)
+	released := h.scavengeLocked(^uintptr(0), nanotime())
 	unlock(&h.lock)
 	gp.m.mallocing--
 
 	if debug.gctrace > 0 {
 		if released > 0 {
-			print("scvg", k, ": ", released>>20, " MB released\n")
+			print("scvg: ", released>>20, " MB released (forced)\n")
 		}
-		print("scvg", k, ": inuse: ", memstats.heap_inuse>>20, ", idle: ", memstats.heap_idle>>20, ", sys: ", memstats.heap_sys>>20, ", released: ", memstats.heap_released>>20, ", consumed: ", (memstats.heap_sys-memstats.heap_released)>>20, " (MB)\n")
+		print("scvg: inuse: ", memstats.heap_inuse>>20, ", idle: ", memstats.heap_idle>>20, ", sys: ", memstats.heap_sys>>20, ", released: ", memstats.heap_released>>20, ", consumed: ", (memstats.heap_sys-memstats.heap_released)>>20, " (MB)\n")
 	}
 }
 
 //go:linkname runtime_debug_freeOSMemory runtime/debug.freeOSMemory
 func runtime_debug_freeOSMemory() {
 	GC()
-	systemstack(func() { mheap_.scavenge(-1, ^uint64(0), 0) })
+	systemstack(func() { mheap_.scavengeAll() })
 }
 
 // Initialize a new span with the given start and npages.
@@ -1436,14 +1336,15 @@ func (span *mspan) init(base uintptr, npages uintptr) {
 	span.spanclass = 0
 	span.elemsize = 0
 	span.state = mSpanDead
-	span.unusedsince = 0
-	span.scavenged = false
 	span.speciallock.key = 0
 	span.specials = nil
 	span.needzero = 0
 	span.freeindex = 0
 	span.allocBits = nil
 	span.gcmarkBits = nil
+	span.isUserArenaChunk = false
+	span.userArenaFaulted = false
+	span.guard = nil
 }
 
 func (span *mspan) inList() bool {
@@ -1542,8 +1443,10 @@ func (list *mSpanList) takeAll(other *mSpanList) {
 }
 
 const (
-	_KindSpecialFinalizer = 1
-	_KindSpecialProfile   = 2
+	_KindSpecialFinalizer  = 1
+	_KindSpecialProfile    = 2
+	_KindSpecialCleanup    = 3 // 同一对象可以有多个
+	_KindSpecialWeakHandle = 4
 	// Note: The finalizer special must be first because if we're freeing
 	// an object, a finalizer special will cause the freeing operation
 	// to abort, and we want to keep the other special records around
@@ -1562,7 +1465,7 @@ type special struct {
 // offset & next, which this routine will fill in.
 // Returns true if the special was successfully added, false otherwise.
 // (The add will fail only if a record with the same p and s->kind
-//  already exists.)
+//  already exists, except for cleanup records.)
 func addspecial(p unsafe.Pointer, s *special) bool {
 	span := spanOfHeap(uintptr(p))
 	if span == nil {
@@ -1587,7 +1490,7 @@ func addspecial(p unsafe.Pointer, s *special) bool {
 		if x == nil {
 			break
 		}
-		if offset == uintptr(x.offset) && kind == x.kind {
+		if offset == uintptr(x.offset) && kind == x.kind && kind != _KindSpecialCleanup {
 			unlock(&span.speciallock)
 			releasem(mp)
 			return false // already exists
@@ -1729,6 +1632,207 @@ func setprofilebucket(p unsafe.Pointer, b *bucket) {
 	}
 }
 
+// 对象上的一个 cleanup，由 AddCleanup 添加。
+// 与 finalizer 不同，cleanup 不会复活对象，因此同一对象可以有多个 cleanup。
+//
+//go:notinheap
+type specialcleanup struct {
+	special special
+	fn      *funcval // 不接收参数的闭包，可能为堆指针
+	id      uint64   // 用于 Cleanup.Stop 识别该记录
+}
+
+// addcleanup 为对象 p 添加 cleanup f，返回其 ID。p 必须为对象的起始地址。
+func addcleanup(p unsafe.Pointer, f *funcval) uint64 {
+	lock(&mheap_.speciallock)
+	s := (*specialcleanup)(mheap_.specialcleanupalloc.alloc())
+	mheap_.cleanupID++
+	id := mheap_.cleanupID
+	unlock(&mheap_.speciallock)
+	s.special.kind = _KindSpecialCleanup
+	s.fn = f
+	s.id = id
+
+	mp := acquirem()
+	addspecial(p, &s.special)
+	// 与 addfinalizer 相同，markrootSpans 可能已经运行过，
+	// 因此需要标记 fn。但不扫描对象本身，cleanup 不会使对象引用的内存存活。
+	if gcphase != _GCoff {
+		gcw := &mp.p.ptr().gcw
+		scanblock(uintptr(unsafe.Pointer(&s.fn)), sys.PtrSize, &oneptrmask[0], gcw, nil)
+	}
+	releasem(mp)
+	return id
+}
+
+// removecleanup 移除对象 p 上 ID 为 id 的 cleanup，报告是否找到。
+func removecleanup(p unsafe.Pointer, id uint64) bool {
+	span := spanOfHeap(uintptr(p))
+	if span == nil {
+		// 对象所在的 span 已经被释放
+		return false
+	}
+	mp := acquirem()
+	span.ensureSwept()
+
+	offset := uintptr(p) - span.base()
+
+	var found *specialcleanup
+	lock(&span.speciallock)
+	for t := &span.specials; *t != nil; t = &(*t).next {
+		s := *t
+		if offset == uintptr(s.offset) && s.kind == _KindSpecialCleanup && (*specialcleanup)(unsafe.Pointer(s)).id == id {
+			*t = s.next
+			found = (*specialcleanup)(unsafe.Pointer(s))
+			break
+		}
+	}
+	unlock(&span.speciallock)
+	releasem(mp)
+
+	if found == nil {
+		return false
+	}
+	lock(&mheap_.speciallock)
+	mheap_.specialcleanupalloc.free(unsafe.Pointer(found))
+	unlock(&mheap_.speciallock)
+	return true
+}
+
+// 对象的弱引用句柄。
+//
+// 句柄是一个在堆上分配的 uintptr，保存对象的地址，因此对 GC 不可见。
+// 所有指向同一对象的弱指针共享同一个句柄，当对象不可达时，
+// 清扫会在释放对象之前将句柄清零。
+//
+//go:notinheap
+type specialweakhandle struct {
+	special special
+	handle  *uintptr // 堆指针
+}
+
+// getOrAddWeakHandle 返回对象 p 的弱引用句柄，若不存在则添加一个。
+// p 必须为对象的起始地址。
+func getOrAddWeakHandle(p unsafe.Pointer) *uintptr {
+	if handle := getWeakHandle(p); handle != nil {
+		return handle
+	}
+
+	lock(&mheap_.speciallock)
+	s := (*specialweakhandle)(mheap_.specialweakalloc.alloc())
+	unlock(&mheap_.speciallock)
+
+	handle := new(uintptr)
+	*handle = uintptr(p)
+	s.special.kind = _KindSpecialWeakHandle
+	s.handle = handle
+	if addspecial(p, &s.special) {
+		// 与 addfinalizer 相同，句柄本身是根
+		if gcphase != _GCoff {
+			mp := acquirem()
+			gcw := &mp.p.ptr().gcw
+			scanblock(uintptr(unsafe.Pointer(&s.handle)), sys.PtrSize, &oneptrmask[0], gcw, nil)
+			releasem(mp)
+		}
+		return handle
+	}
+
+	// 另一个 goroutine 抢先添加了句柄
+	lock(&mheap_.speciallock)
+	mheap_.specialweakalloc.free(unsafe.Pointer(s))
+	unlock(&mheap_.speciallock)
+
+	handle = getWeakHandle(p)
+	if handle == nil {
+		throw("failed to get or add weak handle")
+	}
+	return handle
+}
+
+// getWeakHandle 返回对象 p 的弱引用句柄，若不存在则返回 nil
+func getWeakHandle(p unsafe.Pointer) *uintptr {
+	span := spanOfHeap(uintptr(p))
+	if span == nil {
+		throw("getWeakHandle on invalid pointer")
+	}
+
+	// 与 addspecial 相同，清扫会在不加锁的情况下访问 specials
+	mp := acquirem()
+	span.ensureSwept()
+
+	offset := uintptr(p) - span.base()
+
+	var handle *uintptr
+	lock(&span.speciallock)
+	for s := span.specials; s != nil; s = s.next {
+		if offset == uintptr(s.offset) && s.kind == _KindSpecialWeakHandle {
+			handle = (*specialweakhandle)(unsafe.Pointer(s)).handle
+			break
+		}
+	}
+	unlock(&span.speciallock)
+	releasem(mp)
+	return handle
+}
+
+// weak_runtime_registerWeakPointer 返回 p 所在对象的弱引用句柄，以及 p 在对象中的偏移量。
+// 不在堆上的对象（全局变量、零大小的对象）永远不会被释放，因此为其返回一个不会被清零的句柄。
+//
+//go:linkname weak_runtime_registerWeakPointer weak.runtime_registerWeakPointer
+func weak_runtime_registerWeakPointer(p unsafe.Pointer) (unsafe.Pointer, uintptr) {
+	var base uintptr
+	if debug.sbrk == 0 {
+		base, _, _ = findObject(uintptr(p), 0, 0)
+	}
+	if base == 0 {
+		handle := new(uintptr)
+		*handle = uintptr(p)
+		return unsafe.Pointer(handle), 0
+	}
+	handle := getOrAddWeakHandle(unsafe.Pointer(base))
+	// 在句柄添加完成之前保持对象存活
+	KeepAlive(p)
+	return unsafe.Pointer(handle), uintptr(p) - base
+}
+
+// weak_runtime_isPointer 报告 x 的动态类型是否为指针
+//
+//go:linkname weak_runtime_isPointer weak.runtime_isPointer
+func weak_runtime_isPointer(x interface{}) bool {
+	t := efaceOf(&x)._type
+	return t != nil && t.kind&kindMask == kindPtr
+}
+
+// weak_runtime_makeStrongFromWeak 返回句柄 u 指向的对象，若对象已不可达则返回 nil。
+//
+//go:linkname weak_runtime_makeStrongFromWeak weak.runtime_makeStrongFromWeak
+func weak_runtime_makeStrongFromWeak(u unsafe.Pointer) unsafe.Pointer {
+	handle := (*uintptr)(u)
+
+	// 禁止抢占，使得在此期间不会开始新的 GC 周期
+	mp := acquirem()
+	p := atomic.Loaduintptr(handle)
+	if p == 0 {
+		releasem(mp)
+		return nil
+	}
+	// 对象可能已经不可达但所在的 span 还没有被清扫。
+	// 确保 span 已被清扫，之后句柄中的值才是可信的。
+	// 即使 p 已经被释放，对任意 span 调用 ensureSwept 也是安全的。
+	if span := spanOfHeap(p); span != nil {
+		span.ensureSwept()
+	}
+	ptr := unsafe.Pointer(atomic.Loaduintptr(handle))
+
+	// 与写屏障的 Yuasa 部分相同：标记阶段中由弱指针得到的强指针必须被标记，
+	// 否则对象可能在被重新引用后仍被当作不可达
+	if ptr != nil && gcphase != _GCoff {
+		shade(uintptr(ptr))
+	}
+	releasem(mp)
+	return ptr
+}
+
 // Do whatever cleanup needs to be done to deallocate s. It has
 // already been unlinked from the mspan specials list.
 func freespecial(s *special, p unsafe.Pointer, size uintptr) {
@@ -1745,6 +1849,19 @@ func freespecial(s *special, p unsafe.Pointer, size uintptr) {
 		lock(&mheap_.speciallock)
 		mheap_.specialprofilealloc.free(unsafe.Pointer(sp))
 		unlock(&mheap_.speciallock)
+	case _KindSpecialCleanup:
+		sc := (*specialcleanup)(unsafe.Pointer(s))
+		// fint 为 nil 表示 cleanup，见 runfinq
+		queuefinalizer(nil, sc.fn, 0, nil, nil)
+		lock(&mheap_.speciallock)
+		mheap_.specialcleanupalloc.free(unsafe.Pointer(sc))
+		unlock(&mheap_.speciallock)
+	case _KindSpecialWeakHandle:
+		sw := (*specialweakhandle)(unsafe.Pointer(s))
+		atomic.Storeuintptr(sw.handle, 0)
+		lock(&mheap_.speciallock)
+		mheap_.specialweakalloc.free(unsafe.Pointer(sw))
+		unlock(&mheap_.speciallock)
 	default:
 		throw("bad special kind")
 		panic("not reached")
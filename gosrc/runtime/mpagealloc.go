@@ -0,0 +1,973 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 页分配器
+//
+// 页分配器管理堆中的空闲页，取代了原先按大小排序的空闲 span 树堆（treap）。
+// 堆的地址空间被划分为 pallocChunkBytes（4 MiB）大小的 chunk，每个 chunk
+// 使用一个 bitmap 记录其中每一页是否已被分配，另一个 bitmap 记录空闲页的物理内存
+// 是否已经归还给操作系统（scavenged）。空闲的页不再由 mspan 表示，
+// mspan 只在页被分配时创建，在页被释放时销毁。
+//
+// 为了快速查找连续的空闲页，bitmap 之上建立了一棵基数树（radix tree）。
+// 树的每个节点是一个 pallocSum 摘要，记录它所覆盖的地址范围中：
+// 开头连续空闲页的数量（start）、最长的连续空闲页的数量（max）以及
+// 末尾连续空闲页的数量（end）。叶子节点对应一个 chunk，每一层的节点覆盖
+// 下一层的 2^summaryLevelBits 个节点。查找 npages 个页时从根开始，
+// 进入第一个 max >= npages 的节点，或者找到跨越相邻节点边界的空闲区间，
+// 因此分配总是选择地址最低的足够大的空闲区间（address-ordered first-fit）。
+//
+// 每一层的摘要都保存在一段预留的连续地址空间中，只有堆实际使用的地址范围对应的部分
+// 才会被映射，因此即使在 64 位平台上，元数据的开销也与堆的大小成正比。
+//
+// searchAddr 记录了一个地址，在它之前的页全部已被分配，查找总是从 searchAddr 开始。
+//
+// 此外，每个 P 持有一个 pageCache（见 mpagecache.go），缓存 64 个对齐的页，
+// 小的 span 可以直接从中分配而无需持有堆锁。
+//
+// 页分配器的所有状态都由 mheap_.lock 保护。
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+const (
+	// The size of a bitmap chunk, i.e. the amount of bits (that is, pages) to consider
+	// in the bitmap at once.
+	pallocChunkPages    = 1 << logPallocChunkPages
+	pallocChunkBytes    = pallocChunkPages * pageSize
+	logPallocChunkPages = 9
+	logPallocChunkBytes = logPallocChunkPages + _PageShift
+
+	// The number of radix bits for each level.
+	//
+	// The value of 3 is chosen such that the block of summaries we need to scan at
+	// each level fits in 64 bytes (2^3 summaries * 8 bytes per summary), which is
+	// close to the L1 cache line width on many systems. Also, a value of 3 fits 4 tree
+	// levels perfectly into the 21-bit pallocBits summary field at the root level.
+	//
+	// The following equation explains how each of the constants relate:
+	// summaryL0Bits + (summaryLevels-1)*summaryLevelBits + logPallocChunkBytes = heapAddrBits
+	//
+	// summaryLevels is an architecture-dependent value defined in mpagealloc_*.go.
+	summaryLevelBits = 3
+	summaryL0Bits    = heapAddrBits - logPallocChunkBytes - (summaryLevels-1)*summaryLevelBits
+
+	// pallocChunksL2Bits is the number of bits of the chunk index number
+	// covered by the second level of the chunks map.
+	//
+	// See (*pageAlloc).chunks for more details. Update the documentation
+	// there should this change.
+	pallocChunksL2Bits  = heapAddrBits - logPallocChunkBytes - pallocChunksL1Bits
+	pallocChunksL1Shift = pallocChunksL2Bits
+
+	// Maximum searchAddr value, which indicates that the heap has no free space.
+	maxSearchAddr = ^uintptr(0)
+)
+
+// chunkIndex returns the global index of the palloc chunk containing the
+// pointer p.
+func chunkIndex(p uintptr) chunkIdx {
+	return chunkIdx((p + arenaBaseOffset) / pallocChunkBytes)
+}
+
+// chunkBase returns the base address of the palloc chunk at index ci.
+func chunkBase(ci chunkIdx) uintptr {
+	return uintptr(ci)*pallocChunkBytes - arenaBaseOffset
+}
+
+// chunkPageIndex computes the index of the page that contains p,
+// relative to the chunk which contains p.
+func chunkPageIndex(p uintptr) uint {
+	return uint(p % pallocChunkBytes / pageSize)
+}
+
+// chunkIdx is the type of a chunk index, as returned by chunkIndex.
+type chunkIdx uint
+
+// l1 returns the index into the first level of (*pageAlloc).chunks.
+func (i chunkIdx) l1() uint {
+	if pallocChunksL1Bits == 0 {
+		// Let the compiler optimize this away if there's no
+		// L1 map.
+		return 0
+	} else {
+		return uint(i) >> pallocChunksL1Shift
+	}
+}
+
+// l2 returns the index into the second level of (*pageAlloc).chunks.
+func (i chunkIdx) l2() uint {
+	if pallocChunksL1Bits == 0 {
+		return uint(i)
+	} else {
+		return uint(i) & (1<<pallocChunksL2Bits - 1)
+	}
+}
+
+// addrsToSummaryRange 返回 [base, limit) 在第 level 层对应的摘要下标范围 [lo, hi)
+func addrsToSummaryRange(level int, base, limit uintptr) (lo int, hi int) {
+	// This is slightly more nuanced than just a shift for the exclusive
+	// upper-bound. Note that the exclusive upper bound may be within a
+	// summary at this level, meaning if we just do the obvious computation
+	// hi will end up being an inclusive upper bound. Unfortunately, just
+	// adding 1 to that is too broad since we might be on the very edge of
+	// of a summary's max page count boundary for this level
+	// (1 << levelLogPages[level]). So, make limit an inclusive upper bound
+	// then shift, then add 1, so we get an exclusive upper bound at the end.
+	lo = int((base + arenaBaseOffset) >> levelShift[level])
+	hi = int(((limit-1)+arenaBaseOffset)>>levelShift[level]) + 1
+	return
+}
+
+// blockAlignSummaryRange 将第 level 层的摘要下标范围 [lo, hi) 向外对齐到
+// 该层的块大小（1 << levelBits[level]）
+func blockAlignSummaryRange(level int, lo, hi int) (int, int) {
+	e := uintptr(1) << levelBits[level]
+	return int(uintptr(lo) &^ (e - 1)), int(round(uintptr(hi), e))
+}
+
+// levelIndexToAddr 返回第 level 层第 idx 个摘要所覆盖的地址范围的起始地址
+func levelIndexToAddr(level, idx int) uintptr {
+	return uintptr(idx)<<levelShift[level] - arenaBaseOffset
+}
+
+// addrToLevelIndex 返回第 level 层中覆盖 addr 的摘要的下标
+func addrToLevelIndex(level int, addr uintptr) int {
+	return int((addr + arenaBaseOffset) >> levelShift[level])
+}
+
+// addrRange 表示地址区间 [base, limit)
+type addrRange struct {
+	base, limit uintptr
+}
+
+// size 返回区间的字节数
+func (a addrRange) size() uintptr {
+	if a.limit <= a.base {
+		return 0
+	}
+	return a.limit - a.base
+}
+
+// subtract 从 a 中去掉与 b 重叠的部分。
+// a 与 b 要么不重叠，要么只在一侧重叠，要么 b 包含 a；
+// 若 b 严格包含于 a 中（需要将 a 分成两段），则 throw。
+func (a addrRange) subtract(b addrRange) addrRange {
+	if b.base <= a.base && a.limit <= b.limit {
+		return addrRange{}
+	} else if a.base < b.base && b.limit < a.limit {
+		throw("bad prune")
+	} else if b.limit > a.base && a.base >= b.base {
+		a.base = b.limit
+	} else if a.limit > b.base && a.limit <= b.limit {
+		a.limit = b.base
+	}
+	return a
+}
+
+// addrRanges 为一组有序且互不相交的地址区间。
+// 其 backing store 由 persistentalloc 分配，因此不在 Go 堆中。
+type addrRanges struct {
+	ranges  []addrRange
+	sysStat *uint64 // 记录 backing store 的统计字段
+}
+
+func (a *addrRanges) init(sysStat *uint64) {
+	ranges := (*notInHeapSlice)(unsafe.Pointer(&a.ranges))
+	ranges.len = 0
+	ranges.cap = 16
+	ranges.array = (*notInHeap)(persistentalloc(unsafe.Sizeof(addrRange{})*uintptr(ranges.cap), sys.PtrSize, sysStat))
+	a.sysStat = sysStat
+}
+
+// findSucc 返回第一个 base 大于 addr 的区间的下标，不存在时返回 len(a.ranges)
+func (a *addrRanges) findSucc(addr uintptr) int {
+	// 区间的数量很少，线性查找即可
+	for i := range a.ranges {
+		if addr < a.ranges[i].base {
+			return i
+		}
+	}
+	return len(a.ranges)
+}
+
+// add 加入一个与已有区间都不重叠的区间 r，并与相邻的区间合并
+func (a *addrRanges) add(r addrRange) {
+	i := a.findSucc(r.base)
+	coalescesDown := i > 0 && a.ranges[i-1].limit == r.base
+	coalescesUp := i < len(a.ranges) && r.limit == a.ranges[i].base
+	if coalescesUp && coalescesDown {
+		// We have neighbors and they both border us.
+		// Merge a.ranges[i-1], r, and a.ranges[i] together into a.ranges[i-1].
+		a.ranges[i-1].limit = a.ranges[i].limit
+
+		// Delete a.ranges[i].
+		copy(a.ranges[i:], a.ranges[i+1:])
+		a.ranges = a.ranges[:len(a.ranges)-1]
+	} else if coalescesDown {
+		// We have a neighbor at a lower address only and it borders us.
+		// Merge the new space into a.ranges[i-1].
+		a.ranges[i-1].limit = r.limit
+	} else if coalescesUp {
+		// We have a neighbor at a higher address only and it borders us.
+		// Merge the new space into a.ranges[i].
+		a.ranges[i].base = r.base
+	} else {
+		// We may or may not have neighbors which don't border us.
+		// Add the new range.
+		if len(a.ranges)+1 > cap(a.ranges) {
+			// Grow the array. Note that this leaks the old array, but since
+			// we're doubling we have at most 2x waste. For a 1 TiB heap and
+			// 4 MiB arenas which are all discontiguous (both very conservative
+			// assumptions), this would waste at most 4 MiB of memory.
+			oldRanges := a.ranges
+			ranges := (*notInHeapSlice)(unsafe.Pointer(&a.ranges))
+			ranges.len = len(oldRanges) + 1
+			ranges.cap = cap(oldRanges) * 2
+			ranges.array = (*notInHeap)(persistentalloc(unsafe.Sizeof(addrRange{})*uintptr(ranges.cap), sys.PtrSize, a.sysStat))
+
+			// Copy in the old array, but make space for the new range.
+			copy(a.ranges[:i], oldRanges[:i])
+			copy(a.ranges[i+1:], oldRanges[i:])
+		} else {
+			a.ranges = a.ranges[:len(a.ranges)+1]
+			copy(a.ranges[i+1:], a.ranges[i:])
+		}
+		a.ranges[i] = r
+	}
+}
+
+// pageAlloc 为页分配器，见文件开头的说明
+type pageAlloc struct {
+	// summary 为基数树中每一层的摘要。
+	//
+	// 每一层的 slice 指向一段预留的地址空间，其长度为当前已经映射的部分的上界，
+	// 容量为该层摘要的总数。第 0 层为根，最后一层的每个摘要对应一个 chunk。
+	summary [summaryLevels][]pallocSum
+
+	// chunks 为每个 chunk 的 bitmap，以 chunkIndex 为下标。
+	//
+	// 在 64 位平台上，全部展开后 chunks 需要 O(GiB) 的地址空间，
+	// 因此与 mheap.arenas 类似使用两级的稀疏数组，第二级在堆增长时按需分配。
+	// 在 32 位平台上 pallocChunksL1Bits 为 0，只有一个第二级数组。
+	chunks [1 << pallocChunksL1Bits]*[1 << pallocChunksL2Bits]pallocData
+
+	// searchAddr 之前的页全部已被分配。
+	// 为 maxSearchAddr 时表示堆中没有空闲页。
+	searchAddr uintptr
+
+	// start 与 end 为页分配器所管理的 chunk 下标的范围 [start, end)
+	start, end chunkIdx
+
+	// inUse 为页分配器所管理的地址区间，即所有 grow 过的区间
+	inUse addrRanges
+
+	// sysStat 为记录页分配器元数据内存的统计字段
+	sysStat *uint64
+}
+
+func (s *pageAlloc) init(sysStat *uint64) {
+	if levelLogPages[0] > logMaxPackedValue {
+		// We can't represent 1<<levelLogPages[0] pages, the maximum number
+		// of pages we need to represent at the root level, in a summary, which
+		// is a big problem. Throw.
+		print("runtime: root level max pages = ", 1<<levelLogPages[0], "\n")
+		print("runtime: summary max pages = ", maxPackedValue, "\n")
+		throw("root level max pages doesn't fit in summary")
+	}
+	s.sysStat = sysStat
+
+	// Initialize s.inUse.
+	s.inUse.init(sysStat)
+
+	// System-dependent initialization.
+	s.sysInit()
+
+	// Start with the searchAddr in a state indicating there's no free memory.
+	s.searchAddr = maxSearchAddr
+}
+
+// chunkOf 返回下标为 ci 的 chunk 的 bitmap
+func (s *pageAlloc) chunkOf(ci chunkIdx) *pallocData {
+	return &s.chunks[ci.l1()][ci.l2()]
+}
+
+// grow 将地址区间 [base, base+size) 交给页分配器管理，这些页初始为空闲且已归还
+// （新映射的内存还没有物理内存）。
+//
+// 区间不能与已经管理的区间重叠。
+//
+// h.lock 必须被持有。
+func (s *pageAlloc) grow(base, size uintptr) {
+	// Round up to chunks, since we can't deal with increments smaller
+	// than chunks. Also, sysGrow expects aligned values.
+	limit := round(base+size, pallocChunkBytes)
+	base = base &^ (pallocChunkBytes - 1)
+
+	// Grow the summary levels in a system-dependent manner.
+	// We just update a bunch of additional metadata here.
+	s.sysGrow(base, limit)
+
+	// Update s.start and s.end.
+	// If no growth happened yet, start == 0. This is generally
+	// safe since the zero page is unmapped.
+	firstGrowth := s.start == 0
+	start, end := chunkIndex(base), chunkIndex(limit)
+	if firstGrowth || start < s.start {
+		s.start = start
+	}
+	if end > s.end {
+		s.end = end
+	}
+	// Note that [base, limit) will never overlap with any existing
+	// range inUse because grow only ever adds never-used memory
+	// regions to the page allocator.
+	s.inUse.add(addrRange{base, limit})
+
+	// A grow operation is a lot like a free operation, so if our
+	// chunk ends up below the searchAddr, update the searchAddr to the
+	// new address, just like in free.
+	if base < s.searchAddr {
+		s.searchAddr = base
+	}
+
+	// Add entries into chunks, which is sparse, if needed. Then,
+	// initialize the bitmap.
+	//
+	// Newly-grown memory is always considered scavenged.
+	for c := chunkIndex(base); c < chunkIndex(limit); c++ {
+		if s.chunks[c.l1()] == nil {
+			// Create the necessary l2 entry.
+			//
+			// Store it atomically to avoid races with readers which
+			// don't acquire the heap lock.
+			r := sysAlloc(unsafe.Sizeof(*s.chunks[0]), s.sysStat)
+			if r == nil {
+				throw("pageAlloc: out of memory")
+			}
+			atomic.StorepNoWB(unsafe.Pointer(&s.chunks[c.l1()]), r)
+		}
+		s.chunkOf(c).scavenged.setAll()
+	}
+
+	// Update summaries accordingly. The grow acts like a free, so
+	// we need to ensure this newly-free memory is visible in the
+	// summaries.
+	s.update(base, (limit-base)/pageSize, true, false)
+}
+
+// update 在 [base, base+npages*pageSize) 的 bitmap 发生变化后更新基数树中的摘要。
+//
+// contig 表示该区间是否整体被分配或释放，此时区间中间的 chunk 的摘要可以直接设置；
+// alloc 表示这是一次分配还是释放，只在 contig 为 true 时有意义。
+//
+// h.lock 必须被持有。
+func (s *pageAlloc) update(base, npages uintptr, contig, alloc bool) {
+	// base, limit, start, and end are inclusive.
+	limit := base + npages*pageSize - 1
+	sc, ec := chunkIndex(base), chunkIndex(limit)
+
+	// Handle updating the lowest level first.
+	if sc == ec {
+		// Fast path: the allocation doesn't span more than one chunk,
+		// so update this one and if the summary didn't change, return.
+		x := s.summary[len(s.summary)-1][sc]
+		y := s.chunkOf(sc).summarize()
+		if x == y {
+			return
+		}
+		s.summary[len(s.summary)-1][sc] = y
+	} else if contig {
+		// Slow contiguous path: the allocation spans more than one chunk
+		// and at least one summary is guaranteed to change.
+		summary := s.summary[len(s.summary)-1]
+
+		// Update the summary for chunk sc.
+		summary[sc] = s.chunkOf(sc).summarize()
+
+		// Update the summaries for chunks in between, which are
+		// either totally allocated or freed.
+		whole := s.summary[len(s.summary)-1][sc+1 : ec]
+		if alloc {
+			// Should optimize into a memclr.
+			for i := range whole {
+				whole[i] = 0
+			}
+		} else {
+			for i := range whole {
+				whole[i] = freeChunkSum
+			}
+		}
+
+		// Update the summary for chunk ec.
+		summary[ec] = s.chunkOf(ec).summarize()
+	} else {
+		// Slow general path: the allocation spans more than one chunk
+		// and at least one summary is guaranteed to change.
+		//
+		// We can't assume a contiguous allocation happened, so walk over
+		// every chunk in the range and manually recompute the summary.
+		summary := s.summary[len(s.summary)-1]
+		for c := sc; c <= ec; c++ {
+			summary[c] = s.chunkOf(c).summarize()
+		}
+	}
+
+	// Walk up the radix tree and update the summaries appropriately.
+	changed := true
+	for l := len(s.summary) - 2; l >= 0 && changed; l-- {
+		// Update summaries at level l from summaries at level l+1.
+		changed = false
+
+		// "Constants" for the previous level which we
+		// need to compute the summary from that level.
+		logEntriesPerBlock := levelBits[l+1]
+		logMaxPages := levelLogPages[l+1]
+
+		// lo and hi describe all the parts of the level we need to look at.
+		lo, hi := addrsToSummaryRange(l, base, limit+1)
+
+		// Iterate over each block, updating the corresponding summary in the less-granular level.
+		for i := lo; i < hi; i++ {
+			children := s.summary[l+1][i<<logEntriesPerBlock : (i+1)<<logEntriesPerBlock]
+			sum := mergeSummaries(children, logMaxPages)
+			old := s.summary[l][i]
+			if old != sum {
+				changed = true
+				s.summary[l][i] = sum
+			}
+		}
+	}
+}
+
+// allocRange 将 [base, base+npages*pageSize) 标记为已分配并更新摘要。
+// 返回该区间中已经归还给操作系统的字节数。
+//
+// h.lock 必须被持有。
+func (s *pageAlloc) allocRange(base, npages uintptr) uintptr {
+	limit := base + npages*pageSize - 1
+	sc, ec := chunkIndex(base), chunkIndex(limit)
+	si, ei := chunkPageIndex(base), chunkPageIndex(limit)
+
+	scav := uint(0)
+	if sc == ec {
+		// The range doesn't cross any chunk boundaries.
+		chunk := s.chunkOf(sc)
+		scav += chunk.scavenged.popcntRange(si, ei+1-si)
+		chunk.allocRange(si, ei+1-si)
+	} else {
+		// The range crosses at least one chunk boundary.
+		chunk := s.chunkOf(sc)
+		scav += chunk.scavenged.popcntRange(si, pallocChunkPages-si)
+		chunk.allocRange(si, pallocChunkPages-si)
+		for c := sc + 1; c < ec; c++ {
+			chunk := s.chunkOf(c)
+			scav += chunk.scavenged.popcntRange(0, pallocChunkPages)
+			chunk.allocAll()
+		}
+		chunk = s.chunkOf(ec)
+		scav += chunk.scavenged.popcntRange(0, ei+1)
+		chunk.allocRange(0, ei+1)
+	}
+	s.update(base, npages, true, true)
+	return uintptr(scav) * pageSize
+}
+
+// find 在整个堆中查找 npages 个连续的空闲页，返回第一页的地址，找不到时返回 0。
+//
+// 第二个返回值为新的 searchAddr：查找过程中能够确定的第一个空闲页所在区间的起始地址。
+//
+// h.lock 必须被持有。
+func (s *pageAlloc) find(npages uintptr) (uintptr, uintptr) {
+	// Search algorithm.
+	//
+	// This algorithm walks each level l of the radix tree from the root level
+	// to the leaf level. It iterates over at most 1 << levelBits[l] of entries
+	// in a given level in the radix tree, and uses the summary information to
+	// find either:
+	//  1) That a given subtree contains a large enough contiguous region, at
+	//     which point it continues iterating on the next level, or
+	//  2) That there are enough contiguous boundary-crossing bits to satisfy
+	//     the allocation, at which point it knows exactly where to start
+	//     allocating from.
+	//
+	// i tracks the index into the current level l's structure for the
+	// contiguous 1 << levelBits[l] entries we're actually interested in.
+	//
+	// NOTE: Technically this search could allocate a region which crosses
+	// the arenaBaseOffset boundary, which when arenaBaseOffset != 0, is
+	// a discontinuity. However, the only way this could happen is if the
+	// page at the zero address is mapped, and this is impossible on
+	// every system we support where arenaBaseOffset != 0. So, the
+	// discontinuity is already encoded in the fact that the OS will never
+	// map the zero page for us, and this function doesn't try to handle
+	// this case in any way.
+
+	// i is the beginning of the block of entries we're searching at the
+	// current level.
+	i := 0
+
+	// firstFree is the region of address space that we are certain to
+	// find the first free page in the heap. base and bound are the inclusive
+	// bounds of this window. At each level, this window is narrowed as we
+	// find the memory region containing the first free page of memory.
+	// To begin with, the range reflects the full process address space.
+	//
+	// firstFree is updated by calling foundFree each time free space in the
+	// heap is discovered.
+	//
+	// At the end of the search, base is the best new searchAddr we could
+	// deduce in this search.
+	firstFree := struct {
+		base, bound uintptr
+	}{
+		base:  0,
+		bound: (1<<heapAddrBits - 1) - arenaBaseOffset,
+	}
+	// foundFree takes the given address range [addr, addr+size) and
+	// updates firstFree if it is a narrower range. The input range must
+	// either be fully contained within firstFree or not overlap with it
+	// at all.
+	//
+	// This way, we'll record the first summary we find with any free
+	// pages on the root level and narrow that down if we descend into
+	// that summary. But as soon as we need to iterate beyond that summary
+	// in a level to find a large enough range, we'll stop narrowing.
+	foundFree := func(addr, size uintptr) {
+		if firstFree.base <= addr && addr+size-1 <= firstFree.bound {
+			// This range fits within the current firstFree window, so narrow
+			// down the firstFree window to the base and bound of this range.
+			firstFree.base = addr
+			firstFree.bound = addr + size - 1
+		} else if !(addr+size-1 < firstFree.base || addr > firstFree.bound) {
+			// This range only partially overlaps with the firstFree range,
+			// so throw.
+			print("runtime: addr = ", hex(addr), ", size = ", size, "\n")
+			print("runtime: base = ", hex(firstFree.base), ", bound = ", hex(firstFree.bound), "\n")
+			throw("range partially overlaps")
+		}
+	}
+
+	// lastSum is the summary which we saw on the previous level that made us
+	// move on to the next level. Used to print additional information in the
+	// case of a catastrophic failure.
+	// lastSumIdx is that summary's index in the previous level.
+	lastSum := packPallocSum(0, 0, 0)
+	lastSumIdx := -1
+
+nextLevel:
+	for l := 0; l < len(s.summary); l++ {
+		// For the root level, entriesPerBlock is the whole level.
+		entriesPerBlock := 1 << levelBits[l]
+		logMaxPages := levelLogPages[l]
+
+		// We've moved into a new level, so let's update i to our new
+		// starting index. This is a no-op for level 0.
+		i <<= levelBits[l]
+
+		// Slice out the block of entries we care about.
+		entries := s.summary[l][i : i+entriesPerBlock]
+
+		// Determine j0, the first index we should start iterating from.
+		// The searchAddr may help us eliminate iterations if we followed the
+		// searchAddr on the previous level or we're on the root leve, in which
+		// case the searchAddr should be the same as i after levelShift.
+		j0 := 0
+		if searchIdx := addrToLevelIndex(l, s.searchAddr); searchIdx&^(entriesPerBlock-1) == i {
+			j0 = searchIdx & (entriesPerBlock - 1)
+		}
+
+		// Run over the level entries looking for
+		// a contiguous run of at least npages either
+		// within an entry or across entries.
+		//
+		// base contains the page index (relative to
+		// the first entry's first page) of the currently
+		// considered run of consecutive pages.
+		//
+		// size contains the size of the currently considered
+		// run of consecutive pages.
+		var base, size uint
+		for j := j0; j < len(entries); j++ {
+			sum := entries[j]
+			if sum == 0 {
+				// A full entry means we broke any streak and
+				// that we should skip it altogether.
+				size = 0
+				continue
+			}
+
+			// We've encountered a non-zero summary which means
+			// free memory, so update firstFree.
+			foundFree(levelIndexToAddr(l, i+j), (uintptr(1)<<logMaxPages)*pageSize)
+
+			s := sum.start()
+			if size+s >= uint(npages) {
+				// If size == 0 we don't have a run yet,
+				// which means base isn't valid. So, set
+				// base to the first page in this block.
+				if size == 0 {
+					base = uint(j) << logMaxPages
+				}
+				// We hit npages; we're done!
+				size += s
+				break
+			}
+			if sum.max() >= uint(npages) {
+				// The entry itself contains npages contiguous
+				// free pages, so continue on the next level
+				// to find that run.
+				i += j
+				lastSumIdx = i
+				lastSum = sum
+				continue nextLevel
+			}
+			if size == 0 || s < 1<<logMaxPages {
+				// We either don't have a current run started, or this entry
+				// isn't totally free (meaning we can't continue the current
+				// one), so try to begin a new run by setting size and base
+				// based on sum.end.
+				size = sum.end()
+				base = uint(j+1)<<logMaxPages - size
+				continue
+			}
+			// The entry is completely free, so continue the run.
+			size += 1 << logMaxPages
+		}
+		if size >= uint(npages) {
+			// We found a sufficiently large run of free pages straddling
+			// some boundary, so compute the address and return it.
+			addr := levelIndexToAddr(l, i) + uintptr(base)*pageSize
+			return addr, firstFree.base
+		}
+		if l == 0 {
+			// We're at level zero, so that means we've exhausted our search.
+			return 0, maxSearchAddr
+		}
+
+		// We're not at level zero, and we exhausted the level we were looking in.
+		// This means that either our calculations were wrong or the level above
+		// lied to us. In either case, dump some useful state and throw.
+		print("runtime: summary[", l-1, "][", lastSumIdx, "] = ", lastSum.start(), ", ", lastSum.max(), ", ", lastSum.end(), "\n")
+		print("runtime: level = ", l, ", npages = ", npages, ", j0 = ", j0, "\n")
+		print("runtime: s.searchAddr = ", hex(s.searchAddr), ", i = ", i, "\n")
+		print("runtime: levelShift[level] = ", levelShift[l], ", levelBits[level] = ", levelBits[l], "\n")
+		for j := 0; j < len(entries); j++ {
+			sum := entries[j]
+			print("runtime: summary[", l, "][", i+j, "] = (", sum.start(), ", ", sum.max(), ", ", sum.end(), ")\n")
+		}
+		throw("bad summary data")
+	}
+
+	// Since we've gotten to this point, that means we haven't found a
+	// sufficiently-sized free region straddling some boundary (chunk or larger).
+	// This means the last summary we inspected must have had a large enough "max"
+	// value, so look inside the chunk to find a suitable run.
+	//
+	// After iterating over all levels, i must contain a chunk index which
+	// is what the final level represents.
+	ci := chunkIdx(i)
+	j, searchIdx := s.chunkOf(ci).find(npages, 0)
+	if j == ^uint(0) {
+		// We couldn't find any space in this chunk despite the summaries telling
+		// us it should be there. There's likely a bug, so dump some state and throw.
+		sum := s.summary[len(s.summary)-1][i]
+		print("runtime: summary[", len(s.summary)-1, "][", i, "] = (", sum.start(), ", ", sum.max(), ", ", sum.end(), ")\n")
+		print("runtime: npages = ", npages, "\n")
+		throw("bad summary data")
+	}
+
+	// Compute the address at which the free space starts.
+	addr := chunkBase(ci) + uintptr(j)*pageSize
+
+	// Since we actually searched the chunk, we may have
+	// found an even narrower free window.
+	searchAddr := chunkBase(ci) + uintptr(searchIdx)*pageSize
+	foundFree(searchAddr, chunkBase(ci+1)-searchAddr)
+	return addr, firstFree.base
+}
+
+// alloc 分配 npages 个连续的页，返回第一页的地址以及其中已归还给操作系统的字节数。
+// 没有足够的空闲页时返回 (0, 0)。
+//
+// h.lock 必须被持有。
+func (s *pageAlloc) alloc(npages uintptr) (addr uintptr, scav uintptr) {
+	// If the searchAddr refers to a region which has a higher address than
+	// any known chunk, then we know we're out of memory.
+	if s.searchAddr == maxSearchAddr || chunkIndex(s.searchAddr) >= s.end {
+		return 0, 0
+	}
+
+	// If npages has a chance of fitting in the chunk where the searchAddr is,
+	// search it directly.
+	searchAddr := uintptr(0)
+	if pallocChunkPages-chunkPageIndex(s.searchAddr) >= uint(npages) {
+		// npages is guaranteed to be no greater than pallocChunkPages here.
+		i := chunkIndex(s.searchAddr)
+		if max := s.summary[len(s.summary)-1][i].max(); max >= uint(npages) {
+			j, searchIdx := s.chunkOf(i).find(npages, chunkPageIndex(s.searchAddr))
+			if j == ^uint(0) {
+				print("runtime: max = ", max, ", npages = ", npages, "\n")
+				print("runtime: searchIdx = ", chunkPageIndex(s.searchAddr), ", s.searchAddr = ", hex(s.searchAddr), "\n")
+				throw("bad summary data")
+			}
+			addr = chunkBase(i) + uintptr(j)*pageSize
+			searchAddr = chunkBase(i) + uintptr(searchIdx)*pageSize
+			goto Found
+		}
+	}
+	// We failed to use a searchAddr for one reason or another, so try
+	// the slow path.
+	addr, searchAddr = s.find(npages)
+	if addr == 0 {
+		if npages == 1 {
+			// We failed to find a single free page, the smallest unit
+			// of allocation. This means we know the heap is completely
+			// exhausted. Otherwise, the heap still might have free
+			// space in it, just not enough contiguous space to
+			// accommodate npages.
+			s.searchAddr = maxSearchAddr
+		}
+		return 0, 0
+	}
+Found:
+	// Go ahead and actually mark the bits now that we have an address.
+	scav = s.allocRange(addr, npages)
+
+	// If we found a higher searchAddr, we know that all the
+	// heap memory before that searchAddr in an offset address space is
+	// allocated, so bump s.searchAddr up to the new one.
+	if s.searchAddr < searchAddr {
+		s.searchAddr = searchAddr
+	}
+	return addr, scav
+}
+
+// free 将 [base, base+npages*pageSize) 标记为空闲
+//
+// h.lock 必须被持有。
+func (s *pageAlloc) free(base, npages uintptr) {
+	// If we're freeing pages below the searchAddr, update searchAddr.
+	if base < s.searchAddr {
+		s.searchAddr = base
+	}
+	now := nanotime()
+	limit := base + npages*pageSize - 1
+	if npages == 1 {
+		// Fast path: we're clearing a single bit, and we know exactly
+		// where it is, so mark it directly.
+		chunk := s.chunkOf(chunkIndex(base))
+		chunk.free1(chunkPageIndex(base))
+		chunk.lastFree = now
+	} else {
+		// Slow path: we're clearing more bits so we may need to iterate.
+		sc, ec := chunkIndex(base), chunkIndex(limit)
+		si, ei := chunkPageIndex(base), chunkPageIndex(limit)
+
+		if sc == ec {
+			// The range doesn't cross any chunk boundaries.
+			chunk := s.chunkOf(sc)
+			chunk.free(si, ei+1-si)
+			chunk.lastFree = now
+		} else {
+			// The range crosses at least one chunk boundary.
+			chunk := s.chunkOf(sc)
+			chunk.free(si, pallocChunkPages-si)
+			chunk.lastFree = now
+			for c := sc + 1; c < ec; c++ {
+				chunk := s.chunkOf(c)
+				chunk.freeAll()
+				chunk.lastFree = now
+			}
+			chunk = s.chunkOf(ec)
+			chunk.free(0, ei+1)
+			chunk.lastFree = now
+		}
+	}
+	s.update(base, npages, true, false)
+}
+
+// scavenge 将至多 nbytes 字节空闲且尚未归还的页的物理内存归还给操作系统，
+// 返回实际归还的字节数。只考虑在 unusedBefore 时刻（含）之后没有页被释放的 chunk。
+//
+// 归还从最高的地址开始：分配总是选择地址最低的空闲页，因此高地址的空闲页
+// 最不可能马上被重新使用。已归还的页在 scavenged bitmap 中标记，再次分配时
+// 由分配方负责 sysUsed，因此 scavenger 不会重复归还同一段内存，
+// 分配器也不需要为了抵消 RSS 的增长而去归还其他的空闲内存。
+//
+// h.lock 必须被持有。
+func (s *pageAlloc) scavenge(nbytes uintptr, unusedBefore int64) uintptr {
+	released := uintptr(0)
+	for r := len(s.inUse.ranges) - 1; r >= 0 && released < nbytes; r-- {
+		rng := s.inUse.ranges[r]
+		for ci := chunkIndex(rng.limit - 1); released < nbytes; ci-- {
+			// 叶子层的摘要为 0 表示 chunk 中没有空闲页
+			if s.summary[len(s.summary)-1][ci] != 0 && s.chunkOf(ci).lastFree <= unusedBefore {
+				released += s.scavengeChunk(ci, nbytes-released)
+			}
+			if ci == chunkIndex(rng.base) {
+				break
+			}
+		}
+	}
+	return released
+}
+
+// scavengeChunk 归还 chunk ci 中至多 max 字节（向上取整到物理页）空闲且尚未归还的页，
+// 返回实际归还的字节数。
+//
+// h.lock 必须被持有。
+func (s *pageAlloc) scavengeChunk(ci chunkIdx, max uintptr) uintptr {
+	chunk := s.chunkOf(ci)
+
+	// 只能以物理页为单位归还，一个物理页中的所有页都必须空闲且尚未归还。
+	// chunk 的起始地址总是对齐到物理页。
+	minPages := uint(1)
+	if physPageSize > pageSize {
+		minPages = uint(physPageSize / pageSize)
+	}
+	// candidate 报告第 i 页是否可以被归还
+	candidate := func(i uint) bool {
+		return chunk.pallocBits.pages64(i)&(1<<(i%64)) == 0 && chunk.scavenged.get(i) == 0
+	}
+
+	released := uintptr(0)
+	top := uint(pallocChunkPages)
+	for top > 0 && released < max {
+		// 找到以 top 结尾的最长的一段可以被归还的页 [bottom, top)
+		for top > 0 && !candidate(top-1) {
+			top--
+		}
+		bottom := top
+		for bottom > 0 && candidate(bottom-1) {
+			bottom--
+		}
+		start, end := round(uintptr(bottom), uintptr(minPages)), uintptr(top)&^uintptr(minPages-1)
+		top = bottom
+		if start >= end {
+			continue
+		}
+		// 从高地址一侧归还，不超过 max
+		if rem := max - released; (end-start)*pageSize > rem {
+			start = end - round((rem+pageSize-1)/pageSize, uintptr(minPages))
+		}
+		addr := chunkBase(ci) + start*pageSize
+		n := (end - start) * pageSize
+		sysUnused(unsafe.Pointer(addr), n)
+		chunk.scavenged.setRange(uint(start), uint(end-start))
+		released += n
+	}
+	return released
+}
+
+// pallocSum 为打包后的摘要，包含三个 logMaxPackedValue 位的数：start、max 与 end。
+//
+// 当三个数都等于 maxPackedValue 时，它们无法被打包，此时最高位为 1。
+type pallocSum uint64
+
+const (
+	pallocSumBytes = unsafe.Sizeof(pallocSum(0))
+
+	// maxPackedValue is the maximum value that any of the three fields in
+	// the pallocSum may take on.
+	maxPackedValue    = 1 << logMaxPackedValue
+	logMaxPackedValue = logPallocChunkPages + (summaryLevels-1)*summaryLevelBits
+
+	freeChunkSum = pallocSum(uint64(pallocChunkPages) |
+		uint64(pallocChunkPages<<logMaxPackedValue) |
+		uint64(pallocChunkPages<<(2*logMaxPackedValue)))
+)
+
+// packPallocSum takes a start, max, and end value and produces a pallocSum.
+func packPallocSum(start, max, end uint) pallocSum {
+	if max == maxPackedValue {
+		return pallocSum(uint64(1 << 63))
+	}
+	return pallocSum((uint64(start) & (maxPackedValue - 1)) |
+		((uint64(max) & (maxPackedValue - 1)) << logMaxPackedValue) |
+		((uint64(end) & (maxPackedValue - 1)) << (2 * logMaxPackedValue)))
+}
+
+// start extracts the start value from a packed sum.
+func (p pallocSum) start() uint {
+	if uint64(p)&uint64(1<<63) != 0 {
+		return maxPackedValue
+	}
+	return uint(uint64(p) & (maxPackedValue - 1))
+}
+
+// max extracts the max value from a packed sum.
+func (p pallocSum) max() uint {
+	if uint64(p)&uint64(1<<63) != 0 {
+		return maxPackedValue
+	}
+	return uint((uint64(p) >> logMaxPackedValue) & (maxPackedValue - 1))
+}
+
+// end extracts the end value from a packed sum.
+func (p pallocSum) end() uint {
+	if uint64(p)&uint64(1<<63) != 0 {
+		return maxPackedValue
+	}
+	return uint((uint64(p) >> (2 * logMaxPackedValue)) & (maxPackedValue - 1))
+}
+
+// unpack unpacks all three values from the summary.
+func (p pallocSum) unpack() (uint, uint, uint) {
+	if uint64(p)&uint64(1<<63) != 0 {
+		return maxPackedValue, maxPackedValue, maxPackedValue
+	}
+	return uint(uint64(p) & (maxPackedValue - 1)),
+		uint((uint64(p) >> logMaxPackedValue) & (maxPackedValue - 1)),
+		uint((uint64(p) >> (2 * logMaxPackedValue)) & (maxPackedValue - 1))
+}
+
+// mergeSummaries merges consecutive summaries which may each represent at
+// most 1 << logMaxPagesPerSum pages each together into one.
+func mergeSummaries(sums []pallocSum, logMaxPagesPerSum uint) pallocSum {
+	// Merge the summaries in sums into one.
+	//
+	// We do this by keeping a running summary representing the merged
+	// summaries of sums[:i] in start, max, and end.
+	start, max, end := sums[0].unpack()
+	for i := 1; i < len(sums); i++ {
+		// Merge in sums[i].
+		si, mi, ei := sums[i].unpack()
+
+		// Merge in sums[i].start only if the running summary is
+		// completely free, otherwise this summary's start
+		// plays no role in the combined sum.
+		if start == uint(i)<<logMaxPagesPerSum {
+			start += si
+		}
+
+		// Recompute the max value of the running sum by looking
+		// across the boundary between the running sum and sums[i]
+		// and at the max sums[i], taking the greatest of those two
+		// and the max of the running sum.
+		if end+si > max {
+			max = end + si
+		}
+		if mi > max {
+			max = mi
+		}
+
+		// Merge in end by checking if this new summary is totally
+		// free. If it is, then we want to extend the running sum's
+		// end by the new summary. If not, then we have some alloc'd
+		// pages in there and we just want to take the end value in
+		// sums[i].
+		if ei == 1<<logMaxPagesPerSum {
+			end += 1 << logMaxPagesPerSum
+		} else {
+			end = ei
+		}
+	}
+	return packPallocSum(start, max, end)
+}
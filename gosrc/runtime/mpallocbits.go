@@ -0,0 +1,389 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"runtime/internal/sys"
+)
+
+// pageBits 为一个 palloc chunk 中每页一位的 bitmap
+type pageBits [pallocChunkPages / 64]uint64
+
+// get 返回第 i 位的值
+func (b *pageBits) get(i uint) uint {
+	return uint((b[i/64] >> (i % 64)) & 1)
+}
+
+// block64 返回包含第 i 位的 64 位
+func (b *pageBits) block64(i uint) uint64 {
+	return b[i/64]
+}
+
+// set 将第 i 位置为 1
+func (b *pageBits) set(i uint) {
+	b[i/64] |= 1 << (i % 64)
+}
+
+// setRange 将 [i, i+n) 位置为 1
+func (b *pageBits) setRange(i, n uint) {
+	_ = b[i/64]
+	if n == 1 {
+		// Fast path for the n == 1 case.
+		b.set(i)
+		return
+	}
+	// Set bits [i, j].
+	j := i + n - 1
+	if i/64 == j/64 {
+		b[i/64] |= ((uint64(1) << n) - 1) << (i % 64)
+		return
+	}
+	_ = b[j/64]
+	// Set leading bits.
+	b[i/64] |= ^uint64(0) << (i % 64)
+	for k := i/64 + 1; k < j/64; k++ {
+		b[k] = ^uint64(0)
+	}
+	// Set trailing bits.
+	b[j/64] |= (uint64(1) << (j%64 + 1)) - 1
+}
+
+// setAll 将所有位置为 1
+func (b *pageBits) setAll() {
+	for i := range b {
+		b[i] = ^uint64(0)
+	}
+}
+
+// clear 将第 i 位置为 0
+func (b *pageBits) clear(i uint) {
+	b[i/64] &^= 1 << (i % 64)
+}
+
+// clearRange 将 [i, i+n) 位置为 0
+func (b *pageBits) clearRange(i, n uint) {
+	_ = b[i/64]
+	if n == 1 {
+		// Fast path for the n == 1 case.
+		b.clear(i)
+		return
+	}
+	// Clear bits [i, j].
+	j := i + n - 1
+	if i/64 == j/64 {
+		b[i/64] &^= ((uint64(1) << n) - 1) << (i % 64)
+		return
+	}
+	_ = b[j/64]
+	// Clear leading bits.
+	b[i/64] &^= ^uint64(0) << (i % 64)
+	for k := i/64 + 1; k < j/64; k++ {
+		b[k] = 0
+	}
+	// Clear trailing bits.
+	b[j/64] &^= (uint64(1) << (j%64 + 1)) - 1
+}
+
+// clearAll 将所有位置为 0
+func (b *pageBits) clearAll() {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// clearBlock64 将包含第 i 位的 64 位中 mask 为 1 的位置为 0
+func (b *pageBits) clearBlock64(i uint, mask uint64) {
+	b[i/64] &^= mask
+}
+
+// popcntRange 返回 [i, i+n) 中为 1 的位的个数
+func (b *pageBits) popcntRange(i, n uint) (s uint) {
+	if n == 1 {
+		return uint((b[i/64] >> (i % 64)) & 1)
+	}
+	_ = b[i/64]
+	j := i + n - 1
+	if i/64 == j/64 {
+		return popcnt64((b[i/64] >> (i % 64)) & ((1 << n) - 1))
+	}
+	_ = b[j/64]
+	s += popcnt64(b[i/64] >> (i % 64))
+	for k := i/64 + 1; k < j/64; k++ {
+		s += popcnt64(b[k])
+	}
+	s += popcnt64(b[j/64] & ((1 << (j%64 + 1)) - 1))
+	return
+}
+
+// pallocBits 为页分配器中一个 chunk 的 bitmap，1 表示页已被分配，0 表示页空闲
+type pallocBits pageBits
+
+// summarize 返回 b 的打包后的摘要：开头连续空闲页的数量、最长的连续空闲页的数量
+// 以及末尾连续空闲页的数量
+func (b *pallocBits) summarize() pallocSum {
+	var start, max, cur uint
+	const notSetYet = ^uint(0) // sentinel for start value
+	start = notSetYet
+	for i := 0; i < len(b); i++ {
+		x := b[i]
+		for bit := uint(0); bit < 64; {
+			// 跳过一段连续的空闲页。x>>bit 为 0 时 Ctz64 返回 64，需要截断。
+			z := uint(sys.Ctz64(x >> bit))
+			if z > 64-bit {
+				z = 64 - bit
+			}
+			cur += z
+			bit += z
+			if bit == 64 {
+				// 空闲页可能延续到下一个 uint64
+				break
+			}
+			// 遇到已分配的页，结束当前的空闲区间
+			if start == notSetYet {
+				start = cur
+			}
+			if cur > max {
+				max = cur
+			}
+			cur = 0
+			// 跳过一段连续的已分配页
+			bit += uint(sys.Ctz64(^(x >> bit)))
+		}
+	}
+	if start == notSetYet {
+		// Made it all the way through without finding a single 1 bit.
+		const n = uint(64 * len(b))
+		return packPallocSum(n, n, n)
+	}
+	if cur > max {
+		max = cur
+	}
+	return packPallocSum(start, max, cur)
+}
+
+// find 从 searchIdx 开始查找 npages 个连续的空闲页，返回第一页的下标，
+// 找不到时返回 ^uint(0)。
+//
+// 第二个返回值为下一次查找可以使用的 searchIdx，即 b 中第一个空闲页的下标（若已知）。
+// searchIdx 之前的页必须都已被分配。
+func (b *pallocBits) find(npages uintptr, searchIdx uint) (uint, uint) {
+	if npages == 1 {
+		addr := b.find1(searchIdx)
+		return addr, addr
+	} else if npages <= 64 {
+		return b.findSmallN(npages, searchIdx)
+	}
+	return b.findLargeN(npages, searchIdx)
+}
+
+// find1 是 find 在 npages == 1 时的实现
+func (b *pallocBits) find1(searchIdx uint) uint {
+	for i := searchIdx / 64; i < uint(len(b)); i++ {
+		x := b[i]
+		if x == ^uint64(0) {
+			continue
+		}
+		return i*64 + uint(sys.Ctz64(^x))
+	}
+	return ^uint(0)
+}
+
+// findSmallN 是 find 在 2 <= npages <= 64 时的实现
+func (b *pallocBits) findSmallN(npages uintptr, searchIdx uint) (uint, uint) {
+	end, newSearchIdx := uint(0), ^uint(0)
+	for i := searchIdx / 64; i < uint(len(b)); i++ {
+		bi := b[i]
+		if bi == ^uint64(0) {
+			end = 0
+			continue
+		}
+		// First see if we can pack our allocation in the trailing
+		// zeros plus the end of the last 64 bits.
+		start := uint(sys.Ctz64(bi))
+		if newSearchIdx == ^uint(0) {
+			// The new searchIdx is going to be at these 64 bits after any
+			// 1s we file, so count trailing 1s.
+			newSearchIdx = i*64 + uint(sys.Ctz64(^bi))
+		}
+		if end+start >= uint(npages) {
+			return i*64 - end, newSearchIdx
+		}
+		// Next, check the interior of the 64-bit chunk.
+		j := findBitRange64(^bi, uint(npages))
+		if j < 64 {
+			return i*64 + j, newSearchIdx
+		}
+		end = leadingZeros64(bi)
+	}
+	return ^uint(0), newSearchIdx
+}
+
+// findLargeN 是 find 在 npages > 64 时的实现
+func (b *pallocBits) findLargeN(npages uintptr, searchIdx uint) (uint, uint) {
+	start, size, newSearchIdx := ^uint(0), uint(0), ^uint(0)
+	for i := searchIdx / 64; i < uint(len(b)); i++ {
+		x := b[i]
+		if x == ^uint64(0) {
+			size = 0
+			continue
+		}
+		if newSearchIdx == ^uint(0) {
+			// The new searchIdx is going to be at these 64 bits after any
+			// 1s we file, so count trailing 1s.
+			newSearchIdx = i*64 + uint(sys.Ctz64(^x))
+		}
+		if size == 0 {
+			size = leadingZeros64(x)
+			start = i*64 + 64 - size
+			continue
+		}
+		s := uint(sys.Ctz64(x))
+		if s+size >= uint(npages) {
+			size += s
+			return start, newSearchIdx
+		}
+		if s < 64 {
+			size = leadingZeros64(x)
+			start = i*64 + 64 - size
+			continue
+		}
+		size += 64
+	}
+	if size < uint(npages) {
+		return ^uint(0), newSearchIdx
+	}
+	return start, newSearchIdx
+}
+
+// allocRange 将 [i, i+n) 页标记为已分配
+func (b *pallocBits) allocRange(i, n uint) {
+	(*pageBits)(b).setRange(i, n)
+}
+
+// allocAll 将所有页标记为已分配
+func (b *pallocBits) allocAll() {
+	(*pageBits)(b).setAll()
+}
+
+// free1 将第 i 页标记为空闲
+func (b *pallocBits) free1(i uint) {
+	(*pageBits)(b).clear(i)
+}
+
+// free 将 [i, i+n) 页标记为空闲
+func (b *pallocBits) free(i, n uint) {
+	(*pageBits)(b).clearRange(i, n)
+}
+
+// freeAll 将所有页标记为空闲
+func (b *pallocBits) freeAll() {
+	(*pageBits)(b).clearAll()
+}
+
+// pages64 返回包含第 i 页的 64 页的 bitmap
+func (b *pallocBits) pages64(i uint) uint64 {
+	return (*pageBits)(b).block64(i)
+}
+
+// allocPages64 将包含第 i 页的 64 页中 alloc 为 1 的页标记为已分配
+func (b *pallocBits) allocPages64(i uint, alloc uint64) {
+	b[i/64] |= alloc
+}
+
+// findBitRange64 返回 c 中第一个由至少 n 个连续的 1 组成的区间的起始位置，
+// 不存在时返回值 >= 64。n 必须大于 0。
+func findBitRange64(c uint64, n uint) uint {
+	// This implementation is based on shrinking the length of
+	// runs of contiguous 1 bits. We remove the top n-1 1 bits
+	// from each run of 1s, then look for the first remaining 1 bit.
+	p := n - 1   // number of 1s we want to remove.
+	k := uint(1) // current minimum width of runs of 0 in c.
+	for p > 0 {
+		if p <= k {
+			// Shift p 0s down into the top of each run of 1s.
+			c &= c >> (p & 63)
+			break
+		}
+		// Shift k 0s down into the top of each run of 1s.
+		c &= c >> (k & 63)
+		if c == 0 {
+			return 64
+		}
+		p -= k
+		// We've just doubled the minimum length of 0-runs.
+		// This allows us to shift farther in the next iteration.
+		k *= 2
+	}
+	// Find first remaining 1.
+	// Since we shrunk from the top down, the first 1 is in
+	// its correct original position.
+	return uint(sys.Ctz64(c))
+}
+
+// leadingZeros64 返回 x 中前导 0 的个数，x 为 0 时返回 64
+func leadingZeros64(x uint64) uint {
+	if x == 0 {
+		return 64
+	}
+	n := uint(0)
+	if x>>32 == 0 {
+		n += 32
+		x <<= 32
+	}
+	if x>>48 == 0 {
+		n += 16
+		x <<= 16
+	}
+	if x>>56 == 0 {
+		n += 8
+		x <<= 8
+	}
+	if x>>60 == 0 {
+		n += 4
+		x <<= 4
+	}
+	if x>>62 == 0 {
+		n += 2
+		x <<= 2
+	}
+	if x>>63 == 0 {
+		n++
+	}
+	return n
+}
+
+// popcnt64 返回 x 中为 1 的位的个数
+func popcnt64(x uint64) uint {
+	n := uint(0)
+	for x != 0 {
+		x &= x - 1
+		n++
+	}
+	return n
+}
+
+// pallocData 为一个 chunk 的页分配器元数据
+type pallocData struct {
+	pallocBits
+	scavenged pageBits // 1 表示空闲页的物理内存已经归还给操作系统
+
+	// lastFree 为该 chunk 最后一次有页被释放的 nanotime，
+	// 周期性的 scavenge 只归还空闲了足够长时间的 chunk 中的页
+	lastFree int64
+}
+
+// allocRange 将 [i, i+n) 页标记为已分配，并清除其 scavenged 位
+func (m *pallocData) allocRange(i, n uint) {
+	// Clear the scavenged bits when we alloc the range.
+	m.pallocBits.allocRange(i, n)
+	m.scavenged.clearRange(i, n)
+}
+
+// allocAll 将所有页标记为已分配，并清除所有 scavenged 位
+func (m *pallocData) allocAll() {
+	// Clear the scavenged bits when we alloc the range.
+	m.pallocBits.allocAll()
+	m.scavenged.clearAll()
+}
@@ -9,6 +9,7 @@ package runtime
 
 import (
 	"runtime/internal/atomic"
+	"runtime/internal/sys"
 	"unsafe"
 )
 
@@ -27,6 +28,9 @@ const (
 	// size of bucket hash table
 	buckHashSize = 179999
 
+	// typeBucket 哈希表的大小
+	typeHashSize = 4093
+
 	// max depth of stack to record in bucket
 	maxStack = 32
 )
@@ -52,6 +56,34 @@ type bucket struct {
 	hash    uintptr
 	size    uintptr
 	nstk    uintptr
+	tb      *typeBucket // memProfile 桶中对象的类型，其他桶为 nil
+}
+
+// A typeBucket 记录内存 profile 中出现过的一个类型。
+// 同一类型的所有 memProfile 桶共享一个 typeBucket，TypeProfile 通过它按类型汇总。
+//
+// 类型来自 mallocgc 的 typ 参数，对于切片与数组为元素的类型。
+// 不带类型的分配（例如字符串与 []byte 的底层数组）都记录在 typ 为 nil 的 typeBucket 中。
+//
+// typ 可能指向 reflect 在堆上构造的类型。这些类型被 reflect 的缓存一直引用，
+// 因此不会被回收。
+//
+//go:notinheap
+type typeBucket struct {
+	next    *typeBucket // typehash 中的链表
+	allnext *typeBucket
+	typ     *_type
+
+	// sum 为 TypeProfile 汇总时的临时结果，由 proflock 保护
+	sum memRecordCycle
+}
+
+// name 返回 tb 的类型名。
+func (tb *typeBucket) name() string {
+	if tb.typ == nil {
+		return "<untyped>"
+	}
+	return tb.typ.string()
 }
 
 // A memRecord is the bucket data for a bucket of type memProfile,
@@ -144,6 +176,9 @@ var (
 	buckhash  *[179999]*bucket
 	bucketmem uintptr
 
+	tbuckets *typeBucket // 所有 typeBucket
+	typehash *[typeHashSize]*typeBucket
+
 	mProf struct {
 		// All fields in mProf are protected by proflock.
 
@@ -202,7 +237,8 @@ func (b *bucket) bp() *blockRecord {
 }
 
 // Return the bucket for stk[0:nstk], allocating new bucket if needed.
-func stkbucket(typ bucketType, size uintptr, stk []uintptr, alloc bool) *bucket {
+// tb 为 memProfile 桶中对象的类型，其他种类的桶为 nil。
+func stkbucket(typ bucketType, size uintptr, stk []uintptr, tb *typeBucket, alloc bool) *bucket {
 	if buckhash == nil {
 		buckhash = (*[buckHashSize]*bucket)(sysAlloc(unsafe.Sizeof(*buckhash), &memstats.buckhash_sys))
 		if buckhash == nil {
@@ -221,13 +257,17 @@ func stkbucket(typ bucketType, size uintptr, stk []uintptr, alloc bool) *bucket
 	h += size
 	h += h << 10
 	h ^= h >> 6
+	// hash in type
+	h += uintptr(unsafe.Pointer(tb))
+	h += h << 10
+	h ^= h >> 6
 	// finalize
 	h += h << 3
 	h ^= h >> 11
 
 	i := int(h % buckHashSize)
 	for b := buckhash[i]; b != nil; b = b.next {
-		if b.typ == typ && b.hash == h && b.size == size && eqslice(b.stk(), stk) {
+		if b.typ == typ && b.hash == h && b.size == size && b.tb == tb && eqslice(b.stk(), stk) {
 			return b
 		}
 	}
@@ -241,6 +281,7 @@ func stkbucket(typ bucketType, size uintptr, stk []uintptr, alloc bool) *bucket
 	copy(b.stk(), stk)
 	b.hash = h
 	b.size = size
+	b.tb = tb
 	b.next = buckhash[i]
 	buckhash[i] = b
 	if typ == memProfile {
@@ -256,6 +297,30 @@ func stkbucket(typ bucketType, size uintptr, stk []uintptr, alloc bool) *bucket
 	return b
 }
 
+// typebucket 返回类型 t 的 typeBucket，不存在时创建。调用者必须持有 proflock。
+func typebucket(t *_type) *typeBucket {
+	if typehash == nil {
+		typehash = (*[typeHashSize]*typeBucket)(sysAlloc(unsafe.Sizeof(*typehash), &memstats.buckhash_sys))
+		if typehash == nil {
+			throw("runtime: cannot allocate memory")
+		}
+	}
+	i := uintptr(unsafe.Pointer(t)) / sys.PtrSize % typeHashSize
+	for tb := typehash[i]; tb != nil; tb = tb.next {
+		if tb.typ == t {
+			return tb
+		}
+	}
+	tb := (*typeBucket)(persistentalloc(unsafe.Sizeof(typeBucket{}), 0, &memstats.buckhash_sys))
+	bucketmem += unsafe.Sizeof(typeBucket{})
+	tb.typ = t
+	tb.next = typehash[i]
+	typehash[i] = tb
+	tb.allnext = tbuckets
+	tbuckets = tb
+	return tb
+}
+
 func eqslice(x, y []uintptr) bool {
 	if len(x) != len(y) {
 		return false
@@ -337,11 +402,12 @@ func mProf_PostSweep() {
 }
 
 // Called by malloc to record a profiled block.
-func mProf_Malloc(p unsafe.Pointer, size uintptr) {
+// typ 为对象的类型，可能为 nil。
+func mProf_Malloc(p unsafe.Pointer, size uintptr, typ *_type) {
 	var stk [maxStack]uintptr
 	nstk := callers(4, stk[:])
 	lock(&proflock)
-	b := stkbucket(memProfile, size, stk[:nstk], true)
+	b := stkbucket(memProfile, size, stk[:nstk], typebucket(typ), true)
 	c := mProf.cycle
 	mp := b.mp()
 	mpc := &mp.future[(c+2)%uint32(len(mp.future))]
@@ -421,7 +487,7 @@ func saveblockevent(cycles int64, skip int, which bucketType) {
 		nstk = gcallers(gp.m.curg, skip, stk[:])
 	}
 	lock(&proflock)
-	b := stkbucket(which, 0, stk[:nstk], true)
+	b := stkbucket(which, 0, stk[:nstk], nil, true)
 	b.bp().count++
 	b.bp().cycles += cycles
 	unlock(&proflock)
@@ -490,6 +556,10 @@ type MemProfileRecord struct {
 	AllocBytes, FreeBytes     int64       // number of bytes allocated, freed
 	AllocObjects, FreeObjects int64       // number of objects allocated, freed
 	Stack0                    [32]uintptr // stack trace for this record; ends at first 0 entry
+
+	// Type 为这些对象的类型名，对于切片与数组为元素的类型名。
+	// 不带类型信息的分配（例如字符串与 []byte 的底层数组）为 "<untyped>"。
+	Type string
 }
 
 // InUseBytes returns the number of bytes in use (AllocBytes - FreeBytes).
@@ -553,13 +623,10 @@ func MemProfile(p []MemProfileRecord, inuseZero bool) (n int, ok bool) {
 		// has not yet happened. In order to allow profiling when
 		// garbage collection is disabled from the beginning of execution,
 		// accumulate all of the cycles, and recount buckets.
+		mProf_FlushAllLocked()
 		n = 0
 		for b := mbuckets; b != nil; b = b.allnext {
 			mp := b.mp()
-			for c := range mp.future {
-				mp.active.add(&mp.future[c])
-				mp.future[c] = memRecordCycle{}
-			}
 			if inuseZero || mp.active.alloc_bytes != mp.active.free_bytes {
 				n++
 			}
@@ -580,6 +647,92 @@ func MemProfile(p []MemProfileRecord, inuseZero bool) (n int, ok bool) {
 	return
 }
 
+// mProf_FlushAllLocked 将所有周期的事件累加到已发布的 profile 中。
+// 只在还没有任何已发布的数据时使用，见 MemProfile。
+func mProf_FlushAllLocked() {
+	for b := mbuckets; b != nil; b = b.allnext {
+		mp := b.mp()
+		for c := range mp.future {
+			mp.active.add(&mp.future[c])
+			mp.future[c] = memRecordCycle{}
+		}
+	}
+}
+
+// A TypeProfileRecord describes the live objects of a particular type
+// in the memory profile.
+type TypeProfileRecord struct {
+	Type                      string // 类型名，含义与 MemProfileRecord.Type 相同
+	AllocBytes, FreeBytes     int64  // number of bytes allocated, freed
+	AllocObjects, FreeObjects int64  // number of objects allocated, freed
+}
+
+// InUseBytes returns the number of bytes in use (AllocBytes - FreeBytes).
+func (r *TypeProfileRecord) InUseBytes() int64 { return r.AllocBytes - r.FreeBytes }
+
+// InUseObjects returns the number of objects in use (AllocObjects - FreeObjects).
+func (r *TypeProfileRecord) InUseObjects() int64 {
+	return r.AllocObjects - r.FreeObjects
+}
+
+// TypeProfile 返回按类型汇总的内存 profile，即 MemProfile 中所有 Type 相同的记录之和。
+// 只有仍有存活对象的类型会被返回，顺序不确定。
+//
+// TypeProfile returns n, the number of records in the current type profile.
+// If len(p) >= n, TypeProfile copies the profile into p and returns n, true.
+// If len(p) < n, TypeProfile does not change p and returns n, false.
+//
+// 与 MemProfile 一样，记录只包含被采样的分配（见 MemProfileRate），
+// 并且可能落后两个垃圾回收周期。要估计每个类型的存活字节数，
+// 需要像 runtime/pprof 那样按照采样率放大；MemProfileRate 为 1 时记录是精确的。
+// 与 debug.WriteHeapDump 不同，TypeProfile 不需要停止程序。
+func TypeProfile(p []TypeProfileRecord) (n int, ok bool) {
+	lock(&proflock)
+	mProf_FlushLocked()
+	clear := true
+	for b := mbuckets; b != nil; b = b.allnext {
+		mp := b.mp()
+		if mp.active.allocs != 0 || mp.active.frees != 0 {
+			clear = false
+			break
+		}
+	}
+	if clear {
+		// 与 MemProfile 相同，还没有发生过垃圾回收
+		mProf_FlushAllLocked()
+	}
+
+	for tb := tbuckets; tb != nil; tb = tb.allnext {
+		tb.sum = memRecordCycle{}
+	}
+	for b := mbuckets; b != nil; b = b.allnext {
+		b.tb.sum.add(&b.mp().active)
+	}
+	for tb := tbuckets; tb != nil; tb = tb.allnext {
+		if tb.sum.alloc_bytes != tb.sum.free_bytes {
+			n++
+		}
+	}
+	if n <= len(p) {
+		ok = true
+		idx := 0
+		for tb := tbuckets; tb != nil; tb = tb.allnext {
+			if tb.sum.alloc_bytes == tb.sum.free_bytes {
+				continue
+			}
+			r := &p[idx]
+			r.Type = tb.name()
+			r.AllocBytes = int64(tb.sum.alloc_bytes)
+			r.FreeBytes = int64(tb.sum.free_bytes)
+			r.AllocObjects = int64(tb.sum.allocs)
+			r.FreeObjects = int64(tb.sum.frees)
+			idx++
+		}
+	}
+	unlock(&proflock)
+	return
+}
+
 // Write b's data to r.
 func record(r *MemProfileRecord, b *bucket) {
 	mp := b.mp()
@@ -597,6 +750,7 @@ func record(r *MemProfileRecord, b *bucket) {
 	for i := int(b.nstk); i < len(r.Stack0); i++ {
 		r.Stack0[i] = 0
 	}
+	r.Type = b.tb.name()
 }
 
 func iterate_memprof(fn func(*bucket, uintptr, *uintptr, uintptr, uintptr, uintptr)) {
@@ -710,6 +864,50 @@ func ThreadCreateProfile(p []StackRecord) (n int, ok bool) {
 // Most clients should use the runtime/pprof package instead
 // of calling GoroutineProfile directly.
 func GoroutineProfile(p []StackRecord) (n int, ok bool) {
+	return goroutineProfile(len(p), func(i int, gp *g) *StackRecord {
+		return &p[i]
+	})
+}
+
+// GoroutineAllocRecord 描述一个 goroutine 的执行栈，以及它自创建以来在堆上分配的内存。
+type GoroutineAllocRecord struct {
+	AllocBytes   uint64 // 分配的字节数
+	AllocObjects uint64 // 分配的对象个数
+	StackRecord
+}
+
+// GoroutineAllocProfile 与 GoroutineProfile 相同，但每条记录还包含对应 goroutine
+// 分配的内存，统计方式见 GoroutineAllocs。
+// 第一条记录总是调用者所在的 goroutine。
+func GoroutineAllocProfile(p []GoroutineAllocRecord) (n int, ok bool) {
+	return goroutineProfile(len(p), func(i int, gp *g) *StackRecord {
+		r := &p[i]
+		r.AllocBytes = gp.allocBytes
+		r.AllocObjects = gp.allocObjects
+		return &r.StackRecord
+	})
+}
+
+// GoroutineAllocs 返回当前 goroutine 自创建以来在堆上分配的字节数与对象个数。
+//
+// 小对象按其大小等级计算，大对象按其占用的页计算，合并到 tiny 块中的小对象按请求的大小计算。
+// 栈上的分配以及其他 goroutine（包括当前 goroutine 创建的 goroutine）的分配都不计入。
+// 两次调用的差值即为其间的分配，例如可以记录一次请求的分配量，或者为请求设置分配预算：
+//
+//	b0, _ := runtime.GoroutineAllocs()
+//	handle(req)
+//	b1, _ := runtime.GoroutineAllocs()
+//	log.Printf("%s allocated %d bytes", req.URL, b1-b0)
+func GoroutineAllocs() (bytes, objects uint64) {
+	gp := getg()
+	return gp.allocBytes, gp.allocObjects
+}
+
+// goroutineProfile 停止世界，依次对调用者所在的 goroutine 以及其他所有用户 goroutine
+// 调用 record 取得第 i 条记录，并将 goroutine 的栈存入其中。调用者所在的 goroutine
+// 总是第一个，其栈从 goroutineProfile 的调用者的调用者开始，即公开的 API 的调用者。
+// 只有当 goroutine 的个数 n 不超过 max 时才会调用 record。
+func goroutineProfile(max int, record func(i int, gp *g) *StackRecord) (n int, ok bool) {
 	gp := getg()
 
 	isOK := func(gp1 *g) bool {
@@ -727,28 +925,27 @@ func GoroutineProfile(p []StackRecord) (n int, ok bool) {
 		}
 	}
 
-	if n <= len(p) {
+	if n <= max {
 		ok = true
-		r := p
 
-		// Save current goroutine.
+		// Save current goroutine, skipping the exported caller.
 		sp := getcallersp()
 		pc := getcallerpc()
 		systemstack(func() {
-			saveg(pc, sp, gp, &r[0])
+			saveg(pc, sp, 1, gp, record(0, gp))
 		})
-		r = r[1:]
 
 		// Save other goroutines.
+		i := 1
 		for _, gp1 := range allgs {
 			if isOK(gp1) {
-				if len(r) == 0 {
+				if i == max {
 					// Should be impossible, but better to return a
 					// truncated profile than to crash the entire process.
 					break
 				}
-				saveg(^uintptr(0), ^uintptr(0), gp1, &r[0])
-				r = r[1:]
+				saveg(^uintptr(0), ^uintptr(0), 0, gp1, record(i, gp1))
+				i++
 			}
 		}
 	}
@@ -758,8 +955,8 @@ func GoroutineProfile(p []StackRecord) (n int, ok bool) {
 	return n, ok
 }
 
-func saveg(pc, sp uintptr, gp *g, r *StackRecord) {
-	n := gentraceback(pc, sp, 0, gp, 0, &r.Stack0[0], len(r.Stack0), nil, nil, 0)
+func saveg(pc, sp uintptr, skip int, gp *g, r *StackRecord) {
+	n := gentraceback(pc, sp, 0, gp, skip, &r.Stack0[0], len(r.Stack0), nil, nil, 0)
 	if n < len(r.Stack0) {
 		r.Stack0[n] = 0
 	}
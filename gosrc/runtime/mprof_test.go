@@ -0,0 +1,78 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"runtime"
+	"testing"
+)
+
+var (
+	allocSink100 *[100]byte
+	allocSink    []byte
+)
+
+func TestGoroutineAllocs(t *testing.T) {
+	// 内存 profile 的采样不应影响统计，关闭它使测试更确定
+	defer func(rate int) { runtime.MemProfileRate = rate }(runtime.MemProfileRate)
+	runtime.MemProfileRate = 0
+	// 第一次 GC 会在当前 goroutine 上创建后台标记 worker，提前完成它
+	runtime.GC()
+
+	const n = 100
+	b0, o0 := runtime.GoroutineAllocs()
+	for i := 0; i < n; i++ {
+		allocSink100 = new([100]byte) // 大小等级为 112 字节
+	}
+	allocSink = make([]byte, 40000) // 大对象，按页计算
+	b1, o1 := runtime.GoroutineAllocs()
+
+	if got, want := o1-o0, uint64(n+1); got != want {
+		t.Errorf("allocated %d objects, want %d", got, want)
+	}
+	if got, want := b1-b0, uint64(n*112+40960); got != want {
+		t.Errorf("allocated %d bytes, want %d", got, want)
+	}
+
+	// 其他 goroutine 的分配不计入
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < n; i++ {
+			allocSink100 = new([100]byte)
+		}
+		close(done)
+	}()
+	<-done
+	b2, o2 := runtime.GoroutineAllocs()
+	// 创建 goroutine 与 channel 本身的分配计入当前 goroutine，但远小于 n 个对象
+	if o2-o1 >= n || b2-b1 >= n*112 {
+		t.Errorf("allocations of another goroutine were charged: %d bytes, %d objects", b2-b1, o2-o1)
+	}
+}
+
+func TestGoroutineAllocProfile(t *testing.T) {
+	p := make([]runtime.GoroutineAllocRecord, runtime.NumGoroutine()+10)
+	b0, _ := runtime.GoroutineAllocs()
+	n, ok := runtime.GoroutineAllocProfile(p)
+	if !ok || n == 0 {
+		t.Fatalf("GoroutineAllocProfile returned %d, %v", n, ok)
+	}
+	r := p[0]
+	if r.AllocBytes < b0 || r.AllocObjects == 0 {
+		t.Errorf("first record: %d bytes, %d objects; want at least %d bytes", r.AllocBytes, r.AllocObjects, b0)
+	}
+	stk := r.Stack()
+	if len(stk) == 0 {
+		t.Fatalf("first record has an empty stack")
+	}
+	// 第一条记录为调用者所在的 goroutine，其栈从调用者开始
+	if f := runtime.FuncForPC(stk[0] - 1); f == nil || f.Name() != "runtime_test.TestGoroutineAllocProfile" {
+		name := "<nil>"
+		if f != nil {
+			name = f.Name()
+		}
+		t.Errorf("first record starts at %s, want runtime_test.TestGoroutineAllocProfile", name)
+	}
+}
@@ -40,6 +40,10 @@ type mstats struct {
 	heap_released uint64 // bytes released to the os
 	heap_objects  uint64 // total number of allocated objects
 
+	// 后台 scavenger 统计，见 mgcscavenge.go
+	scav_released uint64 // bytes released to the os by the background scavenger; updated atomically
+	scav_goal     uint64 // retained heap memory goal of the background scavenger; ^0 if disabled
+
 	// 低级固定大小的分配统计
 	// 受 fixalloc 锁保护
 	stacks_inuse uint64 // bytes in manually-managed stack spans
@@ -78,23 +82,14 @@ type mstats struct {
 	last_gc_nanotime uint64 // last gc (monotonic time)
 	tinyallocs       uint64 // number of tiny allocations that didn't cause actual allocation; not exported to go directly
 
-	// triggerRatio is the heap growth ratio that triggers marking.
-	//
-	// E.g., if this is 0.6, then GC should start when the live
-	// heap has reached 1.6 times the heap size marked by the
-	// previous cycle. This should be ≤ GOGC/100 so the trigger
-	// heap size is less than the goal heap size. This is set
-	// during mark termination for the next cycle's trigger.
-	triggerRatio float64
-
 	// gc_trigger is the heap size that triggers marking.
 	//
 	// When heap_live ≥ gc_trigger, the mark phase will start.
 	// This is also the heap size by which proportional sweeping
 	// must be complete.
 	//
-	// This is computed from triggerRatio during mark termination
-	// for the next cycle's trigger.
+	// This is computed by gcControllerCommit from the heap goal
+	// and the estimated runway.
 	gc_trigger uint64
 
 	// heap_live is the number of bytes considered live by the GC.
@@ -135,6 +130,11 @@ type mstats struct {
 	// unlike heap_live, heap_marked does not change until the
 	// next mark termination.
 	heap_marked uint64
+
+	// last_next_gc is the next_gc of the previous GC cycle, from
+	// which the background scavenger derives its retained memory
+	// goal. 0 before the first GC cycle completes.
+	last_next_gc uint64
 }
 
 var memstats mstats
@@ -266,6 +266,29 @@ type MemStats struct {
 	// freed.
 	HeapObjects uint64
 
+	// Background scavenger statistics.
+	//
+	// The background scavenger returns free heap memory to the OS
+	// at a paced rate, so that the retained heap memory
+	// (HeapSys - HeapReleased) approaches a goal derived from the
+	// heap goal of the last GC cycle.
+
+	// ScavengeReleased is cumulative bytes of physical memory
+	// returned to the OS by the background scavenger.
+	//
+	// Unlike HeapReleased, this never decreases, and it does not
+	// include memory returned by debug.FreeOSMemory or due to
+	// the memory limit.
+	ScavengeReleased uint64
+
+	// ScavengeGoal is the retained heap memory, in bytes, that the
+	// background scavenger is working toward.
+	//
+	// It is ^uint64(0) if the background scavenger currently has
+	// nothing to do, for example because retained memory is already
+	// below the goal, or no GC cycle has completed yet.
+	ScavengeGoal uint64
+
 	// Stack memory statistics.
 	//
 	// Stacks are not considered part of the heap, but the runtime
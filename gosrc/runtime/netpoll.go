@@ -16,6 +16,10 @@ import (
 // 一个特定的实现（epoll/equeue）必须包含下面的函数：
 // func netpollinit()			// 来初始化轮训器
 // func netpollopen(fd uintptr, pd *pollDesc) int32	// 给 arm edge-triggered 通知以及关联 fd 和 pd
+// func netpoll(delay int64) gList	// 轮询网络。delay < 0 时无限阻塞，delay == 0 时不阻塞，
+//					// delay > 0 时最多阻塞 delay 纳秒
+// func netpollBreak()			// 唤醒阻塞在 netpoll 中的线程
+// func netpollIsPollDescriptor(fd uintptr) bool	// 报告 fd 是否为轮询器使用的描述符
 // 一个实现必须调用下面的函数来表明 pd 已经就绪
 // func netpollready(gpp **g, pd *pollDesc, mode int32)
 
@@ -79,15 +83,29 @@ type pollCache struct {
 }
 
 var (
-	netpollInited  uint32
+	netpollInitLock mutex
+	netpollInited   uint32
+
 	pollcache      pollCache
 	netpollWaiters uint32
 )
 
 //go:linkname poll_runtime_pollServerInit internal/poll.runtime_pollServerInit
 func poll_runtime_pollServerInit() {
-	netpollinit()
-	atomic.Store(&netpollInited, 1)
+	netpollGenericInit()
+}
+
+// netpollGenericInit 初始化轮询器。timer 依赖轮询器来休眠，
+// 因此除了 internal/poll 之外，添加 timer 时也会调用它。
+func netpollGenericInit() {
+	if atomic.Load(&netpollInited) == 0 {
+		lock(&netpollInitLock)
+		if netpollInited == 0 {
+			netpollinit()
+			atomic.Store(&netpollInited, 1)
+		}
+		unlock(&netpollInitLock)
+	}
 }
 
 func netpollinited() bool {
@@ -99,14 +117,7 @@ func netpollinited() bool {
 // poll_runtime_isPollServerDescriptor reports whether fd is a
 // descriptor being used by netpoll.
 func poll_runtime_isPollServerDescriptor(fd uintptr) bool {
-	fds := netpolldescriptor()
-	if GOOS != "aix" {
-		return fd == fds
-	} else {
-		// AIX have a pipe in its netpoll implementation.
-		// Therefore, two fd are returned by netpolldescriptor using a mask.
-		return fd == fds&0xFFFF || fd == (fds>>16)&0xFFFF
-	}
+	return netpollIsPollDescriptor(fd)
 }
 
 //go:linkname poll_runtime_pollOpen internal/poll.runtime_pollOpen
@@ -231,13 +242,13 @@ func poll_runtime_pollSetDeadline(pd *pollDesc, d int64, mode int) {
 	if pd.rt.f == nil {
 		if pd.rd > 0 {
 			pd.rt.f = rtf
-			pd.rt.when = pd.rd
 			// Copy current seq into the timer arg.
 			// Timer func will check the seq against current descriptor seq,
 			// if they differ the descriptor was reused or timers were reset.
 			pd.rt.arg = pd
 			pd.rt.seq = pd.rseq
-			addtimer(&pd.rt)
+			// pollDesc 会被复用，timer 可能已经使用过，因此使用 resettimer
+			resettimer(&pd.rt, pd.rd)
 		}
 	} else if pd.rd != rd0 || combo != combo0 {
 		pd.rseq++ // invalidate current timers
@@ -251,10 +262,9 @@ func poll_runtime_pollSetDeadline(pd *pollDesc, d int64, mode int) {
 	if pd.wt.f == nil {
 		if pd.wd > 0 && !combo {
 			pd.wt.f = netpollWriteDeadline
-			pd.wt.when = pd.wd
 			pd.wt.arg = pd
 			pd.wt.seq = pd.wseq
-			addtimer(&pd.wt)
+			resettimer(&pd.wt, pd.wd)
 		}
 	} else if pd.wd != wd0 || combo != combo0 {
 		pd.wseq++ // invalidate current timers
@@ -245,6 +245,7 @@ func minit() {
 		minitSignalStack()
 	}
 	minitSignalMask()
+	getg().m.procid = uint64(pthread_self())
 }
 
 // Called from dropm to undo the effect of an minit.
@@ -257,6 +258,11 @@ func unminit() {
 	}
 }
 
+// signalM sends a signal to mp.
+func signalM(mp *m, sig int) {
+	pthread_kill(pthread(mp.procid), uint32(sig))
+}
+
 //go:nosplit
 func osyield() {
 	usleep(1)
@@ -368,6 +368,13 @@ func sigprocmask(how int32, new, old *sigset) {
 
 func raise(sig uint32)
 func raiseproc(sig uint32)
+func getpid() int
+func tgkill(tgid, tid, sig int)
+
+// signalM sends a signal to mp.
+func signalM(mp *m, sig int) {
+	tgkill(getpid(), int(mp.procid), sig)
+}
 
 //go:noescape
 func sched_getaffinity(pid, len uintptr, buf *byte) int32
@@ -52,11 +52,126 @@ func panicslice() {
 	panic(sliceError)
 }
 
+// panicBounds 检查是否可以从编译器生成的越界检查 pc 处引发越界 panic，
+// 与 panicindex 一样，运行时自身的越界以及在 malloc 中的越界转为 throw(msg)。
+func panicBounds(pc uintptr, msg string) {
+	// wasm 不能尾调用，入口直接是 goPanic* 函数，getcallerpc 不是越界检查的 pc
+	if sys.GoarchWasm == 0 && hasPrefix(funcname(findfunc(pc)), "runtime.") {
+		throw(msg)
+	}
+	gp := getg()
+	if gp != nil && gp.m != nil && gp.m.mallocing != 0 {
+		throw(msg)
+	}
+}
+
+// 下面的 goPanic* 函数由编译器生成的越界检查调用，引发带有越界的值与上限的 boundsError。
+// 在 amd64 上，越界检查将 x 与 y 放在寄存器中调用汇编实现的 panicIndex 等入口（见 asm_amd64.s），
+// 由它们将参数存入栈中并跳转到对应的 goPanic* 函数，从而使越界检查的调用代码尽可能短。
+// 以 U 结尾的版本用于 x 为无符号整数的情况。
+//
+// 未携带越界值的 panicindex 与 panicslice 仍然保留，供没有使用这些入口的代码调用。
+
+// failures in the comparisons for s[x], 0 <= x < y (y == len(s))
+func goPanicIndex(x int, y int) {
+	panicBounds(getcallerpc(), "index out of range")
+	panic(boundsError{x: int64(x), signed: true, y: y, code: boundsIndex})
+}
+func goPanicIndexU(x uint, y int) {
+	panicBounds(getcallerpc(), "index out of range")
+	panic(boundsError{x: int64(x), signed: false, y: y, code: boundsIndex})
+}
+
+// failures in the comparisons for s[:x], 0 <= x <= y (y == len(s) or cap(s))
+func goPanicSliceAlen(x int, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: true, y: y, code: boundsSliceAlen})
+}
+func goPanicSliceAlenU(x uint, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: false, y: y, code: boundsSliceAlen})
+}
+func goPanicSliceAcap(x int, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: true, y: y, code: boundsSliceAcap})
+}
+func goPanicSliceAcapU(x uint, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: false, y: y, code: boundsSliceAcap})
+}
+
+// failures in the comparisons for s[x:y], 0 <= x <= y
+func goPanicSliceB(x int, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: true, y: y, code: boundsSliceB})
+}
+func goPanicSliceBU(x uint, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: false, y: y, code: boundsSliceB})
+}
+
+// failures in the comparisons for s[::x], 0 <= x <= y (y == len(s) or cap(s))
+func goPanicSlice3Alen(x int, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: true, y: y, code: boundsSlice3Alen})
+}
+func goPanicSlice3AlenU(x uint, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: false, y: y, code: boundsSlice3Alen})
+}
+func goPanicSlice3Acap(x int, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: true, y: y, code: boundsSlice3Acap})
+}
+func goPanicSlice3AcapU(x uint, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: false, y: y, code: boundsSlice3Acap})
+}
+
+// failures in the comparisons for s[:x:y], 0 <= x <= y
+func goPanicSlice3B(x int, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: true, y: y, code: boundsSlice3B})
+}
+func goPanicSlice3BU(x uint, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: false, y: y, code: boundsSlice3B})
+}
+
+// failures in the comparisons for s[x:y:], 0 <= x <= y
+func goPanicSlice3C(x int, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: true, y: y, code: boundsSlice3C})
+}
+func goPanicSlice3CU(x uint, y int) {
+	panicBounds(getcallerpc(), "slice bounds out of range")
+	panic(boundsError{x: int64(x), signed: false, y: y, code: boundsSlice3C})
+}
+
+// Implemented in assembly, as they take arguments in registers.
+// Declared here to mark them as ABIInternal.
+func panicIndex(x int, y int)
+func panicIndexU(x uint, y int)
+func panicSliceAlen(x int, y int)
+func panicSliceAlenU(x uint, y int)
+func panicSliceAcap(x int, y int)
+func panicSliceAcapU(x uint, y int)
+func panicSliceB(x int, y int)
+func panicSliceBU(x uint, y int)
+func panicSlice3Alen(x int, y int)
+func panicSlice3AlenU(x uint, y int)
+func panicSlice3Acap(x int, y int)
+func panicSlice3AcapU(x uint, y int)
+func panicSlice3B(x int, y int)
+func panicSlice3BU(x uint, y int)
+func panicSlice3C(x int, y int)
+func panicSlice3CU(x uint, y int)
+
 var divideError = error(errorString("integer divide by zero"))
 
 func panicdivide() {
 	panicCheckMalloc(divideError)
-	panic(divideError)
+	panic(&DivideError{})
 }
 
 var overflowError = error(errorString("integer overflow"))
@@ -76,8 +191,13 @@ func panicfloat() {
 var memoryError = error(errorString("invalid memory address or nil pointer dereference"))
 
 func panicmem() {
+	panicmemAddr(0)
+}
+
+// panicmemAddr 与 panicmem 相同，但给出了出错的地址，由 sigpanic 调用
+func panicmemAddr(addr uintptr) {
 	panicCheckMalloc(memoryError)
-	panic(memoryError)
+	panic(&MemoryError{Addr: addr})
 }
 
 func throwinit() {
@@ -605,16 +725,22 @@ func sync_throw(s string) {
 func throw(s string) {
 	// throw 的所有东西都应该 recursively nosplit，
 	// 这样即使在不安全的情况下增长栈时，也可以调用它。
+	// 为当前 m 标记正在 throw panic，
+	// 在此之后打印的错误信息也会写入 debug.SetCrashOutput 设置的文件
+	gp := getg()
+	if gp.m.throwing == 0 {
+		gp.m.throwing = 1
+	}
 	systemstack(func() {
+		if crashJSON() {
+			// 错误信息包含在崩溃报告中
+			setCrashThrow(s)
+			return
+		}
 		// 只是在系统栈上打印错误信息，运行时并没有让程序结束运行
 		print("fatal error: ", s, "\n")
 	})
 
-	// 为当前 m 标记正在 throw panic
-	gp := getg()
-	if gp.m.throwing == 0 {
-		gp.m.throwing = 1
-	}
 	fatalthrow()
 	*(*int)(nil) = 0 // not reached
 }
@@ -666,7 +792,7 @@ func fatalthrow() {
 	systemstack(func() {
 		startpanic_m()
 
-		if dopanic_m(gp, pc, sp) {
+		if dopanic_m(gp, pc, sp, nil) {
 			// crash uses a decent amount of nosplit stack and we're already
 			// low on stack in throw, so crash on the system stack (unlike
 			// fatalpanic).
@@ -690,6 +816,7 @@ func fatalpanic(msgs *_panic) {
 	var docrash bool
 	// 切换到系统栈来避免栈增长，如果运行时状态较差则可能导致更糟糕的事情
 	systemstack(func() {
+		var panics *_panic
 		if startpanic_m() && msgs != nil {
 			// 有 panic 消息和 startpanic_m 则可以尝试打印它们
 
@@ -697,10 +824,15 @@ func fatalpanic(msgs *_panic) {
 			// 因此现在可以开始减少 runningPanicDefers 了
 			atomic.Xadd(&runningPanicDefers, -1)
 
-			printpanics(msgs)
+			if crashJSON() {
+				// 由 dopanic_m 打印在崩溃报告中
+				panics = msgs
+			} else {
+				printpanics(msgs)
+			}
 		}
 
-		docrash = dopanic_m(gp, pc, sp)
+		docrash = dopanic_m(gp, pc, sp, panics)
 	})
 
 	if docrash {=
@@ -780,34 +912,46 @@ func startpanic_m() bool {
 var didothers bool
 var deadlock mutex
 
-func dopanic_m(gp *g, pc, sp uintptr) bool {
-	if gp.sig != 0 {
-		signame := signame(gp.sig)
-		if signame != "" {
-			print("[signal ", signame)
-		} else {
-			print("[signal ", hex(gp.sig))
-		}
-		print(" code=", hex(gp.sigcode0), " addr=", hex(gp.sigcode1), " pc=", hex(gp.sigpc), "]\n")
-	}
-
+// dopanic_m 打印 gp 的 traceback。
+// msgs 为需要包含在 JSON 格式崩溃报告中的 panic 消息，只在 GOTRACEBACK=json 时不为 nil。
+func dopanic_m(gp *g, pc, sp uintptr, msgs *_panic) bool {
 	level, all, docrash := gotraceback()
 	_g_ := getg()
-	if level > 0 {
-		if gp != gp.m.curg {
-			all = true
+	if gp != gp.m.curg {
+		all = true
+	}
+	if crashJSON() {
+		var sig crashSignal
+		if gp.sig != 0 {
+			sig = crashSignal{gp.sig, gp.sigcode0, gp.sigcode1, gp.sigpc}
 		}
-		if gp != gp.m.g0 {
-			print("\n")
-			goroutineheader(gp)
-			traceback(pc, sp, 0, gp)
-		} else if level >= 2 || _g_.m.throwing > 0 {
-			print("\nruntime stack:\n")
-			traceback(pc, sp, 0, gp)
+		others := !didothers && all
+		didothers = didothers || others
+		printCrashReport(gp, pc, sp, 0, 0, msgs, sig, others)
+	} else {
+		if gp.sig != 0 {
+			signame := signame(gp.sig)
+			if signame != "" {
+				print("[signal ", signame)
+			} else {
+				print("[signal ", hex(gp.sig))
+			}
+			print(" code=", hex(gp.sigcode0), " addr=", hex(gp.sigcode1), " pc=", hex(gp.sigpc), "]\n")
 		}
-		if !didothers && all {
-			didothers = true
-			tracebackothers(gp)
+
+		if level > 0 {
+			if gp != gp.m.g0 {
+				print("\n")
+				goroutineheader(gp)
+				traceback(pc, sp, 0, gp)
+			} else if level >= 2 || _g_.m.throwing > 0 {
+				print("\nruntime stack:\n")
+				traceback(pc, sp, 0, gp)
+			}
+			if !didothers && all {
+				didothers = true
+				tracebackothers(gp)
+			}
 		}
 	}
 	unlock(&paniclk)
@@ -0,0 +1,15 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build js,wasm
+
+package runtime
+
+// There are no signals to interrupt a running thread on this
+// platform, so preemption is always cooperative.
+const preemptMSupported = false
+
+func preemptM(mp *m) {
+	// Not currently supported.
+}
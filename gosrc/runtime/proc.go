@@ -348,7 +348,7 @@ func acquireSudog() *sudog {
 	s := pp.sudogcache[n-1]
 	pp.sudogcache[n-1] = nil
 	pp.sudogcache = pp.sudogcache[:n-1]
-	if s.elem != nil {
+	if s.elem.get() != nil {
 		throw("acquireSudog: found s.elem != nil in cache")
 	}
 	releasem(mp)
@@ -357,7 +357,7 @@ func acquireSudog() *sudog {
 
 //go:nosplit
 func releaseSudog(s *sudog) {
-	if s.elem != nil {
+	if s.elem.get() != nil {
 		throw("runtime: sudog with non-nil elem")
 	}
 	if s.isSelect {
@@ -372,7 +372,7 @@ func releaseSudog(s *sudog) {
 	if s.waitlink != nil {
 		throw("runtime: sudog with non-nil waitlink")
 	}
-	if s.c != nil {
+	if s.c.get() != nil {
 		throw("runtime: sudog with non-nil c")
 	}
 	gp := getg()
@@ -565,6 +565,7 @@ func schedinit() {
 
 	// 处理 GODEBUG、GOTRACEBACK 调试相关的环境变量设置
 	parsedebugvars()
+	schedSeedInit()
 
 	// 垃圾回收器初始化
 	gcinit()
@@ -572,10 +573,16 @@ func schedinit() {
 	// 网络的上次轮询时间
 	sched.lastpoll = uint64(nanotime())
 
-	// 通过 CPU 核心数和 GOMAXPROCS 环境变量确定 P 的数量
-	procs := ncpu
+	// 通过 CPU 核心数、cgroup CPU 限制和 GOMAXPROCS 环境变量确定 P 的数量
+	procs := defaultGOMAXPROCS(ncpu)
 	if n, ok := atoi32(gogetenv("GOMAXPROCS")); ok && n > 0 {
 		procs = n
+		customGOMAXPROCS = true
+	}
+	if debug.schedseed != 0 {
+		// 确定性调度模式只使用一个 P
+		procs = 1
+		customGOMAXPROCS = true
 	}
 
 	// 调整 P 的数量
@@ -860,6 +867,33 @@ func casgstatus(gp *g, oldval, newval uint32) {
 	if newval == _Grunning {
 		gp.gcscanvalid = false
 	}
+
+	// 维护 synctest 气泡中 goroutine 的计数
+	if sg := gp.syncGroup; sg != nil {
+		sg.changegstatus(gp, oldval, newval)
+	}
+
+	// 对调度延迟进行采样：G 每离开 _Grunning 状态 gTrackingPeriod 次才跟踪一次，
+	// 避免在每次唤醒时都调用 nanotime。
+	// 被跟踪的 G 变为 _Grunnable 时记录时间，无论之后它被放入 runnext、本地队列还是全局队列，
+	// execute 都可以据此计算调度延迟。
+	if oldval == _Grunning {
+		if gp.trackingSeq%gTrackingPeriod == 0 {
+			gp.tracking = true
+		}
+		gp.trackingSeq++
+	}
+	if gp.tracking {
+		if newval == _Grunnable {
+			gp.runnableStamp = nanotime()
+		} else if newval != _Grunning {
+			// 进入 _Gwaiting、_Gsyscall 等状态，等它再次变为 _Grunnable 时计时
+			gp.runnableStamp = 0
+		} else if oldval != _Grunnable {
+			// 例如从系统调用直接返回，没有经过 _Grunnable，本次不记录
+			gp.tracking = false
+		}
+	}
 }
 
 // casgstatus(gp, oldstatus, Gcopystack), assuming oldstatus is Gwaiting or Grunnable.
@@ -1113,7 +1147,7 @@ func startTheWorldWithSema(emitTraceEvent bool) int64 {
 
 	_g_.m.locks++ // disable preemption because it can be holding p in a local var
 	if netpollinited() {
-		list := netpoll(false) // non-blocking
+		list := netpoll(0) // non-blocking
 		injectglist(&list)
 	}
 	lock(&sched.lock)
@@ -2076,8 +2110,16 @@ func handoffp(_p_ *p) {
 		startm(_p_, false)
 		return
 	}
+
+	// The scheduler lock cannot be held when calling wakeNetPoller below
+	// because wakeNetPoller may call wakep which may call startm.
+	when := nobarrierWakeTime(_p_)
 	pidleput(_p_)
 	unlock(&sched.lock)
+
+	if when != 0 {
+		wakeNetPoller(when)
+	}
 }
 
 // 尝试将一个或多个 P 唤醒来执行 G
@@ -2178,7 +2220,14 @@ func execute(gp *g, inheritTime bool) {
 
 	// 将 g 正式切换为 _Grunning 状态
 	casgstatus(gp, _Grunnable, _Grunning)
+	// 对被跟踪的 G 记录从变为可运行到开始运行之间的调度延迟
+	if gp.runnableStamp != 0 {
+		_g_.m.p.ptr().schedLatency.record(nanotime() - gp.runnableStamp)
+		gp.runnableStamp = 0
+	}
+	gp.tracking = false
 	gp.waitsince = 0
+	gp.leaked = false
 	gp.preempt = false
 	gp.stackguard0 = gp.stack.lo + _StackGuard
 	if !inheritTime {
@@ -2226,6 +2275,10 @@ top:
 	if _p_.runSafePointFn != 0 {
 		runSafePointFn()
 	}
+
+	// 运行当前 P 上已到期的 timer，并记下下一个 timer 的到期时间
+	now, pollUntil, _ := checkTimers(_p_, 0)
+
 	if fingwait && fingwake {
 		if gp := wakefing(); gp != nil {
 			ready(gp, 0, true)
@@ -2258,7 +2311,7 @@ top:
 	// 如果有任何类型的逻辑竞争与被阻塞的线程（例如它已经从 netpoll 返回，但尚未设置 lastpoll）
 	// 该线程无论如何都将阻塞 netpoll。
 	if netpollinited() && atomic.Load(&netpollWaiters) > 0 && atomic.Load64(&sched.lastpoll) != 0 {
-		if list := netpoll(false); !list.empty() { // 无阻塞
+		if list := netpoll(0); !list.empty() { // 无阻塞
 			gp := list.pop()
 			injectglist(&list)
 			casgstatus(gp, _Gwaiting, _Grunnable)
@@ -2271,12 +2324,7 @@ top:
 
 	// 从其他 P 中偷 work
 	procs := uint32(gomaxprocs) // 获得 p 的数量
-	if atomic.Load(&sched.npidle) == procs-1 {
-		// GOMAXPROCS = 1 或除了我们之外的所有人都已经 idle 了。
-		// 新的 work 可能出现在 syscall/cgocall/网络/timer返回时
-		// 它们均没有提交到本地运行队列，因此偷取没有任何意义。
-		goto stop
-	}
+	ranTimer := false
 	// 如果 spinning 状态下 m 的数量 >= busy 状态下 p 的数量，直接进入阻塞
 	// 该步骤是有必要的，它用于当 GOMAXPROCS>>1 时但程序的并行机制很慢时
 	// 昂贵的 CPU 消耗。
@@ -2298,12 +2346,41 @@ top:
 				goto top
 			}
 			stealRunNextG := i > 2 // 如果偷了两轮都偷不到，便优先查找 ready 队列
-			if gp := runqsteal(_p_, allp[enum.position()], stealRunNextG); gp != nil {
+			p2 := allp[enum.position()]
+			if _p_ == p2 {
+				continue
+			}
+			if gp := runqsteal(_p_, p2, stealRunNextG); gp != nil {
 				// 总算偷到了，立即返回
 				return gp, false
 			}
+
+			// 最后一轮时，考虑运行其他 P 上已到期的 timer。
+			// 只在最后一轮做这件事，是因为运行 timer 需要对 P 加锁，
+			// 代价比偷 G 更高。
+			if i > 2 && shouldStealTimers(p2) {
+				tnow, w, ran := checkTimers(p2, now)
+				now = tnow
+				if w != 0 && (pollUntil == 0 || w < pollUntil) {
+					pollUntil = w
+				}
+				if ran {
+					// 运行 timer 可能让任意多个 G 变为 ready，
+					// 并被放到这个 P 的本地运行队列上。这破坏了
+					// runqsteal 总能拿到一个 G 的假设，因此直接
+					// 检查本地运行队列。
+					if gp, inheritTime := runqget(_p_); gp != nil {
+						return gp, inheritTime
+					}
+					ranTimer = true
+				}
+			}
 		}
 	}
+	if ranTimer {
+		// 运行过 timer 可能产生了 G，再试一次
+		goto top
+	}
 
 stop:
 
@@ -2320,10 +2397,16 @@ stop:
 		return gp, false
 	}
 
+	delta := int64(-1)
+	if pollUntil != 0 {
+		// 不需要计算 now，checkTimers 已经为我们计算了
+		delta = pollUntil - now
+	}
+
 	// 仅限于 wasm
 	// 如果一个回调返回后没有其他 goroutine 是苏醒的
 	// 则暂停执行直到回调被触发。
-	if beforeIdle() {
+	if beforeIdle(delta) {
 		// 至少一个 goroutine 被唤醒
 		goto top
 	}
@@ -2408,6 +2491,34 @@ stop:
 		}
 	}
 
+	// 同样地，再次检查所有 P 上 timer 的创建或到期，确定 netpoll 的休眠时间。
+	// 这里不能使用 checkTimers，因为 adjusttimers 可能需要分配内存，
+	// 而此时我们已经没有 P 了。
+	for _, _p_ := range allpSnapshot {
+		// 与 nobarrierWakeTime 类似，但尽量少调用 nanotime
+		if atomic.Load(&_p_.adjustTimers) > 0 {
+			// 有 timer 被提前了，需要尽快处理
+			if now == 0 {
+				now = nanotime()
+			}
+			pollUntil = now
+		} else {
+			w := int64(atomic.Load64(&_p_.timer0When))
+			if w != 0 && (pollUntil == 0 || w < pollUntil) {
+				pollUntil = w
+			}
+		}
+	}
+	if pollUntil != 0 {
+		if now == 0 {
+			now = nanotime()
+		}
+		delta = pollUntil - now
+		if delta < 0 {
+			delta = 0
+		}
+	}
+
 	// 再次检查 idle-priority GC work
 	// 和上面重新找 runqueue 的逻辑类似
 	if gcBlackenEnabled != 0 && gcMarkWorkAvailable(nil) {
@@ -2430,33 +2541,58 @@ stop:
 	}
 
 	// poll 网络
-	// 和上面重新找 runqueue 的逻辑类似
-	if netpollinited() && atomic.Load(&netpollWaiters) > 0 && atomic.Xchg64(&sched.lastpoll, 0) != 0 {
+	// 和上面重新找 runqueue 的逻辑类似，但这里需要阻塞直到有新的 work
+	// 或者下一个 timer 到期。
+	if netpollinited() && (atomic.Load(&netpollWaiters) > 0 || pollUntil != 0) && atomic.Xchg64(&sched.lastpoll, 0) != 0 {
+		atomic.Store64(&sched.pollUntil, uint64(pollUntil))
 		if _g_.m.p != 0 {
 			throw("findrunnable: netpoll with p")
 		}
 		if _g_.m.spinning {
 			throw("findrunnable: netpoll with spinning")
 		}
-		list := netpoll(true) // 阻塞到新的 work 有效为止
+		if faketime != 0 {
+			// 使用假时间时，只做非阻塞的轮询
+			delta = 0
+		}
+		list := netpoll(delta) // 阻塞到新的 work 有效或 timer 到期为止
+		atomic.Store64(&sched.pollUntil, 0)
 		atomic.Store64(&sched.lastpoll, uint64(nanotime()))
-		if !list.empty() {
-			lock(&sched.lock)
-			_p_ = pidleget()
-			unlock(&sched.lock)
-			if _p_ != nil {
-				acquirep(_p_)
-				injectglist(gp.schedlink.ptr())
+		if faketime != 0 && list.empty() {
+			// 使用假时间且没有可运行的 G 时，停止当前 M。
+			// checkdead 会负责推进时间。
+			stopm()
+			goto top
+		}
+		lock(&sched.lock)
+		_p_ = pidleget()
+		unlock(&sched.lock)
+		if _p_ == nil {
+			injectglist(&list)
+		} else {
+			acquirep(_p_)
+			if !list.empty() {
+				gp := list.pop()
+				injectglist(&list)
 				casgstatus(gp, _Gwaiting, _Grunnable)
 				if trace.enabled {
 					traceGoUnpark(gp, 0)
 				}
 				return gp, false
 			}
-			injectglist(&list)
+			if wasSpinning {
+				_g_.m.spinning = true
+				atomic.Xadd(&sched.nmspinning, 1)
+			}
+			goto top
+		}
+	} else if pollUntil != 0 && netpollinited() {
+		pollerPollUntil := int64(atomic.Load64(&sched.pollUntil))
+		if pollerPollUntil == 0 || pollerPollUntil > pollUntil {
+			// 有其他 M 阻塞在 netpoll 中，但它醒来得太晚，唤醒它
+			netpollBreak()
 		}
 	}
-
 	// 真的什么都没找到
 	// park 当前的 m
 	stopm()
@@ -2476,7 +2612,7 @@ func pollWork() bool {
 		return true
 	}
 	if netpollinited() && atomic.Load(&netpollWaiters) > 0 && sched.lastpoll != 0 {
-		if list := netpoll(false); !list.empty() {
+		if list := netpoll(0); !list.empty() {
 			injectglist(&list)
 			return true
 		}
@@ -2484,6 +2620,26 @@ func pollWork() bool {
 	return false
 }
 
+// wakeNetPoller wakes up the thread sleeping in the network poller,
+// if there is one, and if it isn't going to wake up anyhow before
+// the when argument.
+func wakeNetPoller(when int64) {
+	if atomic.Load64(&sched.lastpoll) == 0 {
+		// In findrunnable we ensure that when polling the pollUntil
+		// field is either zero or the time to which the current
+		// poll is expected to run. This can have a spurious wakeup
+		// but should never miss a wakeup.
+		pollerPollUntil := int64(atomic.Load64(&sched.pollUntil))
+		if pollerPollUntil == 0 || pollerPollUntil > when {
+			netpollBreak()
+		}
+	} else {
+		// There are no threads in the network poller, try to
+		// get one there so it can handle new timers.
+		wakep()
+	}
+}
+
 func resetspinning() {
 	_g_ := getg()
 	if !_g_.m.spinning {
@@ -2557,6 +2713,9 @@ top:
 		runSafePointFn()
 	}
 
+	// 运行当前 P 上已经到期的 timer
+	checkTimers(_g_.m.p.ptr(), 0)
+
 	var gp *g
 	var inheritTime bool
 
@@ -2574,6 +2733,11 @@ top:
 		gp = gcController.findRunnableGCWorker(_g_.m.p.ptr())
 	}
 
+	// 确定性调度模式，由 PRNG 从所有可运行的 G 中选择
+	if gp == nil && debug.schedseed != 0 {
+		gp = schedSeedPick(_g_.m.p.ptr())
+	}
+
 	if gp == nil {
 		// 说明不在 gc
 		//
@@ -2652,6 +2816,90 @@ func dropg() {
 	setGNoWB(&_g_.m.curg, nil)
 }
 
+// checkTimers runs any timers for the P that are ready.
+// If now is not 0 it is the current time.
+// It returns the current time or 0 if it is not known,
+// and the time when the next timer should run or 0 if there is no next timer,
+// and reports whether it ran any timers.
+// If the time when the next timer should run is not 0,
+// it is always larger than the returned time.
+// We pass now in and out to avoid extra calls of nanotime.
+//go:yeswritebarrierrec
+func checkTimers(pp *p, now int64) (rnow, pollUntil int64, ran bool) {
+	// If there are no timers to adjust, and the first timer on
+	// the heap is not yet ready to run, then there is nothing to do.
+	if atomic.Load(&pp.adjustTimers) == 0 {
+		next := int64(atomic.Load64(&pp.timer0When))
+		if next == 0 {
+			return now, 0, false
+		}
+		if now == 0 {
+			now = nanotime()
+		}
+		if now < next {
+			// Next timer is not ready to run.
+			// But keep going if we would clear deleted timers.
+			// This will only do a lock/unlock on the P once,
+			// so it's cheap enough.
+			if pp != getg().m.p.ptr() || int(atomic.Load(&pp.deletedTimers)) <= int(atomic.Load(&pp.numTimers)/4) {
+				return now, next, false
+			}
+		}
+	}
+
+	lock(&pp.timersLock)
+
+	adjusttimers(pp)
+
+	rnow = now
+	if len(pp.timers) > 0 {
+		if rnow == 0 {
+			rnow = nanotime()
+		}
+		for len(pp.timers) > 0 {
+			// Note that runtimer may temporarily unlock
+			// pp.timersLock.
+			if tw := runtimer(pp, rnow); tw != 0 {
+				if tw > 0 {
+					pollUntil = tw
+				}
+				break
+			}
+			ran = true
+		}
+	}
+
+	// If this is the local P, and there are a lot of deleted timers,
+	// clear them out. We only do this for the local P to reduce
+	// lock contention on timersLock.
+	if pp == getg().m.p.ptr() && int(atomic.Load(&pp.deletedTimers)) > len(pp.timers)/4 {
+		clearDeletedTimers(pp)
+	}
+
+	unlock(&pp.timersLock)
+
+	return rnow, pollUntil, ran
+}
+
+// shouldStealTimers reports whether we should try stealing the timers from p2.
+// We don't steal timers from a running P that is not marked for preemption,
+// on the assumption that it will run its own timers. This reduces
+// contention on the timers lock.
+func shouldStealTimers(p2 *p) bool {
+	if p2.status != _Prunning {
+		return true
+	}
+	mp := p2.m.ptr()
+	if mp == nil || mp.locks > 0 {
+		return false
+	}
+	gp := mp.curg
+	if gp == nil || readgstatus(gp) != _Grunning || !gp.preempt {
+		return false
+	}
+	return true
+}
+
 func parkunlock_c(gp *g, lock unsafe.Pointer) bool {
 	unlock((*mutex)(lock))
 	return true
@@ -2665,6 +2913,14 @@ func park_m(gp *g) {
 		traceGoPark(_g_.m.waittraceev, _g_.m.waittraceskip)
 	}
 
+	// 在 unlockf 返回之前，gp 可能还没有挂到等待队列或 timer 堆上，
+	// 不能让 synctest 气泡因 gp 进入 _Gwaiting 而被认为空闲。
+	// unlockf 返回后 gp 可能已经在其他 M 上运行并退出，因此先保存 sg。
+	sg := gp.syncGroup
+	if sg != nil {
+		sg.incActive()
+	}
+
 	casgstatus(gp, _Grunning, _Gwaiting)
 	dropg()
 
@@ -2678,9 +2934,15 @@ func park_m(gp *g) {
 				traceGoUnpark(gp, 2)
 			}
 			casgstatus(gp, _Gwaiting, _Grunnable)
+			if sg != nil {
+				sg.decActive()
+			}
 			execute(gp, true) // Schedule it back, never returns.
 		}
 	}
+	if sg != nil {
+		sg.decActive()
+	}
 	schedule()
 }
 
@@ -2769,6 +3031,11 @@ func goexit0(gp *g) {
 	gp.param = nil
 	gp.labels = nil
 	gp.timer = nil
+	gp.syncGroup = nil
+	gp.leaked = false
+	gp.allocBytes = 0
+	gp.allocObjects = 0
+	gp.stackGrown = false
 
 	if gcBlackenEnabled != 0 && gp.gcAssistBytes > 0 {
 		// 刷新 assist credit 到全局池。
@@ -3329,6 +3596,10 @@ func newproc(siz int32, fn *funcval) {
 	systemstack(func() {
 		newproc1(fn, (*uint8)(argp), siz, gp, pc)
 	})
+	// 确定性调度模式下，创建 goroutine 是一个调度点
+	if debug.schedseed != 0 {
+		schedSeedPoint()
+	}
 }
 
 // 创建一个运行 fn 的新 g，具有 narg 字节大小的参数，从 argp 开始。
@@ -3362,7 +3633,7 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callergp *g, callerpc uintpt
 	// 也可能运行中本来就已经耗尽了
 	if newg == nil {
 		// 创建一个拥有 _StackMin 大小的栈的 g
-		newg = malg(_StackMin)
+		newg = malg(int32(startingStackSize))
 		// 将新创建的 g 从 _Gidle 更新为 _Gdead 状态
 		casgstatus(newg, _Gidle, _Gdead)
 		allgadd(newg) // 将 Gdead 状态的 g 添加到 allg，这样 GC 不会扫描未初始化的栈
@@ -3431,6 +3702,10 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callergp *g, callerpc uintpt
 	// 调试相关
 	if isSystemGoroutine(newg, false) {
 		atomic.Xadd(&sched.ngsys, +1)
+	} else if _g_.m.curg != nil {
+		// 只有用户 goroutine 继承 synctest 气泡，
+		// 必须在下面变为 _Grunnable 之前设置，从而被计入气泡中
+		newg.syncGroup = _g_.m.curg.syncGroup
 	}
 
 	newg.gcscanvalid = false
@@ -3514,7 +3789,7 @@ func gfput(_p_ *p, gp *g) {
 
 	stksize := gp.stack.hi - gp.stack.lo
 
-	if stksize != _FixedStack {
+	if stksize != uintptr(startingStackSize) {
 		// non-standard stack size - free it.
 		stackfree(gp.stack)
 		gp.stack.lo = 0
@@ -3569,12 +3844,21 @@ retry:
 	}
 	// 拿到一个 g
 	_p_.gFree.n--
+	if gp.stack.lo != 0 && gp.stack.hi-gp.stack.lo != uintptr(startingStackSize) {
+		// gfput 时栈的大小是合适的，但此后初始栈大小发生了变化，释放旧的栈
+		systemstack(func() {
+			stackfree(gp.stack)
+			gp.stack.lo = 0
+			gp.stack.hi = 0
+			gp.stackguard0 = 0
+		})
+	}
 	// 查看是否需要分配运行栈
 	if gp.stack.lo == 0 {
 		// 栈可能从全局 gfree 链表中取得，栈已被 gfput 给释放，所以需要分配一个新的栈。
 		// 栈分配发生在系统栈上
 		systemstack(func() {
-			gp.stack = stackalloc(_FixedStack)
+			gp.stack = stackalloc(startingStackSize)
 		})
 		// 计算栈边界
 		gp.stackguard0 = gp.stack.lo + _StackGuard
@@ -4125,6 +4409,23 @@ func procresize(nprocs int32) *p {
 			globrunqputhead(p.runnext.ptr())
 			p.runnext = 0
 		}
+		// 将 timer 转移到一个仍然存活的 P 上：若当前 P 不会被释放则使用当前 P，
+		// 否则使用 allp[0]
+		if len(p.timers) > 0 {
+			plocal := getg().m.p.ptr()
+			if plocal == nil || plocal.id >= nprocs {
+				plocal = allp[0]
+			}
+			// 此时世界已经停止，但仍需对 timersLock 加锁以满足 moveTimers 的要求
+			lock(&plocal.timersLock)
+			moveTimers(plocal, p.timers)
+			p.timers = nil
+			p.numTimers = 0
+			p.adjustTimers = 0
+			p.deletedTimers = 0
+			atomic.Store64(&p.timer0When, 0)
+			unlock(&plocal.timersLock)
+		}
 		// if there's a background worker, make it runnable and put
 		// it on the global queue so it can clean itself up
 		if gp := p.gcBgMarkWorker.ptr(); gp != nil {
@@ -4155,11 +4456,35 @@ func procresize(nprocs int32) *p {
 		// 释放当前 P 绑定的 cache
 		freemcache(p.mcache)
 		p.mcache = nil
+		// 将 P 缓存的 mspan 结构和空闲页归还给堆
+		systemstack(func() {
+			lock(&mheap_.lock)
+			for i := 0; i < p.mspancache.len; i++ {
+				// Safe to call since the world is stopped.
+				mheap_.spanalloc.free(unsafe.Pointer(p.mspancache.buf[i]))
+			}
+			p.mspancache.len = 0
+			p.pcache.flush(&mheap_.pages)
+			unlock(&mheap_.lock)
+		})
 
 		// 将当前 P 的 G 复链转移到全局
 		gfpurge(p)
 		traceProcFree(p)
 		if raceenabled {
+			if p.timerRaceCtx != 0 {
+				// race detector 通过回调获取 proc context，
+				// 因此这里临时将当前 M 的 P 换成 p，让回调看到正确的值。
+				// 这只在世界已经停止、只有我们在运行时才成立。
+				mp := getg().m
+				phold := mp.p.ptr()
+				mp.p.set(p)
+
+				racectxend(p.timerRaceCtx)
+				p.timerRaceCtx = 0
+
+				mp.p.set(phold)
+			}
 			raceprocdestroy(p.racectx)
 			p.racectx = 0
 		}
@@ -4379,23 +4704,34 @@ func checkdead() {
 	}
 
 	// Maybe jump time forward for playground.
-	gp := timejump()
-	if gp != nil {
-		casgstatus(gp, _Gwaiting, _Grunnable)
-		globrunqput(gp)
-		_p_ := pidleget()
-		if _p_ == nil {
-			throw("checkdead: no p for timer")
+	if faketime != 0 {
+		when, _p_ := timeSleepUntil()
+		if _p_ != nil {
+			faketime = when
+			for pp := &sched.pidle; *pp != 0; pp = &(*pp).ptr().link {
+				if (*pp).ptr() == _p_ {
+					*pp = _p_.link
+					atomic.Xadd(&sched.npidle, -1)
+					break
+				}
+			}
+			mp := mget()
+			if mp == nil {
+				// There should always be a free M since
+				// nothing is running.
+				throw("checkdead: no m for timer")
+			}
+			mp.nextp.set(_p_)
+			notewakeup(&mp.park)
+			return
 		}
-		mp := mget()
-		if mp == nil {
-			// There should always be a free M since
-			// nothing is running.
-			throw("checkdead: no m for timer")
+	}
+
+	// There are no goroutines running, so we can look at the P's.
+	for _, _p_ := range allp {
+		if len(_p_.timers) > 0 {
+			return
 		}
-		mp.nextp.set(_p_)
-		notewakeup(&mp.park)
-		return
 	}
 
 	getg().m.throwing = -1 // do not dump full stacks
@@ -4421,21 +4757,8 @@ func sysmon() {
 	checkdead()
 	unlock(&sched.lock)
 
-	// 如果一个堆 span 在 GC 超过五分钟没有被使用
-	// 则回收交于操作系统
-	scavengelimit := int64(5 * 60 * 1e9)
-
-	// 调试相关
-	if debug.scavenge > 0 {
-		// Scavenge-a-lot for testing.
-		forcegcperiod = 10 * 1e6
-		scavengelimit = 20 * 1e6
-	}
-
-	lastscavenge := nanotime()
-	nscavenge := 0
-
 	lasttrace := int64(0)
+	lastmaxprocs := nanotime()
 	idle := 0 // 没有 wokeup 的周期数
 	delay := uint32(0)
 	for {
@@ -4449,41 +4772,42 @@ func sysmon() {
 		}
 		// 休眠
 		usleep(delay)
+		now := nanotime()
+		// 下一个 timer 的到期时间
+		next, _ := timeSleepUntil()
 
 		// 如果在 STW，则暂时休眠
 		if debug.schedtrace <= 0 && (sched.gcwaiting != 0 || atomic.Load(&sched.npidle) == uint32(gomaxprocs)) {
 			lock(&sched.lock)
 			if atomic.Load(&sched.gcwaiting) != 0 || atomic.Load(&sched.npidle) == uint32(gomaxprocs) {
-				// 标识休眠状态
-				atomic.Store(&sched.sysmonwait, 1)
-				unlock(&sched.lock)
-				// 确保 wake-up 周期足够小从而进行正确的采样
-				maxsleep := forcegcperiod / 2
-				if scavengelimit < forcegcperiod {
-					maxsleep = scavengelimit / 2
-				}
-				shouldRelax := true
-				if osRelaxMinNS > 0 {
-					next := timeSleepUntil()
-					now := nanotime()
-					if next-now < osRelaxMinNS {
-						shouldRelax = false
+				if next > now {
+					// 标识休眠状态
+					atomic.Store(&sched.sysmonwait, 1)
+					unlock(&sched.lock)
+					// 确保 wake-up 周期足够小从而进行正确的采样
+					sleep := forcegcperiod / 2
+					// 不要睡过下一个 timer 的到期时间
+					if next-now < sleep {
+						sleep = next - now
 					}
+					shouldRelax := sleep >= osRelaxMinNS
+					if shouldRelax {
+						osRelax(true)
+					}
+					// 设置休眠超时，在此阻塞
+					notetsleep(&sched.sysmonnote, sleep)
+					if shouldRelax {
+						osRelax(false)
+					}
+					now = nanotime()
+					next, _ = timeSleepUntil()
+
+					// 休眠结束
+					lock(&sched.lock)
+					atomic.Store(&sched.sysmonwait, 0)
+					// 清除休眠超时通知
+					noteclear(&sched.sysmonnote)
 				}
-				if shouldRelax {
-					osRelax(true)
-				}
-				// 设置休眠超时，在此阻塞
-				notetsleep(&sched.sysmonnote, maxsleep)
-				if shouldRelax {
-					osRelax(false)
-				}
-
-				// 休眠结束
-				lock(&sched.lock)
-				atomic.Store(&sched.sysmonwait, 0)
-				// 清除休眠超时通知
-				noteclear(&sched.sysmonnote)
 				idle = 0
 				delay = 20
 			}
@@ -4495,10 +4819,9 @@ func sysmon() {
 		}
 		// 如果超过 10ms 没有 poll，则 poll 一下网络
 		lastpoll := int64(atomic.Load64(&sched.lastpoll))
-		now := nanotime()
 		if netpollinited() && lastpoll != 0 && lastpoll+10*1000*1000 < now {
 			atomic.Cas64(&sched.lastpoll, uint64(lastpoll), uint64(now))
-			list := netpoll(false) // 非阻塞，返回 goroutine 列表
+			list := netpoll(0) // 非阻塞，返回 goroutine 列表
 			if !list.empty() {
 				// 需要在插入 g 列表前减少空闲锁住的 m 的数量（假装有一个正在运行）
 				// 否则会导致这些情况：
@@ -4509,6 +4832,11 @@ func sysmon() {
 				incidlelocked(1)
 			}
 		}
+		if next < now {
+			// 有本应已经运行的 timer，可能是因为某个 P 无法被抢占。
+			// 启动一个 M 来运行它们。
+			startm(nil, false)
+		}
 		// 抢夺在 syscall 中阻塞的 P、运行时间过长的 G
 		if retake(now) != 0 {
 			idle = 0
@@ -4524,13 +4852,18 @@ func sysmon() {
 			injectglist(&list)
 			unlock(&forcegc.lock)
 		}
-		// 一段时间内清理堆
-		if lastscavenge+scavengelimit/2 < now {
-			mheap_.scavenge(int32(nscavenge), uint64(now), uint64(scavengelimit))
-			lastscavenge = now
-			nscavenge++
+		// 定期重新检查 cgroup CPU 限制
+		if lastmaxprocs+maxprocsCheckPeriod < now {
+			lastmaxprocs = now
+			sysmonUpdateMaxProcs()
+		}
+		// 保留内存超过内存限制时，立即归还空闲内存。
+		// 保留内存由 mheap_.lock 保护的统计计算，因此在锁内比较。
+		if atomic.Load64(&memoryLimit) != maxMemoryLimit {
+			lock(&mheap_.lock)
+			mheap_.scavengeForMemoryLimit()
+			unlock(&mheap_.lock)
 		}
-
 		// trace 相关
 		if debug.schedtrace > 0 && lasttrace+int64(debug.schedtrace)*1000000 <= now {
 			lasttrace = now
@@ -4605,7 +4938,12 @@ func retake(now int64) uint32 {
 				pd.schedwhen = now
 				continue
 			}
-			if pd.schedwhen+forcePreemptNS > now {
+			limit := int64(forcePreemptNS)
+			if debug.schedseed != 0 {
+				// 确定性调度模式下抢占点由 PRNG 决定，只在运行过久时才强制抢占
+				limit = schedSeedForcePreemptNS
+			}
+			if pd.schedwhen+limit > now {
 				continue
 			}
 			preemptone(_p_)
@@ -4656,6 +4994,13 @@ func preemptone(_p_ *p) bool {
 	// 来检查栈是否溢出。
 	// 设置 gp.stackgard0 为 StackPreempt 来将抢占转换为正常的栈溢出检查。
 	gp.stackguard0 = stackPreempt
+
+	// 一个没有函数调用的循环永远不会检查 stackguard0，
+	// 因此还需要通过信号请求对该 M 进行异步抢占。
+	if preemptMSupported && debug.asyncpreemptoff == 0 {
+		preemptM(mp)
+	}
+
 	return true
 }
 
@@ -4698,6 +5043,7 @@ func schedtrace(detailed bool) {
 			}
 		}
 	}
+	schedtraceLatency()
 
 	if !detailed {
 		unlock(&sched.lock)
@@ -4742,6 +5088,81 @@ func schedtrace(detailed bool) {
 	unlock(&sched.lock)
 }
 
+// schedtraceLatencyState 保存上一次 schedtrace 时所有 P 的调度延迟直方图之和，
+// 用于打印两次 schedtrace 之间的调度延迟。由 sched.lock 保护。
+var schedtraceLatencyState struct {
+	prev, cur [timeHistTotalBuckets]uint64
+}
+
+// schedtraceLatency 打印自上一次 schedtrace 以来 goroutine 从可运行到开始运行
+// 的延迟摘要，格式为：
+//
+//	SCHED latency: n=N p50=Xus p90=Xus p99=Xus max=Xus
+//
+// 其中分位数为所在直方图桶的下界。
+//
+// sched.lock 必须被持有。
+func schedtraceLatency() {
+	s := &schedtraceLatencyState
+	for i := range s.cur {
+		s.cur[i] = 0
+	}
+	for _, _p_ := range allp {
+		_p_.schedLatency.merge(s.cur[:])
+	}
+	// 将 cur 转换为与上一次的差值，同时保存本次的累积值
+	var n uint64
+	maxBucket := -1
+	for i := range s.cur {
+		total := s.cur[i]
+		if total >= s.prev[i] {
+			s.cur[i] = total - s.prev[i]
+		} else {
+			// P 被 procresize 移除后，其计数不再被统计
+			s.cur[i] = 0
+		}
+		s.prev[i] = total
+		n += s.cur[i]
+		if s.cur[i] != 0 {
+			maxBucket = i
+		}
+	}
+	print("SCHED latency: n=", n)
+	if n > 0 {
+		print(" p50=", timeHistQuantile(s.cur[:], 0.50)/1000, "us",
+			" p90=", timeHistQuantile(s.cur[:], 0.90)/1000, "us",
+			" p99=", timeHistQuantile(s.cur[:], 0.99)/1000, "us",
+			" max=", timeHistBucketMin(maxBucket)/1000, "us")
+	}
+	print("\n")
+}
+
+// readSchedLatency 返回调度延迟直方图各个桶的下界（纳秒），以及每个 P 的直方图计数。
+//
+//go:linkname readSchedLatency runtime/debug.readSchedLatency
+func readSchedLatency() (buckets []int64, counts [][]uint64) {
+	buckets = make([]int64, timeHistTotalBuckets)
+	for i := range buckets {
+		buckets[i] = timeHistBucketMin(i)
+	}
+	counts = make([][]uint64, gomaxprocs)
+	for i := range counts {
+		counts[i] = make([]uint64, timeHistTotalBuckets)
+	}
+	n := 0
+	// 在系统栈上读取，此时不可被抢占，allp 不会被 procresize 修改。
+	systemstack(func() {
+		for i, _p_ := range allp {
+			if i >= len(counts) {
+				break
+			}
+			_p_.schedLatency.merge(counts[i])
+			n++
+		}
+	})
+	return buckets, counts[:n]
+}
+
 // schedEnableUser enables or disables the scheduling of user
 // goroutines.
 //
@@ -4928,6 +5349,10 @@ func runqput(_p_ *p, gp *g, next bool) {
 	if randomizeScheduler && next && fastrand()%2 == 0 {
 		next = false
 	}
+	if debug.schedseed != 0 {
+		// 确定性调度模式下由 PRNG 决定是否使用 runnext
+		next = schedSeedRand()%2 == 0
+	}
 
 	if next {
 	retryNext:
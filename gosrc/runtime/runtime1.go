@@ -13,11 +13,12 @@ import (
 // Keep a cached value to make gotraceback fast,
 // since we call it on every call to gentraceback.
 // The cached value is a uint32 in which the low bits
-// are the "crash" and "all" settings and the remaining
+// are the "crash", "all" and "json" settings and the remaining
 // bits are the traceback value (0 off, 1 on, 2 include system).
 const (
 	tracebackCrash = 1 << iota
 	tracebackAll
+	tracebackJSON
 	tracebackShift = iota
 )
 
@@ -297,44 +298,59 @@ type dbgVar struct {
 // 保存从 GODEBUG env var 解析的变量，
 // 除了 "memprofilerate"，因为该值存在一个int var，它可能已经有一个初始值。
 var debug struct {
+	adaptivestackstart int32
 	allocfreetrace     int32
+	asyncpreemptoff    int32
 	cgocheck           int32
+	containermaxprocs  int32
 	efence             int32
 	gccheckmark        int32
 	gcpacertrace       int32
 	gcshrinkstackoff   int32
 	gcstoptheworld     int32
 	gctrace            int32
+	goroutineleak      int32
+	guardalloc         int32
 	invalidptr         int32
 	madvdontneed       int32 // for Linux; issue 28466
 	sbrk               int32
-	scavenge           int32
 	scheddetail        int32
+	schedseed          int32
 	schedtrace         int32
 	tracebackancestors int32
+	updatemaxprocs     int32
 }
 
 var dbgvars = []dbgVar{
+	{"adaptivestackstart", &debug.adaptivestackstart},
 	{"allocfreetrace", &debug.allocfreetrace},
+	{"asyncpreemptoff", &debug.asyncpreemptoff},
 	{"cgocheck", &debug.cgocheck},
+	{"containermaxprocs", &debug.containermaxprocs},
 	{"efence", &debug.efence},
 	{"gccheckmark", &debug.gccheckmark},
 	{"gcpacertrace", &debug.gcpacertrace},
 	{"gcshrinkstackoff", &debug.gcshrinkstackoff},
 	{"gcstoptheworld", &debug.gcstoptheworld},
 	{"gctrace", &debug.gctrace},
+	{"goroutineleak", &debug.goroutineleak},
+	{"guardalloc", &debug.guardalloc},
 	{"invalidptr", &debug.invalidptr},
 	{"sbrk", &debug.sbrk},
-	{"scavenge", &debug.scavenge},
 	{"scheddetail", &debug.scheddetail},
+	{"schedseed", &debug.schedseed},
 	{"schedtrace", &debug.schedtrace},
 	{"tracebackancestors", &debug.tracebackancestors},
+	{"updatemaxprocs", &debug.updatemaxprocs},
 }
 
 func parsedebugvars() {
 	// defaults
+	debug.adaptivestackstart = 1
 	debug.cgocheck = 1
+	debug.containermaxprocs = 1
 	debug.invalidptr = 1
+	debug.updatemaxprocs = 1
 
 	for p := gogetenv("GODEBUG"); p != ""; {
 		field := ""
@@ -386,6 +402,8 @@ func setTraceback(level string) {
 		t = 2<<tracebackShift | tracebackAll
 	case "crash":
 		t = 2<<tracebackShift | tracebackAll | tracebackCrash
+	case "json":
+		t = 1<<tracebackShift | tracebackAll | tracebackJSON
 	default:
 		t = tracebackAll
 		if n, ok := atoi(level); ok && n == int(uint32(n)) {
@@ -186,6 +186,7 @@ func (gp *guintptr) cas(old, new guintptr) bool {
 }
 
 // setGNoWB 当使用 guintptr 不可行时，在没有 write barrier 下执行 *gp = new
+//
 //go:nosplit
 //go:nowritebarrier
 func setGNoWB(gp **g, new *g) {
@@ -216,6 +217,7 @@ func (mp muintptr) ptr() *m { return (*m)(unsafe.Pointer(mp)) }
 func (mp *muintptr) set(m *m) { *mp = muintptr(unsafe.Pointer(m)) }
 
 // setMNoWB 当使用 muintptr 不可行时，在没有 write barrier 下执行 *mp = new
+//
 //go:nosplit
 //go:nowritebarrier
 func setMNoWB(mp **m, new *m) {
@@ -256,7 +258,7 @@ type sudog struct {
 	isSelect bool
 	next     *sudog
 	prev     *sudog
-	elem     unsafe.Pointer // 数据元素（可能指向栈）
+	elem     maybeTraceablePtr // 数据元素（可能指向栈）
 
 	// 下面的字段永远不会并发的被访问。对于 channel waitlink 只会被 g 访问
 	// 对于 semaphores，所有的字段（包括上面的）只会在持有 semaRoot 锁时被访问
@@ -264,10 +266,62 @@ type sudog struct {
 	acquiretime int64
 	releasetime int64
 	ticket      uint32
-	parent      *sudog // semaRoot 二叉树
-	waitlink    *sudog // g.waiting 列表或 semaRoot
-	waittail    *sudog // semaRoot
-	c           *hchan // channel
+	parent      *sudog             // semaRoot 二叉树
+	waitlink    *sudog             // g.waiting 列表或 semaRoot
+	waittail    *sudog             // semaRoot
+	c           maybeTraceableChan // channel
+}
+
+// maybeTraceablePtr 为一个可以对 GC 隐藏的指针。vu 总是保存指针的值；
+// 可追踪时 vp 与 vu 相同，被隐藏时 vp 为 nil，GC 不会经由它标记所指向的对象。
+//
+// 泄漏检测在标记期间隐藏候选 goroutine 的 sudog 所等待的对象，
+// 并在候选被扫描之前恢复它们，见 goroutineleak.go。
+// 除了泄漏检测，所有访问都必须经由 get 与 set。
+type maybeTraceablePtr struct {
+	vp unsafe.Pointer
+	vu uintptr
+}
+
+//go:nosplit
+func (p *maybeTraceablePtr) get() unsafe.Pointer {
+	return unsafe.Pointer(p.vu)
+}
+
+//go:nosplit
+func (p *maybeTraceablePtr) set(v unsafe.Pointer) {
+	p.vp = v
+	p.vu = uintptr(v)
+}
+
+// setUntraceable 对 GC 隐藏 p。写入时绕过写屏障，否则写屏障会标记原来的值。
+//
+//go:nosplit
+func (p *maybeTraceablePtr) setUntraceable() {
+	*(*uintptr)(unsafe.Pointer(&p.vp)) = 0
+}
+
+// setTraceable 恢复被隐藏的 p。标记期间写屏障会将所指向的对象标记为灰色，
+// 因此在隐藏期间没有被标记的对象不会在本周期被回收。
+//
+//go:nosplit
+func (p *maybeTraceablePtr) setTraceable() {
+	p.vp = unsafe.Pointer(p.vu)
+}
+
+// maybeTraceableChan 为指向 hchan 的 maybeTraceablePtr
+type maybeTraceableChan struct {
+	maybeTraceablePtr
+}
+
+//go:nosplit
+func (p *maybeTraceableChan) get() *hchan {
+	return (*hchan)(p.maybeTraceablePtr.get())
+}
+
+//go:nosplit
+func (p *maybeTraceableChan) set(c *hchan) {
+	p.maybeTraceablePtr.set(unsafe.Pointer(c))
 }
 
 type libcall struct {
@@ -327,6 +381,7 @@ type g struct {
 	gcscandone     bool       // g 执行栈已经 scan 了；此此段受 _Gscan 位保护
 	gcscanvalid    bool       // 在 gc 周期开始时为 false；当 G 从上次 scan 后就没有运行时为 true TODO: remove?
 	throwsplit     bool       // 必须不能进行栈分段
+	asyncSafePoint bool       // 在异步安全点被抢占时为 true，此时栈帧需要保守扫描
 	raceignore     int8       // 忽略 race 检查事件
 	sysblocktraced bool       // StartTrace 已经出发了此 goroutine 的 EvGoInSyscall
 	sysexitticks   int64      // 当 syscall 返回时的 cputicks（用于跟踪）
@@ -346,8 +401,17 @@ type g struct {
 	cgoCtxt        []uintptr      // cgo 回溯上下文
 	labels         unsafe.Pointer // profiler 的标签
 	timer          *timer         // 为 time.Sleep 缓存的计时器
+	syncGroup      *synctestGroup // 所在的 synctest 气泡，见 synctest.go
+	leaked         bool           // 被泄漏检测认定为泄漏，见 goroutineleak.go
+	leakSudog      *sudog         // 阻塞在信号量或 sync.Cond 上时的 sudog，供泄漏检测找到等待的地址
+	leakReported   bool           // 泄漏已经被 GODEBUG=goroutineleak=1 打印过
 	selectDone     uint32         // 我们是否正在参与 select 且某个 goroutine 胜出
 
+	// 调度延迟的采样状态，参见 casgstatus
+	tracking      bool  // 是否跟踪该 G 的调度延迟
+	trackingSeq   uint8 // 用于决定是否跟踪该 G
+	runnableStamp int64 // 跟踪时，G 最近一次变为 _Grunnable 的时间，execute 据此记录调度延迟
+
 	// Per-G GC 状态
 
 	// gcAssistBytes 是该 G 在分配的字节数这一方面的的 GC 辅助 credit
@@ -356,6 +420,14 @@ type g struct {
 	// 我们以字节为单位进行追踪，一遍快速更新并检查 malloc 热路径中分配的债务（分配的字节）。
 	// assist ratio 决定了它与 scan work 债务的对应关系
 	gcAssistBytes int64
+
+	// allocBytes 与 allocObjects 为该 goroutine 自创建以来在堆上分配的字节数与对象个数，
+	// 由 mallocgc 更新，见 GoroutineAllocs
+	allocBytes   uint64
+	allocObjects uint64
+
+	// stackGrown 表示栈使用 profile 开启期间该 goroutine 的栈增长过，见 stackprof.go
+	stackGrown bool
 }
 
 type m struct {
@@ -379,6 +451,8 @@ type m struct {
 	mallocing     int32
 	throwing      int32
 	preemptoff    string // 如果不为空串 ""，继续让当前 g 运行在该 M 上
+	preemptGen    uint32 // 已完成的抢占信号计数 (atomic)
+	signalPending uint32 // 是否有尚未处理的抢占信号 (atomic)
 	locks         int32
 	dying         int32
 	profilehz     int32
@@ -480,6 +554,15 @@ type p struct {
 
 	palloc persistentAlloc // per-P，用于避免 mutex
 
+	// mspancache 为 mspan 结构的 per-P 缓存，分配 span 时可以不持有堆锁取得 mspan。
+	// 仅在持有堆锁时填充，len 不超过 len(buf)。
+	mspancache struct {
+		len int
+		buf [128]*mspan
+	}
+
+	pcache pageCache // per-P 的页缓存，小的 span 从中分配时不需要堆锁
+
 	// Per-P GC 状态
 	gcAssistTime         int64 // assistAlloc 时间 (纳秒)
 	gcFractionalMarkTime int64 // fractional mark worker 的时间 (纳秒)
@@ -489,6 +572,11 @@ type p struct {
 	// gcMarkWorkerStartTime 为该 mark worker 开始的 nanotime()
 	gcMarkWorkerStartTime int64
 
+	// scannedStackSize 与 scannedStacks 为本周期在该 P 上扫描的栈的使用量之和与栈的个数，
+	// 用于计算新 goroutine 的初始栈大小，见 gcComputeStartingStackSize
+	scannedStackSize uint64
+	scannedStacks    uint64
+
 	// gcw 为当前 P 的 GC work buffer 缓存。该 work buffer 会被写入
 	// write barrier，由 mutator 辅助消耗，并处理某些 GC 状态转换。
 	gcw gcWork
@@ -500,13 +588,42 @@ type p struct {
 
 	runSafePointFn uint32 // 如果为 1, 则在下一个 safe-point 运行 sched.safePointFn
 
+	// timer0When 为 timers 堆中第一个 timer 的 when 字段。
+	// 若堆为空，则为 0。原子访问。
+	timer0When uint64
+
+	// timersLock 保护 timers。通常只有当前 P 会访问 timers，
+	// 但调度器也可能在另一个 P 上访问。
+	timersLock mutex
+
+	// 需要在某个时刻执行的 timer。由 timersLock 保护，
+	// 是一个按 when 排序的 4 叉堆。
+	timers []*timer
+
+	// timers 堆中的 timer 数量。原子访问。
+	numTimers uint32
+
+	// timers 堆中处于 timerModifiedEarlier 状态的 timer 数量。原子访问。
+	adjustTimers uint32
+
+	// timers 堆中已删除的 timer 数量。原子访问。
+	deletedTimers uint32
+
+	// 执行 timer 函数时使用的 race context。
+	timerRaceCtx uintptr
+
+	// schedLatency 为在该 P 上开始运行的 goroutine 从 _Grunnable 到 _Grunning
+	// 的延迟分布（采样），供 runtime/metrics、runtime/debug 和 schedtrace 读取。原子更新。
+	schedLatency timeHistogram
+
 	pad cpu.CacheLinePad
 }
 
 type schedt struct {
 	// 应该被原子访问。保持在第一个字段来确保 32 位系统上的对齐
-	goidgen  uint64
-	lastpoll uint64
+	goidgen   uint64
+	lastpoll  uint64 // 上次网络轮询的时间，若当前正在轮询则为 0
+	pollUntil uint64 // 当前轮询休眠到的时间
 
 	lock mutex
 
@@ -724,6 +841,7 @@ const (
 	_TraceRuntimeFrames = 1 << iota // include frames for internal runtime functions.
 	_TraceTrap                      // the initial PC, SP are from a trap, not a return PC from a call
 	_TraceJumpStack                 // if traceback is on a systemstack, resume trace at g that called into it
+	_TraceSilentErrors              // with a callback, stop at frames that cannot be unwound instead of reporting them and throwing
 )
 
 // The maximum number of frames we print for a traceback
@@ -747,6 +865,7 @@ const (
 	waitReasonSelectNoCases                           // "select (no cases)"
 	waitReasonGCAssistWait                            // "GC assist wait"
 	waitReasonGCSweepWait                             // "GC sweep wait"
+	waitReasonGCScavengeWait                          // "GC scavenge wait"
 	waitReasonChanReceive                             // "chan receive"
 	waitReasonChanSend                                // "chan send"
 	waitReasonFinalizerWait                           // "finalizer wait"
@@ -758,6 +877,10 @@ const (
 	waitReasonTraceReaderBlocked                      // "trace reader (blocked)"
 	waitReasonWaitForGCCycle                          // "wait for GC cycle"
 	waitReasonGCWorkerIdle                            // "GC worker (idle)"
+	waitReasonMaxProcsIdle                            // "GOMAXPROCS updater (idle)"
+	waitReasonSynctestRun                             // "synctest.Run"
+	waitReasonSynctestWait                            // "synctest.Wait"
+	waitReasonGCNotifyWait                            // "GC notification wait"
 )
 
 var waitReasonStrings = [...]string{
@@ -774,6 +897,7 @@ var waitReasonStrings = [...]string{
 	waitReasonSelectNoCases:         "select (no cases)",
 	waitReasonGCAssistWait:          "GC assist wait",
 	waitReasonGCSweepWait:           "GC sweep wait",
+	waitReasonGCScavengeWait:        "GC scavenge wait",
 	waitReasonChanReceive:           "chan receive",
 	waitReasonChanSend:              "chan send",
 	waitReasonFinalizerWait:         "finalizer wait",
@@ -785,6 +909,10 @@ var waitReasonStrings = [...]string{
 	waitReasonTraceReaderBlocked:    "trace reader (blocked)",
 	waitReasonWaitForGCCycle:        "wait for GC cycle",
 	waitReasonGCWorkerIdle:          "GC worker (idle)",
+	waitReasonMaxProcsIdle:          "GOMAXPROCS updater (idle)",
+	waitReasonSynctestRun:           "synctest.Run",
+	waitReasonSynctestWait:          "synctest.Wait",
+	waitReasonGCNotifyWait:          "GC notification wait",
 }
 
 func (w waitReason) String() string {
@@ -0,0 +1,157 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 确定性调度
+//
+// 依赖于 goroutine 交错顺序的并发 bug 往往难以复现，因为 schedule 中
+// runqget、globrunqget 与 runqsteal 的顺序以及抢占的时机都取决于真实时间与线程调度。
+// GODEBUG=schedseed=N（N != 0）开启确定性调度模式：
+//
+// 1. GOMAXPROCS 被固定为 1，同一时刻只有一个 goroutine 在运行，也不存在窃取。
+//
+// 2. schedule 在 runnext、本地运行队列与全局运行队列中的所有可运行 G 里，
+//    由 PRNG 均匀地选择下一个运行的 G。
+//
+// 3. runqput 是否将 G 放入 runnext 由 PRNG 决定。
+//
+// 4. 抢占点由 PRNG 决定：运行中的 G 每经过随机数量的调度点（内存分配、
+//    channel 收发、创建 goroutine），就会在下一个函数调用处被同步抢占。
+//    sysmon 基于时间的抢占被放宽到 schedSeedForcePreemptNS，只用于避免死循环饿死其他 G。
+//
+// PRNG 的种子为 N，因此以相同的种子和相同的输入重新运行程序会得到相同的交错顺序。
+// 系统调用、网络轮询、timer 和 GC 仍然依赖于真实时间，可能引入不确定性，
+// 复现时建议同时设置 GOGC=off 并避免依赖真实时间。
+
+package runtime
+
+import "runtime/internal/atomic"
+
+const (
+	// schedSeedMaxBudget 为两个抢占点之间最多经过的调度点数量
+	schedSeedMaxBudget = 64
+
+	// schedSeedForcePreemptNS 为确定性调度模式下 sysmon 抢占长时间运行的 G 的阈值
+	schedSeedForcePreemptNS = 1000 * 1000 * 1000
+)
+
+var (
+	// schedSeedState 为 PRNG 的状态。
+	// 确定性调度模式下只有一个 P，因此只会被持有该 P 的 M 访问。
+	schedSeedState uint64
+
+	// schedSeedBudget 为距离下一个抢占点还剩的调度点数量
+	schedSeedBudget int32
+)
+
+// schedSeedInit 根据 GODEBUG=schedseed 初始化 PRNG。
+// 必须在 parsedebugvars 之后、第一次调度之前调用。
+func schedSeedInit() {
+	if debug.schedseed == 0 {
+		return
+	}
+	// 将种子打散，并保证状态非零
+	schedSeedState = uint64(uint32(debug.schedseed))*0x9E3779B97F4A7C15 | 1
+	schedSeedBudget = int32(schedSeedRand()%schedSeedMaxBudget) + 1
+}
+
+// schedSeedRand 返回下一个伪随机数（xorshift64*）。
+//
+//go:nosplit
+func schedSeedRand() uint32 {
+	x := schedSeedState
+	x ^= x >> 12
+	x ^= x << 25
+	x ^= x >> 27
+	schedSeedState = x
+	return uint32((x * 0x2545F4914F6CDD1D) >> 32)
+}
+
+// schedSeedPoint 为确定性调度模式下的一个调度点。
+// 当预算耗尽时，请求在下一个函数调用处抢占当前的用户 G。
+//
+//go:nosplit
+func schedSeedPoint() {
+	if debug.schedseed == 0 {
+		return
+	}
+	schedSeedBudget--
+	if schedSeedBudget > 0 {
+		return
+	}
+	schedSeedBudget = int32(schedSeedRand()%schedSeedMaxBudget) + 1
+	gp := getg()
+	if gp != gp.m.curg {
+		// 在系统栈上，没有可以抢占的用户 G
+		return
+	}
+	// 与 preemptone 相同，但针对的是自己。
+	// 若当前持有锁，releasem 会在锁释放时重新设置 stackguard0。
+	gp.preempt = true
+	gp.stackguard0 = stackPreempt
+}
+
+// schedSeedPick 在确定性调度模式下从 _p_ 的 runnext、本地运行队列与全局运行队列中
+// 随机选择并移除一个 G。若没有可运行的 G，返回 nil。
+//
+// 只有一个 P，因此本地队列不会被其他 P 窃取。
+func schedSeedPick(_p_ *p) *g {
+	lock(&sched.lock)
+	h := atomic.LoadAcq(&_p_.runqhead)
+	t := _p_.runqtail
+	local := t - h
+	n := local + uint32(sched.runqsize)
+	if _p_.runnext != 0 {
+		n++
+	}
+	if n == 0 {
+		unlock(&sched.lock)
+		return nil
+	}
+	k := schedSeedRand() % n
+
+	if _p_.runnext != 0 {
+		if k == 0 {
+			gp := _p_.runnext.ptr()
+			_p_.runnext = 0
+			unlock(&sched.lock)
+			return gp
+		}
+		k--
+	}
+	if k < local {
+		unlock(&sched.lock)
+		// 将第 k 个 G 交换到队首再出队
+		i, j := h%uint32(len(_p_.runq)), (h+k)%uint32(len(_p_.runq))
+		_p_.runq[i], _p_.runq[j] = _p_.runq[j], _p_.runq[i]
+		gp := _p_.runq[i].ptr()
+		atomic.StoreRel(&_p_.runqhead, h+1)
+		return gp
+	}
+	gp := globrunqremove(int32(k - local))
+	unlock(&sched.lock)
+	return gp
+}
+
+// globrunqremove 移除并返回全局运行队列中的第 k 个 G。
+//
+// sched.lock 必须被持有。
+func globrunqremove(k int32) *g {
+	var prev *g
+	gp := sched.runq.head.ptr()
+	for ; k > 0; k-- {
+		prev = gp
+		gp = gp.schedlink.ptr()
+	}
+	if prev == nil {
+		sched.runq.head = gp.schedlink
+	} else {
+		prev.schedlink = gp.schedlink
+	}
+	if sched.runq.tail.ptr() == gp {
+		sched.runq.tail.set(prev)
+	}
+	gp.schedlink = 0
+	sched.runqsize--
+	return gp
+}
@@ -0,0 +1,118 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package schedtest 帮助测试在运行时的确定性调度模式（GODEBUG=schedseed=N）下
+// 运行并发代码，以便复现依赖于 goroutine 交错顺序的 bug。
+//
+// 调度种子只在进程启动时读取，因此 Run 会以不同的 GODEBUG=schedseed 重新执行
+// 当前的测试二进制，只运行调用它的那个测试：
+//
+//	func TestTransfer(t *testing.T) {
+//		schedtest.Run(t, 100, func() {
+//			// 被测试的并发代码，通过 t 报告错误
+//		})
+//	}
+//
+// 若某个种子失败，Run 会报告该种子以及子进程的输出。之后可以通过
+//
+//	GODEBUG=schedseed=N go test -run '^TestTransfer$'
+//
+// 复现同样的交错顺序。
+package schedtest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// T 为 Run 需要的 *testing.T 的子集。
+type T interface {
+	Helper()
+	Name() string
+	Fatalf(format string, args ...interface{})
+}
+
+// Seed 返回当前进程的调度种子，0 表示没有开启确定性调度模式。
+func Seed() int {
+	seed := 0
+	for _, kv := range strings.Split(os.Getenv("GODEBUG"), ",") {
+		if strings.HasPrefix(kv, "schedseed=") {
+			// 与 runtime 一样，以最后一次出现的设置为准
+			seed, _ = strconv.Atoi(kv[len("schedseed="):])
+		}
+	}
+	return seed
+}
+
+// Run 以种子 1 到 seeds 依次在确定性调度模式下运行 fn。
+//
+// 若当前进程已经处于确定性调度模式（例如由 Run 启动的子进程，或者用户显式
+// 设置了 GODEBUG=schedseed），Run 只是直接调用 fn。否则 Run 为每个种子重新
+// 执行当前的测试二进制，并在第一个失败的种子处调用 t.Fatalf。
+func Run(t T, seeds int, fn func()) {
+	t.Helper()
+	if Seed() != 0 {
+		fn()
+		return
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("schedtest: cannot find test binary: %v", err)
+	}
+	for seed := 1; seed <= seeds; seed++ {
+		cmd := exec.Command(exe, "-test.run=^"+runPattern(t.Name())+"$", "-test.count=1")
+		cmd.Env = seedEnv(os.Environ(), seed)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("schedtest: failed with GODEBUG=schedseed=%d: %v\n%s", seed, err, out)
+		}
+	}
+}
+
+// runPattern 将测试名称转换为 -test.run 的模式：子测试的每一级分别转义。
+func runPattern(name string) string {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = quoteMeta(p)
+	}
+	return strings.Join(parts, "$/^")
+}
+
+// quoteMeta 转义正则表达式中的元字符，与 regexp.QuoteMeta 相同。
+func quoteMeta(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`\.+*?()|[]{}^$`, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// seedEnv 返回将 GODEBUG 中的 schedseed 设置为 seed 后的环境变量。
+func seedEnv(env []string, seed int) []string {
+	setting := fmt.Sprintf("schedseed=%d", seed)
+	out := make([]string, 0, len(env)+1)
+	found := false
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "GODEBUG=") {
+			if kv == "GODEBUG=" {
+				kv += setting
+			} else {
+				kv += "," + setting
+			}
+			found = true
+		}
+		out = append(out, kv)
+	}
+	if !found {
+		out = append(out, "GODEBUG="+setting)
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schedtest
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const interleavingPrefix = "interleaving: "
+
+// interleave 启动若干个 goroutine 交替地向同一个日志追加自己的 id，
+// 并返回最终的日志。每一步都包含 channel 收发，因此在确定性调度模式下
+// 日志的顺序完全由调度种子决定。
+func interleave() string {
+	const workers, steps = 4, 16
+	var (
+		mu  sync.Mutex
+		log []byte
+		wg  sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(id byte) {
+			defer wg.Done()
+			ch := make(chan int, 1)
+			for j := 0; j < steps; j++ {
+				mu.Lock()
+				log = append(log, id)
+				mu.Unlock()
+				ch <- j
+				<-ch
+			}
+		}('a' + byte(i))
+	}
+	wg.Wait()
+	return string(log)
+}
+
+// runInterleave 以 GODEBUG=schedseed=seed 重新执行当前测试，并返回子进程记录的交错顺序。
+func runInterleave(t *testing.T, exe string, seed int) string {
+	t.Helper()
+	cmd := exec.Command(exe, "-test.run=^"+runPattern(t.Name())+"$", "-test.count=1")
+	// 关闭 GC，避免后台标记 worker 引入依赖于真实时间的调度
+	cmd.Env = append(seedEnv(os.Environ(), seed), "GOGC=off")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("GODEBUG=schedseed=%d: %v\n%s", seed, err, out)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, interleavingPrefix) {
+			return line[len(interleavingPrefix):]
+		}
+	}
+	t.Fatalf("GODEBUG=schedseed=%d: no interleaving in output:\n%s", seed, out)
+	return ""
+}
+
+func TestSeedReproducesInterleaving(t *testing.T) {
+	if Seed() != 0 {
+		// 子进程：输出本次的交错顺序，由父进程比较
+		os.Stdout.WriteString(interleavingPrefix + interleave() + "\n")
+		return
+	}
+	if testing.Short() {
+		t.Skip("skipping in short mode: re-executes the test binary")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("cannot find test binary: %v", err)
+	}
+
+	const seeds = 5
+	seen := make(map[string]bool)
+	for seed := 1; seed <= seeds; seed++ {
+		first := runInterleave(t, exe, seed)
+		if again := runInterleave(t, exe, seed); again != first {
+			t.Errorf("seed %d produced different interleavings:\n\t%s\n\t%s", seed, first, again)
+		}
+		seen[first] = true
+	}
+	// 不同的种子应当探索不同的交错顺序，否则种子没有起作用
+	if len(seen) < 2 {
+		t.Errorf("%d seeds all produced the same interleaving", seeds)
+	}
+}
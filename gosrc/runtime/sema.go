@@ -143,7 +143,12 @@ func semacquire1(addr *uint32, lifo bool, profile semaProfileFlags) {
 		// Any semrelease after the cansemacquire knows we're waiting
 		// (we set nwait above), so go to sleep.
 		root.queue(addr, s, lifo)
+		// 泄漏检测通过 g.leakSudog 找到信号量的地址。
+		// 不能使用 g.waiting：copystack 会沿 waitlink 调整 g.waiting 中的 sudog，
+		// 而信号量 sudog 的 waitlink 链接的是同一地址上其他 goroutine 的 sudog。
+		gp.leakSudog = s
 		goparkunlock(&root.lock, waitReasonSemacquire, traceEvGoBlockSync, 4)
+		gp.leakSudog = nil
 		if s.ticket != 0 || cansemacquire(addr) {
 			break
 		}
@@ -223,14 +228,14 @@ func cansemacquire(addr *uint32) bool {
 // queue adds s to the blocked goroutines in semaRoot.
 func (root *semaRoot) queue(addr *uint32, s *sudog, lifo bool) {
 	s.g = getg()
-	s.elem = unsafe.Pointer(addr)
+	s.elem.set(unsafe.Pointer(addr))
 	s.next = nil
 	s.prev = nil
 
 	var last *sudog
 	pt := &root.treap
 	for t := *pt; t != nil; t = *pt {
-		if t.elem == unsafe.Pointer(addr) {
+		if t.elem.get() == unsafe.Pointer(addr) {
 			// Already have addr in list.
 			if lifo {
 				// Substitute s in t's place in treap.
@@ -269,7 +274,7 @@ func (root *semaRoot) queue(addr *uint32, s *sudog, lifo bool) {
 			return
 		}
 		last = t
-		if uintptr(unsafe.Pointer(addr)) < uintptr(t.elem) {
+		if uintptr(unsafe.Pointer(addr)) < uintptr(t.elem.get()) {
 			pt = &t.prev
 		} else {
 			pt = &t.next
@@ -312,10 +317,10 @@ func (root *semaRoot) dequeue(addr *uint32) (found *sudog, now int64) {
 	ps := &root.treap
 	s := *ps
 	for ; s != nil; s = *ps {
-		if s.elem == unsafe.Pointer(addr) {
+		if s.elem.get() == unsafe.Pointer(addr) {
 			goto Found
 		}
-		if uintptr(unsafe.Pointer(addr)) < uintptr(s.elem) {
+		if uintptr(unsafe.Pointer(addr)) < uintptr(s.elem.get()) {
 			ps = &s.prev
 		} else {
 			ps = &s.next
@@ -370,7 +375,7 @@ Found:
 		}
 	}
 	s.parent = nil
-	s.elem = nil
+	s.elem.set(nil)
 	s.next = nil
 	s.prev = nil
 	s.ticket = 0
@@ -513,8 +518,14 @@ func notifyListWait(l *notifyList, t uint32) {
 		l.tail.next = s
 	}
 	l.tail = s
+	// 泄漏检测通过 g.leakSudog 找到 notifyList 的地址，见 semacquire1
+	gp := s.g
+	s.elem.set(unsafe.Pointer(l))
+	gp.leakSudog = s
 	// 将 M/P/G 解绑，并将 G 调整为等待状态，放入 sudog 等待队列中
 	goparkunlock(&l.lock, waitReasonSyncCondWait, traceEvGoBlockCond, 3)
+	gp.leakSudog = nil
+	s.elem.set(nil)
 	if t0 != 0 {
 		blockevent(s.releasetime-t0, 2)
 	}
@@ -79,3 +79,15 @@ func (c *sigctxt) preparePanic(sig uint32, gp *g) {
 	}
 	c.set_rip(uint64(funcPC(sigpanic)))
 }
+
+// pushCall makes it look like the interrupted instruction called
+// targetPC, so that returning from targetPC resumes the interrupted
+// code. It is used to inject asyncPreempt.
+func (c *sigctxt) pushCall(targetPC uintptr) {
+	pc := uintptr(c.rip())
+	sp := uintptr(c.rsp())
+	sp -= sys.PtrSize
+	*(*uintptr)(unsafe.Pointer(sp)) = pc
+	c.set_rsp(uint64(sp))
+	c.set_rip(uint64(targetPC))
+}
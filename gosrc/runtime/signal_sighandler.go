@@ -45,6 +45,14 @@ func sighandler(sig uint32, info *siginfo, ctxt unsafe.Pointer, gp *g) {
 		return
 	}
 
+	if sig == sigPreempt && debug.asyncpreemptoff == 0 {
+		// Might be a preemption signal.
+		doSigPreempt(gp, c)
+		// Even if this was definitely a preemption signal, it
+		// may have been coalesced with another signal, so we
+		// still let it through to the application.
+	}
+
 	if sig == _SIGTRAP && testSigtrap != nil && testSigtrap(info, (*sigctxt)(noescape(unsafe.Pointer(c))), gp) {
 		return
 	}
@@ -108,32 +116,47 @@ func sighandler(sig uint32, info *siginfo, ctxt unsafe.Pointer, gp *g) {
 		startpanic_m()
 	}
 
-	if sig < uint32(len(sigtable)) {
-		print(sigtable[sig].name, "\n")
+	level, _, docrash := gotraceback()
+	if crashJSON() {
+		// 信号信息与 traceback 包含在崩溃报告中
+		if _g_.m.lockedg != 0 && _g_.m.ncgo > 0 && gp == _g_.m.g0 {
+			gp = _g_.m.lockedg.ptr()
+		}
+		if crashing == 0 {
+			cs := crashSignal{sig, uintptr(c.sigcode()), uintptr(c.sigaddr()), c.sigpc()}
+			printCrashReport(gp, c.sigpc(), c.sigsp(), c.siglr(), _TraceTrap, nil, cs, true)
+		}
 	} else {
-		print("Signal ", sig, "\n")
-	}
-
-	print("PC=", hex(c.sigpc()), " m=", _g_.m.id, " sigcode=", c.sigcode(), "\n")
-	if _g_.m.lockedg != 0 && _g_.m.ncgo > 0 && gp == _g_.m.g0 {
-		print("signal arrived during cgo execution\n")
-		gp = _g_.m.lockedg.ptr()
-	}
-	print("\n")
+		if sig < uint32(len(sigtable)) {
+			print(sigtable[sig].name, "\n")
+		} else {
+			print("Signal ", sig, "\n")
+		}
 
-	level, _, docrash := gotraceback()
-	if level > 0 {
-		goroutineheader(gp)
-		tracebacktrap(c.sigpc(), c.sigsp(), c.siglr(), gp)
-		if crashing > 0 && gp != _g_.m.curg && _g_.m.curg != nil && readgstatus(_g_.m.curg)&^_Gscan == _Grunning {
-			// tracebackothers on original m skipped this one; trace it now.
-			goroutineheader(_g_.m.curg)
-			traceback(^uintptr(0), ^uintptr(0), 0, _g_.m.curg)
-		} else if crashing == 0 {
-			tracebackothers(gp)
-			print("\n")
+		print("PC=", hex(c.sigpc()), " m=", _g_.m.id, " sigcode=", c.sigcode(), "\n")
+		if _g_.m.lockedg != 0 && _g_.m.ncgo > 0 && gp == _g_.m.g0 {
+			print("signal arrived during cgo execution\n")
+			gp = _g_.m.lockedg.ptr()
+		}
+		if sig == _SIGSEGV || sig == _SIGBUS {
+			// 例如 C 代码访问了保护分配的对象
+			printGuardFault(uintptr(c.sigaddr()))
+		}
+		print("\n")
+
+		if level > 0 {
+			goroutineheader(gp)
+			tracebacktrap(c.sigpc(), c.sigsp(), c.siglr(), gp)
+			if crashing > 0 && gp != _g_.m.curg && _g_.m.curg != nil && readgstatus(_g_.m.curg)&^_Gscan == _Grunning {
+				// tracebackothers on original m skipped this one; trace it now.
+				goroutineheader(_g_.m.curg)
+				traceback(^uintptr(0), ^uintptr(0), 0, _g_.m.curg)
+			} else if crashing == 0 {
+				tracebackothers(gp)
+				print("\n")
+			}
+			dumpregs(c)
 		}
-		dumpregs(c)
 	}
 
 	if docrash {
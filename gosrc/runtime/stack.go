@@ -734,7 +734,8 @@ func adjustsudogs(gp *g, adjinfo *adjustinfo) {
 	// the data elements pointed to by a SudoG structure
 	// might be in the stack.
 	for s := gp.waiting; s != nil; s = s.waitlink {
-		adjustpointer(adjinfo, unsafe.Pointer(&s.elem))
+		adjustpointer(adjinfo, unsafe.Pointer(&s.elem.vu))
+		adjustpointer(adjinfo, unsafe.Pointer(&s.elem.vp))
 	}
 }
 
@@ -747,7 +748,7 @@ func fillstack(stk stack, b byte) {
 func findsghi(gp *g, stk stack) uintptr {
 	var sghi uintptr
 	for sg := gp.waiting; sg != nil; sg = sg.waitlink {
-		p := uintptr(sg.elem) + uintptr(sg.c.elemsize)
+		p := uintptr(sg.elem.get()) + uintptr(sg.c.get().elemsize)
 		if stk.lo <= p && p < stk.hi && p > sghi {
 			sghi = p
 		}
@@ -770,10 +771,10 @@ func syncadjustsudogs(gp *g, used uintptr, adjinfo *adjustinfo) uintptr {
 	// self-deadlock.
 	var lastc *hchan
 	for sg := gp.waiting; sg != nil; sg = sg.waitlink {
-		if sg.c != lastc {
-			lock(&sg.c.lock)
+		if sg.c.get() != lastc {
+			lock(&sg.c.get().lock)
 		}
-		lastc = sg.c
+		lastc = sg.c.get()
 	}
 
 	// Adjust sudogs.
@@ -793,10 +794,10 @@ func syncadjustsudogs(gp *g, used uintptr, adjinfo *adjustinfo) uintptr {
 	// Unlock channels.
 	lastc = nil
 	for sg := gp.waiting; sg != nil; sg = sg.waitlink {
-		if sg.c != lastc {
-			unlock(&sg.c.lock)
+		if sg.c.get() != lastc {
+			unlock(&sg.c.get().lock)
 		}
-		lastc = sg.c
+		lastc = sg.c.get()
 	}
 
 	return sgsize
@@ -1030,10 +1031,15 @@ func newstack() {
 	oldsize := gp.stack.hi - gp.stack.lo
 	newsize := oldsize * 2 // 两倍于原来的大小
 	if newsize > maxstacksize {
-		print("runtime: goroutine stack exceeds ", maxstacksize, "-byte limit\n")
+		// 给出栈的上限与实际使用的大小，帧数由 traceback 给出，见 tracebackCompact
+		print("runtime: goroutine stack exceeds ", maxstacksize, "-byte limit (set by runtime/debug.SetMaxStack)\n")
+		print("runtime: sp=", hex(sp), " stack=[", hex(gp.stack.lo), ", ", hex(gp.stack.hi), "], ", gp.stack.hi-sp, " bytes in use\n")
 		throw("stack overflow")
 	}
 
+	// 记录到栈使用 profile 中，见 stackprof.go
+	stackProfGrow(gp, oldsize, newsize)
+
 	// goroutine 必须是正在执行过程中才来调用 newstack
 	// 所以这个状态一定是 Grunning 或 Gscanrunning
 	casgstatus(gp, _Grunning, _Gcopystack)
@@ -1088,6 +1094,12 @@ func shrinkstack(gp *g) {
 	if debug.gcshrinkstackoff > 0 {
 		return
 	}
+	if gp.asyncSafePoint {
+		// The frames stopped at an asynchronous safe-point have
+		// no precise pointer maps, so we can't adjust their
+		// pointers when copying the stack.
+		return
+	}
 	f := findfunc(gp.startpc)
 	if f.valid() && f.funcID == funcID_gcBgMarkWorker {
 		// We're not allowed to shrink the gcBgMarkWorker
@@ -1300,3 +1312,40 @@ type stackObjectRecord struct {
 func morestackc() {
 	throw("attempt to execute system stack code on user stack")
 }
+
+// startingStackSize 为新 goroutine 的初始栈大小。
+// 在 GODEBUG=adaptivestackstart=1（默认）时由 gcComputeStartingStackSize 在每个 GC 周期结束时更新，
+// 否则总是 _FixedStack。只在世界停止时修改。
+var startingStackSize = uint32(_FixedStack)
+
+// gcComputeStartingStackSize 根据本周期扫描到的栈的平均使用量计算新 goroutine 的初始栈大小。
+// 大部分 goroutine 的栈都会增长到相近的大小时（例如 RPC 处理函数），新的 goroutine 可以
+// 直接从这个大小开始，省去逐次翻倍的 copystack。使用量少的 goroutine 的栈会在之后的 GC 中被收缩。
+//
+// 在标记终止阶段、世界停止时调用。
+func gcComputeStartingStackSize() {
+	if debug.adaptivestackstart == 0 {
+		return
+	}
+	var scannedStackSize, scannedStacks uint64
+	for _, p := range allp {
+		scannedStackSize += p.scannedStackSize
+		scannedStacks += p.scannedStacks
+		p.scannedStackSize = 0
+		p.scannedStacks = 0
+	}
+	if scannedStacks == 0 {
+		startingStackSize = _FixedStack
+		return
+	}
+	// 加上 _StackGuard，使使用量为平均值的 goroutine 不会触发栈增长
+	avg := scannedStackSize/scannedStacks + _StackGuard
+	if avg > uint64(maxstacksize) {
+		avg = uint64(maxstacksize)
+	}
+	if avg < _FixedStack {
+		avg = _FixedStack
+	}
+	// maxstacksize 不超过 1GB，因此 avg 不会溢出 int32
+	startingStackSize = uint32(round2(int32(avg)))
+}
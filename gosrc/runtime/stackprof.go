@@ -0,0 +1,146 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 栈使用 profile。
+//
+// newstack 每次将 goroutine 的栈扩大一倍时调用 stackProfGrow，按创建 goroutine 的
+// go 语句（g.gopc）汇总：栈增长过的 goroutine 个数、增长的总次数，以及栈达到的最大大小。
+// 栈第一次增长到某个创建位置的最大大小时，还会记录此时 goroutine 的调用栈，
+// 从而可以看出是哪条调用路径需要这么大的栈。
+//
+// 栈只会成倍增长，因此每个创建位置最多记录 log2(maxstacksize/_StackMin) 次调用栈，
+// 其余的增长只需要在锁内更新几个计数。从未增长过栈的 goroutine 不会出现在 profile 中。
+//
+// 与阻塞和互斥锁 profile 一样，栈使用 profile 默认关闭，由 SetStackProfileEnabled 开启。
+// 关闭时 stackProfGrow 只读取一个原子变量，不会获取 stackProf.lock，
+// 因此栈增长不会在所有 P 之间串行化。
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+const stackProfHashSize = 1021
+
+// stackProfBucket 汇总了在同一个位置创建的 goroutine 的栈增长。
+//
+//go:notinheap
+type stackProfBucket struct {
+	next    *stackProfBucket // 哈希链
+	allnext *stackProfBucket // stackProf.all 链表
+
+	gopc       uintptr // 创建 goroutine 的 go 语句的 pc
+	goroutines int64   // 栈增长过的 goroutine 个数
+	growths    int64   // 栈增长的总次数
+	peak       uintptr // 栈达到的最大大小
+
+	// stk 为栈第一次增长到 peak 时 goroutine 的调用栈
+	nstk int
+	stk  [maxStack]uintptr
+}
+
+var stackProf struct {
+	enabled uint32 // 非零表示记录栈增长，原子访问
+
+	lock mutex
+	hash *[stackProfHashSize]*stackProfBucket
+	all  *stackProfBucket
+}
+
+// stackProfGrow 记录 gp 的栈从 oldsize 增长到 newsize。由 newstack 在 g0 上调用。
+func stackProfGrow(gp *g, oldsize, newsize uintptr) {
+	if atomic.Load(&stackProf.enabled) == 0 {
+		return
+	}
+	first := !gp.stackGrown
+	gp.stackGrown = true
+
+	lock(&stackProf.lock)
+	b := stackProfBucketFor(gp.gopc)
+	if first {
+		b.goroutines++
+	}
+	b.growths++
+	if newsize > b.peak {
+		b.peak = newsize
+		b.nstk = gcallers(gp, 0, b.stk[:])
+	}
+	unlock(&stackProf.lock)
+}
+
+// stackProfBucketFor 返回创建位置 gopc 对应的桶，必要时创建它。
+// 调用者必须持有 stackProf.lock。
+func stackProfBucketFor(gopc uintptr) *stackProfBucket {
+	if stackProf.hash == nil {
+		stackProf.hash = (*[stackProfHashSize]*stackProfBucket)(persistentalloc(unsafe.Sizeof(*stackProf.hash), sys.PtrSize, &memstats.buckhash_sys))
+	}
+	i := gopc % stackProfHashSize
+	for b := stackProf.hash[i]; b != nil; b = b.next {
+		if b.gopc == gopc {
+			return b
+		}
+	}
+	b := (*stackProfBucket)(persistentalloc(unsafe.Sizeof(stackProfBucket{}), sys.PtrSize, &memstats.buckhash_sys))
+	b.gopc = gopc
+	b.next = stackProf.hash[i]
+	stackProf.hash[i] = b
+	b.allnext = stackProf.all
+	stackProf.all = b
+	return b
+}
+
+// SetStackProfileEnabled 开启或关闭栈使用 profile，返回之前的设置。
+// profile 默认关闭，关闭期间的栈增长不会被记录，已经记录的数据会被保留。
+func SetStackProfileEnabled(enabled bool) bool {
+	var v uint32
+	if enabled {
+		v = 1
+	}
+	return atomic.Xchg(&stackProf.enabled, v) != 0
+}
+
+// StackProfileRecord 描述了在同一个位置创建的 goroutine 的栈使用情况。
+type StackProfileRecord struct {
+	CreatedBy  uintptr // 创建这些 goroutine 的 go 语句的 PC，0 表示 main goroutine
+	Goroutines int64   // 栈增长过的 goroutine 个数
+	Growths    int64   // 栈增长的总次数
+	PeakBytes  int64   // 这些 goroutine 的栈达到的最大大小
+
+	// 栈第一次增长到 PeakBytes 时 goroutine 的调用栈
+	StackRecord
+}
+
+// StackProfile 返回 n，即当前栈使用 profile 中的记录数，每个创建 goroutine 的位置一条记录。
+// 若 len(p) >= n，StackProfile 将 profile 复制到 p 中并返回 n, true。
+// 若 len(p) < n，StackProfile 不修改 p 并返回 n, false。
+//
+// 只记录 profile 开启期间（见 SetStackProfileEnabled）栈增长过的 goroutine，
+// 栈的大小与增长次数自开启时开始累积，已经退出的 goroutine 同样计算在内。可以据此估计某一类 goroutine 需要的栈大小，
+// 例如为工作池设置大小：PeakBytes 为在该位置创建的所有 goroutine 中最大的栈。
+func StackProfile(p []StackProfileRecord) (n int, ok bool) {
+	lock(&stackProf.lock)
+	for b := stackProf.all; b != nil; b = b.allnext {
+		n++
+	}
+	if n <= len(p) {
+		ok = true
+		for b := stackProf.all; b != nil; b = b.allnext {
+			r := &p[0]
+			r.CreatedBy = b.gopc
+			r.Goroutines = b.goroutines
+			r.Growths = b.growths
+			r.PeakBytes = int64(b.peak)
+			i := copy(r.Stack0[:], b.stk[:b.nstk])
+			for ; i < len(r.Stack0); i++ {
+				r.Stack0[i] = 0
+			}
+			p = p[1:]
+		}
+	}
+	unlock(&stackProf.lock)
+	return
+}
@@ -0,0 +1,375 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// 虚拟时间气泡
+//
+// testing/synctest.Run 在一个「气泡」（bubble）中运行一组 goroutine。
+// 气泡拥有自己的假时钟和 timer 堆：
+//
+// 1. 在气泡中创建的 goroutine 同样属于该气泡（系统 goroutine 除外）。
+//
+// 2. 气泡中的 goroutine 调用 time.Now、time.Sleep 以及创建 Timer/Ticker 时，
+//    使用的是气泡的假时钟，timer 被放入气泡的 timer 堆中，而非 P 的 timer 堆。
+//
+// 3. 当气泡中的所有 goroutine 都「持久阻塞」（durably blocked）时，即只能被气泡中
+//    的其他 goroutine 唤醒，假时钟立即前进到下一个 timer 的触发时间并运行该 timer。
+//    持久阻塞包括阻塞在 channel 收发、select、time.Sleep、sync.Cond 以及信号量
+//    （sync.Mutex、sync.WaitGroup）上。阻塞在系统调用或网络 I/O 上不属于持久阻塞，
+//    此时假时钟不会前进。
+//
+// 4. 若所有 goroutine 都持久阻塞且没有 timer，则气泡发生了死锁，Run 会 panic。
+//
+// 气泡内的 goroutine 数量与其中未持久阻塞的数量由 casgstatus 维护。
+// 在 goroutine 进入 _Gwaiting 到 park_m 执行完 unlockf 之间，它可能还没有把自己
+// 挂到等待队列或 timer 堆上（例如 resetForSleep），此时不能认为气泡是空闲的，
+// 因此 park_m 在这段时间内持有一个 active 计数。
+
+package runtime
+
+import "unsafe"
+
+// synctestBaseTime 为假时钟的初始值：2000-01-01 00:00:00 UTC
+const synctestBaseTime = 946684800000000000
+
+// synctestGroup 表示一个气泡
+type synctestGroup struct {
+	mu mutex
+
+	timers []*timer // 气泡的 timer 堆，以 when 排序
+	now    int64    // 假时钟（纳秒），同时作为 time.Now 的墙上时间和单调时间
+
+	root    *g   // 运行 synctest.Run 的 goroutine
+	waiter  *g   // 在 synctest.Wait 中等待的 goroutine
+	waiting bool // 有 goroutine 正在调用 synctest.Wait
+
+	total   int // 气泡中的 goroutine 数量，包括 root
+	running int // 气泡中未持久阻塞的 goroutine 数量
+	active  int // 阻止气泡被认为空闲的计数，见 maybeWakeLocked
+}
+
+// curSyncGroup 返回当前用户 goroutine 所在的气泡。
+// 在系统栈上调用时（例如在运行气泡的 timer 时）返回 m.curg 所在的气泡。
+//
+//go:nosplit
+func curSyncGroup() *synctestGroup {
+	if gp := getg().m.curg; gp != nil {
+		return gp.syncGroup
+	}
+	return nil
+}
+
+// isIdleInSynctest 报告因 w 而等待的 goroutine 是否为持久阻塞，
+// 即只能被同一气泡中的其他 goroutine 唤醒。
+//
+//go:nosplit
+func (w waitReason) isIdleInSynctest() bool {
+	switch w {
+	case waitReasonChanReceiveNilChan,
+		waitReasonChanSendNilChan,
+		waitReasonSelect,
+		waitReasonSelectNoCases,
+		waitReasonChanReceive,
+		waitReasonChanSend,
+		waitReasonSemacquire,
+		waitReasonSleep,
+		waitReasonSyncCondWait,
+		waitReasonSynctestRun,
+		waitReasonSynctestWait:
+		return true
+	}
+	return false
+}
+
+// changegstatus 在气泡中的 gp 状态从 oldval 变为 newval 时由 casgstatus 调用，
+// 维护 total 与 running。
+//
+//go:nosplit
+func (sg *synctestGroup) changegstatus(gp *g, oldval, newval uint32) {
+	totalDelta := 0
+	wasRunning := true
+	switch oldval {
+	case _Gdead:
+		wasRunning = false
+		totalDelta++
+	case _Gwaiting:
+		if gp.waitreason.isIdleInSynctest() {
+			wasRunning = false
+		}
+	}
+	isRunning := true
+	switch newval {
+	case _Gdead:
+		isRunning = false
+		totalDelta--
+	case _Gwaiting:
+		if gp.waitreason.isIdleInSynctest() {
+			isRunning = false
+		}
+	}
+	if wasRunning == isRunning && totalDelta == 0 {
+		// 不影响气泡是否空闲，无需加锁
+		return
+	}
+	systemstack(func() {
+		lock(&sg.mu)
+		sg.total += totalDelta
+		if wasRunning != isRunning {
+			if isRunning {
+				sg.running++
+			} else {
+				sg.running--
+			}
+		}
+		wake := sg.maybeWakeLocked()
+		unlock(&sg.mu)
+		if wake != nil {
+			goready(wake, 0)
+		}
+	})
+}
+
+// incActive 与 decActive 增减 active 计数
+func (sg *synctestGroup) incActive() {
+	lock(&sg.mu)
+	sg.active++
+	unlock(&sg.mu)
+}
+
+func (sg *synctestGroup) decActive() {
+	lock(&sg.mu)
+	sg.active--
+	if sg.active < 0 {
+		throw("synctest: active < 0")
+	}
+	wake := sg.maybeWakeLocked()
+	unlock(&sg.mu)
+	if wake != nil {
+		goready(wake, 0)
+	}
+}
+
+// maybeWakeLocked 在气泡空闲时返回需要唤醒的 goroutine：
+// 优先唤醒 synctest.Wait 的调用者，否则唤醒 root 以推进假时钟。
+// 被唤醒的 goroutine 持有一个 active 计数，直到它重新运行后释放，
+// 从而避免在它被唤醒的途中被重复唤醒。
+//
+// sg.mu 必须被持有。
+func (sg *synctestGroup) maybeWakeLocked() *g {
+	if sg.running > 0 || sg.active > 0 {
+		return nil
+	}
+	sg.active++
+	if gp := sg.waiter; gp != nil {
+		sg.waiter = nil
+		return gp
+	}
+	return sg.root
+}
+
+//go:linkname synctestRun testing/synctest.run
+func synctestRun(f func()) {
+	gp := getg()
+	if gp.syncGroup != nil {
+		panic("synctest.Run called from within a synctest bubble")
+	}
+	sg := &synctestGroup{
+		now:     synctestBaseTime,
+		root:    gp,
+		total:   1,
+		running: 1,
+	}
+	gp.syncGroup = sg
+	// 当前 goroutine 的 timer 属于真实时间
+	gp.timer = nil
+
+	fv := *(**funcval)(unsafe.Pointer(&f))
+	pc := getcallerpc()
+	systemstack(func() {
+		newproc1(fv, nil, 0, gp, pc)
+	})
+
+	for {
+		// 运行已到期的 timer。
+		// 在系统栈上运行时 m.curg 仍为 root，因此 timer 函数（例如 sendTime）观察到的是假时钟，
+		// AfterFunc 创建的 goroutine 也属于该气泡。
+		systemstack(func() {
+			sg.runtimers()
+		})
+		gopark(synctestidle_c, nil, waitReasonSynctestRun, traceEvGoBlock, 0)
+
+		lock(&sg.mu)
+		// 释放唤醒 root 时持有的 active 计数
+		sg.active--
+		if sg.total == 1 {
+			// 除 root 外的所有 goroutine 都已退出
+			unlock(&sg.mu)
+			break
+		}
+		if len(sg.timers) == 0 {
+			unlock(&sg.mu)
+			gp.syncGroup = nil
+			gp.timer = nil
+			panic("deadlock: all goroutines in bubble are blocked")
+		}
+		if next := sg.timers[0].when; next > sg.now {
+			sg.now = next
+		}
+		unlock(&sg.mu)
+	}
+
+	gp.syncGroup = nil
+	gp.timer = nil
+}
+
+// synctestidle_c 为 root 等待气泡空闲时的 unlockf。
+// 若气泡已经空闲（只剩 park_m 持有的 active 计数），则不 park，直接继续推进假时钟。
+func synctestidle_c(gp *g, _ unsafe.Pointer) bool {
+	sg := gp.syncGroup
+	lock(&sg.mu)
+	canIdle := true
+	if sg.running == 0 && sg.active == 1 {
+		// 与 maybeWakeLocked 相同，为 root 持有一个 active 计数
+		sg.active++
+		canIdle = false
+	}
+	unlock(&sg.mu)
+	return canIdle
+}
+
+//go:linkname synctestWait testing/synctest.wait
+func synctestWait() {
+	gp := getg()
+	sg := gp.syncGroup
+	if sg == nil {
+		panic("goroutine is not in a bubble")
+	}
+	lock(&sg.mu)
+	// 使用 waiting 而非 waiter 检测并发的 Wait 调用，
+	// 因为 waiter 要到 park 时才会被设置。
+	if sg.waiting {
+		unlock(&sg.mu)
+		panic("wait already in progress")
+	}
+	sg.waiting = true
+	unlock(&sg.mu)
+
+	gopark(synctestwait_c, nil, waitReasonSynctestWait, traceEvGoBlock, 0)
+
+	lock(&sg.mu)
+	// 释放唤醒时持有的 active 计数
+	sg.active--
+	if sg.active < 0 {
+		throw("synctest: active < 0")
+	}
+	sg.waiter = nil
+	sg.waiting = false
+	unlock(&sg.mu)
+}
+
+// synctestwait_c 为 synctest.Wait 的 unlockf
+func synctestwait_c(gp *g, _ unsafe.Pointer) bool {
+	sg := gp.syncGroup
+	lock(&sg.mu)
+	if sg.running == 0 && sg.active == 0 {
+		// park_m 在 unlockf 期间持有 active 计数，不可能发生
+		throw("synctest: running == 0 && active == 0")
+	}
+	sg.waiter = gp
+	unlock(&sg.mu)
+	return true
+}
+
+// 气泡的 timer。
+//
+// 气泡的 timer 只会被气泡中的 goroutine 和 root 访问，使用 sg.mu 保护，
+// 因此不需要 P 上的 timer 所使用的状态机：timer 在堆中时状态为 timerWaiting，
+// 否则为 timerNoStatus，删除时直接从堆中移除。
+
+// addtimer 将 t 加入气泡的 timer 堆
+func (sg *synctestGroup) addtimer(t *timer) {
+	lock(&sg.mu)
+	sg.doaddtimer(t)
+	unlock(&sg.mu)
+}
+
+// deltimer 将 t 从气泡的 timer 堆中移除。
+// 报告 t 是否在运行之前被移除。
+func (sg *synctestGroup) deltimer(t *timer) bool {
+	lock(&sg.mu)
+	ok := sg.dodeltimer(t)
+	unlock(&sg.mu)
+	return ok
+}
+
+// modtimer 修改 t，若 t 不在堆中则将其加入。
+func (sg *synctestGroup) modtimer(t *timer, when, period int64, f func(interface{}, uintptr), arg interface{}, seq uintptr) {
+	lock(&sg.mu)
+	sg.dodeltimer(t)
+	t.when = when
+	t.period = period
+	t.f = f
+	t.arg = arg
+	t.seq = seq
+	sg.doaddtimer(t)
+	unlock(&sg.mu)
+}
+
+// sg.mu 必须被持有
+func (sg *synctestGroup) doaddtimer(t *timer) {
+	t.status = timerWaiting
+	i := len(sg.timers)
+	sg.timers = append(sg.timers, t)
+	if !siftupTimer(sg.timers, i) {
+		badTimer()
+	}
+}
+
+// sg.mu 必须被持有
+func (sg *synctestGroup) dodeltimer(t *timer) bool {
+	if t.status != timerWaiting {
+		return false
+	}
+	for i, tt := range sg.timers {
+		if tt != t {
+			continue
+		}
+		last := len(sg.timers) - 1
+		if i != last {
+			sg.timers[i] = sg.timers[last]
+		}
+		sg.timers[last] = nil
+		sg.timers = sg.timers[:last]
+		if i != last {
+			siftupTimer(sg.timers, i)
+			siftdownTimer(sg.timers, i)
+		}
+		t.status = timerNoStatus
+		return true
+	}
+	badTimer()
+	return false
+}
+
+// runtimers 运行所有在 sg.now 之前到期的 timer。
+//
+//go:systemstack
+func (sg *synctestGroup) runtimers() {
+	lock(&sg.mu)
+	for len(sg.timers) > 0 && sg.timers[0].when <= sg.now {
+		t := sg.timers[0]
+		f, arg, seq := t.f, t.arg, t.seq
+		if t.period > 0 {
+			// 留在堆中，调整下一次的触发时间
+			delta := t.when - sg.now
+			t.when += t.period * (1 + -delta/t.period)
+			siftdownTimer(sg.timers, 0)
+		} else {
+			sg.dodeltimer(t)
+		}
+		unlock(&sg.mu)
+		f(arg, seq)
+		lock(&sg.mu)
+	}
+	unlock(&sg.mu)
+}
@@ -13,6 +13,10 @@ import _ "unsafe" // for go:linkname
 
 //go:linkname time_now time.now
 func time_now() (sec int64, nsec int32, mono int64) {
+	if sg := curSyncGroup(); sg != nil {
+		// synctest 气泡中的 goroutine 观察到的是气泡的假时钟
+		return sg.now / 1e9, int32(sg.now % 1e9), sg.now
+	}
 	sec, nsec = walltime()
 	return sec, nsec, nanotime()
 }
@@ -136,8 +136,14 @@ func gentraceback(pc0, sp0, lr0 uintptr, gp *g, skip int, pcbuf *uintptr, max in
 		frame.lr = lr0
 	}
 	waspanic := false
+	// injectedCall is set if the frame below the current one was
+	// injected by a signal handler (sigpanic or asyncPreempt), in
+	// which case frame.pc is not a return address.
+	injectedCall := false
 	cgoCtxt := gp.cgoCtxt
 	printing := pcbuf == nil && callback == nil
+	// strict 表示遇到无法回溯的帧时报告并 throw，见 _TraceSilentErrors
+	strict := callback != nil && flags&_TraceSilentErrors == 0
 	_defer := gp._defer
 
 	for _defer != nil && _defer.sp == _NoArgs {
@@ -158,11 +164,11 @@ func gentraceback(pc0, sp0, lr0 uintptr, gp *g, skip int, pcbuf *uintptr, max in
 
 	f := findfunc(frame.pc)
 	if !f.valid() {
-		if callback != nil || printing {
+		if strict || printing {
 			print("runtime: unknown pc ", hex(frame.pc), "\n")
 			tracebackHexdump(gp.stack, &frame, 0)
 		}
-		if callback != nil {
+		if strict {
 			throw("unknown pc")
 		}
 		return 0
@@ -229,7 +235,7 @@ func gentraceback(pc0, sp0, lr0 uintptr, gp *g, skip int, pcbuf *uintptr, max in
 			// and get confused. Stop if we see PC within jmpdefer
 			// to avoid that confusion.
 			// See golang.org/issue/8153.
-			if callback != nil {
+			if strict {
 				throw("traceback_arm: found jmpdefer when tracing with callback")
 			}
 			frame.lr = 0
@@ -260,11 +266,11 @@ func gentraceback(pc0, sp0, lr0 uintptr, gp *g, skip int, pcbuf *uintptr, max in
 					// return PC. Don't complain.
 					doPrint = false
 				}
-				if callback != nil || doPrint {
+				if strict || doPrint {
 					print("runtime: unexpected return pc for ", funcname(f), " called from ", hex(frame.lr), "\n")
 					tracebackHexdump(gp.stack, &frame, lrPtr)
 				}
-				if callback != nil {
+				if strict {
 					throw("unknown caller pc")
 				}
 			}
@@ -340,7 +346,7 @@ func gentraceback(pc0, sp0, lr0 uintptr, gp *g, skip int, pcbuf *uintptr, max in
 			pc := frame.pc
 			// backup to CALL instruction to read inlining info (same logic as below)
 			tracepc := pc
-			if (n > 0 || flags&_TraceTrap == 0) && frame.pc > f.entry && !waspanic {
+			if (n > 0 || flags&_TraceTrap == 0) && frame.pc > f.entry && !injectedCall {
 				tracepc--
 			}
 			// If there is inlining info, record the inner frames.
@@ -390,7 +396,7 @@ func gentraceback(pc0, sp0, lr0 uintptr, gp *g, skip int, pcbuf *uintptr, max in
 
 			// backup to CALL instruction to read inlining info (same logic as below)
 			tracepc := frame.pc
-			if (n > 0 || flags&_TraceTrap == 0) && frame.pc > f.entry && !waspanic {
+			if (n > 0 || flags&_TraceTrap == 0) && frame.pc > f.entry && !injectedCall {
 				tracepc--
 			}
 			// If there is inlining info, print the inner frames.
@@ -465,6 +471,7 @@ func gentraceback(pc0, sp0, lr0 uintptr, gp *g, skip int, pcbuf *uintptr, max in
 		}
 
 		waspanic = f.funcID == funcID_sigpanic
+		injectedCall = waspanic || f.entry == asyncPreemptPC
 
 		// Do not unwind past the bottom of the stack.
 		if !flr.valid() {
@@ -551,7 +558,8 @@ func gentraceback(pc0, sp0, lr0 uintptr, gp *g, skip int, pcbuf *uintptr, max in
 	// stopped nicely, and the stack walk may not be able to complete.
 	// It's okay in those situations not to use up the entire defer stack:
 	// incomplete information then is still better than nothing.
-	if callback != nil && n < max && _defer != nil {
+	// A callback walk with _TraceSilentErrors is best effort as well.
+	if strict && n < max && _defer != nil {
 		print("runtime: g", gp.goid, ": leftover defer sp=", hex(_defer.sp), " pc=", hex(_defer.pc), "\n")
 		for _defer = gp._defer; _defer != nil; _defer = _defer.link {
 			print("\tdefer ", _defer, " sp=", hex(_defer.sp), " pc=", hex(_defer.pc), "\n")
@@ -559,7 +567,7 @@ func gentraceback(pc0, sp0, lr0 uintptr, gp *g, skip int, pcbuf *uintptr, max in
 		throw("traceback has leftover defers")
 	}
 
-	if callback != nil && n < max && frame.sp != gp.stktopsp {
+	if strict && n < max && frame.sp != gp.stktopsp {
 		print("runtime: g", gp.goid, ": frame.sp=", hex(frame.sp), " top=", hex(gp.stktopsp), "\n")
 		print("\tstack=[", hex(gp.stack.lo), "-", hex(gp.stack.hi), "] n=", n, " max=", max, "\n")
 		throw("traceback did not unwind completely")
@@ -737,14 +745,17 @@ func traceback1(pc, sp, lr uintptr, gp *g, flags uint) {
 		sp = gp.syscallsp
 		flags &^= _TraceTrap
 	}
-	// Print traceback. By default, omits runtime frames.
-	// If that means we print nothing at all, repeat forcing all frames printed.
-	n = gentraceback(pc, sp, lr, gp, 0, nil, _TracebackMaxFrames, nil, nil, flags)
-	if n == 0 && (flags&_TraceRuntimeFrames) == 0 {
-		n = gentraceback(pc, sp, lr, gp, 0, nil, _TracebackMaxFrames, nil, nil, flags|_TraceRuntimeFrames)
-	}
-	if n == _TracebackMaxFrames {
-		print("...additional frames elided...\n")
+	// 很深的栈（通常是无限递归）压缩打印，见 tracebackCompact
+	if !tracebackCompact(pc, sp, lr, gp, flags) {
+		// Print traceback. By default, omits runtime frames.
+		// If that means we print nothing at all, repeat forcing all frames printed.
+		n = gentraceback(pc, sp, lr, gp, 0, nil, _TracebackMaxFrames, nil, nil, flags)
+		if n == 0 && (flags&_TraceRuntimeFrames) == 0 {
+			n = gentraceback(pc, sp, lr, gp, 0, nil, _TracebackMaxFrames, nil, nil, flags|_TraceRuntimeFrames)
+		}
+		if n == _TracebackMaxFrames {
+			print("...additional frames elided...\n")
+		}
 	}
 	printcreatedby(gp)
 
@@ -756,6 +767,110 @@ func traceback1(pc, sp, lr uintptr, gp *g, flags uint) {
 	}
 }
 
+const (
+	tracebackEdgeFrames = _TracebackMaxFrames / 2 // 压缩打印时栈顶与栈底各自最多打印的帧数
+	tracebackMaxCycle   = 32                      // 能识别的调用循环的最大长度（帧数）
+)
+
+// tracebackFrame 记录一个帧的位置，用于从该帧开始继续打印
+type tracebackFrame struct {
+	pc, sp uintptr
+}
+
+// tracebackScan 为 tracebackCompact 对整个栈扫描一遍的结果，由 tracebackScanFrame 填写。
+type tracebackScan struct {
+	depth int // 栈中的帧数
+
+	// top 为栈顶最多 tracebackEdgeFrames 个帧的 pc，在其中查找调用循环
+	top [tracebackEdgeFrames]uintptr
+
+	// 调用循环为 [cycle, cycle+cycleLen) 中的帧，其后的 run 个帧重复这个循环。
+	// cycleLen == 0 表示还没有找到循环；run 不足 cycleLen 时循环还没有完整地重复过一次。
+	// 循环结束后 done 为 true，之后的帧不再参与查找。
+	cycle, cycleLen, run int
+	done                 bool
+
+	// tail 为最近的 tracebackEdgeFrames 个帧，帧 i 保存在 tail[i%tracebackEdgeFrames] 中
+	tail [tracebackEdgeFrames]tracebackFrame
+}
+
+// tracebackScanFrame 为 tracebackCompact 的 gentraceback 回调，v 为 *tracebackScan。
+func tracebackScanFrame(frame *stkframe, v unsafe.Pointer) bool {
+	s := (*tracebackScan)(v)
+	i := s.depth
+	s.depth++
+	s.tail[i%tracebackEdgeFrames] = tracebackFrame{frame.pc, frame.sp}
+	if i < len(s.top) {
+		s.top[i] = frame.pc
+	}
+	if s.done {
+		return true
+	}
+	if s.cycleLen != 0 {
+		if frame.pc == s.top[s.cycle+s.run%s.cycleLen] {
+			s.run++
+			return true
+		}
+		if s.run >= s.cycleLen {
+			s.done = true
+			return true
+		}
+		// 没有完整地重复一次，不是调用循环，从这个帧重新查找
+		s.cycleLen = 0
+		s.run = 0
+	}
+	if i < len(s.top) {
+		for j := i - 1; j >= 0 && j >= i-tracebackMaxCycle; j-- {
+			if s.top[j] == frame.pc {
+				s.cycle, s.cycleLen, s.run = j, i-j, 1
+				break
+			}
+		}
+	}
+	return true
+}
+
+// tracebackCompact 打印很深的栈的 traceback。
+// 栈溢出时栈上通常是同一组帧的成千上万次重复，逐帧打印既看不出问题，也会淹没其他 goroutine 的 traceback。
+// 因此先扫描一遍整个栈：若栈顶的帧中出现了调用循环，只打印一次该循环并给出重复的次数，
+// 然后打印栈底的最后 tracebackEdgeFrames 个帧（没有循环时则打印栈顶与栈底各 tracebackEdgeFrames 个帧），
+// 最后给出栈的总帧数。
+//
+// 栈的帧数不超过 _TracebackMaxFrames 时不打印任何东西并返回 false，由调用者正常打印。
+func tracebackCompact(pc, sp, lr uintptr, gp *g, flags uint) bool {
+	var s tracebackScan
+	gentraceback(pc, sp, lr, gp, 0, nil, maxInt, tracebackScanFrame, noescape(unsafe.Pointer(&s)), flags|_TraceSilentErrors)
+	if s.depth <= _TracebackMaxFrames {
+		return false
+	}
+
+	// 打印栈顶的 [0, top) 帧，省略 [top, end) 帧
+	top, end := tracebackEdgeFrames, tracebackEdgeFrames
+	if s.cycleLen != 0 && s.run >= s.cycleLen {
+		top = s.cycle + s.cycleLen
+		end = top + s.run
+	}
+	gentraceback(pc, sp, lr, gp, 0, nil, top, nil, nil, flags)
+	if end > top {
+		print("...", s.run, " frames elided: the ", s.cycleLen, " frames above repeat ", s.run/s.cycleLen, " more times...\n")
+	}
+
+	// 打印栈底的 [tail, depth) 帧。这些帧不是栈顶，不是从 trap 开始的
+	tail := s.depth - tracebackEdgeFrames
+	if tail < end {
+		tail = end
+	}
+	if tail > end {
+		print("...", tail-end, " frames elided...\n")
+	}
+	if tail < s.depth {
+		f := s.tail[tail%tracebackEdgeFrames]
+		gentraceback(f.pc, f.sp, 0, gp, 0, nil, s.depth-tail, nil, nil, flags&^_TraceTrap)
+	}
+	print("...goroutine stack is ", s.depth, " frames deep...\n")
+	return true
+}
+
 // printAncestorTraceback prints the traceback of the given ancestor.
 // TODO: Unify this with gentraceback and CallersFrames.
 func printAncestorTraceback(ancestor ancestorInfo) {
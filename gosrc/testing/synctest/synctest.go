@@ -0,0 +1,57 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package synctest 为测试并发代码提供虚拟时间。
+//
+// Run 在一个隔离的「气泡」中执行函数。气泡中的 goroutine 使用一个假时钟：
+// time.Now、time.Sleep、time.Timer、time.Ticker 以及基于它们实现的
+// context.WithTimeout 都以假时钟计时。假时钟的初始时间为 2000-01-01 00:00:00 UTC。
+//
+// 假时钟只有在气泡中的所有 goroutine 都持久阻塞时才会前进，并且会立即前进到
+// 下一个 timer 的触发时间。因此依赖超时和重试的代码可以在瞬间完成测试，
+// 并且结果不受机器负载的影响：
+//
+//	func TestRetry(t *testing.T) {
+//		synctest.Run(func() {
+//			start := time.Now()
+//			err := retry(3, time.Second, op) // 每次失败后等待 1 秒
+//			if d := time.Since(start); d != 2*time.Second {
+//				t.Errorf("retry took %v, want 2s", d)
+//			}
+//		})
+//	}
+//
+// 一个 goroutine 在以下情况下是持久阻塞的：
+//
+//   - 在 channel 上收发，或阻塞在 select 中
+//   - 在 time.Sleep 中
+//   - 在 sync.Cond.Wait、sync.Mutex、sync.WaitGroup.Wait 中
+//   - 在 Wait 中
+//
+// 阻塞在系统调用或网络 I/O 上的 goroutine 不是持久阻塞的，此时假时钟不会前进。
+// 气泡中的 goroutine 应该只通过气泡中创建的 channel 和 timer 与其他 goroutine 通信：
+// 被气泡外的 goroutine 唤醒虽然不会出错，但此前气泡可能已被认为空闲而推进了假时钟。
+package synctest
+
+// Run 在一个新的气泡中执行 f，并等待气泡中的所有 goroutine 退出后返回。
+//
+// f 以及 f 直接或间接创建的所有 goroutine 都属于该气泡。
+// 若气泡中的所有 goroutine 都持久阻塞且没有等待触发的 timer，Run 会 panic。
+//
+// 不能在气泡中调用 Run。
+func Run(f func()) {
+	run(f)
+}
+
+// Wait 阻塞直到当前气泡中除调用者以外的所有 goroutine 都持久阻塞。
+// 在此期间假时钟不会前进。
+//
+// 只能在气泡中调用 Wait，且同一时刻每个气泡中只能有一个 goroutine 调用 Wait。
+func Wait() {
+	wait()
+}
+
+// 由 runtime 实现
+func run(f func())
+func wait()
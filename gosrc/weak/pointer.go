@@ -0,0 +1,97 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package weak 提供弱指针。
+//
+// 弱指针引用一个对象，但不会使对象保持可达。当对象不可达后，
+// 所有指向它的弱指针都会被清除，Value 返回 nil。这使得缓存、
+// 规范化（interning）等不希望延长对象生命周期的数据结构可以不依赖 runtime.SetFinalizer：
+//
+//	type cache struct {
+//		mu sync.Mutex
+//		m  map[string]weak.Pointer
+//	}
+//
+//	func (c *cache) get(key string) *T {
+//		c.mu.Lock()
+//		defer c.mu.Unlock()
+//		if v, ok := c.m[key].Value().(*T); ok {
+//			return v
+//		}
+//		v := load(key)
+//		c.m[key] = weak.Make(v)
+//		runtime.AddCleanup(v, func(key interface{}) { c.remove(key.(string)) }, key)
+//		return v
+//	}
+//
+// 弱指针在对象被判定为不可达时、对象被释放之前被清除。若对象设置了 finalizer，
+// 弱指针在 finalizer 运行之前就会被清除，即使 finalizer 复活了对象。
+package weak
+
+import "unsafe"
+
+// Pointer 为指向对象的弱指针，零值表示 nil 指针。
+//
+// 由指向同一对象同一位置的指针创建的两个 Pointer 是相等的，即使对象已经被回收。
+// 因此 Pointer 可以作为 map 的键。
+type Pointer struct {
+	typ unsafe.Pointer // 指针的类型
+	u   unsafe.Pointer // 对象的弱引用句柄，由运行时管理
+	off uintptr        // 指针在对象中的偏移量
+}
+
+// eface 与运行时中空接口的内部表示相同
+type eface struct {
+	typ  unsafe.Pointer
+	data unsafe.Pointer
+}
+
+// Make 创建一个指向 ptr 所指对象的弱指针。ptr 必须为指针或 nil。
+//
+// 指向对象内部（例如结构体的字段）的指针同样可以创建弱指针，
+// 此时弱指针在整个对象不可达时才会被清除。
+// 指向全局变量以及零大小对象的弱指针永远不会被清除，且不保证彼此相等。
+func Make(ptr interface{}) Pointer {
+	e := (*eface)(unsafe.Pointer(&ptr))
+	if e.typ == nil {
+		return Pointer{}
+	}
+	if !runtime_isPointer(ptr) {
+		panic("weak.Make: argument is not a pointer")
+	}
+	if e.data == nil {
+		return Pointer{typ: e.typ}
+	}
+	u, off := runtime_registerWeakPointer(e.data)
+	return Pointer{typ: e.typ, u: u, off: off}
+}
+
+// Value 返回创建 p 时使用的指针。若对象已被回收，则返回 nil 接口值；
+// 若 p 为零值，也返回 nil 接口值。
+//
+// 返回的指针是强引用，调用方持有它期间对象不会被回收。
+func (p Pointer) Value() interface{} {
+	if p.u == nil {
+		if p.typ == nil {
+			return nil
+		}
+		// Make 的参数为有类型的 nil 指针
+		var v interface{}
+		*(*eface)(unsafe.Pointer(&v)) = eface{typ: p.typ}
+		return v
+	}
+	base := runtime_makeStrongFromWeak(p.u)
+	if base == nil {
+		return nil
+	}
+	var v interface{}
+	*(*eface)(unsafe.Pointer(&v)) = eface{typ: p.typ, data: unsafe.Pointer(uintptr(base) + p.off)}
+	return v
+}
+
+// Implemented in runtime.
+
+func runtime_registerWeakPointer(unsafe.Pointer) (unsafe.Pointer, uintptr)
+func runtime_makeStrongFromWeak(unsafe.Pointer) unsafe.Pointer
+func runtime_isPointer(interface{}) bool
@@ -0,0 +1,9 @@
+// Copyright 2025 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Nothing to see here.
+// This file exists so that the go command knows that parts of the
+// package are implemented elsewhere, so that it does not instruct the
+// Go compiler to complain about extern declarations.
+// The actual implementation of the weak handles is in package runtime.